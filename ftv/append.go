@@ -0,0 +1,100 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readStreamEnd decodes the trailer the video ends with, which tells where a
+// part appended to it continues the stream, and whether its frames are
+// numbered. Only the last seconds of the video are decoded, and the video
+// must have been encoded with opts.
+func readStreamEnd(ctx context.Context, video string, opts options) (*streamTrailer, bool, error) {
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath,
+		"-sseof", "-10", // The trailer is the last frame
+		"-i", video,
+		"-vsync", "passthrough",
+		"-vf", decodeFilter,
+		"-f", "rawvideo",
+		"-an",
+		"-",
+	)
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	defer stderr.Close()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false, ffmpegError(fmt.Errorf("starting command: %w", err))
+	}
+
+	// Only the copies of the last frame are kept
+	frameSize := rgbFrameSize(opts)
+	copies := make([][]byte, 0, opts.repeat)
+	buffer := make([]byte, frameSize)
+	var readErr error
+	for {
+		if _, err := io.ReadFull(stdout, buffer); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				readErr = fmt.Errorf("reading from command output: %w", err)
+			}
+			break
+		}
+		if len(copies) < opts.repeat {
+			copies = append(copies, buffer)
+			buffer = make([]byte, frameSize)
+			continue
+		}
+		oldest := copies[0]
+		copy(copies, copies[1:])
+		copies[len(copies)-1] = buffer
+		buffer = oldest
+	}
+	if readErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, false, readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, false, ffmpegExitError(fmt.Errorf("ffmpeg failed: %w", err), stderr)
+	}
+	if len(copies) == 0 {
+		return nil, false, errors.New("video contains no frames")
+	}
+
+	ecc := opts.frameECC()
+	data := make([]byte, frameDataSize(opts))
+	var raw []byte
+	if ecc != nil {
+		raw = make([]byte, rawFrameSize(opts))
+	}
+	if repair := digestFrame(copies, data, raw, opts, ecc); len(repair.failed) > 0 {
+		return nil, false, errors.New("the last frame has too many errors to correct")
+	}
+	trailer, ok := unmarshalTrailer(data)
+	if !ok {
+		return nil, false, errors.New("video does not end with a trailer: it was cut short, is older than format v2, or -size, -dot, -ecc or -repeat differ from the ones used to encode it")
+	}
+	// The trailer starts the frame either way, the number ends it
+	number, numbered := readFrameNumber(data, frameDataSize(opts)-frameNumberSize)
+	return trailer, numbered && number == trailer.frame, nil
+}
+
+// joinAppended writes the frames of video followed by the ones of parts to
+// dest, which may be video itself.
+func joinAppended(ctx context.Context, video string, parts []string, dest string, opts options) error {
+	ext := filepath.Ext(dest)
+	joined := strings.TrimSuffix(dest, ext) + ".joined" + ext
+	defer os.Remove(joined)
+	if err := concatSegments(ctx, append([]string{video}, parts...), joined, opts); err != nil {
+		return err
+	}
+	return os.Rename(joined, dest)
+}
@@ -0,0 +1,461 @@
+package ftv
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A directory given to encode with -format tar is archived by FileToVideo
+// itself, as tar cf would: symlinks are stored as links rather than
+// followed, every directory gets an entry so empty ones come back, and a
+// sparse file only stores its data, so a VM image does not take the size of
+// its holes. Decoding with -format tar into a directory extracts the archive
+// again, holes included.
+
+// extent is a stretch of a file that holds data, the rest of a sparse file
+// being holes that read as zeros.
+type extent struct {
+	offset, length int64
+}
+
+// blockSize is the size of the records of a tar archive.
+const blockSize = 512
+
+// archiveDirectory writes the tree at dir to a temporary tar file and
+// returns its path. The entries are named from the base of dir on.
+func archiveDirectory(dir string) (string, error) {
+	file, err := os.CreateTemp("", ".filetovideo-archive-*")
+	if err != nil {
+		return "", err
+	}
+	out := bufio.NewWriterSize(file, 1<<20)
+	archive := tar.NewWriter(out)
+	root := filepath.Dir(filepath.Clean(dir))
+	entries, sparse := 0, 0
+
+	err = filepath.WalkDir(dir, func(walked string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, walked)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(walked); err != nil {
+				return err
+			}
+		case !info.Mode().IsDir() && !info.Mode().IsRegular():
+			logger.info("reader", "skipping what is neither a file, a directory nor a symlink", fields{"path": walked, "mode": info.Mode().Type().String()})
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		entries++
+		if !info.Mode().IsRegular() {
+			return archive.WriteHeader(header)
+		}
+
+		source, err := os.Open(walked)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		extents := dataExtents(source, info.Size())
+		var data int64
+		for _, e := range extents {
+			data += e.length
+		}
+		if data < info.Size() {
+			sparse++
+			return writeSparse(archive, out, header, source, extents)
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = io.Copy(archive, source)
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("archiving %s: %w", dir, err)
+	}
+	logger.verbose("reader", "directory archived", fields{"dir": dir, "entries": entries, "sparse": sparse})
+	return file.Name(), nil
+}
+
+// writeSparse writes the regular file of header, whose data is only in
+// extents, to out in the GNU sparse format 1.0 of PAX, which archive/tar
+// reads but cannot write: a PAX header with the real name and size, then
+// the map of the extents and the data of every one of them.
+func writeSparse(archive *tar.Writer, out io.Writer, header *tar.Header, file *os.File, extents []extent) error {
+	// The entry before is padded, the records are written straight to out
+	if err := archive.Flush(); err != nil {
+		return err
+	}
+
+	var sparseMap bytes.Buffer
+	// A hole at the end is marked with an empty extent, as GNU tar does
+	if n := len(extents); n == 0 || extents[n-1].offset+extents[n-1].length < header.Size {
+		extents = append(extents, extent{offset: header.Size})
+	}
+	fmt.Fprintf(&sparseMap, "%d\n", len(extents))
+	var data int64
+	for _, e := range extents {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", e.offset, e.length)
+		data += e.length
+	}
+	sparseMap.Write(make([]byte, padding(int64(sparseMap.Len()))))
+
+	var records bytes.Buffer
+	for _, record := range [][2]string{
+		{"GNU.sparse.major", "1"},
+		{"GNU.sparse.minor", "0"},
+		{"GNU.sparse.name", header.Name},
+		{"GNU.sparse.realsize", strconv.FormatInt(header.Size, 10)},
+	} {
+		records.WriteString(paxRecord(record[0], record[1]))
+	}
+
+	dir, base := path.Split(header.Name)
+	paxHeader := ustarBlock(path.Join(dir, "PaxHeaders.0", base), tar.TypeXHeader, int64(records.Len()), header)
+	fileHeader := ustarBlock(path.Join(dir, "GNUSparseFile.0", base), tar.TypeReg, int64(sparseMap.Len())+data, header)
+	records.Write(make([]byte, padding(int64(records.Len()))))
+	for _, b := range [][]byte{paxHeader, records.Bytes(), fileHeader, sparseMap.Bytes()} {
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, e := range extents {
+		if _, err := io.Copy(out, io.NewSectionReader(file, e.offset, e.length)); err != nil {
+			return err
+		}
+	}
+	_, err := out.Write(make([]byte, padding(data)))
+	return err
+}
+
+// paxRecord formats a record of a PAX header, which starts with its own
+// length.
+func paxRecord(key, value string) string {
+	record := " " + key + "=" + value + "\n"
+	size := len(record)
+	for size != len(record)+len(strconv.Itoa(size)) {
+		size = len(record) + len(strconv.Itoa(size))
+	}
+	return strconv.Itoa(size) + record
+}
+
+// ustarBlock returns the ustar header block of an entry named name, cut to
+// what the field holds, of type typeflag and size bytes, with the owner,
+// mode and time of header.
+func ustarBlock(name string, typeflag byte, size int64, header *tar.Header) []byte {
+	b := make([]byte, blockSize)
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	copy(b[0:100], name)
+	ustarNumber(b[100:108], int64(header.Mode&0o7777))
+	ustarNumber(b[108:116], int64(header.Uid))
+	ustarNumber(b[116:124], int64(header.Gid))
+	ustarNumber(b[124:136], size)
+	ustarNumber(b[136:148], header.ModTime.Unix())
+	b[156] = typeflag
+	copy(b[257:265], "ustar\x0000")
+	copy(b[265:297], header.Uname)
+	copy(b[297:329], header.Gname)
+
+	// The checksum is taken with its own field as spaces
+	copy(b[148:156], "        ")
+	var sum int64
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b
+}
+
+// ustarNumber writes x into the numeric field b, in octal if it fits and
+// in the base-256 of GNU tar otherwise.
+func ustarNumber(b []byte, x int64) {
+	if x >= 0 && x < 1<<(3*(len(b)-1)) {
+		copy(b, fmt.Sprintf("%0*o\x00", len(b)-1, x))
+		return
+	}
+	for i := len(b) - 1; i > 0; i-- {
+		b[i] = byte(x)
+		x >>= 8
+	}
+	b[0] = 0x80
+}
+
+// padding returns the bytes that round n up to a whole record.
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+// isArchiveDir reports whether decoding with -format tar to path extracts
+// the archive into it: path is a directory, or ends with a separator.
+func isArchiveDir(path string) bool {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// extractArchive decodes the archive with decodeTo into a temporary file in
+// dir, then extracts it into dir. Files in dir are only replaced with
+// overwrite.
+func extractArchive(dir string, overwrite bool, decodeTo func(dest string) error) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return &stageError{stage: "writer", err: err}
+	}
+	file, err := os.CreateTemp(dir, ".filetovideo-*.tar")
+	if err != nil {
+		return &stageError{stage: "writer", err: err}
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := decodeTo(file.Name()); err != nil {
+		return err
+	}
+	if err := extractTar(file.Name(), dir, overwrite); err != nil {
+		return &stageError{stage: "writer", err: err}
+	}
+	return nil
+}
+
+// extractTar extracts the archive at path into dir, recreating
+// directories, symlinks, hard links and regular files, sparse ones with
+// their holes. Entries that would land outside dir are refused.
+func extractTar(path, dir string, overwrite bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive := tar.NewReader(bufio.NewReaderSize(file, 1<<20))
+
+	type dirTimes struct {
+		path string
+		mode fs.FileMode
+		time time.Time
+	}
+	var dirs []dirTimes
+	entries := 0
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		mode := fs.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// Not through whatever is there in its place, such as a symlink
+			// an earlier entry made
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				if err := replaceable(target, overwrite); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			// Set once their contents are in, a read-only directory would
+			// refuse them
+			dirs = append(dirs, dirTimes{target, mode, header.ModTime})
+		case tar.TypeSymlink:
+			if err := replaceable(target, overwrite); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := extractPath(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := replaceable(target, overwrite); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeGNUSparse:
+			sparse := header.Typeflag == tar.TypeGNUSparse || header.PAXRecords["GNU.sparse.major"] != "" || header.PAXRecords["GNU.sparse.map"] != ""
+			if err := extractFile(archive, target, header.Size, mode, sparse, overwrite); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		default:
+			logger.info("writer", "skipping an entry that is neither a file, a directory nor a link", fields{"entry": header.Name, "type": string(header.Typeflag)})
+			continue
+		}
+		entries++
+	}
+
+	// Deepest first, so setting a directory does not change the time of
+	// the one holding it
+	sort.Slice(dirs, func(a, b int) bool { return dirs[a].path > dirs[b].path })
+	for _, d := range dirs {
+		if err := setDirMetadata(d.path, d.mode, d.time); err != nil {
+			return err
+		}
+	}
+	logger.info("writer", "archive extracted", fields{"dir": dir, "entries": entries})
+	return nil
+}
+
+// extractPath returns where the entry name goes in dir, failing for names
+// that lead out of it, either by themselves or through a symlink extracted
+// earlier.
+func extractPath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if clean == "." {
+		return dir, nil
+	}
+	if path.IsAbs(clean) || filepath.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("archive entry %q leads out of %s", name, dir)
+	}
+	target := dir
+	parts := strings.Split(clean, "/")
+	for i, part := range parts {
+		target = filepath.Join(target, part)
+		if i == len(parts)-1 {
+			break
+		}
+		if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %q goes through the symlink %s", name, target)
+		}
+	}
+	return target, nil
+}
+
+// replaceable fails if something is at path and overwrite is off, and
+// otherwise removes what is there, which a symlink or link would not
+// replace on its own.
+func replaceable(path string, overwrite bool) error {
+	if _, err := os.Lstat(path); err != nil {
+		return nil
+	}
+	if !overwrite {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+	}
+	return os.Remove(path)
+}
+
+// extractFile writes the size bytes of r to path. A sparse file skips the
+// blocks of zeros, leaving holes where the original had them.
+func extractFile(r io.Reader, path string, size int64, mode fs.FileMode, sparse, overwrite bool) error {
+	if err := replaceable(path, overwrite); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if sparse {
+		err = copySparse(file, r)
+		if err == nil {
+			err = file.Truncate(size)
+		}
+	} else {
+		_, err = io.Copy(file, r)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sparseBlock is the unit of the holes extracting leaves, the usual size of
+// a file system block.
+const sparseBlock = 4096
+
+// copySparse copies r to file, seeking past the blocks that are all zeros
+// instead of writing them.
+func copySparse(file *os.File, r io.Reader) error {
+	buffer := make([]byte, 64*sparseBlock)
+	for {
+		n, err := io.ReadFull(r, buffer)
+		for start := 0; start < n; start += sparseBlock {
+			end := start + sparseBlock
+			if end > n {
+				end = n
+			}
+			block := buffer[start:end]
+			if isZero(block) {
+				if _, seekErr := file.Seek(int64(len(block)), io.SeekCurrent); seekErr != nil {
+					return seekErr
+				}
+				continue
+			}
+			if _, writeErr := file.Write(block); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package ftv
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestTar writes an archive of headers to a file and returns its path,
+// every regular file holding its name.
+func writeTestTar(t *testing.T, headers []*tar.Header) string {
+	t.Helper()
+	var b bytes.Buffer
+	archive := tar.NewWriter(&b)
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(h.Name))
+		}
+		if err := archive.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			archive.Write([]byte(h.Name))
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.tar")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "docs")
+	for _, dir := range []string{"empty", "sub"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{"a.txt": "first", "sub/b.txt": "second"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/b.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "sub"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := archiveDirectory(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archive)
+	dest := t.TempDir()
+	if err := extractTar(archive, dest, false); err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dest, "docs", name)
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Fatalf("%s holds %q (%v), expected %q", name, data, err, content)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+			t.Fatalf("%s has mode %v", name, info.Mode())
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "docs", "link")); err != nil || link != "sub/b.txt" {
+		t.Fatalf("link points to %q (%v)", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "docs", "empty")); err != nil || !info.IsDir() {
+		t.Fatalf("empty directory not extracted: %v", err)
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "docs", "sub")); !info.ModTime().Equal(mtime) {
+		t.Fatalf("directory time %v, expected %v", info.ModTime(), mtime)
+	}
+
+	// Extracting over what is there is refused without overwrite
+	if err := extractTar(archive, dest, false); err == nil {
+		t.Fatal("existing files replaced without overwrite")
+	}
+	if err := extractTar(archive, dest, true); err != nil {
+		t.Fatalf("extracting with overwrite: %v", err)
+	}
+}
+
+// TestExtractTarEscapes checks that no entry reaches outside the directory,
+// by its name or through a symlink extracted before it.
+func TestExtractTarEscapes(t *testing.T) {
+	outside := t.TempDir()
+	dir := func(name string, mode int64) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: mode, ModTime: time.Unix(0, 0)}
+	}
+	symlink := &tar.Header{Name: "p/a", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0o777}
+	tests := []struct {
+		name      string
+		headers   []*tar.Header
+		overwrite bool
+		fails     bool
+	}{
+		{"parent", []*tar.Header{{Name: "../x", Typeflag: tar.TypeReg, Mode: 0o644}}, false, true},
+		{"absolute", []*tar.Header{{Name: "/x", Typeflag: tar.TypeReg, Mode: 0o644}}, false, true},
+		{"file through symlink", []*tar.Header{dir("p/", 0o755), symlink, {Name: "p/a/x", Typeflag: tar.TypeReg, Mode: 0o644}}, true, true},
+		{"hard link through symlink", []*tar.Header{dir("p/", 0o755), symlink, {Name: "p/x", Typeflag: tar.TypeLink, Linkname: "p/a/x"}}, true, true},
+		{"directory over symlink", []*tar.Header{dir("p/", 0o755), symlink, dir("p/a/", 0o777)}, false, true},
+		{"directory replacing symlink", []*tar.Header{dir("p/", 0o755), symlink, dir("p/a/", 0o777)}, true, false},
+		{"symlink replacing directory", []*tar.Header{dir("p/", 0o755), dir("p/a/", 0o777), symlink}, true, true},
+	}
+	before, err := os.Stat(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			err := extractTar(writeTestTar(t, tt.headers), dest, tt.overwrite)
+			if tt.fails && err == nil {
+				t.Fatal("extracted")
+			} else if !tt.fails && err != nil {
+				t.Fatalf("extracting: %v", err)
+			}
+
+			after, err := os.Stat(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Mode() != before.Mode() || !after.ModTime().Equal(before.ModTime()) {
+				t.Fatalf("directory outside changed to %v, %v", after.Mode(), after.ModTime())
+			}
+			entries, err := os.ReadDir(outside)
+			if err != nil || len(entries) != 0 {
+				t.Fatalf("%d entries written outside (%v)", len(entries), err)
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(dest), "x")); err == nil {
+				t.Fatal("entry written next to the directory")
+			}
+			if !tt.fails {
+				info, err := os.Lstat(filepath.Join(dest, "p", "a"))
+				if err != nil || !info.IsDir() || info.Mode().Perm() != 0o777 {
+					t.Fatalf("p/a extracted as %v (%v)", info.Mode(), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// inputList is the -i flag, which may be given several times.
+type inputList []string
+
+func (l *inputList) String() string { return strings.Join(*l, ", ") }
+
+func (l *inputList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// expandInputs replaces the glob patterns among inputs by the files they
+// match, in order and without duplicates. URLs are taken as given.
+func expandInputs(inputs []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, input := range inputs {
+		matches := []string{input}
+		if !isURL(input) && strings.ContainsAny(input, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(input); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", input, err)
+			}
+			// Globs only pick up files, a directory is only archived when named
+			// on its own
+			regular := matches[:0]
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+					regular = append(regular, match)
+				}
+			}
+			if len(regular) == 0 {
+				return nil, fmt.Errorf("no files match %s", input)
+			}
+			matches = regular
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// isTemplate reports whether name contains a placeholder for the input.
+func isTemplate(name string) bool {
+	return strings.Contains(name, "{name}") || strings.Contains(name, "{stem}")
+}
+
+// expandTemplate fills the placeholders of name for input: {name} is the file
+// name of the input and {stem} the same without its extension.
+func expandTemplate(name, input string) string {
+	base := filepath.Base(input)
+	if isURL(input) {
+		if u, err := url.Parse(input); err == nil && u.Path != "" {
+			base = path.Base(u.Path)
+		}
+	}
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	return strings.NewReplacer("{name}", base, "{stem}", stem).Replace(name)
+}
+
+// batchJob is the work for one input of the command line.
+type batchJob struct {
+	input  string
+	output string
+	report string
+}
+
+// runBatch runs run for every job, parallel of them at a time, splitting the
+// pixel workers of opts between them. A failed job does not stop the others,
+// it is logged and counted in the number returned.
+func runBatch(ctx context.Context, jobs []batchJob, parallel int, opts options, run func(context.Context, batchJob, options) error) int {
+	if parallel < 1 {
+		parallel = runtime.NumCPU()
+	}
+	if parallel > len(jobs) {
+		parallel = len(jobs)
+	}
+	opts.threads /= parallel
+	if opts.threads < 1 {
+		opts.threads = 1
+	}
+	// Inputs processed at once share the memory budget, fewer of them run
+	// when their share cannot hold a pipeline
+	if budget := opts.maxMemory; budget > 0 {
+		for ; parallel > 1; parallel-- {
+			shared := opts
+			shared.maxMemory = budget / int64(parallel)
+			if shared.fitMemory() == nil {
+				break
+			}
+		}
+		opts.maxMemory = budget / int64(parallel)
+		if err := opts.fitMemory(); err != nil {
+			logger.error("batch", err)
+			return len(jobs)
+		}
+		logger.verbose("memory", "inputs processed at once share -max-memory", fields{"inputs": parallel, "share": formatBytes(opts.maxMemory)})
+	}
+
+	queue := make(chan batchJob)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	wg.Add(parallel)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				jobOpts := opts
+				jobOpts.reportPath = job.report
+				if err := run(ctx, job, jobOpts); err != nil {
+					logger.error("batch", jobError(job.input, err))
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	return failed
+}
+
+// jobError prefixes err with the input it failed on, keeping the stage it
+// came from.
+func jobError(input string, err error) error {
+	var se *stageError
+	if errors.As(err, &se) {
+		return &stageError{stage: se.stage, err: fmt.Errorf("%s: %w", input, se.err)}
+	}
+	return fmt.Errorf("%s: %w", input, err)
+}
@@ -0,0 +1,267 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	benchNull     = "null"
+	benchLossless = "lossless"
+)
+
+// runBench encodes random payloads of the sizes asked for with the current
+// settings, and with the lossless sink decodes them again as they are
+// encoded, then reports the throughput of every stage and of the whole run.
+// No video is stored and ffmpeg is left out, so what is measured is the
+// pipeline of FileToVideo alone. The hot loops of the serializer and the
+// digester have benchmarks of their own in bench_test.go.
+func runBench(args []string) {
+	var (
+		sizes_list string
+		sink       string
+	)
+
+	c := benchCLI(&sizes_list, &sink)
+	c.parse(args)
+
+	if sink != benchNull && sink != benchLossless {
+		c.usageError(fmt.Sprintf("unknown sink %q (expected %s or %s)", sink, benchNull, benchLossless))
+	}
+	var sizes []int64
+	for _, s := range strings.Split(sizes_list, ",") {
+		size, err := parseBytes(strings.TrimSpace(s))
+		if err != nil || size == 0 {
+			c.usageError(fmt.Sprintf("-sizes takes positive sizes such as 1M,16M, not %q", s))
+		}
+		sizes = append(sizes, size)
+	}
+	// A single sink takes the frames, the parts of several segments would be
+	// joined by ffmpeg
+	c.opts.segments = 1
+
+	dir, err := os.MkdirTemp("", "filetovideo-bench-*")
+	if err != nil {
+		logger.fatal("bench", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []benchResult
+	failed := 0
+	for i, size := range sizes {
+		if ctx.Err() != nil {
+			break
+		}
+		result := benchRun(ctx, filepath.Join(dir, fmt.Sprint(i)), size, sink, c.opts)
+		if result.Error != "" {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	// The results are the output of the command, so they are not subject to -q
+	if logger.format == logJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"runs": results})
+	} else {
+		for _, r := range results {
+			fmt.Printf("%-8s  %10d bytes  %8s  %8.2f MB/s", r.Sink, r.Bytes, time.Duration(r.Seconds*float64(time.Second)).Round(time.Millisecond), r.MBps)
+			for _, phase := range benchPhases {
+				if mbps, ok := r.Stages[phaseNames[phase]]; ok {
+					fmt.Printf("  %s %.2f", phaseNames[phase], mbps)
+				}
+			}
+			if r.Error != "" {
+				fmt.Printf("  FAIL %s", r.Error)
+			}
+			fmt.Println()
+		}
+	}
+	if ctx.Err() != nil {
+		logger.fatal("bench", ctx.Err())
+	}
+	if failed > 0 {
+		logger.fatal("bench", fmt.Errorf("%d of %d runs failed", failed, len(results)))
+	}
+}
+
+// benchCLI defines the flags of bench.
+func benchCLI(sizes_list, sink *string) *cli {
+	c := newCLI("bench")
+	c.flags.StringVar(sizes_list, "sizes", "1M,4M", "Comma-separated sizes of the random payloads, such as 1M,16M")
+	c.flags.StringVar(sink, "sink", benchLossless, "Where the frames go: null drops them and only encodes, lossless decodes them again as they are encoded")
+	return c
+}
+
+// benchPhases are the stages bench reports, the others are time spent
+// waiting on the stages around them.
+var benchPhases = []timingPhase{phaseRead, phaseSerialize, phaseDigest, phaseWrite}
+
+type benchResult struct {
+	Sink    string  `json:"sink"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	MBps    float64 `json:"mb_per_second"`
+
+	// Of the time the workers of every stage spent on it, what a single
+	// worker keeps up
+	Stages map[string]float64 `json:"stages_mb_per_second"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// benchRun encodes size random bytes at base into sink, and decodes them
+// again with the lossless one.
+func benchRun(ctx context.Context, base string, size int64, sink string, opts options) benchResult {
+	result := benchResult{Sink: sink, Bytes: size, Stages: map[string]float64{}}
+	opts.timings = &stageTimings{}
+	payload := base + ".bin"
+	want, err := writeRandom(payload, size)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer os.Remove(payload)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts.loopback = newLoopback(sink == benchLossless)
+	start := time.Now()
+	var decodeErr error
+	var decoding sync.WaitGroup
+	if sink == benchLossless {
+		decoding.Add(1)
+		go func() {
+			defer decoding.Done()
+			if decodeErr = decode(ctx, base+".mkv", base+".out", opts); decodeErr != nil {
+				cancel() // Nothing reads the frames anymore
+			}
+		}()
+	}
+	err = encode(ctx, payload, base+".mkv", opts)
+	if err != nil {
+		// The decode waits for frames that do not come
+		cancel()
+	}
+	decoding.Wait()
+	elapsed := time.Since(start)
+	os.Remove(base + ".mkv")
+
+	switch {
+	case decodeErr != nil:
+		err = fmt.Errorf("decoding: %w", decodeErr)
+	case err != nil:
+		err = fmt.Errorf("encoding: %w", err)
+	case sink == benchLossless:
+		got, hashErr := hashOf(base + ".out")
+		os.Remove(base + ".out")
+		if err = hashErr; err == nil && !bytes.Equal(got, want) {
+			err = errors.New("decoded payload differs")
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Seconds = elapsed.Seconds()
+	result.MBps = round2(float64(size) / 1e6 / elapsed.Seconds())
+	for _, phase := range benchPhases {
+		if spent := time.Duration(opts.timings.spent[phase].Load()); spent > 0 {
+			result.Stages[phaseNames[phase]] = round2(float64(size) / 1e6 / spent.Seconds())
+		}
+	}
+	return result
+}
+
+// loopbackTransport hands the frames of the sink to the source as they are
+// written, which bench decodes while it encodes. That is lossless without
+// storing the raw frames, which take hundreds of times the payload. Without
+// a source it is a null sink dropping the frames.
+type loopbackTransport struct {
+	frames chan []byte // nil for a null sink
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newLoopback(lossless bool) *loopbackTransport {
+	t := &loopbackTransport{done: make(chan struct{})}
+	if lossless {
+		t.frames = make(chan []byte, 4)
+	}
+	return t
+}
+
+func (t *loopbackTransport) NewSink(ctx context.Context, dest string, opts options) (frameSink, error) {
+	return &loopbackSink{ctx: ctx, t: t}, nil
+}
+
+func (t *loopbackTransport) NewSource(ctx context.Context, src string, opts options) (frameSource, error) {
+	if t.frames == nil {
+		return nil, errors.New("the null sink keeps no frames to decode")
+	}
+	return &loopbackSource{ctx: ctx, t: t}, nil
+}
+
+type loopbackSink struct {
+	ctx context.Context
+	t   *loopbackTransport
+}
+
+func (s *loopbackSink) WriteFrame(pixels []byte) error {
+	if s.t.frames == nil {
+		return nil
+	}
+	rgb := make([]byte, len(pixels)/4*3)
+	rgbaToRGB(rgb, pixels)
+	select {
+	case s.t.frames <- rgb:
+	case <-s.t.done:
+		// The decode has what it needs, the frames after it are ignored
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	return nil
+}
+
+func (s *loopbackSink) Close() error {
+	if s.t.frames != nil {
+		close(s.t.frames)
+	}
+	return nil
+}
+
+type loopbackSource struct {
+	ctx context.Context
+	t   *loopbackTransport
+}
+
+func (s *loopbackSource) ReadFrame(pixels []byte) error {
+	select {
+	case rgb, ok := <-s.t.frames:
+		if !ok || len(rgb) != len(pixels) {
+			return io.EOF
+		}
+		copy(pixels, rgb)
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *loopbackSource) Close() error {
+	s.t.once.Do(func() { close(s.t.done) })
+	return nil
+}
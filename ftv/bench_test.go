@@ -0,0 +1,92 @@
+package ftv
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// benchSettings are the settings the serializer and digester loops are
+// benchmarked with, on frames of the default size.
+var benchSettings = []struct {
+	name     string
+	settings map[string]string
+}{
+	{"dots", nil},
+	{"ecc", map[string]string{"ecc": "32"}},
+	{"hamming", map[string]string{"ecc": "hamming"}},
+	{"24 bit", map[string]string{"dot_bits": "24"}},
+	{"dct", map[string]string{"modulation": "dct"}},
+	{"repeat", map[string]string{"repeat": "3"}},
+}
+
+func benchOptions(b *testing.B, settings map[string]string) options {
+	b.Helper()
+	opts := defaultOptions()
+	for key, value := range settings {
+		if err := opts.set(key, value); err != nil {
+			b.Fatalf("setting %s: %v", key, err)
+		}
+	}
+	if err := opts.validate(); err != nil {
+		b.Fatal(err)
+	}
+	return opts
+}
+
+// benchFrame returns a frame's worth of random stream bytes.
+func benchFrame(opts options) []byte {
+	frame := make([]byte, frameCapacity(opts))
+	rand.New(rand.NewSource(1)).Read(frame)
+	return frame
+}
+
+// BenchmarkSerializer paints a frame of random data.
+func BenchmarkSerializer(b *testing.B) {
+	for _, bb := range benchSettings {
+		b.Run(bb.name, func(b *testing.B) {
+			opts := benchOptions(b, bb.settings)
+			serializer := newFrameSerializer(opts)
+			frame := benchFrame(opts)
+			pixels := newFramePool(geometryOf(opts).frameBytes(4))
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pixelData := pixels.get()
+				serializer.serialize(i, frame, pixelData)
+				pixels.put(pixelData)
+			}
+		})
+	}
+}
+
+// BenchmarkDigester reads a frame of random data back into bytes, every
+// -repeat copy of it.
+func BenchmarkDigester(b *testing.B) {
+	for _, bb := range benchSettings {
+		b.Run(bb.name, func(b *testing.B) {
+			opts := benchOptions(b, bb.settings)
+			frame := benchFrame(opts)
+			pixelData := make([]byte, geometryOf(opts).frameBytes(4))
+			newFrameSerializer(opts).serialize(0, frame, pixelData)
+			rgb := make([]byte, rgbFrameSize(opts))
+			rgbaToRGB(rgb, pixelData)
+			copies := make([][]byte, opts.repeat)
+			for c := range copies {
+				copies[c] = rgb
+			}
+			ecc := opts.frameECC()
+			var raw []byte
+			if ecc != nil {
+				raw = make([]byte, rawFrameSize(opts))
+			}
+			data := newFramePool(frameDataSize(opts))
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				processed := data.get()
+				digestFrame(copies, processed, raw, opts, ecc)
+				data.put(processed)
+			}
+		})
+	}
+}
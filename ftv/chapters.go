@@ -0,0 +1,106 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// chapter is a stretch of the video named after the files of a tar payload
+// that start in it, so players and ffprobe -show_chapters show where every
+// file is and a file can be cut out by seeking to its chapter.
+type chapter struct {
+	title string
+	start int // First frame of the video
+	end   int // Frame after the last
+}
+
+// tarChapters returns a chapter for every interleaved block of the video
+// some files of entries start in, the stream header taking headerSize bytes
+// ahead of the archive and the video totalFrames frames before -repeat and
+// after its -intro. Every chapter lasts until the next one, the last until
+// the end.
+func tarChapters(entries []tarEntry, headerSize, totalFrames int, opts options) []chapter {
+	blockBytes := int64(frameCapacity(opts)) * int64(opts.interleave)
+	intro := introFrames(opts)
+	var (
+		chapters []chapter
+		files    []string // Starting in the last chapter
+	)
+	name := func() {
+		if len(files) == 0 {
+			return
+		}
+		chapters[len(chapters)-1].title = files[0]
+		if len(files) > 1 {
+			chapters[len(chapters)-1].title += fmt.Sprintf(" and %d more", len(files)-1)
+		}
+	}
+	for _, entry := range entries {
+		start := intro + int((int64(headerSize)+entry.offset)/blockBytes)*opts.interleave*opts.repeat
+		if n := len(chapters); n == 0 || chapters[n-1].start != start {
+			name()
+			if n > 0 {
+				chapters[n-1].end = start
+			}
+			chapters = append(chapters, chapter{start: start})
+			files = files[:0]
+		}
+		files = append(files, entry.name)
+	}
+	name()
+	if n := len(chapters); n > 0 {
+		chapters[n-1].end = intro + totalFrames*opts.repeat
+	}
+	return chapters
+}
+
+// addChapters writes chapters into the video at path, remuxing it with
+// ffmpeg without re-encoding.
+func addChapters(ctx context.Context, path string, chapters []chapter, opts options) error {
+	var metadata strings.Builder
+	metadata.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		// Frames as the time base place every chapter on its first frame
+		fmt.Fprintf(&metadata, "[CHAPTER]\nTIMEBASE=1/%d\nSTART=%d\nEND=%d\ntitle=%s\n", opts.fps, c.start, c.end, escapeMetadata(c.title))
+	}
+	list := path + ".chapters.txt"
+	if err := os.WriteFile(list, []byte(metadata.String()), 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(list)
+
+	ext := filepath.Ext(path)
+	chaptered := strings.TrimSuffix(path, ext) + ".chaptered" + ext
+	defer os.Remove(chaptered)
+	args := []string{
+		"-y",
+		"-i", path,
+		"-f", "ffmetadata",
+		"-i", list,
+		"-map", "0",
+		"-map_chapters", "1",
+		"-c", "copy",
+	}
+	args = append(args, muxerArgs(chaptered, opts)...)
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath, append(args, chaptered)...)
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stderr.Close()
+	if err != nil {
+		return ffmpegExitError(fmt.Errorf("adding chapters: %w", err), stderr)
+	}
+	if err := os.Rename(chaptered, path); err != nil {
+		return err
+	}
+	logger.verbose("ffmpeg", "chapters added", fields{"chapters": len(chapters)})
+	return nil
+}
+
+// escapeMetadata escapes the characters special to an ffmetadata file.
+func escapeMetadata(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(s)
+}
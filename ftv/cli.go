@@ -0,0 +1,601 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// subcommands maps the optional first argument to its handler. Without one
+// the program encodes, or decodes when -d is given.
+var subcommands = map[string]func(args []string){
+	"bench":      runBench,
+	"completion": runCompletion,
+	"doctor":     runDoctor,
+	"estimate":   runEstimate,
+	"merge":      runMerge,
+	"selftest":   runSelftest,
+	"serve":      runServe,
+	"watch":      runWatch,
+}
+
+// Main runs the FileToVideo command line with the arguments in os.Args and
+// exits the process when it fails.
+func Main() {
+	watchPauseSignals()
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	var (
+		mode            bool
+		input_files     inputList
+		output_file     string
+		show_progress   bool
+		show_tui        bool
+		upload_target   string
+		force           bool
+		parallel_jobs   int
+		target_duration time.Duration
+	)
+
+	c := mainCLI(os.Args[0], &mode, &input_files, &output_file, &parallel_jobs, &show_progress, &show_tui, &force, &upload_target, &target_duration)
+	c.parse(os.Args[1:])
+
+	if len(input_files) == 0 {
+		c.usageError("The -i flag is mandatory")
+	}
+	inputs, err := expandInputs(input_files)
+	if err != nil {
+		logger.fatal("cli", err)
+	}
+	batch := len(inputs) > 1
+	for _, input_file := range inputs {
+		// Remote inputs are checked when the pipeline opens them, and a video
+		// can come from any URL ffmpeg can open
+		if !isRemote(input_file) && !(mode && isURL(input_file)) && !(input_file == stdinInput && !mode) {
+			if info, err := os.Stat(input_file); os.IsNotExist(err) {
+				logger.fatal("cli", fmt.Errorf("file %s does not exist", input_file))
+			} else if err != nil {
+				logger.fatal("cli", fmt.Errorf("checking file existence: %w", err))
+			} else if info.IsDir() && !mode && c.opts.payloadFormat != payloadTar {
+				c.usageError(fmt.Sprintf("%s is a directory, which encoding only takes with -format tar", input_file))
+			}
+		}
+	}
+
+	// The parts of a split video make up a single job
+	var split_parts []string
+	if c.opts.split && mode {
+		if c.opts.live || output_file == stdoutOutput {
+			c.usageError("The parts of a split video cannot be decoded with -live or to standard output")
+		}
+		sortSegmentPaths(inputs)
+		split_parts = inputs
+		inputs = inputs[:1]
+		batch = false
+	}
+
+	if c.opts.rangeOffset != 0 || c.opts.rangeLength != 0 {
+		if !mode {
+			c.usageError("The -offset and -length flags only apply to decoding")
+		}
+		if c.opts.rangeOffset < 0 || c.opts.rangeLength <= 0 {
+			c.usageError("The -offset flag takes a byte of the payload and -length a positive number of bytes")
+		}
+		if batch || output_file == "" {
+			c.usageError("The -offset and -length flags take a single input and -o")
+		}
+		// The range is read from the frames holding it alone
+		if c.opts.split || c.opts.live || c.opts.partial || c.opts.parity != "" || c.opts.deltaBase != "" || c.opts.dropDuplicates || c.opts.reportPath != "" || c.opts.payloadFormat == payloadTar {
+			c.usageError("The -offset and -length flags cannot be combined with -split, -live, -partial, -parity, -base, -drop-duplicates, -report or -format tar")
+		}
+	}
+
+	// Decoding without -o restores the original file name
+	if output_file == "" && !mode {
+		c.usageError("The -o flag is mandatory when encoding")
+	}
+	if (c.opts.reportPath != "" || c.opts.partial) && !mode {
+		c.usageError("The -report and -partial flags only apply to decoding")
+	}
+	if c.opts.manifest != "" {
+		if batch {
+			c.usageError("The -manifest flag only applies to a single input")
+		}
+		if c.opts.appendTo != "" {
+			c.usageError("The -manifest flag cannot be combined with -append")
+		}
+		if mode {
+			// The settings in the manifest are those of the video, flags
+			// given on the command line still win
+			m, err := readManifest(c.opts.manifest)
+			if err != nil {
+				logger.fatal("cli", err)
+			}
+			if err := applySettings(&c.opts, m.Settings, c.explicit); err != nil {
+				c.usageError(fmt.Sprintf("manifest: %v", err))
+			}
+			if err := c.opts.validate(); err != nil {
+				c.usageError(err.Error())
+			}
+		}
+	}
+	if c.opts.dropDuplicates {
+		if !mode {
+			c.usageError("The -drop-duplicates flag only applies to decoding")
+		}
+		// The copies of a frame are duplicates on purpose
+		if c.opts.repeat > 1 {
+			c.usageError("The -drop-duplicates flag cannot be combined with -repeat")
+		}
+	}
+	if c.opts.live {
+		if !mode {
+			c.usageError("The -live flag only applies to decoding")
+		}
+		if batch {
+			c.usageError("The -live flag only applies to a single input")
+		}
+		// Frames are only told apart from their position in the video, a
+		// stream joined in its middle can only be synced on a header frame
+		if c.opts.interleave > 1 || c.opts.repeat > 1 {
+			c.usageError("The -live flag cannot be combined with -interleave or -repeat")
+		}
+	}
+	if c.opts.appendTo != "" {
+		if mode {
+			c.usageError("The -append flag only applies to encoding")
+		}
+		if batch {
+			c.usageError("The -append flag only applies to a single input")
+		}
+		if isURL(c.opts.appendTo) || isURL(output_file) {
+			c.usageError("The -append flag needs a local video and output")
+		}
+		if _, err := os.Stat(c.opts.appendTo); err != nil {
+			logger.fatal("cli", err)
+		}
+	}
+	if c.opts.deltaBase != "" {
+		if c.opts.appendTo != "" || c.opts.partial {
+			c.usageError("The -base flag cannot be combined with -append or -partial")
+		}
+		if batch {
+			c.usageError("The -base flag only applies to a single input")
+		}
+		if !mode && (isRemote(inputs[0]) || isRemote(c.opts.deltaBase)) {
+			c.usageError("The -base flag needs a local input and base when encoding")
+		}
+		if !isURL(c.opts.deltaBase) {
+			if _, err := os.Stat(c.opts.deltaBase); err != nil {
+				logger.fatal("cli", err)
+			}
+		}
+	}
+	if c.opts.split && !mode {
+		if c.opts.appendTo != "" || upload_target != "" || isRemote(output_file) || isURL(output_file) {
+			c.usageError("The -split flag cannot be combined with -append, -upload or a remote output")
+		}
+	}
+	if c.opts.parity != "" {
+		if mode {
+			if batch || c.opts.live {
+				c.usageError("The -parity flag takes a single input and no -live when decoding")
+			}
+			if !isURL(c.opts.parity) {
+				if _, err := os.Stat(c.opts.parity); err != nil {
+					logger.fatal("cli", err)
+				}
+			}
+		} else {
+			if _, err := parseParityShare(c.opts.parity); err != nil {
+				c.usageError(err.Error())
+			}
+			if c.opts.appendTo != "" || isLive(output_file) {
+				c.usageError("The -parity flag cannot be combined with -append or a live stream output")
+			}
+		}
+	}
+	if c.opts.transport == transportImages || c.opts.transport == transportY4M {
+		for _, path := range append([]string{output_file, c.opts.deltaBase}, inputs...) {
+			if isRemote(path) || isURL(path) {
+				c.usageError(fmt.Sprintf("The %s transport needs local paths", c.opts.transport))
+			}
+		}
+		if c.opts.segments > 1 || c.opts.appendTo != "" || c.opts.live || upload_target != "" {
+			c.usageError(fmt.Sprintf("The %s transport cannot be combined with -segments, -append, -live or -upload", c.opts.transport))
+		}
+	}
+	if output_file == stdoutOutput && !mode {
+		if c.opts.transport != transportY4M {
+			c.usageError("Only decoding or the y4m transport can write to standard output")
+		}
+		if batch || c.opts.split || c.opts.parity != "" {
+			c.usageError("Encoding to standard output takes a single input and no -split or -parity")
+		}
+		// Standard output carries the video
+		logger.out = os.Stderr
+	}
+	if output_file == stdoutOutput && mode {
+		if batch || c.opts.reportPath != "" || c.opts.partial || c.opts.deltaBase != "" || c.opts.restoreMetadata || c.opts.parity != "" {
+			c.usageError("Decoding to standard output takes a single input and no -report, -partial, -base, -restore or -parity")
+		}
+		// Standard output carries the payload
+		logger.out = os.Stderr
+	}
+	if isLive(output_file) {
+		if mode {
+			c.usageError("Decoding cannot write to a live stream")
+		}
+		if batch || c.opts.segments > 1 || c.opts.appendTo != "" || upload_target != "" {
+			c.usageError("A live stream output takes a single input and no -segments, -append or -upload")
+		}
+		if c.opts.container != "" {
+			c.usageError("A live stream output has the container of its protocol, -container does not apply")
+		}
+	}
+	if !mode && c.opts.transport == transportFFmpeg {
+		if err := checkContainer(output_file, c.opts); err != nil {
+			c.usageError(err.Error())
+		}
+	}
+	if batch {
+		if output_file != "" && !isTemplate(output_file) {
+			c.usageError("With several inputs -o must contain {name} or {stem}")
+		}
+		if c.opts.reportPath != "" && !isTemplate(c.opts.reportPath) {
+			c.usageError("With several inputs -report must contain {name} or {stem}")
+		}
+		if show_progress || show_tui {
+			c.usageError("The -progress and -tui flags only apply to a single input")
+		}
+	}
+	if show_tui {
+		if show_progress {
+			c.usageError("The -tui flag cannot be combined with -progress")
+		}
+		if !isTerminal(os.Stderr) {
+			c.usageError("The -tui flag needs standard error to be a terminal")
+		}
+	}
+
+	jobs := make([]batchJob, len(inputs))
+	outputs := map[string]string{}
+	for i, input_file := range inputs {
+		job := batchJob{input: input_file, output: output_file, report: c.opts.reportPath}
+		if isTemplate(job.output) {
+			job.output = expandTemplate(job.output, input_file)
+		}
+		if isTemplate(job.report) {
+			job.report = expandTemplate(job.report, input_file)
+		}
+		if job.output != "" {
+			if other, ok := outputs[job.output]; ok {
+				c.usageError(fmt.Sprintf("%s and %s would both be written to %s", other, input_file, job.output))
+			}
+			outputs[job.output] = input_file
+		}
+		// A typo in -o must not destroy an existing file. Remote outputs are
+		// replaced like any upload would.
+		// Archives are extracted into a directory that may exist, and refuse
+		// to replace the files in it
+		if job.output != "" && job.output != stdoutOutput && !isRemote(job.output) && !force && !(mode && c.opts.payloadFormat == payloadTar && isArchiveDir(job.output)) {
+			if _, err := os.Stat(job.output); err == nil {
+				logger.fatal("cli", fmt.Errorf("%s already exists, use -force to overwrite it", job.output))
+			} else if !os.IsNotExist(err) {
+				logger.fatal("cli", fmt.Errorf("checking output: %w", err))
+			}
+		}
+		jobs[i] = job
+	}
+	c.opts.overwrite = force
+
+	if upload_target != "" {
+		if mode {
+			c.usageError("The -upload flag only applies to encoding")
+		}
+		if err := checkUploadTarget(upload_target, c.opts); err != nil {
+			c.usageError(err.Error())
+		}
+	}
+	if target_duration != 0 {
+		if mode || target_duration < 0 {
+			c.usageError("The -target-duration flag takes a positive duration and only applies to encoding")
+		}
+		if c.opts.appendTo != "" {
+			c.usageError("The -target-duration flag cannot be combined with -append, whose settings are those of the video")
+		}
+		for _, input_file := range inputs {
+			if input_file == stdinInput || isRemote(input_file) {
+				c.usageError("The -target-duration flag needs local input files, whose size is known up front")
+			}
+		}
+	}
+
+	var profiles []string
+	if c.opts.profile != "" {
+		if profiles, err = parseProfiles(c.opts.profile); err != nil {
+			c.usageError(err.Error())
+		}
+	}
+
+	// Interrupting the program shuts the pipeline and ffmpeg down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The profiles cover every input, and are written before the program
+	// exits whether the run failed or not
+	stopProfiles := func() {}
+	if profiles != nil {
+		if stopProfiles, err = startProfiles(profiles); err != nil {
+			logger.fatal("profile", err)
+		}
+		c.opts.timings = &stageTimings{}
+	}
+	finish := func() {
+		if c.opts.timings != nil {
+			c.opts.timings.log()
+		}
+		stopProfiles()
+	}
+
+	if show_progress {
+		if mode {
+			c.opts.onProgress = terminalProgress(os.Stderr, "ffmpeg", "digester", "writer")
+		} else {
+			c.opts.onProgress = terminalProgress(os.Stderr, "reader", "serializer", "ffmpeg")
+		}
+	}
+	var dash *dashboard
+	if show_tui {
+		if mode {
+			dash = newDashboard(os.Stderr, "decode "+jobs[0].input, &c.opts, "ffmpeg", "digester", "writer")
+		} else {
+			dash = newDashboard(os.Stderr, "encode "+jobs[0].input, &c.opts, "reader", "serializer", "ffmpeg")
+		}
+	}
+
+	run := func(ctx context.Context, job batchJob, opts options) error {
+		if mode && opts.rangeLength > 0 {
+			return decodeRange(ctx, job.input, job.output, opts)
+		}
+		if mode && job.output == stdoutOutput {
+			r, err := newReader(ctx, job.input, opts)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			_, err = io.Copy(os.Stdout, r)
+			return err
+		}
+		if mode && opts.payloadFormat == payloadTar && isArchiveDir(job.output) {
+			return extractArchive(job.output, opts.overwrite, func(dest string) error {
+				if split_parts != nil {
+					return decodeSplit(ctx, split_parts, dest, opts)
+				}
+				return decode(ctx, job.input, dest, opts)
+			})
+		}
+		if mode && split_parts != nil {
+			return decodeSplit(ctx, split_parts, job.output, opts)
+		}
+		if mode {
+			return decode(ctx, job.input, job.output, opts)
+		}
+		if target_duration > 0 {
+			info, err := os.Stat(job.input)
+			if err != nil {
+				return &stageError{stage: "reader", err: err}
+			}
+			headerSize := newStreamHeader(info.Size(), metadataOf(info)).size
+			if opts, err = fitDuration(info.Size(), headerSize, target_duration, opts, c.explicit); err != nil {
+				return &stageError{stage: "encode", err: err}
+			}
+			logger.info("encode", "settings picked for the target duration, decode with the same -dot and -dot-bits", fields{"input": job.input, "dot": opts.dotSize, "dot_bits": opts.dotBits, "fps": opts.fps})
+		}
+		encodeJob := encode
+		if job.input == stdinInput && opts.segments == 1 && opts.appendTo == "" && opts.deltaBase == "" && opts.parity == "" && opts.manifest == "" {
+			// Frames go out as the input comes in
+			encodeJob = func(ctx context.Context, _, dest string, opts options) error {
+				return encodeReader(ctx, os.Stdin, dest, opts)
+			}
+		}
+		if err := encodeJob(ctx, job.input, job.output, opts); err != nil {
+			return err
+		}
+		if upload_target != "" {
+			location, err := upload(ctx, job.output, upload_target, opts)
+			if err != nil {
+				return &stageError{stage: "upload", err: err}
+			}
+			logger.info("upload", "video uploaded", fields{"input": job.input, "location": location})
+		}
+		return nil
+	}
+
+	if !batch {
+		err := run(ctx, jobs[0], c.opts)
+		if dash != nil {
+			dash.close()
+		}
+		finish()
+		if err != nil {
+			if mode {
+				logger.fatal("decode", err)
+			}
+			logger.fatal("encode", err)
+		}
+		return
+	}
+	failed := runBatch(ctx, jobs, parallel_jobs, c.opts, run)
+	finish()
+	if ctx.Err() != nil {
+		logger.fatal("batch", errors.New("interrupted"))
+	}
+	if failed > 0 {
+		logger.fatal("batch", fmt.Errorf("%d of %d inputs failed", failed, len(jobs)))
+	}
+	logger.info("batch", "all inputs done", fields{"inputs": len(jobs)})
+}
+
+// mainCLI defines the flags of encoding and decoding without a subcommand.
+func mainCLI(name string, mode *bool, input_files *inputList, output_file *string, parallel_jobs *int, show_progress, show_tui, force *bool, upload_target *string, target_duration *time.Duration) *cli {
+	c := newCLI(name)
+	c.flags.BoolVar(mode, "d", false, "Changes mode to decode")
+	c.flags.Var(input_files, "i", "Path to the input file, - for standard input when encoding; may be a glob or given several times to process many files")
+	c.flags.StringVar(output_file, "o", "", "Path to the output file, when decoding defaults to the original file name and - writes to standard output; with several inputs {name} and {stem} stand for the input's file name with and without extension")
+	c.flags.IntVar(parallel_jobs, "jobs", 0, "Number of inputs processed at once when there are several, 0 for one per CPU")
+	c.flags.BoolVar(show_progress, "progress", false, "Show a live progress line on stderr")
+	c.flags.BoolVar(show_tui, "tui", false, "Show a live dashboard on stderr, which must be a terminal, with the frames, frames per second and queue of every stage, ffmpeg's status, an ETA and the latest events")
+	c.flags.StringVar(&c.opts.reportPath, "report", "", "Write a JSON report of the errors found and corrected in every frame when decoding")
+	c.flags.StringVar(&c.opts.manifest, "manifest", "", "When encoding, also write a JSON manifest of the settings, stream header, payload hash and frames of the video to this path, such as out.mp4.json; when decoding, take the settings from it and use its header and hash where the video's are damaged")
+	c.flags.BoolVar(&c.opts.partial, "partial", false, "When decoding a damaged video, write what can be recovered with the gaps zeroed and a hole map next to it")
+	c.flags.StringVar(&c.opts.payloadFormat, "format", payloadRaw, "What the input is: raw, or tar to check that it is a tar archive and mark the video so, such as tar cf - dir piped to -i -, or to archive the directory given to -i with its symlinks, empty directories and sparse files; when decoding, tar refuses videos not marked so, for -o - piped to tar xf -, and extracts them into a directory -o names")
+	c.flags.BoolVar(&c.opts.intro, "intro", c.opts.intro, "When encoding, start the video with a few seconds of readable text naming the file, its size, the date and how to decode it, for whoever finds the video without knowing what it is")
+	c.flags.BoolVar(&c.opts.dropDuplicates, "drop-duplicates", false, "When decoding, drop frames that repeat the one before them, as screen recorders and editors add to videos of variable frame rate")
+	c.flags.StringVar(&c.opts.profile, "profile", "", "Profile the run: cpu, mem or trace, or several comma-separated, written to filetovideo.cpu.pprof, filetovideo.mem.pprof and filetovideo.trace in the current directory; also logs the time the stages spent reading, serializing, waiting on ffmpeg and the reorder window, digesting and writing")
+	c.flags.BoolVar(&c.opts.live, "live", false, "Decode from a live stream or a file still being written, starting with the next video if joined in the middle of one")
+	c.flags.BoolVar(force, "force", false, "Overwrite the output if it already exists")
+	c.flags.StringVar(&c.opts.appendTo, "append", "", "Encode the input as a continuation of this video, -o gets both; the settings must match the ones it was encoded with")
+	c.flags.StringVar(&c.opts.deltaBase, "base", "", "Encode only the changes to the input since this earlier version of it; when decoding such a video, the video of that version")
+	c.flags.StringVar(&c.opts.parity, "parity", "", "Also write a video of Reed-Solomon parity over the input, this share of its size such as 10%, named after -o with .parity before the extension; when decoding, the parity video to repair the video with")
+	c.flags.BoolVar(&c.opts.split, "split", false, "With -segments, keep the segments as videos of their own named after -o; when decoding, the inputs are the parts of such a video and are decoded at once")
+	c.flags.StringVar(upload_target, "upload", "", "Upload the video after encoding: youtube, rclone:remote:path or an http(s) URL")
+	c.flags.Int64Var(&c.opts.rangeOffset, "offset", 0, "When decoding, start at this byte of the payload; with -length only the frames holding the range are decoded, ffmpeg seeking to the first of them by -fps")
+	c.flags.Int64Var(&c.opts.rangeLength, "length", 0, "When decoding, write only this many bytes of the payload from -offset on")
+	c.flags.DurationVar(target_duration, "target-duration", 0, "When encoding, pick the dot size, bits per dot and frame rate fitting the input in about this much video, such as 10m; decoding needs the -dot and -dot-bits picked")
+	return c
+}
+
+// cli holds the flags shared by the default mode and every subcommand.
+type cli struct {
+	flags     *flag.FlagSet
+	opts      options
+	explicit  map[string]bool // Flags given on the command line
+	configErr error
+	logFormat string
+	preset    string
+	channel   string
+	quiet     bool
+	verbose   bool
+	debug     bool
+}
+
+func newCLI(name string) *cli {
+	c := &cli{flags: flag.NewFlagSet(name, flag.ExitOnError)}
+
+	// Config file and environment provide the flag defaults
+	c.opts = defaultOptions()
+	c.configErr = loadConfig(&c.opts)
+
+	c.flags.IntVar(&c.opts.threads, "t", c.opts.threads, "Number of pixel worker threads")
+	c.flags.IntVar(&c.opts.readers, "readers", c.opts.readers, "Number of file reader threads when encoding")
+	c.flags.IntVar(&c.opts.writers, "writers", c.opts.writers, "Number of file writer threads when decoding")
+	c.flags.StringVar(&c.opts.codec, "codec", c.opts.codec, "Video codec used by ffmpeg when encoding, auto picks the fastest available H.264 encoder, vp9 and av1 one of those formats; ffv1 and libx264rgb are lossless and need no ECC, pass the same one when decoding")
+	c.flags.StringVar(&c.opts.bitrate, "bitrate", c.opts.bitrate, "Video bitrate used by ffmpeg when encoding")
+	c.flags.IntVar(&c.opts.gop, "gop", c.opts.gop, "Keyframe interval (keyint) in frames when encoding; shorter GOPs keep every frame closer to what was encoded through re-encoding platforms, at the cost of size")
+	c.flags.IntVar(&c.opts.bframes, "bframes", c.opts.bframes, "B-frames between reference frames when encoding, 0 for none, -1 for the encoder's default; H.264 and HEVC only")
+	c.flags.IntVar(&c.opts.fps, "fps", c.opts.fps, "Frames per second of the video when encoding; when decoding, only the times in -report and the summary use it")
+	c.flags.Var(sizeValue{&c.opts}, "size", "Frame size as WIDTHxHEIGHT, such as 1080x1920 for portrait video; must match when decoding")
+	c.flags.IntVar(&c.opts.dotSize, "dot", c.opts.dotSize, "Size of a single data dot in pixels")
+	c.flags.IntVar(&c.opts.dotBits, "dot-bits", c.opts.dotBits, "Bits every dot carries: 3, or 24 for lossless and very high bitrate videos; must match when decoding")
+	c.flags.StringVar(&c.opts.modulation, "modulation", c.opts.modulation, "How frames carry the data: dots, or dct to hide it in the low frequencies lossy codecs keep; must match when decoding")
+	c.flags.Var(eccValue{&c.opts}, "ecc", "Reed-Solomon parity bytes per 255 byte codeword of every frame, 0 for none (32 when -dot-bits is 24, where it is mandatory unless the codec is lossless), or hamming for a cheap code correcting single flipped bits at twice the size; must match when decoding")
+	c.flags.IntVar(&c.opts.interleave, "interleave", c.opts.interleave, "Number of frames each block of data is spread over, must match when decoding")
+	c.flags.BoolVar(&c.opts.frameStrip, "frame-strip", c.opts.frameStrip, "Reserve the top rows of every frame for its index, offset and CRC, which decoding uses to order and check frames; must match when decoding")
+	c.flags.IntVar(&c.opts.repeat, "repeat", c.opts.repeat, "Number of times every frame is written, decoding takes a majority vote; must match when decoding")
+	c.flags.StringVar(&c.opts.transport, "transport", c.opts.transport, "What the frames go through: ffmpeg, images for a directory of PNG frames in place of the video, or y4m for an uncompressed YUV4MPEG2 stream")
+	c.flags.StringVar(&c.opts.container, "container", c.opts.container, "Container of the video when encoding: mp4 (with the index up front for streaming), mkv or webm, which takes VP8, VP9 or AV1 and makes -codec auto pick a VP9 encoder; by default the extension of -o picks it")
+	c.flags.StringVar(&c.opts.ffmpegPath, "ffmpeg", c.opts.ffmpegPath, "Path to the ffmpeg binary")
+	c.flags.BoolVar(&c.opts.reproducible, "reproducible", c.opts.reproducible, "Encode the same input with the same settings into byte-identical videos: a software encoder with pinned threads, bitexact flags and no timestamps in the container or the intro")
+	c.flags.Var(argsValue{&c.opts}, "ffmpeg-args", "Extra arguments for the ffmpeg encoding or decoding the frames, quoted like in a shell and added before the output, such as filters or container flags")
+	c.flags.BoolVar(&c.opts.mmap, "mmap", c.opts.mmap, "Memory-map the input file when encoding instead of reading it")
+	c.flags.IntVar(&c.opts.segments, "segments", c.opts.segments, "Number of ffmpeg processes encoding parts of the video in parallel")
+	c.flags.BoolVar(&c.opts.restoreMetadata, "restore", c.opts.restoreMetadata, "Restore the mode bits and modification time of the original file when decoding")
+	c.flags.IntVar(&c.opts.reorderWindow, "window", c.opts.reorderWindow, "Maximum number of frames buffered out of order")
+	c.flags.IntVar(&c.opts.queueDepth, "queue-depth", c.opts.queueDepth, "Number of frames buffered between two stages of the pipeline, 0 to hand them over in lockstep")
+	c.flags.Int64Var(&c.opts.maxLength, "max-length", c.opts.maxLength, "Largest payload in bytes a video may declare when decoding, 0 for no limit")
+	c.flags.BoolVar(&c.opts.nice, "nice", c.opts.nice, "Run ffmpeg at a lower priority so other programs stay responsive")
+	c.flags.Float64Var(&c.opts.maxThroughput, "max-throughput", c.opts.maxThroughput, "Most frames per second the pipeline processes, 0 for no limit")
+	c.flags.Var(memoryValue{&c.opts}, "max-memory", "Most memory a run takes, such as 512M, shrinking the queues, reorder window, segments and worker threads to fit; 0 for no limit")
+	c.flags.StringVar(&c.preset, "preset", "", "Named group of settings for a target platform: "+presetNames())
+	c.flags.StringVar(&c.channel, "channel", "", "Transport the video goes through, picks dot size, bits per dot, repetition and ECC: "+channelNames()+"; must match when decoding")
+	c.flags.StringVar(&c.logFormat, "log-format", "text", "Log output format: text or json")
+	c.flags.BoolVar(&c.quiet, "q", false, "Quiet, only print errors")
+	c.flags.BoolVar(&c.verbose, "v", false, "Verbose, print per-stage timings and ffmpeg output")
+	c.flags.BoolVar(&c.debug, "vv", false, "Very verbose, additionally print per-frame events")
+	return c
+}
+
+// parse parses args, configures the logger and validates the options.
+func (c *cli) parse(args []string) {
+	c.flags.Parse(args)
+
+	format, err := parseLogFormat(c.logFormat)
+	if err != nil {
+		c.usageError(err.Error())
+	}
+	logger.format = format
+
+	if c.quiet && (c.verbose || c.debug) {
+		c.usageError("The -q flag cannot be combined with -v or -vv")
+	}
+	switch {
+	case c.quiet:
+		logger.level = levelError
+	case c.debug:
+		logger.level = levelDebug
+	case c.verbose:
+		logger.level = levelVerbose
+	}
+
+	if c.configErr != nil {
+		logger.fatal("config", c.configErr)
+	}
+
+	c.explicit = map[string]bool{}
+	c.flags.Visit(func(f *flag.Flag) { c.explicit[f.Name] = true })
+	if c.preset != "" {
+		if err := applyPreset(&c.opts, c.preset, c.explicit); err != nil {
+			c.usageError(err.Error())
+		}
+	}
+	if c.channel != "" {
+		if err := applyChannel(&c.opts, c.channel, c.explicit); err != nil {
+			c.usageError(err.Error())
+		}
+	}
+
+	if err := c.opts.validate(); err != nil {
+		c.usageError(err.Error())
+	}
+	if c.opts.maxMemory > 0 {
+		if err := c.opts.fitMemory(); err != nil {
+			c.usageError(err.Error())
+		}
+		logger.verbose("memory", "pipeline sized for -max-memory", memoryFields(c.opts))
+		// The collector works harder rather than let garbage go past it
+		debug.SetMemoryLimit(c.opts.maxMemory)
+	}
+}
+
+// usageError reports a command line mistake and exits. The flag listing is
+// only printed for humans, json consumers get the error event alone.
+func (c *cli) usageError(msg string) {
+	logger.reportError("cli", withCause(errUsage, errors.New(msg)), true)
+	if logger.format == logText {
+		c.flags.PrintDefaults()
+	}
+	os.Exit(exitUsage)
+}
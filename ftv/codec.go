@@ -0,0 +1,1448 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rgbFrameSize returns the size of a frame as ffmpeg hands it to decode, 3
+// bytes per pixel.
+func rgbFrameSize(opts options) int {
+	return geometryOf(opts).frameBytes(3)
+}
+
+// rawFrameSize returns how many bytes the modulation of opts fits in a
+// frame.
+func rawFrameSize(opts options) int {
+	return modulatorOf(opts).Capacity()
+}
+
+// frameDataSize returns how many bytes a frame of opts carries, the stream
+// and the frame number, which is what the frame's buffers are made of.
+func frameDataSize(opts options) int {
+	if ecc := opts.frameECC(); ecc != nil {
+		return ecc.dataSize()
+	}
+	return rawFrameSize(opts)
+}
+
+// frameCapacity returns how many bytes of the stream fit into a single frame,
+// which is less than it carries when ECC is used or the frame is numbered.
+func frameCapacity(opts options) int {
+	if opts.frameNumbers {
+		return frameDataSize(opts) - frameNumberSize
+	}
+	return frameDataSize(opts)
+}
+
+// framesNeeded returns how many frames a stream (header and payload) of
+// streamLength bytes occupies, including interleaving padding.
+func framesNeeded(streamLength int64, opts options) int64 {
+	frames := streamFrames(streamLength, frameCapacity(opts))
+	return interleavedFrames(frames, opts.interleave)
+}
+
+type frameData struct {
+	frameID int
+	value   []byte
+}
+
+// --- Encode
+
+// videoEncoder is what the ffmpeg processes of an encode are started with.
+type videoEncoder struct {
+	codec       string
+	initArgs    []string
+	filter      string
+	pixelFormat string
+}
+
+// losslessCodecs keep every pixel as it was encoded, so the frames need no
+// ECC: ffv1 always, libx264rgb at -qp 0, which command asks it for.
+var losslessCodecs = map[string]bool{"ffv1": true, "libx264rgb": true}
+
+// newVideoEncoder picks the encoder of opts.
+func newVideoEncoder(ctx context.Context, opts options) (*videoEncoder, error) {
+	codec, err := resolveCodec(ctx, opts.ffmpegPath, opts.codec, opts.reproducible)
+	if err != nil {
+		return nil, err
+	}
+	e := &videoEncoder{codec: codec}
+	e.initArgs, e.filter = encoderArgs(codec)
+	if e.filter == "" {
+		if e.pixelFormat, err = pickPixelFormat(ctx, opts, codec); err != nil {
+			return nil, err
+		}
+		logger.verbose("ffmpeg", "pixel format", fields{"codec": codec, "format": e.pixelFormat})
+	}
+	return e, nil
+}
+
+// command returns the ffmpeg encoding raw RGBA frames from its standard
+// input to output.
+func (e *videoEncoder) command(ctx context.Context, opts options, output string) *exec.Cmd {
+	liveMuxer, _ := liveFormat(output)
+	args := append([]string{}, e.initArgs...)
+	if liveMuxer != "" {
+		args = append(args, "-re") // Frames go out in real time
+	}
+	args = append(args,
+		"-y",             // Overwrite output file if it exists
+		"-f", "rawvideo", // Input format as raw video
+		"-pix_fmt", "rgba", // Pixel format as RGBA
+		"-s", fmt.Sprintf("%dx%d", opts.width, opts.height), // Video size
+		"-framerate", strconv.Itoa(opts.fps), // Frame rate
+		"-i", "-", // Read input from pipe
+	)
+	colorFilter, colorTags := encodeColor(e.pixelFormat)
+	var filters []string
+	if colorFilter != "" {
+		filters = append(filters, colorFilter)
+	}
+	if e.filter != "" {
+		filters = append(filters, e.filter) // Upload frames for hardware encoders that need it
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	if e.filter == "" && e.pixelFormat != "" {
+		args = append(args, "-pix_fmt", e.pixelFormat)
+	}
+	args = append(args, colorTags...)
+	args = append(args, "-sws_flags", scalerFlags) // Keep the colors of neighbouring dots apart
+	args = append(args, "-c:v", e.codec)           // Output codec, the fastest available one by default
+	switch {
+	case e.codec == "libx264rgb":
+		args = append(args, "-qp", "0") // Lossless
+	case !losslessCodecs[e.codec]:
+		args = append(args, "-b:v", opts.bitrate) // Output bitrate, 30 Mbps by default
+	}
+	args = append(args,
+		"-r", strconv.Itoa(opts.fps),
+		"-g", strconv.Itoa(opts.gop),
+		"-an", // Disable audio processing
+	)
+	if opts.bframes >= 0 {
+		args = append(args, "-bf", strconv.Itoa(opts.bframes))
+	}
+	args = append(args, encoderSpeed(e.codec, opts.gop)...)
+	if opts.reproducible {
+		args = append(args, "-threads", strconv.Itoa(reproducibleThreads), "-flags:v", "+bitexact")
+	}
+	if liveMuxer != "" {
+		args = append(args, "-f", liveMuxer)
+	}
+	args = append(args, muxerArgs(output, opts)...)
+	args = append(args, opts.ffmpegArgs...)
+	args = append(args, output) // Output file path or stream URL
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath, args...)
+	if opts.nice {
+		lowerPriority(cmd)
+	}
+	return cmd
+}
+
+// reproducibleThreads is the encoder threads of -reproducible. How libx264
+// and libvpx split the work between threads shows in what they write, so
+// their count cannot follow the machine.
+const reproducibleThreads = 8
+
+// encoderSpeed returns the options making codec encode fast, and the
+// keyframe interval libx264 does not take from -g alone. libvpx and libaom
+// trade speed for size with -cpu-used and SVT-AV1 with a numbered preset,
+// the other encoders take the fast preset.
+func encoderSpeed(codec string, gop int) []string {
+	switch codec {
+	case "libvpx-vp9", "libvpx":
+		// Aim at the bitrate in one pass, on all cores
+		return []string{"-deadline", "good", "-cpu-used", "4", "-row-mt", "1"}
+	case "libaom-av1":
+		return []string{"-cpu-used", "6", "-row-mt", "1"}
+	case "libsvtav1":
+		return []string{"-preset", "8"}
+	}
+	return []string{"-x264opts", "keyint=" + strconv.Itoa(gop), "-preset", "fast"}
+}
+
+// frameSerializer paints the frames of the stream. Every serializer worker
+// has its own, for the buffers.
+type frameSerializer struct {
+	opts      options
+	geometry  frameGeometry
+	modulator frameModulator
+	ecc       *frameECC
+	capacity  int
+	data, raw []byte // The frame padded to its capacity and numbered, and with the ECC
+}
+
+func newFrameSerializer(opts options) *frameSerializer {
+	s := &frameSerializer{opts: opts, geometry: geometryOf(opts), modulator: modulatorOf(opts), ecc: opts.frameECC(), capacity: frameCapacity(opts)}
+	if s.ecc != nil || opts.frameStrip || opts.frameNumbers {
+		s.data = make([]byte, frameDataSize(opts))
+	}
+	if s.ecc != nil {
+		s.raw = make([]byte, rawFrameSize(opts))
+	}
+	return s
+}
+
+// serialize paints frame, frame id of the video, into the zeroed RGBA frame
+// pixelData.
+func (s *frameSerializer) serialize(id int, frame, pixelData []byte) {
+	bits := frame
+	if s.data != nil {
+		n := copy(s.data[:s.capacity], frame)
+		for i := n; i < len(s.data); i++ {
+			s.data[i] = 0
+		}
+		if s.opts.frameNumbers {
+			putFrameNumber(s.data, s.capacity, id)
+		}
+		bits = s.data
+	}
+	if s.ecc != nil {
+		s.ecc.encode(s.data, s.raw)
+		bits = s.raw
+	}
+	if s.opts.frameStrip {
+		// The CRC covers the padding too, decode reads the whole frame
+		strip := newFrameStrip(id, s.data, s.capacity, s.opts.interleave)
+		writeStrip(strip, pixelData, s.geometry)
+	}
+	s.modulator.PackFrame(bits, pixelData)
+}
+
+// encode turns srcFile into the video destFile. Cancelling ctx stops every
+// stage of the pipeline and kills ffmpeg.
+func encode(ctx context.Context, srcFile, destFile string, opts options) error {
+	// A part appended to a video from before v6 leaves its frames unnumbered
+	// like the video's
+	var base *streamTrailer
+	if opts.appendTo != "" {
+		trailer, numbered, err := readStreamEnd(ctx, opts.appendTo, opts)
+		if err != nil {
+			return &stageError{stage: "ffmpeg", err: fmt.Errorf("reading the end of %s: %w", opts.appendTo, err)}
+		}
+		base = trailer
+		opts.frameNumbers = numbered
+	}
+	geometry := geometryOf(opts)
+	processedBytesPerFrame := frameCapacity(opts)
+
+	start := time.Now()
+
+	payloadFile := srcFile
+	if srcFile == stdinInput {
+		spooled, err := spoolStdin()
+		if err != nil {
+			return &stageError{stage: "reader", err: err}
+		}
+		defer os.Remove(spooled)
+		payloadFile = spooled
+	}
+	// A directory is archived first, see archive.go
+	archived := false
+	if opts.payloadFormat == payloadTar && srcFile != stdinInput && !isRemote(srcFile) {
+		if info, err := os.Stat(srcFile); err == nil && info.IsDir() {
+			archive, err := archiveDirectory(srcFile)
+			if err != nil {
+				return &stageError{stage: "reader", err: err}
+			}
+			defer os.Remove(archive)
+			payloadFile = archive
+			archived = true
+		}
+	}
+	var tarFiles []tarEntry
+	if opts.payloadFormat == payloadTar {
+		entries, err := checkTarFile(payloadFile)
+		if err != nil {
+			return &stageError{stage: "reader", err: err}
+		}
+		tarFiles = entries
+	}
+	// Against a base only the delta is encoded, under the metadata of the
+	// input
+	if opts.deltaBase != "" {
+		deltaFile, err := writeDelta(payloadFile, opts.deltaBase)
+		if err != nil {
+			return &stageError{stage: "reader", err: fmt.Errorf("computing the delta: %w", err)}
+		}
+		defer os.Remove(deltaFile)
+		payloadFile = deltaFile
+	}
+	input, err := openPayload(ctx, payloadFile, processedBytesPerFrame, opts.mmap)
+	if err != nil {
+		return &stageError{stage: "reader", err: err}
+	}
+	defer input.Close()
+	payloadSize := input.size()
+	metadata, err := sourceMetadata(srcFile)
+	if err != nil {
+		return &stageError{stage: "reader", err: err}
+	}
+	if archived {
+		metadata.name += ".tar"
+		metadata.mode = 0o644
+	}
+	h := newStreamHeader(payloadSize, metadata)
+	h.delta = opts.deltaBase != ""
+	h.dct = opts.modulation == modulationDCT
+	h.strip = opts.frameStrip
+	h.tar = opts.payloadFormat == payloadTar
+	header := h.marshal()
+
+	// An appended part continues after the trailer of the video, the frames
+	// up to the next interleaved block repeat that trailer
+	var (
+		firstFrame   int // Of the video in the stream
+		fillers      [][]byte
+		previousHash []byte
+		totalLength  = payloadSize
+	)
+	if base != nil {
+		header = marshalPartHeader(base.length, payloadSize, false)
+		firstFrame = base.frame + 1
+		for id := firstFrame; id < int(interleavedFrames(int64(firstFrame), opts.interleave)); id++ {
+			fillers = append(fillers, (&streamTrailer{length: base.length, frame: id, hash: base.hash}).marshal())
+		}
+		previousHash = base.hash[:]
+		totalLength += base.length
+		logger.verbose("reader", "appending", fields{"video": opts.appendTo, "offset": base.length, "first_frame": firstFrame})
+	}
+	input.setHeader(header)
+	streamLength := payloadSize + int64(len(header))
+	streamFrameCount := int(streamFrames(streamLength, processedBytesPerFrame))
+
+	dataFrames := int(framesNeeded(streamLength, opts))
+	trailerFrame := len(fillers) + dataFrames
+	totalFrames := trailerFrame + 1
+	isPayload := func(id int) bool { return id >= len(fillers) && id < trailerFrame }
+	segments := newSegmentPlan(totalFrames, opts.segments)
+
+	// The trailer closes the video with the hash of the payload, so a video
+	// cut short is noticed on decode. The payload is hashed as it is read,
+	// the trailer is the one frame waiting for it.
+	hashed := newHashedPayload(input, segments.count == 1, processedBytesPerFrame, len(header), streamFrameCount, previousHash)
+	defer hashed.close()
+	input = hashed
+
+	if opts.interleave > 1 {
+		input = newInterleavedPayload(input, opts.interleave, processedBytesPerFrame, streamFrameCount)
+	}
+
+	output, err := prepareVideoOutput(destFile, opts)
+	if err != nil {
+		return &stageError{stage: "ffmpeg", err: err}
+	}
+	defer output.cleanup()
+	if isLive(destFile) && (opts.segments > 1 || base != nil) {
+		return &stageError{stage: "ffmpeg", err: errors.New("a live stream cannot be split into segments or appended to")}
+	}
+
+	transport := transportOf(opts)
+
+	// Serialized frames are recycled once ffmpeg has consumed them
+	pixelBuffers := newFramePool(geometry.frameBytes(4))
+
+	// With several segments every one is encoded by its own ffmpeg into a
+	// part file, the parts are joined once all of them are done unless they
+	// are to be kept as videos of their own
+	outputs := []string{output.path}
+	keepSegments := false
+	if segments.count > 1 || base != nil {
+		outputs = segmentPaths(output.path, segments.count)
+		defer func() {
+			if !keepSegments {
+				removeFiles(outputs)
+			}
+		}()
+	}
+	reorders := make([]*reorderBuffer, segments.count)
+	for s := range reorders {
+		reorders[s] = newReorderBuffer(opts.reorderWindow)
+		reorders[s].next = segments.first(s)
+	}
+
+	progress := newProgress(opts.onProgress, "reader", "serializer", "ffmpeg")
+	progress.setTotal(int64(totalFrames))
+	throttle := newThrottle(opts.maxThroughput)
+
+	p := newPipeline(ctx)
+	go func() {
+		<-p.ctx.Done()
+		for _, reorder := range reorders {
+			reorder.abort()
+		}
+	}()
+
+	// Each reader handles every opts.readers-th frame starting at first, in
+	// an order that alternates between the segments so all of them progress
+	reader := func(worker, first int, framesChanOut chan<- frameData, wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		stats := newStageStats()
+		for i := first; i < totalFrames; i += opts.readers {
+			id := segments.frame(i)
+			waiting := time.Now()
+			if !reorders[segments.of(id)].wait(id) { // Backpressure when ffmpeg falls behind
+				return
+			}
+			opts.timings.since(phaseReorder, waiting)
+			if !throttle.wait(p.ctx) {
+				return
+			}
+			stats.blockedSince(waiting)
+			stats.add(id)
+
+			reading := time.Now()
+			var frame []byte
+			if id < len(fillers) {
+				frame = fillers[id]
+			} else if isPayload(id) {
+				var err error
+				if frame, err = input.frame(id - len(fillers)); err != nil {
+					p.fail("reader", fmt.Errorf("reading file: %w", err))
+					return
+				}
+			} else {
+				hash, err := hashed.hash(p.ctx)
+				if err != nil {
+					p.fail("reader", fmt.Errorf("hashing file: %w", err))
+					return
+				}
+				frame = (&streamTrailer{length: totalLength, frame: firstFrame + trailerFrame, hash: hash}).marshal()
+			}
+			opts.timings.since(phaseRead, reading)
+			sending := time.Now()
+			if !p.send(framesChanOut, frameData{frameID: id, value: frame}) {
+				return
+			}
+			stats.blockedSince(sending)
+			progress.add("reader")
+		}
+		logger.verbose("reader", "worker done", stats.fields(worker))
+	}
+
+	ffmpegInstance := func(segment int, framesChanIn <-chan frameData, wg *sync.WaitGroup) {
+		start := time.Now()
+		defer wg.Done()
+		reorder := reorders[segment]
+
+		sink, err := transport.NewSink(p.ctx, outputs[segment], opts)
+		if err != nil {
+			p.fail("ffmpeg", err)
+			return
+		}
+		logger.verbose("ffmpeg", "opened", fields{"segment": segment, "elapsed": time.Since(start)})
+		written := 0
+
+		var writeErr error
+		if segment == 0 && base == nil && opts.intro {
+			introSize := payloadSize
+			if opts.deltaBase != "" {
+				introSize = -1 // Of the delta, not the file
+			}
+			writeErr = writeIntro(sink, metadata, introSize, opts)
+		}
+	frames:
+		for frame := range framesChanIn {
+			if writeErr != nil {
+				break
+			}
+			reorder.push(frame)
+			for next, ok := reorder.pop(); ok; next, ok = reorder.pop() {
+				writing := time.Now()
+				for n := 0; n < opts.repeat; n++ {
+					if writeErr = sink.WriteFrame(next.value); writeErr != nil {
+						break frames
+					}
+				}
+				opts.timings.since(phaseFFmpeg, writing)
+				pixelBuffers.put(next.value)
+				written++
+				progress.add("ffmpeg")
+			}
+		}
+
+		// The sink knows better why a write failed
+		if err := sink.Close(); err != nil {
+			p.fail("ffmpeg", err)
+			return
+		}
+		if writeErr != nil {
+			p.fail("ffmpeg", writeErr)
+			return
+		}
+		logger.verbose("ffmpeg", "finished", fields{"segment": segment, "frames": written, "elapsed": time.Since(start)})
+	}
+
+	serializer := func(worker int, framesChanIn <-chan frameData, frameProxyChans []chan frameData, wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		s := newFrameSerializer(opts)
+		stats := newStageStats()
+		for iddFrame := range framesChanIn {
+			stats.add(iddFrame.frameID)
+			frame := iddFrame.value
+			pixelData := pixelBuffers.get()
+			serializing := time.Now()
+			s.serialize(firstFrame+iddFrame.frameID, frame, pixelData)
+			opts.timings.since(phaseSerialize, serializing)
+			if isPayload(iddFrame.frameID) {
+				input.release(frame)
+			}
+			iddFrame.value = pixelData
+			logger.debug("serializer", "frame serialized", fields{"worker": worker, "frame": iddFrame.frameID})
+			sending := time.Now()
+			if !p.send(frameProxyChans[segments.of(iddFrame.frameID)], iddFrame) {
+				return
+			}
+			stats.blockedSince(sending)
+			progress.add("serializer")
+		}
+		logger.verbose("serializer", "worker done", stats.fields(worker))
+	}
+
+	// Initialize ffmpegInstance group, one per segment
+	var ffmpegWaitGroup sync.WaitGroup
+	ffmpegInputs := make([]chan frameData, segments.count)
+	for s := range ffmpegInputs {
+		ffmpegWaitGroup.Add(1)
+		ffmpegInputs[s] = make(chan frameData, opts.queueDepth)
+		go ffmpegInstance(s, ffmpegInputs[s], &ffmpegWaitGroup)
+	}
+
+	// Initialize serializer group
+	var serializerWaitGroup sync.WaitGroup
+	rawFramesChan := make(chan frameData, opts.queueDepth)
+	if opts.onQueues != nil {
+		opts.onQueues([]queueGauge{
+			{stage: "serializer", capacity: opts.queueDepth, length: func() int { return len(rawFramesChan) }},
+			{stage: "ffmpeg", capacity: opts.queueDepth * len(ffmpegInputs), length: func() int {
+				n := 0
+				for _, ch := range ffmpegInputs {
+					n += len(ch)
+				}
+				return n
+			}},
+		})
+	}
+	for w := 1; w <= opts.threads; w++ {
+		serializerWaitGroup.Add(1)
+		go serializer(w, rawFramesChan, ffmpegInputs, &serializerWaitGroup)
+	}
+
+	// Initialize reader group
+	var readerWaitGroup sync.WaitGroup
+	for r := 0; r < opts.readers; r++ {
+		readerWaitGroup.Add(1)
+		go reader(r+1, r, rawFramesChan, &readerWaitGroup)
+	}
+
+	readerWaitGroup.Wait()
+	close(rawFramesChan)
+	serializerWaitGroup.Wait()
+
+	if !p.failed() {
+		logger.verbose("serializer", "frames digested", fields{"frames": totalFrames, "elapsed": time.Since(start)})
+	}
+
+	for _, ffmpegInput := range ffmpegInputs {
+		close(ffmpegInput)
+	}
+	ffmpegWaitGroup.Wait()
+
+	if err := p.result(); err != nil {
+		return err
+	}
+	summary := runSummary{
+		mode:    "encode",
+		payload: payloadSize,
+		frames:  int64(totalFrames),
+		start:   start,
+		stages:  progress.throughput(),
+	}
+	if base != nil {
+		// The frames of the video appended to are copied as they are
+		if err := joinAppended(ctx, opts.appendTo, outputs, output.path, opts); err != nil {
+			return &stageError{stage: "ffmpeg", err: err}
+		}
+	} else if opts.split && segments.count > 1 {
+		keepSegments = true
+		if output.dest != "" {
+			kept := segmentPaths(output.dest, segments.count)
+			for i := range outputs {
+				if err := os.Rename(outputs[i], kept[i]); err != nil {
+					removeFiles(outputs[i:])
+					return &stageError{stage: "ffmpeg", err: err}
+				}
+			}
+			outputs = kept
+		}
+	} else if segments.count > 1 {
+		if err := concatSegments(ctx, outputs, output.path, opts); err != nil {
+			return &stageError{stage: "ffmpeg", err: err}
+		}
+	}
+	// A video of its own gets a chapter at every file of a tar payload,
+	// which an appended part or a video in parts cannot
+	if len(tarFiles) > 0 && base == nil && !keepSegments && opts.deltaBase == "" && opts.transport == transportFFmpeg && !isLive(destFile) {
+		chapters := tarChapters(tarFiles, len(header), totalFrames, opts)
+		if err := addChapters(ctx, output.path, chapters, opts); err != nil {
+			return &stageError{stage: "ffmpeg", err: err}
+		}
+	}
+	if keepSegments {
+		logger.info("encode", "video exported as separate parts", fields{"parts": outputs, "bytes": payloadSize, "frames": totalFrames, "elapsed": time.Since(start)})
+	} else {
+		if err := output.commit(ctx); err != nil {
+			return &stageError{stage: "upload", err: err}
+		}
+		logger.info("encode", "video exported successfully", fields{"output": destFile, "bytes": payloadSize, "frames": totalFrames, "elapsed": time.Since(start)})
+	}
+	if opts.manifest != "" {
+		hash, err := hashed.hash(ctx)
+		if err != nil {
+			return &stageError{stage: "reader", err: err}
+		}
+		if err := writeManifest(opts.manifest, newManifest(h, hash[:], dataFrames, opts)); err != nil {
+			return &stageError{stage: "encode", err: fmt.Errorf("writing the manifest: %w", err)}
+		}
+		logger.verbose("encode", "manifest written", fields{"path": opts.manifest})
+	}
+	summary.log(opts)
+	if opts.parity != "" {
+		if err := encodeParity(ctx, payloadFile, destFile, opts); err != nil {
+			return &stageError{stage: "parity", err: err}
+		}
+	}
+	return nil
+}
+
+// --- Decode
+
+// writeHoleMap stores the hole map of a partial recovery next to the output,
+// which may be remote.
+func writeHoleMap(ctx context.Context, dest string, holes []byte) error {
+	output, err := prepareOutput(dest)
+	if err != nil {
+		return err
+	}
+	defer output.cleanup()
+	if err := os.WriteFile(output.path, holes, 0o644); err != nil {
+		return err
+	}
+	return output.commit(ctx)
+}
+
+// digestFrame reads the dots of the copies of a frame into the zeroed data,
+// frameDataSize bytes, correcting them with ecc unless it is nil. raw is
+// where the dots are read to before the correction.
+func digestFrame(copies [][]byte, data, raw []byte, opts options, ecc *frameECC) frameRepair {
+	bits := data
+	if ecc != nil {
+		for i := range raw {
+			raw[i] = 0
+		}
+		bits = raw
+	}
+	modulatorOf(opts).UnpackFrame(copies, bits)
+	if ecc == nil {
+		return frameRepair{}
+	}
+	return ecc.decode(raw, data)
+}
+
+// majorityBit reads the bit carried by the channel byte at pos, by majority
+// vote when the frame was repeated. Ties are broken by the summed intensity.
+func majorityBit(copies [][]byte, pos int) bool {
+	if len(copies) == 1 {
+		return copies[0][pos]&0x80 != 0
+	}
+	votes, sum := 0, 0
+	for _, c := range copies {
+		if c[pos]&0x80 != 0 {
+			votes++
+		}
+		sum += int(c[pos])
+	}
+	if votes*2 == len(copies) {
+		return sum >= len(copies)*0x80
+	}
+	return votes*2 > len(copies)
+}
+
+// duplicateTolerance is the mean difference of the channels of two frames,
+// out of 255, below which they are the same frame coded twice.
+const duplicateTolerance = 2
+
+// sameFrame reports whether the frames a and b are the same up to what lossy
+// coding changes.
+func sameFrame(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	limit := len(a) * duplicateTolerance
+	diff := 0
+	for i := range a {
+		if a[i] > b[i] {
+			diff += int(a[i] - b[i])
+		} else {
+			diff += int(b[i] - a[i])
+		}
+		if diff >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// decode extracts the payload of the video srcFile into destFile, or into the
+// current directory under the original name if destFile is empty. Cancelling
+// ctx stops every stage of the pipeline and kills ffmpeg.
+// If the header cannot be read it retries with the dot grid detected in the
+// first frames, for videos a platform rescaled.
+func decode(ctx context.Context, srcFile, destFile string, opts options) error {
+	err := decodePayload(ctx, []string{srcFile}, destFile, nil, opts)
+	if !errors.Is(err, errHeaderUnread) || opts.transport != transportFFmpeg || opts.live || opts.sourceFilter != "" {
+		return err
+	}
+	guess, detectErr := detectGrid(ctx, srcFile, opts)
+	if detectErr != nil {
+		logger.verbose("decode", "no dot grid detected", fields{"error": detectErr})
+		return err
+	}
+	if guess.width == opts.width && guess.height == opts.height && guess.dotSize == opts.dotSize && guess.sourceFilter == "" {
+		return err // The grid is where it was looked for already
+	}
+	logger.info("decode", "header unreadable, retrying with the detected grid", fields{"size": fmt.Sprintf("%dx%d", guess.width, guess.height), "dot": guess.dotSize, "filter": guess.sourceFilter})
+	return decodePayload(ctx, []string{srcFile}, destFile, nil, guess)
+}
+
+// decodeSplit is decode for a video encoded with -split, given as its parts
+// in order. Every part gets an ffmpeg of its own, all of them run at once.
+func decodeSplit(ctx context.Context, parts []string, destFile string, opts options) error {
+	return decodePayload(ctx, parts, destFile, nil, opts)
+}
+
+// decodePayload is decode of the parts srcFiles, writing the payload to pipe
+// instead of a file if it is not nil. Frames are expected to carry their
+// number, a video from before v6 is decoded again without once its header
+// tells.
+func decodePayload(ctx context.Context, srcFiles []string, destFile string, pipe *payloadPipe, opts options) error {
+	err := decodeFrames(ctx, srcFiles, destFile, pipe, opts)
+	if !errors.Is(err, errFrameNumbers) {
+		return err
+	}
+	if opts.live {
+		return &stageError{stage: "writer", err: fmt.Errorf("the video was encoded before format v%d, whose frames carry no numbers, and cannot be decoded live: decode it once it is complete", numberedVersion)}
+	}
+	opts.frameNumbers = !opts.frameNumbers
+	logger.verbose("decode", "decoding again with the frame layout of the header", fields{"frame_numbers": opts.frameNumbers})
+	return decodeFrames(ctx, srcFiles, destFile, pipe, opts)
+}
+
+// decodeFrames is decodePayload with the frame layout of opts.
+func decodeFrames(ctx context.Context, srcFiles []string, destFile string, pipe *payloadPipe, opts options) error {
+	rawBytesPerFrame := rgbFrameSize(opts)
+	processedBytesPerFrame := frameCapacity(opts)
+	rawBytes := rawFrameSize(opts)
+	ecc := opts.frameECC()
+	start := time.Now()
+
+	// What the header declares is checked against the frames there are, if
+	// the transport can count them
+	sourceFrames, err := videoFrames(srcFiles, opts)
+	if err != nil {
+		return &stageError{stage: "ffmpeg", err: err}
+	}
+
+	// With a parity video, which is decoded first, the video is recovered
+	// like a partial one and then repaired
+	var parity *parityData
+	if opts.parity != "" {
+		if parity, err = loadParity(ctx, opts); err != nil {
+			return &stageError{stage: "parity", err: err}
+		}
+	}
+	recovering := opts.partial || parity != nil
+
+	// Without a destination the file gets its original name, which is only
+	// known once the header has been decoded
+	var file *os.File
+	var out payloadOutput
+	output := &outputFile{}
+	switch {
+	case pipe != nil:
+		out = pipe
+	case destFile == "":
+		file, err = os.CreateTemp(".", ".filetovideo-*")
+		if err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		output.path = file.Name()
+		defer os.Remove(output.path) // Renamed away on success
+		out = fileOutput{file}
+	default:
+		output, err = prepareOutput(destFile)
+		if err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		defer output.cleanup()
+		file, err = os.Create(output.path)
+		if err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		out = fileOutput{file}
+	}
+	progress := newProgress(opts.onProgress, "ffmpeg", "digester", "writer")
+	throttle := newThrottle(opts.maxThroughput)
+	p := newPipeline(ctx)
+
+	// Frames as read from ffmpeg go back to the pool once digested, digested
+	// frames once the writer is done with them
+	groupBuffers := newFramePool(rawBytesPerFrame * opts.repeat)
+	dataBuffers := newFramePool(frameDataSize(opts))
+
+	// The frames of a split video are numbered on from the end of the parts
+	// before, which all have as many frames. That number is only known once
+	// the first part's header has been read, until then the other parts wait.
+	var partFrames int
+	partFramesKnown := make(chan struct{})
+	if len(srcFiles) == 1 {
+		close(partFramesKnown)
+	}
+
+	// Platforms pad videos with frames of their own after the end of the
+	// stream. The header of every part tells how many frames the parts found
+	// so far take, the frames after them may be the next part or padding:
+	// they are kept until the writer found out, then dropped and ffmpeg is
+	// not read any further.
+	var knownFrames atomic.Int64
+	var streamEnded atomic.Bool
+	pastEnd := func(id int) bool {
+		end := knownFrames.Load()
+		return end > 0 && int64(id) >= end
+	}
+
+	// Ffmpeg instance runner goroutines, one per part
+	var ffmpegWaitGroup sync.WaitGroup
+	ffmpegWaitGroup.Add(len(srcFiles))
+	ffmpegOutputChan := make(chan frameData, opts.queueDepth)
+	for part, srcFile := range srcFiles {
+		go func(part int, srcFile string, ffmpegOutputChan chan<- frameData, wg *sync.WaitGroup) {
+			defer wg.Done()
+
+			var videoStart *liveSync
+			if opts.live {
+				videoStart = newLiveSync(opts, ecc)
+			}
+			source, err := transportOf(opts).NewSource(p.ctx, srcFile, opts)
+			if err != nil {
+				p.fail("ffmpeg", err)
+				return
+			}
+			logger.verbose("ffmpeg", "opened", fields{"part": part, "elapsed": time.Since(start)})
+
+			// With -repeat every frame is followed by its copies, all of them are
+			// handed to the digester together
+			buffer := groupBuffers.get()
+			frameCount := 0
+			firstFrame := 0           // Of the part in the video
+			var header [][]byte       // The first block of a split video, while it is read
+			var blocked time.Duration // Waiting for the digesters
+			var previous []byte       // The frame sent last, with -drop-duplicates
+			var previousHash uint64
+			duplicates := 0
+			hashSeed := maphash.MakeSeed()
+
+			var readErr error
+			intro := 0 // Frames of an -intro skipped
+		frames:
+			for {
+				for c := 0; c < opts.repeat; c++ {
+					reading := time.Now()
+					err := source.ReadFrame(buffer[c*rawBytesPerFrame : (c+1)*rawBytesPerFrame])
+					opts.timings.since(phaseFFmpeg, reading)
+					if err == nil && c == 0 && frameCount == 0 && isIntroFrame(buffer[:rawBytesPerFrame], opts) {
+						intro++
+						c--
+						continue
+					}
+					if err == io.EOF && c > 0 && (frameCount > 0 || part == 0) {
+						// The video ends in the middle of the copies of its last
+						// frame, the ones that made it still get a vote
+						if p.send(ffmpegOutputChan, frameData{frameID: firstFrame + frameCount, value: buffer[:c*rawBytesPerFrame]}) {
+							frameCount++
+							progress.add("ffmpeg")
+						}
+					}
+					if err != nil {
+						if err != io.EOF {
+							readErr = err
+						} else if header != nil || (part == 0 && frameCount == 0 && len(srcFiles) > 1) {
+							readErr = withCause(ErrIncomplete, errors.New("the first part of the video ends before its header"))
+						}
+						break frames
+					}
+				}
+				if streamEnded.Load() && pastEnd(firstFrame+frameCount) {
+					logger.verbose("ffmpeg", "end of the video reached, the frames after it are ignored", fields{"part": part, "frame": firstFrame + frameCount})
+					break
+				}
+
+				if videoStart != nil && videoStart.frames == 0 && !videoStart.check(buffer) {
+					continue
+				}
+				if videoStart != nil && frameCount == videoStart.frames && videoStart.more {
+					videoStart.nextPart(buffer)
+				}
+				if opts.dropDuplicates {
+					// Variable frame rate videos repeat frames to keep up a
+					// constant rate, which would shift every frame after them.
+					// Frame rate conversion repeats them exactly, which the hash
+					// finds without comparing the frames, repeats coded twice are
+					// only close
+					hash := maphash.Bytes(hashSeed, buffer)
+					if previous != nil && (hash == previousHash || sameFrame(buffer, previous)) {
+						duplicates++
+						logger.debug("ffmpeg", "duplicate frame dropped", fields{"part": part, "frame": firstFrame + frameCount, "exact": hash == previousHash})
+						continue
+					}
+					previous = append(previous[:0], buffer...)
+					previousHash = hash
+				}
+				if part == 0 && len(srcFiles) > 1 && frameCount < opts.interleave {
+					// The digester takes the buffer, the header is read off
+					// a copy
+					header = append(header, append([]byte(nil), buffer...))
+					if len(header) == opts.interleave {
+						if partFrames, readErr = splitVideoFrames(header, opts, ecc); readErr != nil {
+							break
+						}
+						partFrames = (partFrames + len(srcFiles) - 1) / len(srcFiles)
+						close(partFramesKnown)
+						header = nil
+					}
+				} else if part > 0 && frameCount == 0 {
+					select {
+					case <-partFramesKnown:
+					case <-p.ctx.Done():
+						break frames
+					}
+					firstFrame = part * partFrames
+				}
+				// Holding the frame back stalls ffmpeg as well
+				if !throttle.wait(p.ctx) {
+					break
+				}
+				// The digester returns the buffer once it is done with it
+				sending := time.Now()
+				if !p.send(ffmpegOutputChan, frameData{frameID: firstFrame + frameCount, value: buffer}) {
+					break
+				}
+				blocked += time.Since(sending)
+				frameCount++
+				progress.add("ffmpeg")
+				// A live source is left once the video is complete, ffmpeg would
+				// otherwise wait for more
+				if videoStart != nil && frameCount >= videoStart.frames && !videoStart.more {
+					break
+				}
+				buffer = groupBuffers.get()
+			}
+
+			if err := source.Close(); err != nil {
+				p.fail("ffmpeg", err)
+				return
+			}
+			if readErr != nil {
+				p.fail("ffmpeg", readErr)
+				return
+			}
+			if intro > 0 {
+				logger.verbose("ffmpeg", "intro skipped", fields{"part": part, "frames": intro})
+			}
+			logger.verbose("ffmpeg", "finished", fields{"part": part, "frames": frameCount, "duplicates": duplicates, "blocked": blocked, "elapsed": time.Since(start)})
+		}(part, srcFile, ffmpegOutputChan, &ffmpegWaitGroup)
+	}
+
+	// Frames beyond repair are collected for the report and for partial
+	// recovery, which both need decoding to go on
+	var report *integrityReport
+	if opts.reportPath != "" || recovering {
+		report = newIntegrityReport()
+	}
+
+	// With a number or a strip every frame says where it belongs, frames
+	// ffmpeg delivers twice are only written once
+	geometry := geometryOf(opts)
+	var placed struct {
+		sync.Mutex
+		frames map[int]bool
+	}
+	placed.frames = map[int]bool{}
+
+	// account takes in what digesting frame id repaired, failing with the
+	// data beyond repair unless it is being recovered
+	var correctedBytes, failedCodewords atomic.Int64
+	account := func(id int, repair frameRepair) error {
+		if repair.mismatch {
+			err := withCause(ErrUncorrectable, fmt.Errorf("frame %d: data does not match its CRC", id))
+			if report == nil {
+				return err
+			}
+			logger.error("digester", err)
+		}
+		if ecc != nil {
+			if len(repair.failed) > 0 {
+				failedCodewords.Add(int64(len(repair.failed)))
+				err := withCause(ErrUncorrectable, fmt.Errorf("frame %d: %d of %d codewords have too many errors to correct", id, len(repair.failed), ecc.blocks))
+				if report == nil {
+					return err
+				}
+				logger.error("digester", err)
+			}
+			if repair.corrected > 0 {
+				correctedBytes.Add(int64(repair.corrected))
+				logger.debug("digester", "errors corrected", fields{"frame": id, "bytes": repair.corrected})
+			}
+		}
+		if report != nil {
+			report.add(id, repair)
+		}
+		return nil
+	}
+	// Frames past the parts known so far are only accounted for once it is
+	// known whether they belong to a part
+	var unsettled struct {
+		sync.Mutex
+		repairs map[int]frameRepair
+	}
+	unsettled.repairs = map[int]frameRepair{}
+	var ignoredFrames atomic.Int64
+
+	// Frame processing goroutines
+	var frameDigesterWaitGroup sync.WaitGroup
+	frameDigesterWaitGroup.Add(opts.threads)
+	digestedFramesChan := make(chan frameData, opts.queueDepth)
+	if opts.onQueues != nil {
+		opts.onQueues([]queueGauge{
+			{stage: "digester", capacity: opts.queueDepth, length: func() int { return len(ffmpegOutputChan) }},
+			{stage: "writer", capacity: opts.queueDepth, length: func() int { return len(digestedFramesChan) }},
+		})
+	}
+	for i := 0; i < opts.threads; i++ {
+		go func(worker int, ffmpegOutputChan <-chan frameData, digestedFramesChan chan<- frameData, wg *sync.WaitGroup) {
+			defer wg.Done()
+
+			var raw []byte // What the dots carry when it still has to go through ECC
+			if ecc != nil {
+				raw = make([]byte, rawBytes)
+			}
+
+			stats := newStageStats()
+			for frame := range ffmpegOutputChan {
+				stats.add(frame.frameID)
+				copies := make([][]byte, len(frame.value)/rawBytesPerFrame)
+				for c := range copies {
+					copies[c] = frame.value[c*rawBytesPerFrame : (c+1)*rawBytesPerFrame]
+				}
+				processedBytes := dataBuffers.get()
+				digesting := time.Now()
+				repair := digestFrame(copies, processedBytes, raw, opts, ecc)
+				opts.timings.since(phaseDigest, digesting)
+				if opts.frameNumbers {
+					number, ok := readFrameNumber(processedBytes, processedBytesPerFrame)
+					switch {
+					case !ok:
+						// The frame keeps its place in the order ffmpeg delivered
+						// it. Without a report the hash finds the damage: until
+						// the header is read the frames may be from before v6.
+						logger.verbose("digester", "frame number unreadable", fields{"frame": frame.frameID})
+						repair.mismatch = report != nil
+					case number != frame.frameID:
+						logger.verbose("digester", "frame delivered out of place", fields{"frame": number, "position": frame.frameID})
+						frame.frameID = number
+					}
+				}
+				if opts.frameStrip {
+					strip := readStrip(copies, geometry)
+					if strip.offset != stripOffset(strip.index, processedBytesPerFrame, opts.interleave) {
+						// The strip is damaged too, the frame keeps its place in
+						// the order ffmpeg delivered it
+						logger.verbose("digester", "frame strip unreadable", fields{"frame": frame.frameID})
+					} else {
+						if strip.index != frame.frameID {
+							logger.verbose("digester", "frame delivered out of place", fields{"frame": strip.index, "position": frame.frameID})
+							frame.frameID = strip.index
+						}
+						repair.mismatch = !strip.matches(processedBytes)
+					}
+				}
+				if opts.frameNumbers || opts.frameStrip {
+					placed.Lock()
+					duplicate := placed.frames[frame.frameID]
+					placed.frames[frame.frameID] = true
+					placed.Unlock()
+					if duplicate {
+						logger.verbose("digester", "frame delivered twice", fields{"frame": frame.frameID})
+						groupBuffers.put(frame.value)
+						dataBuffers.put(processedBytes)
+						continue
+					}
+				}
+				groupBuffers.put(frame.value)
+
+				switch {
+				case pastEnd(frame.frameID) && streamEnded.Load():
+					ignoredFrames.Add(1)
+					dataBuffers.put(processedBytes)
+					continue
+				case pastEnd(frame.frameID):
+					unsettled.Lock()
+					unsettled.repairs[frame.frameID] = repair
+					unsettled.Unlock()
+				default:
+					if err := account(frame.frameID, repair); err != nil {
+						p.fail("digester", err)
+						return
+					}
+				}
+
+				frame.value = processedBytes[:processedBytesPerFrame]
+				logger.debug("digester", "frame digested", fields{"worker": worker, "frame": frame.frameID})
+				sending := time.Now()
+				if !p.send(digestedFramesChan, frame) {
+					return
+				}
+				stats.blockedSince(sending)
+				progress.add("digester")
+			}
+			logger.verbose("digester", "worker done", stats.fields(worker))
+		}(i+1, ffmpegOutputChan, digestedFramesChan, &frameDigesterWaitGroup)
+	}
+
+	// Writer goroutines, every frame (or interleaved block) has a fixed offset
+	// in the output so they can write independently. The header at the start
+	// of the stream tells where the payload starts and how long it is, which
+	// is used to cut off the padding at the end once everything is written.
+	blocks := newDeinterleaver(opts.interleave, processedBytesPerFrame, dataBuffers)
+	headerRead := false // Set by the writer reading the header, read once they are done
+	stream := newStreamWriter(out, processedBytesPerFrame, opts.interleave, func(header *streamHeader, part streamPart) error {
+		headerRead = true
+		if part.first == 0 && (header.version >= numberedVersion) != opts.frameNumbers {
+			// Nothing was written yet, what the frames hold depends on it
+			return errFrameNumbers
+		}
+		frames := int64(part.first + part.frames)
+		if header.version >= 2 {
+			frames++ // Trailer
+		}
+		if err := checkLength(part, frames, sourceFrames, opts); err != nil {
+			return err
+		}
+		if pipe != nil {
+			if header.delta {
+				return errors.New("video holds the changes to an earlier version of the file, which cannot be streamed")
+			}
+			pipe.startPart(part.offset)
+		} else if err := reserveSpace(file, part.offset+part.length); err != nil {
+			// The length comes from the video, which may be damaged or forged
+			return err
+		}
+		progress.setTotal(frames)
+		knownFrames.Store(frames)
+		if part.first > 0 {
+			logger.verbose("writer", "read appended part", fields{"offset": part.offset, "length": part.length})
+			return nil
+		}
+		if header.delta && opts.deltaBase == "" {
+			return errors.New("video holds the changes to an earlier version of the file, pass the video of that version with -base")
+		}
+		if opts.payloadFormat == payloadTar && !header.tar {
+			return errors.New("video does not hold a tar archive, it was encoded without -format tar")
+		}
+		logger.verbose("writer", "read header", fields{"format": header.version, "length": header.length, "dct": header.dct, "tar": header.tar})
+		if header.version == 0 {
+			logger.info("writer", "video uses the legacy v0 format", nil)
+		}
+		return nil
+	})
+	if opts.manifest != "" {
+		m, err := readManifest(opts.manifest)
+		if err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		// The trailer read from the video, if it can be, replaces this one
+		stream.manifest = m
+		stream.setTrailer(m.trailer())
+	}
+	var writerWaitGroup sync.WaitGroup
+	writerWaitGroup.Add(opts.writers)
+	for i := 0; i < opts.writers; i++ {
+		go func(worker int, digestedFramesChan <-chan frameData, wg *sync.WaitGroup) {
+			defer wg.Done()
+
+			stats := newStageStats()
+			for frame := range digestedFramesChan {
+				stats.add(frame.frameID)
+				if trailer, ok := parseTrailer(frame); ok {
+					stream.setTrailer(trailer)
+					dataBuffers.put(frame.value)
+					progress.add("writer")
+					continue
+				}
+				chunk, ok := blocks.add(frame)
+				if !ok {
+					continue // Waiting for the rest of the block
+				}
+				writing := time.Now()
+				err := stream.write(chunk)
+				opts.timings.since(phaseWrite, writing)
+				blocks.release(chunk)
+				if err != nil {
+					p.fail("writer", err)
+					return
+				}
+				if stream.complete() {
+					streamEnded.Store(true)
+				}
+				for i := 0; i < chunk.frames; i++ {
+					progress.add("writer")
+				}
+			}
+			logger.verbose("writer", "worker done", stats.fields(worker))
+		}(i+1, digestedFramesChan, &writerWaitGroup)
+	}
+
+	// Wait for each group to finish
+	ffmpegWaitGroup.Wait()
+	close(ffmpegOutputChan)
+	frameDigesterWaitGroup.Wait()
+	close(digestedFramesChan)
+	writerWaitGroup.Wait()
+
+	unsettledFrames := make([]int, 0, len(unsettled.repairs))
+	for id := range unsettled.repairs {
+		unsettledFrames = append(unsettledFrames, id)
+	}
+	sort.Ints(unsettledFrames)
+	for _, id := range unsettledFrames {
+		if pastEnd(id) {
+			ignoredFrames.Add(1)
+		} else if err := account(id, unsettled.repairs[id]); err != nil {
+			p.fail("digester", err)
+			break
+		}
+	}
+	if ignored := ignoredFrames.Load(); ignored > 0 {
+		logger.verbose("decode", "frames after the end of the video ignored", fields{"frames": ignored})
+	}
+
+	if err := p.result(); err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return headerUnread(err, headerRead)
+	}
+
+	summary := func(payload int64) {
+		runSummary{
+			mode:            "decode",
+			payload:         payload,
+			frames:          progress.frames("ffmpeg"),
+			start:           start,
+			stages:          progress.throughput(),
+			corrected:       correctedBytes.Load(),
+			failedCodewords: failedCodewords.Load(),
+		}.log(opts)
+	}
+	if corrected := correctedBytes.Load(); corrected > 0 {
+		metrics.addCorrected(corrected)
+		logger.verbose("digester", "ECC corrected errors", fields{"bytes": corrected})
+	}
+	if pipe != nil {
+		// Everything has been handed out already, all that is left is to
+		// tell whether it was right
+		header, err := stream.result()
+		if err == nil {
+			err = blocks.incomplete()
+		}
+		if err == nil {
+			err = stream.verify(header)
+		}
+		if err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		logger.verbose("decode", "video streamed", fields{"bytes": header.length, "elapsed": time.Since(start)})
+		summary(header.length)
+		return nil
+	}
+	// The report is written whatever the outcome, it matters most when
+	// decoding failed
+	writeReport := func(header *streamHeader, verified *bool) error {
+		if opts.reportPath == "" {
+			return nil
+		}
+		return report.write(opts.reportPath, srcFiles[0], header, verified, opts)
+	}
+	header, err := stream.result()
+	if err != nil {
+		file.Close()
+		writeReport(nil, nil)
+		return headerUnread(&stageError{stage: "writer", err: err}, headerRead)
+	}
+	var damaged []byteRange
+	if err := blocks.incomplete(); err != nil {
+		if !recovering {
+			file.Close()
+			writeReport(header, nil)
+			return &stageError{stage: "writer", err: err}
+		}
+		// Keep what the frames that made it carry
+		for _, chunk := range blocks.flush() {
+			if err := stream.write(chunk); err != nil {
+				file.Close()
+				return &stageError{stage: "writer", err: err}
+			}
+			damaged = append(damaged, clipToPayload(byteRange{Offset: chunk.offset, Length: int64(len(chunk.value))}, header))
+		}
+	}
+	length := header.length
+	if err := file.Truncate(length); err != nil {
+		file.Close()
+		return &stageError{stage: "writer", err: err}
+	}
+	var missing []byteRange
+	if recovering {
+		missing = mergeRanges(stream.missing(header))
+		damaged = mergeRanges(append(damaged, report.suspectRanges(header, opts)...))
+	}
+	repaired := false
+	if parity != nil {
+		erasures := mergeRanges(append(append([]byteRange(nil), missing...), damaged...))
+		corrected, err := parity.repair(file, erasures, opts.threads)
+		if err != nil {
+			if !opts.partial {
+				file.Close()
+				writeReport(header, nil)
+				return &stageError{stage: "parity", err: err}
+			}
+			logger.error("parity", err)
+		} else {
+			repaired = true
+			missing, damaged = nil, nil
+			logger.info("parity", "payload repaired", fields{"bytes": corrected})
+		}
+	}
+	verifyErr := stream.verify(header)
+	if repaired {
+		// The parity video has the hash of the payload too, a trailer lost
+		// along with the frames before it does not matter
+		verifyErr = nil
+	}
+	var verified *bool
+	if header.version >= 2 || repaired {
+		ok := verifyErr == nil
+		verified = &ok
+	}
+	if err := writeReport(header, verified); err != nil {
+		file.Close()
+		return &stageError{stage: "writer", err: fmt.Errorf("writing report: %w", err)}
+	}
+	var holes []byte
+	if opts.partial {
+		if len(missing) == 0 && len(damaged) == 0 && errors.Is(verifyErr, ErrHashMismatch) {
+			// The damage is somewhere no frame told of, no byte can be
+			// trusted
+			file.Close()
+			return &stageError{stage: "writer", err: fmt.Errorf("%w, and no frame was found missing or damaged", verifyErr)}
+		}
+		if len(missing) > 0 || len(damaged) > 0 || verifyErr != nil {
+			hash, _ := stream.trailerHash(header)
+			frames := holeFrames(mergeRanges(append(append([]byteRange(nil), missing...), damaged...)), header, opts)
+			holes = holeMap(length, hash, missing, damaged, frames, verified)
+			err := fmt.Errorf("recovered partially, %d ranges missing and %d damaged", len(missing), len(damaged))
+			if verifyErr != nil {
+				err = fmt.Errorf("%w: %v", err, verifyErr)
+			}
+			logger.error("writer", err)
+			if len(frames) > 0 {
+				// A capture can be recorded again where it failed and merged
+				// with this recovery
+				runs := make([]string, len(frames))
+				for i, r := range frames {
+					runs[i] = r.String()
+				}
+				logger.info("writer", "frames to record again", fields{"frames": strings.Join(runs, ", ")})
+			}
+		}
+	} else {
+		if report != nil && !repaired {
+			if failed := report.failedFrames(); len(failed) > 0 {
+				file.Close()
+				return &stageError{stage: "digester", err: withCause(ErrUncorrectable, fmt.Errorf("%d frames could not be corrected, see %s", len(failed), opts.reportPath))}
+			}
+		}
+		if verifyErr != nil {
+			file.Close()
+			return &stageError{stage: "writer", err: verifyErr}
+		}
+	}
+	if err := file.Close(); err != nil {
+		return &stageError{stage: "writer", err: err}
+	}
+	if header.delta {
+		if err := rebuildFromDelta(ctx, output.path, opts); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		if info, err := os.Stat(output.path); err == nil {
+			length = info.Size()
+		}
+	}
+	if opts.restoreMetadata && output.remote == nil {
+		if err := restoreMetadata(output.path, header.metadata); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+	}
+	if destFile == "" {
+		if destFile, err = originalName(header.metadata); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		rename := renameNew
+		if opts.overwrite {
+			rename = os.Rename
+		}
+		if err := rename(output.path, destFile); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+	}
+	if err := output.commit(ctx); err != nil {
+		return &stageError{stage: "upload", err: err}
+	}
+	if holes != nil {
+		if err := writeHoleMap(ctx, destFile+".holes.json", holes); err != nil {
+			return &stageError{stage: "writer", err: fmt.Errorf("writing hole map: %w", err)}
+		}
+		logger.info("decode", "video decoded partially", fields{"output": destFile, "holes": destFile + ".holes.json", "bytes": length, "elapsed": time.Since(start)})
+		summary(length)
+		return nil
+	}
+
+	logger.info("decode", "video decoded successfully", fields{"output": destFile, "bytes": length, "elapsed": time.Since(start)})
+	summary(length)
+	return nil
+}
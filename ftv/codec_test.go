@@ -0,0 +1,331 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.out = io.Discard
+	os.Exit(m.Run())
+}
+
+// testOptions are small frames on the memory transport, with settings
+// applied by their key in the config file.
+func testOptions(t *testing.T, settings map[string]string) options {
+	t.Helper()
+	opts := defaultOptions()
+	opts.width, opts.height, opts.dotSize = 320, 240, 4
+	opts.threads = 2
+	for key, value := range settings {
+		if err := opts.set(key, value); err != nil {
+			t.Fatalf("setting %s: %v", key, err)
+		}
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	opts.transport = transportMemory
+	return opts
+}
+
+// testPayload returns n random bytes, the same ones on every run.
+func testPayload(n int) []byte {
+	payload := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(payload)
+	return payload
+}
+
+// encodeTest encodes payload to the memory video name.
+func encodeTest(t *testing.T, payload []byte, name string, opts options) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(src, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(context.Background(), src, name, opts); err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	if len(memoryVideo(name)) == 0 {
+		t.Fatal("no frames encoded")
+	}
+}
+
+// decodeTest decodes the memory video name, returning the decoded file.
+func decodeTest(t *testing.T, name string, opts options) (string, error) {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "decoded")
+	return dest, decode(context.Background(), name, dest, opts)
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		settings map[string]string
+	}{
+		{"plain", 40000, nil},
+		{"empty", 0, nil},
+		{"ecc", 40000, map[string]string{"ecc": "16"}},
+		{"hamming", 40000, map[string]string{"ecc": "hamming"}},
+		{"interleave", 100000, map[string]string{"ecc": "16", "interleave": "3"}},
+		{"repeat", 40000, map[string]string{"repeat": "3"}},
+		{"strip", 40000, map[string]string{"frame_strip": "true", "size": "640x480"}},
+		{"dct", 20000, map[string]string{"modulation": "dct"}},
+		{"24 bit", 100000, map[string]string{"dot_bits": "24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t, tt.settings)
+			payload := testPayload(tt.size)
+			name := "roundtrip/" + tt.name
+			encodeTest(t, payload, name, opts)
+			dest, err := decodeTest(t, name, opts)
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			decoded, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Fatalf("decoded %d bytes differing from the %d encoded", len(decoded), len(payload))
+			}
+		})
+	}
+}
+
+// TestRoundTripRepairs damages frames the ECC or the other copies of
+// -repeat make up for.
+func TestRoundTripRepairs(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		damage   func(frames [][]byte)
+	}{
+		{"ecc", map[string]string{"ecc": "32"}, func(frames [][]byte) {
+			// A few dots of every frame but the header's
+			for _, frame := range frames[1:] {
+				for i := len(frame) / 3; i < len(frame)/3+48; i++ {
+					frame[i] ^= 0xff
+				}
+			}
+		}},
+		{"repeat", map[string]string{"repeat": "3"}, func(frames [][]byte) {
+			// The middle copy of one frame
+			middle := frames[len(frames)/2/3*3+1]
+			for i := range middle {
+				middle[i] = 0
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t, tt.settings)
+			payload := testPayload(40000)
+			name := "repairs/" + tt.name
+			encodeTest(t, payload, name, opts)
+			tt.damage(memoryVideo(name))
+			dest, err := decodeTest(t, name, opts)
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			decoded, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Fatal("damage not repaired")
+			}
+		})
+	}
+}
+
+// TestPartial checks that -partial reports every byte it could not recover
+// in the hole map, and fails without it.
+func TestPartial(t *testing.T) {
+	tests := []struct {
+		name     string
+		damage   func(frames [][]byte) [][]byte
+		settings map[string]string
+	}{
+		{"damaged frame", func(frames [][]byte) [][]byte {
+			// Noise, a blank frame reads as codewords of zeros, which are
+			// valid ones
+			rand.New(rand.NewSource(1)).Read(frames[len(frames)/2])
+			return frames
+		}, map[string]string{"ecc": "16"}},
+		{"dropped frame", func(frames [][]byte) [][]byte {
+			middle := len(frames) / 2
+			return append(frames[:middle:middle], frames[middle+1:]...)
+		}, nil},
+		{"dropped frame ecc", func(frames [][]byte) [][]byte {
+			middle := len(frames) / 2
+			return append(frames[:middle:middle], frames[middle+1:]...)
+		}, map[string]string{"ecc": "16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t, tt.settings)
+			payload := testPayload(40000)
+			name := "partial/" + tt.name
+			encodeTest(t, payload, name, opts)
+			setMemoryVideo(name, tt.damage(memoryVideo(name)))
+
+			if _, err := decodeTest(t, name, opts); err == nil {
+				t.Fatal("damaged video decoded without -partial")
+			}
+			opts.partial = true
+			dest, err := decodeTest(t, name, opts)
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			decoded, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(dest + ".holes.json")
+			if err != nil {
+				t.Fatalf("no hole map: %v", err)
+			}
+			var holes holeMapFile
+			if err := json.Unmarshal(data, &holes); err != nil {
+				t.Fatal(err)
+			}
+			if len(holes.Holes) == 0 {
+				t.Fatal("empty hole map")
+			}
+			if holes.Length != int64(len(payload)) || len(decoded) != len(payload) {
+				t.Fatalf("recovered %d bytes, hole map of %d, encoded %d", len(decoded), holes.Length, len(payload))
+			}
+			known := make([]bool, len(payload))
+			for i := range known {
+				known[i] = true
+			}
+			for _, h := range holes.Holes {
+				for i := h.Offset; i < h.Offset+h.Length; i++ {
+					known[i] = false
+				}
+			}
+			for i := range payload {
+				if known[i] && decoded[i] != payload[i] {
+					t.Fatalf("byte %d is wrong but in no hole", i)
+				}
+			}
+		})
+	}
+}
+
+// unnumberVideo turns the memory video name, encoded without frame numbers,
+// into one from before v6 by giving it a v5 header.
+func unnumberVideo(t *testing.T, name string, opts options) {
+	t.Helper()
+	frames := memoryVideo(name)
+	ecc := opts.frameECC()
+	block, data, failed := digestBlock(frames[:opts.interleave], opts, ecc)
+	if failed >= 0 {
+		t.Fatal("header unreadable")
+	}
+	block[4], block[5] = numberedVersion-1, 2 // Version and compat
+	interleaveBlock(block, data)
+	capacity := frameCapacity(opts)
+	pixels := make([]byte, geometryOf(opts).frameBytes(4))
+	for i := range data {
+		for j := range pixels {
+			pixels[j] = 0
+		}
+		newFrameSerializer(opts).serialize(i, data[i][:capacity], pixels)
+		rgbaToRGB(frames[i], pixels)
+	}
+}
+
+// TestUnnumbered decodes videos from before v6, whose frames carry no
+// number.
+func TestUnnumbered(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+	}{
+		{"plain", nil},
+		{"interleave", map[string]string{"ecc": "16", "interleave": "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t, tt.settings)
+			unnumbered := opts
+			unnumbered.frameNumbers = false
+			payload := testPayload(40000)
+			name := "unnumbered/" + tt.name
+			encodeTest(t, payload, name, unnumbered)
+			unnumberVideo(t, name, unnumbered)
+
+			dest, err := decodeTest(t, name, opts)
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			decoded, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Fatal("decoded payload differs")
+			}
+		})
+	}
+}
+
+// TestUnnumberedPartial checks that a damaged video whose frames tell
+// nothing of it fails with -partial rather than handing out an empty hole
+// map.
+func TestUnnumberedPartial(t *testing.T) {
+	opts := testOptions(t, nil)
+	unnumbered := opts
+	unnumbered.frameNumbers = false
+	name := "unnumbered/partial"
+	encodeTest(t, testPayload(40000), name, unnumbered)
+	unnumberVideo(t, name, unnumbered)
+	frames := memoryVideo(name)
+	rand.New(rand.NewSource(1)).Read(frames[len(frames)/2])
+
+	opts.partial = true
+	if _, err := decodeTest(t, name, opts); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("decoding returned %v, expected a hash mismatch", err)
+	}
+}
+
+func TestDecodeRange(t *testing.T) {
+	for _, numbered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("numbered %v", numbered), func(t *testing.T) {
+			opts := testOptions(t, map[string]string{"interleave": "2"})
+			encoding := opts
+			encoding.frameNumbers = numbered
+			payload := testPayload(40000)
+			name := fmt.Sprintf("range/%v", numbered)
+			encodeTest(t, payload, name, encoding)
+			if !numbered {
+				unnumberVideo(t, name, encoding)
+			}
+
+			opts.rangeOffset, opts.rangeLength = 12345, 20000
+			dest := filepath.Join(t.TempDir(), "range")
+			if err := decodeRange(context.Background(), name, dest, opts); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			decoded, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, payload[12345:12345+20000]) {
+				t.Fatal("decoded range differs")
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package ftv
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// completionShells maps the shells completion scripts are written for to
+// the function writing them.
+var completionShells = map[string]func(w io.Writer, name string, commands []completionCommand){
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// runCompletion prints the completion script of a shell. It is left out of
+// the help, users only run it once to install the script.
+func runCompletion(args []string) {
+	if len(args) != 1 || completionShells[args[0]] == nil {
+		logger.reportError("cli", withCause(errUsage, errors.New("usage: completion "+strings.Join(sortedKeys(completionShells), "|"))), true)
+		os.Exit(exitUsage)
+	}
+	name := filepath.Base(os.Args[0])
+	completionShells[args[0]](os.Stdout, name, completionCommands())
+}
+
+// completionCommand is the default mode, with an empty name, or a subcommand
+// along with its flags.
+type completionCommand struct {
+	name  string
+	flags []completionFlag
+}
+
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+	values []string // Empty if the value is free, which completes a file
+}
+
+// completionCommands returns the default mode and every subcommand. The flag
+// sets are built by the same functions the commands parse with, so the
+// scripts cannot drift from them.
+func completionCommands() []completionCommand {
+	var (
+		s        string
+		b        bool
+		n        int
+		d        time.Duration
+		inputs   inputList
+		decoding bool
+	)
+	sets := []struct {
+		name string
+		c    *cli
+	}{
+		{"", mainCLI("", &decoding, &inputs, &s, &n, &b, &b, &b, &s, &d)},
+		{"bench", benchCLI(&s, &s)},
+		{"doctor", doctorCLI()},
+		{"estimate", estimateCLI(&s, &d)},
+		{"merge", mergeCLI(&inputs, &s, &b)},
+		{"selftest", selftestCLI(&b)},
+		{"serve", serveCLI(&s, &s, &s, &b, &s, &b, &n, &n)},
+		{"watch", watchCLI(&s, &s, &s, &s, &d, &s)},
+	}
+
+	values := map[string][]string{
+		"preset":     sortedKeys(presets),
+		"channel":    sortedKeys(channels),
+		"modulation": {"dots", "dct"},
+		"dot-bits":   {"3", "24"},
+		"transport":  {transportFFmpeg, transportImages, transportY4M},
+		"container":  sortedKeys(containers),
+		"log-format": {"text", "json"},
+	}
+	commands := make([]completionCommand, 0, len(sets))
+	for _, set := range sets {
+		command := completionCommand{name: set.name}
+		set.c.flags.VisitAll(func(f *flag.Flag) {
+			bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+			command.flags = append(command.flags, completionFlag{
+				name:   f.Name,
+				usage:  f.Usage,
+				isBool: ok && bf.IsBoolFlag(),
+				values: values[f.Name],
+			})
+		})
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// subcommandNames returns the names of the subcommands among commands.
+func subcommandNames(commands []completionCommand) []string {
+	var names []string
+	for _, command := range commands {
+		if command.name != "" {
+			names = append(names, command.name)
+		}
+	}
+	return names
+}
+
+// shellFunction turns name into a valid shell function name.
+func shellFunction(name string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func bashCompletion(w io.Writer, name string, commands []completionCommand) {
+	fn := shellFunction(name)
+	subcommands := strings.Join(subcommandNames(commands), " ")
+	fmt.Fprintf(w, "# bash completion for %s\n", name)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" cmd=\n")
+	fmt.Fprintf(w, "\tif [[ $COMP_CWORD -gt 1 ]]; then\n")
+	fmt.Fprintf(w, "\t\tcase \"${COMP_WORDS[1]}\" in %s) cmd=\"${COMP_WORDS[1]}\" ;; esac\n", strings.ReplaceAll(subcommands, " ", "|"))
+	fmt.Fprintf(w, "\tfi\n")
+
+	fmt.Fprintf(w, "\tlocal flags values\n")
+	fmt.Fprintf(w, "\tcase \"$cmd\" in\n")
+	for _, command := range commands {
+		label := command.name
+		if label == "" {
+			label = "''"
+		}
+		var flags []string
+		fmt.Fprintf(w, "\t%s)\n", label)
+		fmt.Fprintf(w, "\t\tcase \"${prev#-}\" in\n")
+		for _, f := range command.flags {
+			flags = append(flags, "-"+f.name)
+			switch {
+			case f.values != nil:
+				fmt.Fprintf(w, "\t\t%s) values=%s ;;\n", f.name, shellQuote(strings.Join(f.values, " ")))
+			case !f.isBool:
+				fmt.Fprintf(w, "\t\t%s) values=- ;;\n", f.name)
+			}
+		}
+		fmt.Fprintf(w, "\t\tesac\n")
+		fmt.Fprintf(w, "\t\tflags=%s\n", shellQuote(strings.Join(flags, " ")))
+		fmt.Fprintf(w, "\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n")
+
+	// A flag taking a value is followed by one of its values or a file
+	fmt.Fprintf(w, "\tif [[ \"$prev\" == -* && -n \"$values\" ]]; then\n")
+	fmt.Fprintf(w, "\t\tif [[ \"$values\" == - ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\t\telse\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W \"$values\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tif [[ -z \"$cmd\" && $COMP_CWORD -eq 1 && \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(subcommands))
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o filenames -F %s %s\n", fn, name)
+}
+
+// zshDescription escapes s for the brackets of an _arguments spec.
+func zshDescription(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func zshCompletion(w io.Writer, name string, commands []completionCommand) {
+	fn := shellFunction(name)
+	subcommands := subcommandNames(commands)
+	fmt.Fprintf(w, "#compdef %s\n\n", name)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cmd=\n")
+	fmt.Fprintf(w, "\tif (( CURRENT > 2 )); then\n")
+	fmt.Fprintf(w, "\t\tcase $words[2] in\n")
+	fmt.Fprintf(w, "\t\t%s)\n", strings.Join(subcommands, "|"))
+	fmt.Fprintf(w, "\t\t\tcmd=$words[2]\n")
+	fmt.Fprintf(w, "\t\t\tshift 2 words\n")
+	fmt.Fprintf(w, "\t\t\twords=(%s $words)\n", name)
+	fmt.Fprintf(w, "\t\t\t(( CURRENT-- ))\n")
+	fmt.Fprintf(w, "\t\t\t;;\n")
+	fmt.Fprintf(w, "\t\tesac\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tcase $cmd in\n")
+	for _, command := range commands {
+		label := command.name
+		if label == "" {
+			label = "''"
+		}
+		fmt.Fprintf(w, "\t%s)\n", label)
+		fmt.Fprintf(w, "\t\t_arguments -S")
+		if command.name == "" {
+			fmt.Fprintf(w, " \\\n\t\t\t%s", shellQuote("1:command:("+strings.Join(subcommands, " ")+")"))
+		}
+		for _, f := range command.flags {
+			spec := "-" + f.name + "[" + zshDescription(f.usage) + "]"
+			switch {
+			case f.values != nil:
+				spec += ":" + f.name + ":(" + strings.Join(f.values, " ") + ")"
+			case !f.isBool:
+				spec += ":" + f.name + ":_files"
+			}
+			fmt.Fprintf(w, " \\\n\t\t\t%s", shellQuote(spec))
+		}
+		fmt.Fprintf(w, "\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "}\n\n")
+	// Autoloaded from fpath the file is the completion function, sourced it
+	// registers it
+	fmt.Fprintf(w, "if [[ $funcstack[1] == %s ]]; then\n", fn)
+	fmt.Fprintf(w, "\t%s \"$@\"\n", fn)
+	fmt.Fprintf(w, "else\n")
+	fmt.Fprintf(w, "\tcompdef %s %s\n", fn, name)
+	fmt.Fprintf(w, "fi\n")
+}
+
+func fishCompletion(w io.Writer, name string, commands []completionCommand) {
+	subcommands := strings.Join(subcommandNames(commands), " ")
+	fmt.Fprintf(w, "# fish completion for %s\n", name)
+	fmt.Fprintf(w, "complete -c %s -f\n", name)
+	for _, command := range commands {
+		condition := "not __fish_seen_subcommand_from " + subcommands
+		if command.name == "" {
+			fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s\n", name, shellQuote(subcommands))
+		} else {
+			condition = "__fish_seen_subcommand_from " + command.name
+		}
+		for _, f := range command.flags {
+			line := fmt.Sprintf("complete -c %s -n %s -o %s", name, shellQuote(condition), f.name)
+			switch {
+			case f.values != nil:
+				line += " -x -a " + shellQuote(strings.Join(f.values, " "))
+			case !f.isBool:
+				line += " -r -F"
+			}
+			fmt.Fprintf(w, "%s -d %s\n", line, shellQuote(f.usage))
+		}
+	}
+}
@@ -0,0 +1,662 @@
+package ftv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// options are the tunables shared by encode and decode. They start from
+// defaultOptions, are overridden by the config file, then by FILETOVIDEO_*
+// environment variables and finally by command line flags.
+type options struct {
+	codec       string
+	bitrate     string
+	width       int // Frame size in pixels
+	height      int
+	dotSize     int
+	dotBits     int    // Bits every dot carries, 3 (one per channel) or 24 (a byte per channel)
+	modulation  string // How frames carry bits: modulationDots or modulationDCT
+	ecc         int    // Reed-Solomon parity bytes per codeword of a frame, 0 for none
+	threads     int    // Pixel workers (serializers and digesters)
+	readers     int    // Parallel file readers when encoding
+	writers     int    // Parallel file writers when decoding
+	ffmpegPath  string
+	mmap        bool   // Map the input file instead of reading it when encoding
+	segments    int    // Parallel ffmpeg processes when encoding
+	gop         int    // Frames between keyframes
+	bframes     int    // Consecutive B-frames between references, -1 for the encoder's default
+	fps         int    // Frames per second of the video, decode only uses it for the times in reports and to seek to -offset
+	interleave  int    // Frames each block of the stream is spread over
+	repeat      int    // Times every frame is written to the video
+	pixelFormat string // Pixel format of the encoded video, empty for the encoder's default
+	frameStrip  bool   // Reserve the top rows of every frame for its index, offset and CRC
+	transport   string // What stores the frames: transportFFmpeg, transportImages or transportY4M
+	container   string // Of the video, containerMP4, containerMKV or containerWebM; empty to follow its extension
+
+	// End the data of every frame with its number (see framenumber.go). Only
+	// videos from before format v6 and the parts appended to them lack it.
+	frameNumbers bool
+
+	// Protect frames with the Hamming code rather than Reed-Solomon, which
+	// costs more space but far less CPU. ecc is unused then.
+	eccHamming bool
+
+	// Extra arguments of the ffmpeg encoding or decoding the frames, added
+	// before the output
+	ffmpegArgs []string
+
+	// Run ffmpeg at a lower priority and cap the frames per second going
+	// through the pipeline, 0 for no cap, so background jobs leave the
+	// machine usable
+	nice          bool
+	maxThroughput float64
+
+	// Apply the mode bits and modification time stored in the video to the
+	// decoded file
+	restoreMetadata bool
+
+	// Encode the same input with the same settings into the same bytes: a
+	// software encoder with pinned threads, bitexact flags, and neither
+	// timestamps nor tool versions in the container or the intro
+	reproducible bool
+
+	// Largest payload a video may declare when decoding, 0 for no limit. The
+	// header is read before anything else of the video, a damaged or forged
+	// one must not get to allocate the disk
+	maxLength int64
+
+	// Replace an existing file of the original name when decoding without a
+	// destination
+	overwrite bool
+
+	// OAuth client used by -upload youtube
+	youtubeClientID     string
+	youtubeClientSecret string
+
+	// Most frames held back while waiting for an earlier frame to finish
+	reorderWindow int
+
+	// Frames buffered between two stages of the pipeline
+	queueDepth int
+
+	// Bytes the pipeline may take, 0 for no limit. The queues and workers
+	// are shrunk to fit (see memory.go)
+	maxMemory int64
+
+	// Video whose stream encode continues, a new one is started if empty
+	appendTo string
+
+	// Encode: earlier version of the input to encode only the changes to.
+	// Decode: video of that version, which the changes are applied to.
+	deltaBase string
+
+	// Encode: share of parity, such as 10%, of the parity video written
+	// next to the video. Decode: parity video the video is repaired with.
+	// Unused if empty.
+	parity string
+
+	// Where decode writes its integrity report, none if empty
+	reportPath string
+
+	// Encode: where to write the sidecar manifest of the video. Decode:
+	// manifest to take the settings, header and trailer from. Unused if
+	// empty (see manifest.go).
+	manifest string
+
+	// Write what can be recovered of a damaged video and a hole map rather
+	// than failing
+	partial bool
+
+	// Decode from a live stream or a file still being written, waiting for
+	// the start of the next video if joined in the middle of one
+	live bool
+
+	// Encode: keep the segments as videos of their own rather than joining
+	// them. Decode: the inputs are those videos, in order.
+	split bool
+
+	// Decode: drop frames that repeat the one before them, which variable
+	// frame rate videos are padded with
+	dropDuplicates bool
+
+	// What the payload is, payloadRaw or payloadTar (see tar.go)
+	payloadFormat string
+
+	// Encode: start the video with a few seconds of a frame telling in
+	// plain text what it holds and how to decode it (see intro.go). Decode
+	// skips such frames either way.
+	intro bool
+
+	// Filters ffmpeg puts the decoded frames through before handing them
+	// over, set by decode to bring a rescaled video back to the detected
+	// grid (see grid.go)
+	sourceFilter string
+
+	// Decode: only the rangeLength bytes of the payload from rangeOffset
+	// on, the whole payload if rangeLength is 0 (see seek.go). seek is the
+	// seconds of the video ffmpeg skips before handing frames over.
+	rangeOffset int64
+	rangeLength int64
+	seek        float64
+
+	// -profile: the profiles written while the program runs (see
+	// profile.go), and where the stages add up the time they spend
+	profile string
+	timings *stageTimings
+
+	// bench: where the frames go instead of the transport (see bench.go)
+	loopback *loopbackTransport
+
+	// onProgress, if set, is called as frames move through the pipeline
+	onProgress ProgressFunc
+
+	// onQueues, if set, is given the queues between the stages once the
+	// pipeline is set up
+	onQueues queuesFunc
+}
+
+func defaultOptions() options {
+	return options{
+		codec:      autoCodec,
+		bitrate:    "30M",
+		width:      1920,
+		height:     1080,
+		dotSize:    8,
+		dotBits:    3,
+		modulation: modulationDots,
+		transport:  transportFFmpeg,
+		threads:    runtime.NumCPU(),
+		readers:    1,
+		writers:    1,
+		ffmpegPath: "ffmpeg",
+		segments:   1,
+		gop:        300,
+		bframes:    -1,
+		fps:        60,
+		interleave: 1,
+		repeat:     1,
+
+		frameNumbers: true,
+
+		reorderWindow: 16,
+		queueDepth:    4,
+		maxLength:     1 << 40,
+		payloadFormat: payloadRaw,
+	}
+}
+
+// Options are the settings of NewReader and NewWriter. Start from
+// DefaultOptions or LoadOptions, the zero value is not usable.
+type Options struct {
+	opts options
+}
+
+// DefaultOptions returns the settings FileToVideo uses when nothing is
+// configured.
+func DefaultOptions() Options {
+	return Options{opts: defaultOptions()}
+}
+
+// LoadOptions returns the defaults with the config file and the environment
+// applied, as the command line starts from.
+func LoadOptions() (Options, error) {
+	opts := defaultOptions()
+	if err := loadConfig(&opts); err != nil {
+		return Options{}, err
+	}
+	return Options{opts: opts}, nil
+}
+
+// Set sets a setting by its key in the config file, such as "dot_size" or
+// "ecc".
+func (o *Options) Set(key, value string) error {
+	return o.opts.set(key, value)
+}
+
+// Preset applies the settings of the preset called name, such as "youtube".
+func (o *Options) Preset(name string) error {
+	return applyPreset(&o.opts, name, nil)
+}
+
+// Channel applies the settings of the channel called name, such as "camera".
+func (o *Options) Channel(name string) error {
+	return applyChannel(&o.opts, name, nil)
+}
+
+// OnProgress has fn told how far every stage got, nil for nothing.
+func (o *Options) OnProgress(fn ProgressFunc) {
+	o.opts.onProgress = fn
+}
+
+// maxBFrames is the most B-frames libx264 puts between references.
+const maxBFrames = 16
+
+// defaultECC is the ECC of 24 bit dots, which cannot do without: the
+// smallest shift of a color changes the byte it carries.
+const defaultECC = 32
+
+// eccHamming is the value of -ecc picking the Hamming code.
+const eccHamming = "hamming"
+
+// eccParity returns the Reed-Solomon parity bytes per codeword actually
+// used, 0 with the Hamming code. A lossless codec needs none, even with a
+// byte per channel.
+func (o *options) eccParity() int {
+	if o.eccHamming {
+		return 0
+	}
+	if o.ecc == 0 && o.dotBits == 24 && !losslessCodecs[o.codec] {
+		return defaultECC
+	}
+	return o.ecc
+}
+
+// frameECC returns the ECC layout of the frames, nil without ECC.
+func (o *options) frameECC() *frameECC {
+	if o.eccHamming {
+		return newHammingECC(rawFrameSize(*o))
+	}
+	parity := o.eccParity()
+	if parity == 0 {
+		return nil
+	}
+	return newFrameECC(rawFrameSize(*o), parity)
+}
+
+func (o *options) validate() error {
+	if o.threads < 1 || o.readers < 1 || o.writers < 1 {
+		return fmt.Errorf("cannot spawn less than 1 threads")
+	}
+	if o.width < 2 || o.height < 2 || o.width%2 != 0 || o.height%2 != 0 {
+		return fmt.Errorf("frame width and height must be even and at least 2 pixels")
+	}
+	if o.dotSize < 1 || o.width%o.dotSize != 0 || o.height%o.dotSize != 0 {
+		return fmt.Errorf("dot size must divide both %d and %d", o.width, o.height)
+	}
+	if o.dotBits != 3 && o.dotBits != 24 {
+		return fmt.Errorf("dots carry either 3 or 24 bits")
+	}
+	switch o.modulation {
+	case modulationDots:
+	case modulationDCT:
+		if o.width%dctBlock != 0 || o.height%dctBlock != 0 {
+			return fmt.Errorf("the dct modulation needs a frame size divisible by %d", dctBlock)
+		}
+		if o.dotBits != 3 {
+			return fmt.Errorf("the dct modulation does not use -dot-bits")
+		}
+	default:
+		return fmt.Errorf("unknown modulation %q (expected %s or %s)", o.modulation, modulationDots, modulationDCT)
+	}
+	if o.transport != transportFFmpeg && o.transport != transportImages && o.transport != transportY4M {
+		return fmt.Errorf("unknown transport %q (expected %s, %s or %s)", o.transport, transportFFmpeg, transportImages, transportY4M)
+	}
+	if o.container != "" {
+		if _, ok := containers[o.container]; !ok {
+			return fmt.Errorf("unknown container %q (expected %s, %s or %s)", o.container, containerMP4, containerMKV, containerWebM)
+		}
+		if o.transport != transportFFmpeg {
+			return fmt.Errorf("-container only applies to the %s transport", transportFFmpeg)
+		}
+	}
+	if o.transport == transportY4M && o.dotBits == 24 {
+		// A byte per channel has to come back exactly, the trip through
+		// YUV is off by one here and there
+		return fmt.Errorf("the %s transport cannot carry -dot-bits 24", transportY4M)
+	}
+	if o.payloadFormat != payloadRaw && o.payloadFormat != payloadTar {
+		return fmt.Errorf("unknown payload format %q (expected %s or %s)", o.payloadFormat, payloadRaw, payloadTar)
+	}
+	if o.frameStrip {
+		if o.width/stripBits < stripMinBand {
+			return fmt.Errorf("the frame strip needs frames at least %d pixels wide", stripBits*stripMinBand)
+		}
+		if o.height <= stripHeight(*o) {
+			return fmt.Errorf("frames of %d pixels are too short for the frame strip", o.height)
+		}
+	}
+	if o.ecc < 0 || o.ecc > 128 {
+		return fmt.Errorf("ECC must use between 0 and 128 parity bytes per codeword")
+	}
+	if o.eccHamming && rawFrameSize(*o) < 2 {
+		return fmt.Errorf("frames of %d pixel dots are too small for the Hamming code", o.dotSize)
+	}
+	if parity := o.eccParity(); parity > 0 && newFrameECC(rawFrameSize(*o), parity).length <= parity {
+		return fmt.Errorf("frames of %d pixel dots are too small for %d ECC parity bytes", o.dotSize, parity)
+	}
+	if frameCapacity(*o) < trailerSize {
+		return fmt.Errorf("frames of %d pixel dots are too small to carry the stream", o.dotSize)
+	}
+	if o.segments < 1 {
+		return fmt.Errorf("cannot encode less than 1 segments")
+	}
+	if o.interleave < 1 {
+		return fmt.Errorf("interleave depth must be at least 1 frame")
+	}
+	if o.repeat < 1 {
+		return fmt.Errorf("every frame must be written at least once")
+	}
+	if o.gop < 1 {
+		return fmt.Errorf("keyframe interval must be at least 1 frame")
+	}
+	if o.bframes < -1 || o.bframes > maxBFrames {
+		return fmt.Errorf("B-frames must be between 0 and %d, or -1 for the encoder's default", maxBFrames)
+	}
+	if o.bframes > 0 {
+		// Only the H.264 and HEVC encoders take -bf, an unknown one is left
+		// to ffmpeg
+		switch family := codecFamily(o.codec); family {
+		case "h264", "hevc", "":
+		default:
+			return fmt.Errorf("-bframes only applies to H.264 and HEVC, not to the %s of -codec %s", family, o.codec)
+		}
+		if o.bframes >= o.gop {
+			return fmt.Errorf("%d B-frames do not fit between the keyframes of a %d frame GOP", o.bframes, o.gop)
+		}
+	}
+	if o.reproducible && isHardwareEncoder(o.codec) {
+		return fmt.Errorf("-reproducible needs a software encoder, the output of %s varies with the device and driver", o.codec)
+	}
+	if o.fps < 1 || o.fps > 240 {
+		return fmt.Errorf("frame rate must be between 1 and 240 frames per second")
+	}
+	if o.queueDepth < 0 {
+		return fmt.Errorf("queue depth cannot be negative")
+	}
+	if o.reorderWindow < 1 {
+		return fmt.Errorf("reorder window must be at least 1 frame")
+	}
+	if o.maxThroughput < 0 {
+		return fmt.Errorf("throughput cap cannot be negative")
+	}
+	if o.maxLength < 0 {
+		return fmt.Errorf("maximum payload length cannot be negative")
+	}
+	if o.codec == "" {
+		return fmt.Errorf("codec cannot be empty")
+	}
+	if o.ffmpegPath == "" {
+		return fmt.Errorf("ffmpeg path cannot be empty")
+	}
+	return nil
+}
+
+// set applies a single setting by its config file key.
+func (o *options) set(key, value string) error {
+	var err error
+	switch key {
+	case "codec":
+		o.codec = value
+	case "bitrate":
+		o.bitrate = value
+	case "dot_size":
+		o.dotSize, err = strconv.Atoi(value)
+	case "size":
+		o.width, o.height, err = parseSize(value)
+	case "dot_bits":
+		o.dotBits, err = strconv.Atoi(value)
+	case "modulation":
+		o.modulation = value
+	case "ecc":
+		o.eccHamming = value == eccHamming
+		if o.eccHamming {
+			o.ecc = 0
+		} else {
+			o.ecc, err = strconv.Atoi(value)
+		}
+	case "threads":
+		o.threads, err = strconv.Atoi(value)
+	case "readers":
+		o.readers, err = strconv.Atoi(value)
+	case "writers":
+		o.writers, err = strconv.Atoi(value)
+	case "ffmpeg":
+		o.ffmpegPath = value
+	case "mmap":
+		o.mmap, err = strconv.ParseBool(value)
+	case "segments":
+		o.segments, err = strconv.Atoi(value)
+	case "max_memory":
+		o.maxMemory, err = parseBytes(value)
+	case "gop":
+		o.gop, err = strconv.Atoi(value)
+	case "bframes":
+		o.bframes, err = strconv.Atoi(value)
+	case "fps":
+		o.fps, err = strconv.Atoi(value)
+	case "interleave":
+		o.interleave, err = strconv.Atoi(value)
+	case "repeat":
+		o.repeat, err = strconv.Atoi(value)
+	case "ffmpeg_args":
+		o.ffmpegArgs, err = splitArgs(value)
+	case "pixel_format":
+		o.pixelFormat = value
+	case "frame_strip":
+		o.frameStrip, err = strconv.ParseBool(value)
+	case "transport":
+		o.transport = value
+	case "nice":
+		o.nice, err = strconv.ParseBool(value)
+	case "max_throughput":
+		o.maxThroughput, err = strconv.ParseFloat(value, 64)
+	case "container":
+		o.container = value
+	case "restore_metadata":
+		o.restoreMetadata, err = strconv.ParseBool(value)
+	case "intro":
+		o.intro, err = strconv.ParseBool(value)
+	case "reproducible":
+		o.reproducible, err = strconv.ParseBool(value)
+	case "youtube_client_id":
+		o.youtubeClientID = value
+	case "youtube_client_secret":
+		o.youtubeClientSecret = value
+	case "reorder_window":
+		o.reorderWindow, err = strconv.Atoi(value)
+	case "queue_depth":
+		o.queueDepth, err = strconv.Atoi(value)
+	case "max_length":
+		o.maxLength, err = strconv.ParseInt(value, 10, 64)
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return nil
+}
+
+// parseSize parses a frame size written as WIDTHxHEIGHT.
+func parseSize(value string) (int, int, error) {
+	w, h, _ := strings.Cut(strings.ToLower(value), "x")
+	width, err := strconv.Atoi(w)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not WIDTHxHEIGHT", value)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not WIDTHxHEIGHT", value)
+	}
+	return width, height, nil
+}
+
+// argsValue is the -ffmpeg-args flag, split like a shell would.
+type argsValue struct{ opts *options }
+
+func (v argsValue) String() string {
+	if v.opts == nil {
+		return ""
+	}
+	return strings.Join(v.opts.ffmpegArgs, " ")
+}
+
+func (v argsValue) Set(value string) error { return v.opts.set("ffmpeg_args", value) }
+
+// sizeValue is the -size flag, setting the width and height of options.
+type sizeValue struct{ opts *options }
+
+func (v sizeValue) String() string {
+	if v.opts == nil {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", v.opts.width, v.opts.height)
+}
+
+func (v sizeValue) Set(value string) error { return v.opts.set("size", value) }
+
+// memoryValue is the -max-memory flag, a size such as 512M.
+type memoryValue struct{ opts *options }
+
+func (v memoryValue) String() string {
+	if v.opts == nil {
+		return ""
+	}
+	return formatBytes(v.opts.maxMemory)
+}
+
+func (v memoryValue) Set(value string) error { return v.opts.set("max_memory", value) }
+
+// eccValue is the -ecc flag, parity bytes or eccHamming.
+type eccValue struct{ opts *options }
+
+func (v eccValue) String() string {
+	if v.opts == nil {
+		return ""
+	}
+	if v.opts.eccHamming {
+		return eccHamming
+	}
+	return strconv.Itoa(v.opts.ecc)
+}
+
+func (v eccValue) Set(value string) error { return v.opts.set("ecc", value) }
+
+var configKeys = []string{
+	"codec", "bitrate", "size", "dot_size", "dot_bits", "modulation", "ecc", "threads", "readers", "writers",
+	"ffmpeg", "ffmpeg_args", "mmap", "segments", "gop", "bframes", "fps", "interleave", "repeat", "pixel_format", "frame_strip", "transport", "container",
+	"reorder_window", "queue_depth", "max_memory", "max_length", "nice", "max_throughput", "restore_metadata", "intro", "reproducible", "youtube_client_id", "youtube_client_secret",
+}
+
+// configPath returns $FILETOVIDEO_CONFIG if set, otherwise
+// ~/.config/filetovideo/config.toml (or the platform equivalent).
+func configPath() string {
+	if path := os.Getenv("FILETOVIDEO_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "filetovideo", "config.toml")
+}
+
+// loadConfig applies the config file and the environment on top of opts.
+// A missing config file is not an error.
+func loadConfig(opts *options) error {
+	if path := configPath(); path != "" {
+		file, err := os.Open(path)
+		if err == nil {
+			err = parseConfig(file, opts.set)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for _, key := range configKeys {
+		env := "FILETOVIDEO_" + strings.ToUpper(key)
+		if value, ok := os.LookupEnv(env); ok {
+			if err := opts.set(key, value); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfig reads the small subset of TOML the config file needs:
+// top level `key = value` pairs with string, integer or boolean values.
+func parseConfig(r io.Reader, apply func(key, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			return fmt.Errorf("line %d: tables are not supported", lineNumber)
+		}
+
+		key, rawValue, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value, err := parseConfigValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := apply(key, value); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseConfigValue(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	switch raw[0] {
+	case '"':
+		end := 0
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\\' {
+				i++
+			} else if raw[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := checkTrailingComment(raw[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(raw[:end+1])
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := checkTrailingComment(raw[end+2:]); err != nil {
+			return "", err
+		}
+		return raw[1 : end+1], nil
+	}
+
+	// Bare values (integers and booleans) end at the first comment
+	value, _, _ := strings.Cut(raw, "#")
+	return strings.TrimSpace(value), nil
+}
+
+func checkTrailingComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && rest[0] != '#' {
+		return fmt.Errorf("unexpected %q after value", rest)
+	}
+	return nil
+}
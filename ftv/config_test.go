@@ -0,0 +1,54 @@
+package ftv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		values []string // key=value as applied
+		err    string
+	}{
+		{"values", "codec = \"libx264\"\nthreads = 4\nmmap = true\n", []string{"codec=libx264", "threads=4", "mmap=true"}, ""},
+		{"comments", "# defaults\n\n  bitrate = '10M' # of the video\nfps=30# frames\n", []string{"bitrate=10M", "fps=30"}, ""},
+		{"escapes", `name = "a \"b\" \\ c"`, []string{`name=a "b" \ c`}, ""},
+		{"literal string", `ffmpeg = 'C:\ffmpeg\bin' # windows`, []string{`ffmpeg=C:\ffmpeg\bin`}, ""},
+		{"hash in string", `args = "-metadata title=#1"`, []string{"args=-metadata title=#1"}, ""},
+		{"table", "codec = \"libx264\"\n[encode]\n", nil, "line 2: tables are not supported"},
+		{"no value", "codec\n", nil, "line 1: expected key = value"},
+		{"empty value", "codec =\n", nil, "line 1: missing value"},
+		{"unterminated string", `codec = "libx264`, nil, "line 1: unterminated string"},
+		{"unterminated literal string", `codec = 'libx264`, nil, "line 1: unterminated string"},
+		{"after string", `codec = "libx264" libx265`, nil, `line 1: unexpected "libx265" after value`},
+		{"rejected", "threads = 4\n\nunknown = 1\n", nil, "line 3: unknown key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var values []string
+			err := parseConfig(strings.NewReader(tt.config), func(key, value string) error {
+				if key == "unknown" {
+					return errors.New("unknown key")
+				}
+				values = append(values, key+"="+value)
+				return nil
+			})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("parsed with %v, expected %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Fatalf("applied %q, expected %q", values, tt.values)
+			}
+		})
+	}
+}
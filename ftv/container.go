@@ -0,0 +1,168 @@
+package ftv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Containers the video of encode can be written in. Without -container the
+// one the extension of the output stands for is used, as ffmpeg would pick
+// it, and an extension it is not known for is left to ffmpeg.
+const (
+	containerMP4  = "mp4"
+	containerMKV  = "mkv"
+	containerWebM = "webm"
+)
+
+// containerFormat is what a container takes and how ffmpeg writes it.
+type containerFormat struct {
+	muxer      string
+	mimeType   string
+	extensions []string
+	families   []string // Of the codecs it holds, nil for all of them
+}
+
+var containers = map[string]containerFormat{
+	containerMP4:  {muxer: "mp4", mimeType: "video/mp4", extensions: []string{".mp4", ".m4v"}, families: []string{"h264", "hevc", "av1", "vp9"}},
+	containerMKV:  {muxer: "matroska", mimeType: "video/x-matroska", extensions: []string{".mkv"}},
+	containerWebM: {muxer: "webm", mimeType: "video/webm", extensions: []string{".webm"}, families: []string{"vp8", "vp9", "av1"}},
+}
+
+// webmCodec is what -codec auto picks for webm, which takes no H.264.
+const webmCodec = vp9Codec
+
+// codecFamily returns the format the ffmpeg encoder codec writes, empty for
+// encoders it does not know.
+func codecFamily(codec string) string {
+	switch {
+	case codec == autoCodec || strings.HasPrefix(codec, "libx264") || strings.HasPrefix(codec, "h264_"):
+		return "h264"
+	case codec == "libx265" || strings.HasPrefix(codec, "hevc_"):
+		return "hevc"
+	case codec == av1Codec || codec == "libaom-av1" || codec == "libsvtav1" || codec == "librav1e" || strings.HasPrefix(codec, "av1_"):
+		return "av1"
+	case codec == vp9Codec || codec == "libvpx-vp9" || strings.HasPrefix(codec, "vp9_"):
+		return "vp9"
+	case codec == "libvpx" || strings.HasPrefix(codec, "vp8_"):
+		return "vp8"
+	case codec == "ffv1":
+		return "ffv1"
+	}
+	return ""
+}
+
+// containerOf returns the container the video at path is written in, empty
+// if it is left to ffmpeg.
+func containerOf(path string, opts options) string {
+	if opts.container != "" {
+		return opts.container
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for name, format := range containers {
+		for _, e := range format.extensions {
+			if ext == e {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// videoContentType returns the media type of the video at path, for
+// uploads. Containers left to ffmpeg are sent as plain bytes.
+func videoContentType(path string, opts options) string {
+	if format, ok := containers[containerOf(path, opts)]; ok {
+		return format.mimeType
+	}
+	return "application/octet-stream"
+}
+
+// videoExtension returns the extension of the video encode writes with opts
+// where only its name is up to FileToVideo, as in serve and watch: that of
+// -container, or else of mp4 unless the codec does not go in it, such as
+// ffv1 or VP8, which get the first container taking them.
+func videoExtension(opts options) string {
+	if opts.container != "" {
+		return containers[opts.container].extensions[0]
+	}
+	family := codecFamily(opts.codec)
+	if family == "" {
+		return containers[containerMP4].extensions[0]
+	}
+	for _, name := range []string{containerMP4, containerWebM, containerMKV} {
+		format := containers[name]
+		if format.families == nil {
+			return format.extensions[0]
+		}
+		for _, f := range format.families {
+			if f == family {
+				return format.extensions[0]
+			}
+		}
+	}
+	return containers[containerMKV].extensions[0]
+}
+
+// containerCodec returns the codec encode asks ffmpeg for: opts.codec,
+// unless it is auto and the container of path takes no H.264.
+func containerCodec(path string, opts options) string {
+	if opts.codec == autoCodec && containerOf(path, opts) == containerWebM {
+		return webmCodec
+	}
+	return opts.codec
+}
+
+// checkContainer fails if the video at dest cannot be written with opts:
+// -container contradicts its extension, or the codec does not go in the
+// container.
+func checkContainer(dest string, opts options) error {
+	name := containerOf(dest, opts)
+	if name == "" {
+		return nil
+	}
+	ext := strings.ToLower(filepath.Ext(dest))
+	if opts.container != "" && ext != "" {
+		for other, format := range containers {
+			for _, e := range format.extensions {
+				if e == ext && other != name {
+					return fmt.Errorf("-container %s does not match the extension of %s", name, dest)
+				}
+			}
+		}
+	}
+	family := codecFamily(containerCodec(dest, opts))
+	format := containers[name]
+	if family == "" || format.families == nil {
+		return nil
+	}
+	for _, f := range format.families {
+		if f == family {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s cannot hold %s video from -codec %s (it takes %s)", name, family, opts.codec, strings.Join(format.families, ", "))
+}
+
+// muxerArgs returns the output options of an ffmpeg writing the video at
+// path: the muxer if -container picked it, for mp4 the index moved to the
+// front, so players start before the whole video is downloaded, and with
+// -reproducible no creation time, ffmpeg version or other metadata that
+// would differ from one run to the next.
+func muxerArgs(path string, opts options) []string {
+	if isLive(path) {
+		return nil
+	}
+	var args []string
+	name := containerOf(path, opts)
+	if opts.container != "" {
+		args = append(args, "-f", containers[name].muxer)
+	}
+	if name == containerMP4 {
+		args = append(args, "-movflags", "+faststart")
+	}
+	if opts.reproducible {
+		args = append(args, "-fflags", "+bitexact", "-map_metadata", "-1")
+	}
+	return args
+}
@@ -0,0 +1,53 @@
+package ftv
+
+import "testing"
+
+func TestVideoExtension(t *testing.T) {
+	tests := []struct {
+		codec, container string
+		ext              string
+	}{
+		{autoCodec, "", ".mp4"},
+		{"libx265", "", ".mp4"},
+		{vp9Codec, "", ".mp4"},
+		{"ffv1", "", ".mkv"},
+		{"libvpx", "", ".webm"},
+		{"some_encoder", "", ".mp4"},
+		{autoCodec, containerWebM, ".webm"},
+		{"libx264", containerMKV, ".mkv"},
+	}
+	for _, tt := range tests {
+		opts := defaultOptions()
+		opts.codec, opts.container = tt.codec, tt.container
+		ext := videoExtension(opts)
+		if ext != tt.ext {
+			t.Errorf("-codec %s -container %q: %s, expected %s", tt.codec, tt.container, ext, tt.ext)
+		}
+		if err := checkContainer("video"+ext, opts); err != nil {
+			t.Errorf("-codec %s -container %q: %v", tt.codec, tt.container, err)
+		}
+	}
+}
+
+func TestCheckContainer(t *testing.T) {
+	tests := []struct {
+		dest, codec, container string
+		fails                  bool
+	}{
+		{"video.mp4", autoCodec, "", false},
+		{"video.mp4", "ffv1", "", true},
+		{"video.webm", "libx264", "", true},
+		{"video.webm", autoCodec, "", false},
+		{"video.mkv", "ffv1", "", false},
+		{"video.mp4", autoCodec, containerWebM, true},
+		{"video", "ffv1", containerMP4, true},
+		{"video.avi", "ffv1", "", false},
+	}
+	for _, tt := range tests {
+		opts := defaultOptions()
+		opts.codec, opts.container = tt.codec, tt.container
+		if err := checkContainer(tt.dest, opts); (err != nil) != tt.fails {
+			t.Errorf("%s -codec %s -container %q: %v", tt.dest, tt.codec, tt.container, err)
+		}
+	}
+}
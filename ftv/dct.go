@@ -0,0 +1,109 @@
+package ftv
+
+import "math"
+
+// With the dct modulation the frame is cut into 8x8 pixel blocks, like the
+// ones H.264 and VP9 transform, and every block carries a bit in the sign of
+// each of its lowest frequency DCT coefficients. Those are what a lossy
+// encoder keeps longest, so the bits survive re-encodes that smear dots
+// beyond recognition. Blocks are gray around mid level, so chroma
+// subsampling does not touch them either. Bits go block by block, row by
+// row from the top left corner, most significant bit first.
+const (
+	modulationDots = "dots"
+	modulationDCT  = "dct"
+
+	dctBlock     = 8
+	dctAmplitude = 24 // Peak of every coefficient's pattern, in pixel levels
+)
+
+// dctCoefficients are the (u, v) frequencies carrying the bits of a block,
+// the first ones of the zigzag order after DC.
+var dctCoefficients = [][2]int{{0, 1}, {1, 0}, {2, 0}, {1, 1}, {0, 2}}
+
+// dctBasis holds the pattern of every coefficient over the pixels of a
+// block, between -1 and 1.
+var dctBasis = func() [][dctBlock * dctBlock]float64 {
+	basis := make([][dctBlock * dctBlock]float64, len(dctCoefficients))
+	for i, c := range dctCoefficients {
+		for y := 0; y < dctBlock; y++ {
+			for x := 0; x < dctBlock; x++ {
+				basis[i][y*dctBlock+x] = math.Cos(float64(2*x+1)*float64(c[0])*math.Pi/(2*dctBlock)) *
+					math.Cos(float64(2*y+1)*float64(c[1])*math.Pi/(2*dctBlock))
+			}
+		}
+	}
+	return basis
+}()
+
+// dctBlocks is the dct modulation over the frames of g.
+type dctBlocks struct{ g frameGeometry }
+
+func (m dctBlocks) Capacity() int {
+	return (m.g.width / dctBlock) * (m.g.height / dctBlock) * len(dctCoefficients) / 8
+}
+
+// PackFrame paints bits into the RGBA frame pixelData.
+func (m dctBlocks) PackFrame(bits, pixelData []byte) {
+	g := m.g
+	columns := g.width / dctBlock
+	total := len(bits) * 8
+	var levels [dctBlock * dctBlock]float64
+	for block := 0; block*len(dctCoefficients) < total; block++ {
+		for p := range levels {
+			levels[p] = 128
+		}
+		for i := range dctCoefficients {
+			sign := -1.0
+			if bit := block*len(dctCoefficients) + i; bit < total && bits[bit/8]&(0x80>>(bit%8)) != 0 {
+				sign = 1
+			}
+			for p := range levels {
+				levels[p] += sign * dctAmplitude * dctBasis[i][p]
+			}
+		}
+		x0, y0 := block%columns*dctBlock, g.top+block/columns*dctBlock
+		for p, level := range levels {
+			value := byte(math.Round(level))
+			offset := ((y0+p/dctBlock)*g.width + x0 + p%dctBlock) * 4 // 4 channels
+			pixelData[offset], pixelData[offset+1], pixelData[offset+2] = value, value, value
+		}
+	}
+}
+
+// UnpackFrame recovers the zeroed bits from the RGB frame copies by
+// correlating the luma of every block with the coefficient patterns. The
+// correlations of all copies are added up, so a copy damaged a little is
+// outvoted by the others.
+func (m dctBlocks) UnpackFrame(copies [][]byte, bits []byte) {
+	g := m.g
+	columns := g.width / dctBlock
+	total := len(bits) * 8
+	var luma [dctBlock * dctBlock]float64
+	for block := 0; block*len(dctCoefficients) < total; block++ {
+		x0, y0 := block%columns*dctBlock, g.top+block/columns*dctBlock
+		for p := range luma {
+			luma[p] = 0
+		}
+		for _, c := range copies {
+			for p := range luma {
+				offset := ((y0+p/dctBlock)*g.width + x0 + p%dctBlock) * 3
+				luma[p] += 0.299*float64(c[offset]) + 0.587*float64(c[offset+1]) + 0.114*float64(c[offset+2])
+			}
+		}
+		for i := range dctCoefficients {
+			bit := block*len(dctCoefficients) + i
+			if bit >= total {
+				break
+			}
+			// The mean level does not matter, the patterns average to zero
+			var sum float64
+			for p, level := range luma {
+				sum += level * dctBasis[i][p]
+			}
+			if sum > 0 {
+				bits[bit/8] |= 0x80 >> (bit % 8)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package ftv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A delta holds the blocks of a file that changed since an earlier version of
+// it, the base. It is encoded like any payload, with the delta flag set in the
+// stream header, and decoding applies it to the file decoded from the video of
+// the base. All integers are big endian:
+//
+//	 0  4  magic "FTVX"
+//	 4  4  block size
+//	 8  8  length of the new version
+//	16  8  length of the base
+//	24 32  SHA-256 of the base
+//	56 32  SHA-256 of the new version
+//	88  8  number of changed blocks
+//	96     index of every changed block, 8 bytes each, followed by the blocks
+//
+// The last block of the new version may be short. Blocks past the end of
+// the base are always changed.
+const (
+	deltaMagic      = "FTVX"
+	deltaBlockSize  = 64 << 10
+	deltaHeaderSize = 96
+)
+
+type deltaHeader struct {
+	blockSize  int
+	length     int64
+	baseLength int64
+	baseHash   [sha256.Size]byte
+	hash       [sha256.Size]byte
+	changed    int64
+}
+
+func (h *deltaHeader) marshal() []byte {
+	b := make([]byte, deltaHeaderSize)
+	copy(b, deltaMagic)
+	binary.BigEndian.PutUint32(b[4:], uint32(h.blockSize))
+	binary.BigEndian.PutUint64(b[8:], uint64(h.length))
+	binary.BigEndian.PutUint64(b[16:], uint64(h.baseLength))
+	copy(b[24:], h.baseHash[:])
+	copy(b[56:], h.hash[:])
+	binary.BigEndian.PutUint64(b[88:], uint64(h.changed))
+	return b
+}
+
+func parseDeltaHeader(b []byte) (*deltaHeader, error) {
+	if len(b) < deltaHeaderSize || string(b[:4]) != deltaMagic {
+		return nil, errors.New("not a delta")
+	}
+	h := &deltaHeader{
+		blockSize:  int(binary.BigEndian.Uint32(b[4:])),
+		length:     int64(binary.BigEndian.Uint64(b[8:])),
+		baseLength: int64(binary.BigEndian.Uint64(b[16:])),
+		changed:    int64(binary.BigEndian.Uint64(b[88:])),
+	}
+	copy(h.baseHash[:], b[24:])
+	copy(h.hash[:], b[56:])
+	if h.blockSize <= 0 || h.length < 0 || h.baseLength < 0 || h.changed < 0 ||
+		h.changed > (h.length+int64(h.blockSize)-1)/int64(h.blockSize) {
+		return nil, errors.New("delta header is corrupted")
+	}
+	return h, nil
+}
+
+// writeDelta writes the delta turning base into input to a temporary file
+// and returns its path, which the caller removes.
+func writeDelta(input, base string) (string, error) {
+	src, err := os.Open(input)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	old, err := os.Open(base)
+	if err != nil {
+		return "", err
+	}
+	defer old.Close()
+
+	// First pass: hash both versions and find the blocks that differ
+	header := &deltaHeader{blockSize: deltaBlockSize}
+	var changed []int64
+	newHash, baseHash := sha256.New(), sha256.New()
+	block, baseBlock := make([]byte, deltaBlockSize), make([]byte, deltaBlockSize)
+	for index := int64(0); ; index++ {
+		n, err := io.ReadFull(src, block)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("reading %s: %w", input, err)
+		}
+		m, baseErr := io.ReadFull(old, baseBlock)
+		if baseErr != nil && baseErr != io.EOF && baseErr != io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("reading %s: %w", base, baseErr)
+		}
+		if n == 0 && m == 0 {
+			break
+		}
+		newHash.Write(block[:n])
+		baseHash.Write(baseBlock[:m])
+		header.length += int64(n)
+		header.baseLength += int64(m)
+		if n > 0 && (m < n || !bytes.Equal(block[:n], baseBlock[:n])) {
+			changed = append(changed, index)
+		}
+	}
+	newHash.Sum(header.hash[:0])
+	baseHash.Sum(header.baseHash[:0])
+	header.changed = int64(len(changed))
+
+	// Second pass: write the changed blocks after the header and the indices
+	out, err := os.CreateTemp("", ".filetovideo-delta-*")
+	if err != nil {
+		return "", err
+	}
+	written := false
+	defer func() {
+		if !written {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	buffered := bufio.NewWriter(out)
+	buffered.Write(header.marshal())
+	var index [8]byte
+	for _, i := range changed {
+		binary.BigEndian.PutUint64(index[:], uint64(i))
+		buffered.Write(index[:])
+	}
+	for _, i := range changed {
+		n, err := src.ReadAt(block, i*deltaBlockSize)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("reading %s: %w", input, err)
+		}
+		if _, err := buffered.Write(block[:n]); err != nil {
+			return "", err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	written = true
+	logger.verbose("reader", "delta written", fields{"changed_blocks": len(changed), "blocks": (header.length + deltaBlockSize - 1) / deltaBlockSize})
+	return out.Name(), nil
+}
+
+// applyDelta writes to dest the new version of the file base that the delta
+// at path turns it into, refusing to make it longer than maxLength unless
+// that is 0.
+func applyDelta(path, base, dest string, maxLength int64) error {
+	delta, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+	prefix := make([]byte, deltaHeaderSize)
+	if _, err := io.ReadFull(delta, prefix); err != nil {
+		return errors.New("delta is truncated")
+	}
+	header, err := parseDeltaHeader(prefix)
+	if err != nil {
+		return err
+	}
+	if maxLength > 0 && header.length > maxLength {
+		return withCause(ErrCorruptHeader, fmt.Errorf("delta declares a file of %d bytes, more than the %d of -max-length", header.length, maxLength))
+	}
+
+	// The base must be the very version the delta was made against
+	old, err := os.Open(base)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	baseHash := sha256.New()
+	baseLength, err := io.Copy(io.MultiWriter(out, baseHash), old)
+	if err != nil {
+		return fmt.Errorf("copying the base: %w", err)
+	}
+	if baseLength != header.baseLength || !bytes.Equal(baseHash.Sum(nil), header.baseHash[:]) {
+		return errors.New("the base video does not hold the version of the file the delta was made against")
+	}
+	if err := out.Truncate(header.length); err != nil {
+		return err
+	}
+
+	indices := make([]byte, header.changed*8)
+	if _, err := io.ReadFull(delta, indices); err != nil {
+		return errors.New("delta is truncated")
+	}
+	block := make([]byte, header.blockSize)
+	for i := int64(0); i < header.changed; i++ {
+		offset := int64(binary.BigEndian.Uint64(indices[i*8:])) * int64(header.blockSize)
+		if offset >= header.length {
+			return errors.New("delta is corrupted: block past the end of the file")
+		}
+		n := int64(header.blockSize)
+		if offset+n > header.length {
+			n = header.length - offset
+		}
+		if _, err := io.ReadFull(delta, block[:n]); err != nil {
+			return errors.New("delta is truncated")
+		}
+		if _, err := out.WriteAt(block[:n], offset); err != nil {
+			return err
+		}
+	}
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, out); err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), header.hash[:]) {
+		return errors.New("file rebuilt from the delta does not match the hash of the new version")
+	}
+	return out.Close()
+}
+
+// rebuildFromDelta replaces the delta decoded to path by the file it is the
+// new version of, decoding opts.deltaBase next to it for the base.
+func rebuildFromDelta(ctx context.Context, path string, opts options) error {
+	deltaPath, basePath := path+".delta", path+".base"
+	if err := os.Rename(path, deltaPath); err != nil {
+		return err
+	}
+	defer os.Remove(deltaPath)
+	defer os.Remove(basePath)
+
+	baseOpts := opts
+	baseOpts.deltaBase = ""
+	baseOpts.reportPath = ""
+	baseOpts.partial = false
+	baseOpts.restoreMetadata = false
+	baseOpts.overwrite = true
+	baseOpts.onProgress = nil
+	logger.verbose("writer", "decoding the base", fields{"video": opts.deltaBase})
+	if err := decode(ctx, opts.deltaBase, basePath, baseOpts); err != nil {
+		return fmt.Errorf("decoding the base %s: %w", opts.deltaBase, err)
+	}
+	if err := applyDelta(deltaPath, basePath, path, opts.maxLength); err != nil {
+		return fmt.Errorf("applying the delta: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !freebsd
+
+package ftv
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// setDirMetadata sets the mode and times of the directory at path. It fails
+// rather than following a symlink an entry extracted later put in its place.
+func setDirMetadata(path string, mode fs.FileMode, t time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is no longer a directory", path)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(path, t, t)
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd
+
+package ftv
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// setDirMetadata sets the mode and times of the directory at path. It fails
+// rather than following a symlink an entry extracted later put in its place.
+func setDirMetadata(path string, mode fs.FileMode, t time.Time) error {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.ENOTDIR) {
+		return fmt.Errorf("%s is no longer a directory", path)
+	} else if err != nil {
+		return &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	dir := os.NewFile(uintptr(fd), path)
+	defer dir.Close()
+	if err := dir.Chmod(mode); err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	if err := syscall.Futimes(fd, []syscall.Timeval{tv, tv}); err != nil {
+		return &fs.PathError{Op: "futimes", Path: path, Err: err}
+	}
+	return nil
+}
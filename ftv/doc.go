@@ -0,0 +1,6 @@
+// Package ftv converts files to videos and back. It is the whole of the
+// FileToVideo command, whose command line Main runs, and can be used from
+// other programs through NewWriter, which encodes what is written to it
+// into a video, and NewReader, which decodes a video into a stream. Both
+// take Options, and their errors match the Err causes with errors.Is.
+package ftv
@@ -0,0 +1,204 @@
+package ftv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// runDoctor checks what encoding and decoding need on this machine, ffmpeg,
+// its encoders and a round trip with the current settings, and prints what
+// passed and how to fix what did not.
+func runDoctor(args []string) {
+	c := doctorCLI()
+	c.parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	checks := diagnose(ctx, c.opts)
+	failed := 0
+	for _, check := range checks {
+		if check.Status == doctorFail {
+			failed++
+		}
+	}
+
+	// The report is the output of the command, so it is not subject to -q
+	if logger.format == logJSON {
+		json.NewEncoder(os.Stdout).Encode(checks)
+	} else {
+		for _, check := range checks {
+			fmt.Printf("%-4s  %-18s  %s\n", strings.ToUpper(check.Status), check.Name, check.Detail)
+			if check.Hint != "" {
+				fmt.Printf("      %-18s  -> %s\n", "", check.Hint)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		logger.fatal("doctor", ctx.Err())
+	}
+	if failed > 0 {
+		logger.fatal("doctor", fmt.Errorf("%d of %d checks failed", failed, len(checks)))
+	}
+}
+
+// doctorCLI defines the flags of doctor, the shared ones pick the settings
+// checked.
+func doctorCLI() *cli {
+	return newCLI("doctor")
+}
+
+// Outcomes of a check. A warning leaves encoding working, only slower or
+// with less choice.
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// diagnose runs the checks of doctor in order, skipping those that need
+// what an earlier one found missing.
+func diagnose(ctx context.Context, opts options) []doctorCheck {
+	var checks []doctorCheck
+	needsFFmpeg := opts.transport == transportFFmpeg
+
+	version, err := ffmpegVersion(ctx, opts.ffmpegPath)
+	if err != nil {
+		check := doctorCheck{
+			Name:   "ffmpeg",
+			Status: doctorFail,
+			Detail: err.Error(),
+			Hint:   "install ffmpeg and put it on PATH or next to FileToVideo, or point -ffmpeg at it",
+		}
+		if !needsFFmpeg {
+			check.Status = doctorWarn
+			check.Detail += ", -transport " + opts.transport + " does without it"
+		}
+		checks = append(checks, check)
+		if needsFFmpeg {
+			return checks
+		}
+	} else {
+		path := lookFFmpeg(opts.ffmpegPath)
+		if found, err := exec.LookPath(path); err == nil {
+			path = found
+		}
+		checks = append(checks, doctorCheck{Name: "ffmpeg", Status: doctorPass, Detail: version + " at " + path})
+		checks = append(checks, diagnoseEncoders(ctx, opts)...)
+	}
+
+	checks = append(checks, diagnoseRoundTrip(ctx, opts))
+	return checks
+}
+
+// ffmpegVersion returns the version ffmpeg reports, such as "6.1.1".
+func ffmpegVersion(ctx context.Context, ffmpegPath string) (string, error) {
+	out, err := ffmpegCommand(ctx, ffmpegPath, "-hide_banner", "-version").Output()
+	if err != nil {
+		if errors.Is(ffmpegError(err), ErrFFmpegNotFound) {
+			return "", fmt.Errorf("%s not found", ffmpegPath)
+		}
+		return "", ffmpegError(err)
+	}
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	// The first line looks like "ffmpeg version 6.1.1 Copyright (c) ..."
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", fmt.Errorf("%s -version prints no version, it may not be ffmpeg", ffmpegPath)
+	}
+	return fields[2], nil
+}
+
+// diagnoseEncoders checks every hardware encoder ffmpeg was built with and
+// the encoder -codec picks.
+func diagnoseEncoders(ctx context.Context, opts options) []doctorCheck {
+	available, err := listEncoders(ctx, opts.ffmpegPath)
+	if err != nil {
+		return []doctorCheck{{Name: "encoders", Status: doctorFail, Detail: err.Error(), Hint: "check that ffmpeg runs on its own: ffmpeg -encoders"}}
+	}
+
+	var checks []doctorCheck
+	usable := 0
+	for _, encoder := range hwEncoders {
+		if !available[encoder.codec] {
+			continue
+		}
+		check := doctorCheck{Name: encoder.codec, Status: doctorPass, Detail: encoder.vendor}
+		if err := probeEncoder(ctx, opts.ffmpegPath, encoder); err != nil {
+			check.Status = doctorWarn
+			check.Detail = fmt.Sprintf("%s, built in but unusable: %s", encoder.vendor, firstLine(err.Error()))
+			check.Hint = "install or update the " + encoder.vendor + " driver, or ignore it if this machine has no such hardware"
+		} else {
+			usable++
+		}
+		checks = append(checks, check)
+	}
+	if usable == 0 {
+		checks = append(checks, doctorCheck{
+			Name:   "hardware encoders",
+			Status: doctorWarn,
+			Detail: "none usable, encoding runs on the CPU",
+			Hint:   "an ffmpeg built with NVENC, Quick Sync, VideoToolbox, AMF or VA-API and the matching driver encode faster",
+		})
+	}
+
+	codec, err := resolveCodec(ctx, opts.ffmpegPath, opts.codec, opts.reproducible)
+	switch {
+	case err != nil:
+		checks = append(checks, doctorCheck{Name: "encoder", Status: doctorFail, Detail: err.Error(), Hint: "install an ffmpeg built with libx264, or pick an encoder it has with -codec"})
+	case !available[codec]:
+		checks = append(checks, doctorCheck{Name: "encoder", Status: doctorFail, Detail: "ffmpeg has no encoder " + codec, Hint: "install an ffmpeg built with it, or pick one from ffmpeg -encoders with -codec"})
+	default:
+		checks = append(checks, doctorCheck{Name: "encoder", Status: doctorPass, Detail: codec + " for -codec " + opts.codec})
+	}
+	return checks
+}
+
+// diagnoseRoundTrip encodes a payload of two frames with opts and decodes it.
+func diagnoseRoundTrip(ctx context.Context, opts options) doctorCheck {
+	check := doctorCheck{Name: "round trip"}
+	dir, err := os.MkdirTemp("", "filetovideo-doctor-*")
+	if err != nil {
+		check.Status = doctorFail
+		check.Detail = err.Error()
+		check.Hint = "make the temporary directory writable, or point TMPDIR at one that is"
+		return check
+	}
+	defer os.RemoveAll(dir)
+
+	size := int64(frameCapacity(opts)) + 1
+	result := roundTrip(ctx, filepath.Join(dir, "doctor"), size, opts)
+	if result.Error != "" {
+		check.Status = doctorFail
+		check.Detail = firstLine(result.Error)
+		check.Hint = "run selftest with the same flags: if only its lossless round trips pass, raise -bitrate or use a larger -dot or fewer -dot-bits"
+		return check
+	}
+	check.Status = doctorPass
+	check.Detail = fmt.Sprintf("%d bytes encoded and decoded with the current settings in %.1fs", size, result.Seconds)
+	return check
+}
+
+// firstLine returns s up to its first line break, ffmpeg errors can go on
+// for pages.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,190 @@
+package ftv
+
+// Dots are laid out row by row from the top left corner of the frame, below
+// the strip if there is one (see strip.go). With
+// 3 bits per dot every RGB channel is either fully on or off and the bytes
+// are read most significant bit first. With 24 bits per dot the channels of
+// a dot are three consecutive bytes.
+
+// frameGeometry places the dots in a frame. Encode and decode share it, so
+// any frame size that the dot size divides round-trips.
+type frameGeometry struct {
+	width, height int // Size of the data area in pixels
+	top           int // Rows of pixels above the data area, taken by the strip
+	dotSize       int
+}
+
+func geometryOf(opts options) frameGeometry {
+	top := stripHeight(opts)
+	return frameGeometry{width: opts.width, height: opts.height - top, top: top, dotSize: opts.dotSize}
+}
+
+// columns returns how many dots fit in a line of the frame.
+func (g frameGeometry) columns() int { return g.width / g.dotSize }
+
+// dots returns how many dots a frame holds.
+func (g frameGeometry) dots() int { return g.columns() * (g.height / g.dotSize) }
+
+// frameBytes returns the size of a frame of bytesPerPixel.
+func (g frameGeometry) frameBytes(bytesPerPixel int) int {
+	return g.width * (g.top + g.height) * bytesPerPixel
+}
+
+// dotOrigin returns the top left pixel of dot n.
+func (g frameGeometry) dotOrigin(n int) (x, y int) {
+	return n % g.columns() * g.dotSize, g.top + n/g.columns()*g.dotSize
+}
+
+// sampleOffset returns where the pixel read back for dot n starts in a frame
+// of bytesPerPixel. It is the middle of the dot, whose edges blur the most.
+// For dots of 3 pixels or more the 2x2 block of subsampled chroma holding it
+// lies within the dot too, so its color is not mixed with a neighbour's.
+func (g frameGeometry) sampleOffset(n, bytesPerPixel int) int {
+	x, y := g.dotOrigin(n)
+	center := (g.dotSize - 1) / 2
+	return ((y+center)*g.width + x + center) * bytesPerPixel
+}
+
+// paintRow hands fill the top line of pixels of the row of dots starting at
+// dot first and copies what it painted down over the rest of the row, so a
+// dot is painted one line at a time rather than pixel by pixel.
+func (g frameGeometry) paintRow(pixelData []byte, first int, fill func(line []byte)) {
+	lineBytes := g.width * 4 // 4 channels
+	_, y := g.dotOrigin(first)
+	line := pixelData[y*lineBytes : (y+1)*lineBytes]
+	fill(line)
+	for l := 1; l < g.dotSize; l++ {
+		copy(pixelData[(y+l)*lineBytes:(y+l+1)*lineBytes], line)
+	}
+}
+
+// bitDots is the modulation of 3 bit dots, every RGB channel fully on or
+// off.
+type bitDots struct {
+	g    frameGeometry
+	runs [8][]byte // A line of pixels of a dot of every color, RGBA
+}
+
+func newBitDots(g frameGeometry) bitDots {
+	m := bitDots{g: g}
+	for color := range m.runs {
+		run := make([]byte, g.dotSize*4)
+		for p := 0; p < len(run); p += 4 {
+			for channel := 0; channel < 3; channel++ {
+				if color&(4>>channel) != 0 {
+					run[p+channel] = 0xff
+				}
+			}
+		}
+		m.runs[color] = run
+	}
+	return m
+}
+
+func (m bitDots) Capacity() int { return m.g.dots() * 3 / 8 }
+
+// PackFrame paints bits into the RGBA frame pixelData, one bit per channel.
+// Every 3 bytes are 8 dots, whose colors are taken out of them at once.
+func (m bitDots) PackFrame(bits, pixelData []byte) {
+	g := m.g
+	dots := (len(bits)*8 + 2) / 3
+	columns := g.columns()
+	runBytes := g.dotSize * 4
+	for first := 0; first < dots; first += columns {
+		end := first + columns
+		if end > dots {
+			end = dots
+		}
+		g.paintRow(pixelData, first, func(line []byte) {
+			colors := dotColors(bits, first/8)
+			for dot := first; dot < end; dot++ {
+				if dot%8 == 0 {
+					colors = dotColors(bits, dot/8)
+				}
+				if color := colors[dot%8]; color != 0 {
+					copy(line[(dot-first)*runBytes:], m.runs[color])
+				}
+			}
+		})
+	}
+}
+
+// dotColors returns the colors of the 8 dots of group, which the 3 bytes
+// of bits from group*3 on carry, as 3 bits red, green and blue from the
+// most significant. Bytes past the end of bits read as 0.
+func dotColors(bits []byte, group int) (colors [8]byte) {
+	var word uint32
+	for i := group * 3; i < group*3+3; i++ {
+		word <<= 8
+		if i < len(bits) {
+			word |= uint32(bits[i])
+		}
+	}
+	for i := range colors {
+		colors[i] = byte(word >> (21 - 3*i) & 7)
+	}
+	return colors
+}
+
+// fullDots is the modulation of 24 bit dots, a byte per RGB channel.
+type fullDots struct{ g frameGeometry }
+
+func (m fullDots) Capacity() int { return m.g.dots() * 3 }
+
+// PackFrame paints values into the RGBA frame pixelData, one byte per
+// channel. The first pixel of a dot is doubled until the line of the dot is
+// full.
+func (m fullDots) PackFrame(values, pixelData []byte) {
+	g := m.g
+	dots := (len(values) + 2) / 3
+	columns := g.columns()
+	runBytes := g.dotSize * 4
+	for first := 0; first < dots; first += columns {
+		end := first + columns
+		if end > dots {
+			end = dots
+		}
+		g.paintRow(pixelData, first, func(line []byte) {
+			for dot := first; dot < end; dot++ {
+				run := line[(dot-first)*runBytes : (dot-first+1)*runBytes]
+				copy(run[:3], values[dot*3:])
+				for n := 4; n < len(run); n *= 2 {
+					copy(run[n:], run[:n])
+				}
+			}
+		})
+	}
+}
+
+// UnpackFrame samples every dot of the RGB frame copies into the zeroed
+// bits, one bit per channel. The channels of the last dot beyond the end of
+// bits are left out.
+func (m bitDots) UnpackFrame(copies [][]byte, bits []byte) {
+	g := m.g
+	total := len(bits) * 8
+	bit := 0
+	for dot := 0; bit < total; dot++ {
+		offset := g.sampleOffset(dot, 3)
+		for channel := 0; channel < 3 && bit < total; channel++ {
+			if majorityBit(copies, offset+channel) {
+				bits[bit/8] |= 0x80 >> (bit % 8)
+			}
+			bit++
+		}
+	}
+}
+
+// UnpackFrame samples every dot of the RGB frame copies into values, one
+// byte per channel. Repeated frames are averaged, their errors are small
+// shifts of the color rather than flipped bits.
+func (m fullDots) UnpackFrame(copies [][]byte, values []byte) {
+	g := m.g
+	for i := range values {
+		channel := g.sampleOffset(i/3, 3) + i%3
+		sum := 0
+		for _, c := range copies {
+			sum += int(c[channel])
+		}
+		values[i] = byte((sum + len(copies)/2) / len(copies))
+	}
+}
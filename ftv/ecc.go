@@ -0,0 +1,94 @@
+package ftv
+
+// frameECC protects every frame with Reed-Solomon or Hamming codewords. The raw frame,
+// as many bytes as its dots carry, is split into codewords of equal length
+// of up to 255 bytes whose bytes are interleaved, so a damaged area of the
+// frame is spread over all of them:
+//
+//	raw[i*blocks+b] = codeword b, byte i
+//
+// Codeword b carries bytes b*k ... (b+1)*k-1 of the data, k being its length
+// without the parity bytes. The few raw bytes left over are unused.
+type frameECC struct {
+	code   codewordCoder
+	parity int // Bytes of every codeword
+	blocks int
+	length int // Of every codeword
+}
+
+// codewordCoder is the code of the codewords, Reed-Solomon or Hamming (see
+// hamming.go).
+type codewordCoder interface {
+	// encode fills the parity at the end of codeword from the data in front
+	encode(codeword []byte)
+	// decode corrects codeword in place and returns how many bytes were wrong
+	decode(codeword []byte) (int, error)
+}
+
+func newFrameECC(rawSize, parity int) *frameECC {
+	blocks := (rawSize + 254) / 255
+	return &frameECC{code: newReedSolomon(parity), parity: parity, blocks: blocks, length: rawSize / blocks}
+}
+
+// newHammingECC is newFrameECC for the Hamming code, whose codewords are
+// half parity.
+func newHammingECC(rawSize int) *frameECC {
+	blocks := (rawSize + 253) / 254
+	length := rawSize / blocks &^ 1
+	return &frameECC{code: hamming{}, parity: length / 2, blocks: blocks, length: length}
+}
+
+// dataSize returns how many bytes of data a frame carries.
+func (e *frameECC) dataSize() int { return e.blocks * (e.length - e.parity) }
+
+// encode protects data, which must be dataSize bytes long, into raw.
+func (e *frameECC) encode(data, raw []byte) {
+	k := e.length - e.parity
+	codeword := make([]byte, e.length)
+	for b := 0; b < e.blocks; b++ {
+		copy(codeword, data[b*k:(b+1)*k])
+		e.code.encode(codeword)
+		for i, c := range codeword {
+			raw[i*e.blocks+b] = c
+		}
+	}
+}
+
+// frameRepair is what decoding the ECC of a frame and checking its strip
+// found.
+type frameRepair struct {
+	corrected int   // Bytes corrected
+	codewords int   // Codewords that needed corrections
+	failed    []int // Codewords beyond repair, their data is left as read
+	mismatch  bool  // The data does not match the CRC of the frame strip
+}
+
+// decode corrects raw and writes the data to data.
+func (e *frameECC) decode(raw, data []byte) frameRepair {
+	k := e.length - e.parity
+	codeword := make([]byte, e.length)
+	var repair frameRepair
+	for b := 0; b < e.blocks; b++ {
+		for i := range codeword {
+			codeword[i] = raw[i*e.blocks+b]
+		}
+		n, err := e.code.decode(codeword)
+		if err != nil {
+			repair.failed = append(repair.failed, b)
+			for i := range codeword {
+				codeword[i] = raw[i*e.blocks+b]
+			}
+		} else if n > 0 {
+			repair.corrected += n
+			repair.codewords++
+		}
+		copy(data[b*k:], codeword[:k])
+	}
+	return repair
+}
+
+// codewordData returns the range of the frame data carried by codeword b.
+func (e *frameECC) codewordData(b int) (start, end int) {
+	k := e.length - e.parity
+	return b * k, (b + 1) * k
+}
@@ -0,0 +1,96 @@
+package ftv
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+)
+
+// The causes a run fails with, for programs embedding FileToVideo or wrapping
+// its CLI to branch on. The errors of NewReader, NewWriter, encode and decode
+// match them with errors.Is, while their messages keep the details.
+var (
+	ErrFFmpegNotFound     = errors.New("ffmpeg not found")
+	ErrFFmpegFailed       = errors.New("ffmpeg failed")
+	ErrCorruptHeader      = errors.New("stream header missing or corrupt")
+	ErrUnsupportedVersion = errors.New("video needs a newer version of FileToVideo")
+	ErrUncorrectable      = errors.New("frames too damaged to correct")
+	ErrIncomplete         = errors.New("video incomplete")
+	ErrHashMismatch       = errors.New("payload does not match the hash in the video")
+	ErrInterrupted        = errors.New("interrupted")
+
+	errUsage = errors.New("invalid command line")
+)
+
+// causeError is err, matching cause as well.
+type causeError struct {
+	cause error
+	err   error
+}
+
+func withCause(cause, err error) error { return &causeError{cause: cause, err: err} }
+
+func (e *causeError) Error() string { return e.err.Error() }
+
+func (e *causeError) Unwrap() []error { return []error{e.err, e.cause} }
+
+// ffmpegError gives an error of running ffmpeg its cause: the binary is
+// missing or it exited with an error. Anything else is returned as is.
+func ffmpegError(err error) error {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		return withCause(ErrFFmpegNotFound, err)
+	case errors.As(err, &exitErr):
+		return withCause(ErrFFmpegFailed, err)
+	}
+	return err
+}
+
+// ffmpegExitError is ffmpegError for a finished ffmpeg, adding what it last
+// wrote to stderr if it failed: its exit status alone does not tell why.
+func ffmpegExitError(err error, stderr *lineWriter) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if tail := stderr.tail(); tail != "" {
+			err = fmt.Errorf("%w: %s", err, tail)
+		}
+	}
+	return ffmpegError(err)
+}
+
+// Exit codes of the CLI. 1 is any other failure, the codes of the causes
+// come first in the order the causes are checked in.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+var exitCauses = []struct {
+	cause error
+	code  int
+	name  string // The code of the error in json logs
+}{
+	{errUsage, exitUsage, "usage"},
+	{ErrInterrupted, 130, "interrupted"},
+	{ErrFFmpegNotFound, 3, "ffmpeg_not_found"},
+	{ErrUnsupportedVersion, 4, "unsupported_version"},
+	{ErrCorruptHeader, 5, "corrupt_header"},
+	{ErrUncorrectable, 6, "uncorrectable"},
+	{ErrIncomplete, 7, "incomplete"},
+	{ErrHashMismatch, 8, "hash_mismatch"},
+	{ErrFFmpegFailed, 9, "ffmpeg_failed"},
+}
+
+// exitCode returns the exit code of err and its name.
+func exitCode(err error) (int, string) {
+	for _, c := range exitCauses {
+		if errors.Is(err, c.cause) {
+			return c.code, c.name
+		}
+	}
+	return exitFailure, "failure"
+}
@@ -0,0 +1,171 @@
+package ftv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runEstimate prints what encoding a file would produce without running ffmpeg.
+func runEstimate(args []string) {
+	var (
+		input_file      string
+		target_duration time.Duration
+	)
+
+	c := estimateCLI(&input_file, &target_duration)
+	c.parse(args)
+
+	if input_file == "" {
+		c.usageError("The -i flag is mandatory")
+	}
+	info, err := os.Stat(input_file)
+	if err != nil {
+		logger.fatal("cli", err)
+	}
+
+	bitsPerSecond, err := parseBitrate(c.opts.bitrate)
+	if err != nil {
+		c.usageError(err.Error())
+	}
+
+	headerSize := newStreamHeader(info.Size(), metadataOf(info)).size
+	if target_duration > 0 {
+		if c.opts, err = fitDuration(info.Size(), headerSize, target_duration, c.opts, c.explicit); err != nil {
+			logger.fatal("estimate", err)
+		}
+	}
+	e := estimateEncoding(info.Size(), headerSize, c.opts, bitsPerSecond)
+
+	// The estimate is the result of the command, so it is not subject to -q
+	if logger.format == logJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"payload_bytes":              info.Size(),
+			"frames":                     e.frames,
+			"frame_capacity":             frameCapacity(c.opts),
+			"duration_seconds":           e.duration.Seconds(),
+			"output_bytes":               e.outputBytes,
+			"data_rate_bytes_per_second": e.dataRate,
+			"dot_size":                   c.opts.dotSize,
+			"dot_bits":                   c.opts.dotBits,
+			"fps":                        c.opts.fps,
+		})
+		return
+	}
+	if target_duration > 0 {
+		fmt.Printf("Settings:     -dot %d -dot-bits %d -fps %d\n", c.opts.dotSize, c.opts.dotBits, c.opts.fps)
+	}
+	fmt.Printf("Payload:      %d bytes\n", info.Size())
+	fmt.Printf("Frames:       %d (%d bytes each)\n", e.frames, frameCapacity(c.opts))
+	fmt.Printf("Duration:     %s at %d fps\n", e.duration, c.opts.fps)
+	fmt.Printf("Output size:  ~%.2f MB at %s\n", float64(e.outputBytes)/1e6, c.opts.bitrate)
+	fmt.Printf("Data rate:    %.2f kB/s (%.2f MB per minute of video)\n", e.dataRate/1e3, e.dataRate*60/1e6)
+}
+
+// estimateCLI defines the flags of estimate.
+func estimateCLI(input_file *string, target_duration *time.Duration) *cli {
+	c := newCLI("estimate")
+	c.flags.StringVar(input_file, "i", "", "Path to the input file")
+	c.flags.DurationVar(target_duration, "target-duration", 0, "Pick the dot size, bits per dot and frame rate fitting the input in about this much video, such as 10m")
+	return c
+}
+
+type encodingEstimate struct {
+	frames      int64
+	duration    time.Duration
+	outputBytes int64   // Approximate, assumes ffmpeg hits the bitrate exactly
+	dataRate    float64 // Payload bytes per second of video
+}
+
+func estimateEncoding(payloadSize int64, headerSize int, opts options, bitsPerSecond int64) encodingEstimate {
+	frames := (framesNeeded(payloadSize+int64(headerSize), opts) + 1) * int64(opts.repeat) // With the trailer
+	seconds := float64(frames) / float64(opts.fps)
+	return encodingEstimate{
+		frames:      frames,
+		duration:    time.Duration(seconds * float64(time.Second)),
+		outputBytes: int64(seconds * float64(bitsPerSecond) / 8),
+		dataRate:    float64(payloadSize) / seconds,
+	}
+}
+
+// targetFrameRates are the frame rates -target-duration picks from.
+var targetFrameRates = []int{24, 30, 60}
+
+// fitDuration returns opts with the dot size, bits per dot and frame rate
+// fitting a payload of payloadSize bytes in at most target of video. The
+// settings surviving the most are tried first: larger dots, then fewer
+// frames a second, which leaves every frame more of the bitrate, then fewer
+// bits per dot. Dots are never made larger than opts has them, a byte per
+// channel is only tried with a lossless codec, and the settings whose flag
+// is in explicit are kept.
+func fitDuration(payloadSize int64, headerSize int, target time.Duration, opts options, explicit map[string]bool) (options, error) {
+	dots := []int{opts.dotSize}
+	bits := []int{opts.dotBits}
+	if opts.modulation == modulationDots {
+		if !explicit["dot"] {
+			dots = nil
+			for size := opts.dotSize; size >= 1; size-- {
+				if opts.width%size == 0 && opts.height%size == 0 {
+					dots = append(dots, size)
+				}
+			}
+		}
+		if !explicit["dot-bits"] && losslessCodecs[opts.codec] {
+			bits = []int{3, 24}
+		}
+	}
+	rates := []int{opts.fps}
+	if !explicit["fps"] {
+		rates = targetFrameRates
+	}
+
+	shortest := time.Duration(-1)
+	for _, dotSize := range dots {
+		for _, fps := range rates {
+			for _, dotBits := range bits {
+				candidate := opts
+				candidate.dotSize, candidate.fps, candidate.dotBits = dotSize, fps, dotBits
+				if candidate.validate() != nil {
+					continue
+				}
+				duration := estimateEncoding(payloadSize, headerSize, candidate, 0).duration
+				if duration <= target {
+					return candidate, nil
+				}
+				if shortest < 0 || duration < shortest {
+					shortest = duration
+				}
+			}
+		}
+	}
+	if shortest < 0 {
+		return opts, errors.New("no settings to pick from for -target-duration")
+	}
+	return opts, fmt.Errorf("the input needs at least %s of video with the settings -target-duration can pick, more than %s", (shortest + 10*time.Millisecond - 1).Truncate(10*time.Millisecond), target)
+}
+
+// parseBitrate understands ffmpeg style bitrates such as "30M" or "800k".
+func parseBitrate(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		multiplier = 1e3
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1e9
+	}
+	number := s
+	if multiplier != 1 {
+		number = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,31 @@
+package ftv
+
+import "testing"
+
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		in  string
+		out int64
+		err string
+	}{
+		{"800k", 800e3, ""},
+		{"2.5M", 2.5e6, ""},
+		{"1G", 1e9, ""},
+		{"64000", 64000, ""},
+		{"xM", 0, `invalid bitrate "xM"`},
+		{"0k", 0, `invalid bitrate "0k"`},
+		{"", 0, `invalid bitrate ""`},
+	}
+	for _, tt := range tests {
+		bitrate, err := parseBitrate(tt.in)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseBitrate(%q) = %d, %v, expected %s", tt.in, bitrate, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || bitrate != tt.out {
+			t.Errorf("parseBitrate(%q) = %d, %v, expected %d", tt.in, bitrate, err, tt.out)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// command is exec.CommandContext for the helpers this tool runs. They are
+// kept out of the console's signal group, so an interrupt reaches only this
+// process, which then stops them through ctx and cleans up after itself.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = childProcAttr()
+	return cmd
+}
+
+// ffmpegCommand runs the ffmpeg found by lookFFmpeg.
+func ffmpegCommand(ctx context.Context, ffmpegPath string, args ...string) *exec.Cmd {
+	return command(ctx, lookFFmpeg(ffmpegPath), args...)
+}
+
+// splitArgs splits s into arguments the way a POSIX shell does, minus
+// expansions: whitespace separates them, single quotes keep everything,
+// double quotes everything but backslash escapes.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\' && (quote == 0 || i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1])):
+			if i+1 == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// lookFFmpeg resolves ffmpegPath. A bare name missing from PATH is looked
+// for next to the executable too, which is where ffmpeg.exe usually ends up
+// on Windows.
+func lookFFmpeg(ffmpegPath string) string {
+	if strings.ContainsAny(ffmpegPath, `/\`) {
+		return ffmpegPath
+	}
+	if _, err := exec.LookPath(ffmpegPath); err == nil {
+		return ffmpegPath
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ffmpegPath
+	}
+	name := ffmpegPath
+	if runtime.GOOS == "windows" && filepath.Ext(name) == "" {
+		name += ".exe"
+	}
+	candidate := filepath.Join(filepath.Dir(exe), name)
+	if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+		return candidate
+	}
+	return ffmpegPath
+}
@@ -0,0 +1,41 @@
+package ftv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Since format v6 the data of every frame ends with the number of the frame
+// in the video and a CRC of the data before it and the number, under the ECC
+// like the rest. Decoding puts every frame where its number says it belongs,
+// so a frame the channel dropped leaves a gap that is reported missing rather
+// than shifting the frames after it, and the CRC catches damaged frames that
+// slipped past ECC, or that had no ECC at all. Unlike the -frame-strip, the
+// number costs no more than its 8 bytes but is lost with the data.
+const (
+	frameNumberSize = 8 // Number and CRC
+	numberedVersion = 6 // First format whose frames carry their number
+)
+
+// errFrameNumbers means the frames were digested expecting numbers in them
+// when the header says there are none, or the other way around.
+var errFrameNumbers = errors.New("the video was read with the wrong frame layout")
+
+// putFrameNumber writes the number of frame id after the capacity bytes of
+// stream at the start of data.
+func putFrameNumber(data []byte, capacity, id int) {
+	record := data[capacity : capacity+frameNumberSize]
+	binary.BigEndian.PutUint32(record, uint32(id))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data[:capacity+4]))
+}
+
+// readFrameNumber returns the number written by putFrameNumber, ok false if
+// data does not match its CRC.
+func readFrameNumber(data []byte, capacity int) (id int, ok bool) {
+	record := data[capacity : capacity+frameNumberSize]
+	if crc32.ChecksumIEEE(data[:capacity+4]) != binary.BigEndian.Uint32(record[4:]) {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(record)), true
+}
@@ -0,0 +1,319 @@
+package ftv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// A video a platform rescaled or cropped no longer has its dots where -size
+// and -dot put them, so not even its header can be read. The dots still
+// leave an edge every dot wide though: detectGrid adds up how much the first
+// frames change from one column of pixels to the next, and from one row to
+// the next, and takes the strongest period of those edge profiles out of
+// their Fourier transform. Its phase is where the first whole dot starts.
+const (
+	gridProbeFrames = 4    // Frames the grid is detected in
+	gridMinPitch    = 2.5  // Smallest dot in pixels, smaller ones do not survive rescaling
+	gridMaxPitch    = 64.0 // Largest dot in pixels
+	gridMinPeak     = 8    // How many times the period must stand out of the median of the spectrum
+)
+
+// errHeaderUnread marks a decode that failed before the stream header could
+// be read, which detecting the grid may help with.
+var errHeaderUnread = errors.New("stream header unread")
+
+// headerUnread marks err with errHeaderUnread if decoding failed before the
+// header was read, the way it does when the dots are not where it looks.
+func headerUnread(err error, headerRead bool) error {
+	if headerRead || !errors.Is(err, ErrCorruptHeader) && !errors.Is(err, ErrUncorrectable) && !errors.Is(err, ErrIncomplete) {
+		return err
+	}
+	return withCause(errHeaderUnread, err)
+}
+
+// detectGrid returns opts with the geometry of the dots in the first frames
+// of the video src. Dots of a whole number of pixels starting at the corner
+// only need the frame and dot size changed, anything else is cropped and
+// scaled back to dots of the size opts expect by ffmpeg.
+func detectGrid(ctx context.Context, src string, opts options) (options, error) {
+	frames, width, height, err := probeFrames(ctx, src, opts)
+	if err != nil {
+		return opts, err
+	}
+	edgesX, edgesY, activityX, activityY := edgeProfiles(frames, width, height)
+	x, ok := locateAxis(edgesX, activityX)
+	if !ok {
+		return opts, errors.New("no dot grid along the width of the frames")
+	}
+	y, ok := locateAxis(edgesY, activityY)
+	if !ok {
+		return opts, errors.New("no dot grid along the height of the frames")
+	}
+	logger.verbose("decode", "grid detected", fields{"width": width, "height": height, "pitch_x": x.pitch, "pitch_y": y.pitch, "origin_x": x.origin, "origin_y": y.origin, "columns": x.dots, "rows": y.dots})
+
+	unit := opts.dotSize
+	if opts.modulation == modulationDCT {
+		unit = dctBlock
+	}
+	guess := opts
+	dot := int(math.Round(x.pitch))
+	if math.Abs(x.pitch-float64(dot)) < 0.05 && math.Abs(y.pitch-float64(dot)) < 0.05 &&
+		x.origin < 0.5 && y.origin < 0.5 && x.dots*dot == width && y.dots*dot == height &&
+		(opts.modulation != modulationDCT || dot == dctBlock) {
+		guess.width, guess.height, guess.dotSize = width, height, dot
+	} else {
+		cropX, cropY := int(math.Round(x.origin)), int(math.Round(y.origin))
+		cropWidth := int(math.Round(float64(x.dots) * x.pitch))
+		if cropWidth > width-cropX {
+			cropWidth = width - cropX
+		}
+		cropHeight := int(math.Round(float64(y.dots) * y.pitch))
+		if cropHeight > height-cropY {
+			cropHeight = height - cropY
+		}
+		guess.width, guess.height = x.dots*unit, y.dots*unit
+		guess.sourceFilter = fmt.Sprintf("crop=%d:%d:%d:%d,scale=%d:%d:flags=neighbor", cropWidth, cropHeight, cropX, cropY, guess.width, guess.height)
+	}
+	if err := guess.validate(); err != nil {
+		return opts, fmt.Errorf("detected grid of %.2fx%.2f pixel dots: %w", x.pitch, y.pitch, err)
+	}
+	return guess, nil
+}
+
+// probeFrames returns the first frames of the video src as rgb24 pixels at
+// the size they are stored at.
+func probeFrames(ctx context.Context, src string, opts options) ([][]byte, int, int, error) {
+	source, err := ffmpegSource(src)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath,
+		"-i", source,
+		"-frames:v", strconv.Itoa(gridProbeFrames),
+		"-vf", decodeFilter,
+		"-c:v", "ppm",
+		"-f", "image2pipe",
+		"-an",
+		"-",
+	)
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	stderr.Close()
+	if err != nil {
+		return nil, 0, 0, ffmpegExitError(fmt.Errorf("probing frames: %w", err), stderr)
+	}
+
+	var frames [][]byte
+	var width, height int
+	r := bufio.NewReader(bytes.NewReader(out))
+	for {
+		pixels, w, h, err := readPPM(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("probing frames: %w", err)
+		}
+		if frames != nil && (w != width || h != height) {
+			break // The size changes mid-video, the first ones are enough
+		}
+		frames, width, height = append(frames, pixels), w, h
+	}
+	if frames == nil {
+		return nil, 0, 0, errors.New("probing frames: video has no frames")
+	}
+	return frames, width, height, nil
+}
+
+// readPPM reads a binary PPM image of 8 bit channels off r, returning io.EOF
+// if there is none left.
+func readPPM(r *bufio.Reader) ([]byte, int, int, error) {
+	var magic string
+	var width, height, maxValue int
+	if _, err := fmt.Fscan(r, &magic); err != nil {
+		return nil, 0, 0, io.EOF
+	}
+	if _, err := fmt.Fscan(r, &width, &height, &maxValue); err != nil || magic != "P6" {
+		return nil, 0, 0, errors.New("ffmpeg did not write a PPM image")
+	}
+	if width < 1 || height < 1 || maxValue != 255 {
+		return nil, 0, 0, fmt.Errorf("unsupported %dx%d PPM image of maximum value %d", width, height, maxValue)
+	}
+	if _, err := r.ReadByte(); err != nil { // The whitespace ending the header
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	pixels := make([]byte, width*height*3)
+	if _, err := io.ReadFull(r, pixels); err != nil {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	return pixels, width, height, nil
+}
+
+// edgeProfiles returns how much the rgb24 frames change into every column
+// of pixels from the one left of it and into every row from the one above,
+// and how much they change along every column and row.
+func edgeProfiles(frames [][]byte, width, height int) (edgesX, edgesY, activityX, activityY []float64) {
+	edgesX, edgesY = make([]float64, width), make([]float64, height)
+	activityX, activityY = make([]float64, width), make([]float64, height)
+	absDiff := func(a, b byte) float64 {
+		if a > b {
+			return float64(a - b)
+		}
+		return float64(b - a)
+	}
+	for _, pixels := range frames {
+		for y := 0; y < height; y++ {
+			line := pixels[y*width*3 : (y+1)*width*3]
+			for i := 3; i < len(line); i++ {
+				d := absDiff(line[i], line[i-3])
+				edgesX[i/3] += d
+				activityY[y] += d
+			}
+			if y == 0 {
+				continue
+			}
+			above := pixels[(y-1)*width*3 : y*width*3]
+			for i := range line {
+				d := absDiff(line[i], above[i])
+				edgesY[y] += d
+				activityX[i/3] += d
+			}
+		}
+	}
+	return edgesX, edgesY, activityX, activityY
+}
+
+// gridAxis places the dots along one axis of the frames.
+type gridAxis struct {
+	pitch  float64 // Pixels from one dot to the next
+	origin float64 // Where the first whole dot starts
+	dots   int
+}
+
+// locateAxis finds the dots along an axis from the edges across it and the
+// activity along it, which padding added around the frames has none of.
+func locateAxis(edges, activity []float64) (gridAxis, bool) {
+	pitch, offset, ok := findPeriod(edges)
+	if !ok {
+		return gridAxis{}, false
+	}
+	sorted := append([]float64(nil), activity...)
+	sort.Float64s(sorted)
+	threshold := sorted[len(sorted)/2] / 5
+	first, last := -1, -1
+	for i, v := range activity {
+		if v > threshold {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return gridAxis{}, false
+	}
+	// The edges repeat every pitch from offset on, the first dot starts at
+	// the first of them within the active lines
+	origin := offset + math.Ceil((float64(first)-offset-0.5)/pitch)*pitch
+	dots := int((float64(last+1)-origin)/pitch + 0.5)
+	if dots < 1 {
+		return gridAxis{}, false
+	}
+	return gridAxis{pitch: pitch, origin: origin, dots: dots}, true
+}
+
+// findPeriod returns the period of the strongest frequency of profile
+// between gridMinPitch and gridMaxPitch, and the offset of its first peak.
+// ok is false if no frequency stands out.
+func findPeriod(profile []float64) (pitch, offset float64, ok bool) {
+	n := len(profile)
+	var mean float64
+	for _, v := range profile {
+		mean += v
+	}
+	mean /= float64(n)
+	centered := make([]float64, n)
+	for i, v := range profile {
+		centered[i] = v - mean
+	}
+	dft := func(frequency float64) complex128 {
+		var re, im float64
+		for x, v := range centered {
+			angle := 2 * math.Pi * frequency * float64(x) / float64(n)
+			re += v * math.Cos(angle)
+			im -= v * math.Sin(angle)
+		}
+		return complex(re, im)
+	}
+
+	low := int(math.Ceil(float64(n) / gridMaxPitch))
+	if low < 2 {
+		low = 2
+	}
+	high := int(float64(n) / gridMinPitch)
+	if high+1 > n/2 {
+		high = n/2 - 1
+	}
+	if high <= low {
+		return 0, 0, false
+	}
+	magnitudes := make([]float64, high+2)
+	best := low
+	for k := low - 1; k <= high+1; k++ {
+		c := dft(float64(k))
+		magnitudes[k] = math.Hypot(real(c), imag(c))
+		if k >= low && k <= high && magnitudes[k] > magnitudes[best] {
+			best = k
+		}
+	}
+	sorted := append([]float64(nil), magnitudes[low:high+1]...)
+	sort.Float64s(sorted)
+	if median := sorted[len(sorted)/2]; magnitudes[best] < gridMinPeak*median {
+		return 0, 0, false
+	}
+	// Sharp edges have harmonics as well, the dots are the lowest of them
+	// that is still about as strong
+	fundamental := best
+harmonics:
+	for divisor := 4; divisor >= 2; divisor-- {
+		k := int(math.Round(float64(best) / float64(divisor)))
+		if k-1 < low {
+			continue
+		}
+		for _, candidate := range []int{k - 1, k + 1} {
+			if magnitudes[candidate] > magnitudes[k] {
+				k = candidate
+			}
+		}
+		if magnitudes[k] >= magnitudes[best]/2 {
+			fundamental = k
+			break harmonics
+		}
+	}
+	best = fundamental
+
+	// The peak lies between two frequencies of the transform in general
+	frequency := float64(best)
+	left, center, right := magnitudes[best-1], magnitudes[best], magnitudes[best+1]
+	if d := left - 2*center + right; d < 0 {
+		frequency += 0.5 * (left - right) / d
+	}
+	pitch = float64(n) / frequency
+	c := dft(frequency)
+	offset = math.Mod(-math.Atan2(imag(c), real(c))/(2*math.Pi)*pitch, pitch)
+	if offset < 0 {
+		offset += pitch
+	}
+	if pitch-offset < 0.5 {
+		offset = 0
+	}
+	return pitch, offset, true
+}
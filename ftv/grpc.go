@@ -0,0 +1,323 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ErmitaVulpe/FileToVideo/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const grpcChunkSize = 1 << 20
+
+// grpcProgressInterval is how often at most a stream is sent progress.
+const grpcProgressInterval = 250 * time.Millisecond
+
+// grpcServer implements the FileToVideo service from pb/filetovideo.proto.
+// Inputs and outputs are spooled through dir since ffmpeg needs real files.
+// Calls wait for their ffmpeg slots in the queue of the HTTP API, at the
+// priority of their options, and one that fails is run again up to retries
+// times like a job.
+type grpcServer struct {
+	pb.UnimplementedFileToVideoServer
+
+	dir     string
+	opts    options
+	slots   *ffmpegSlots
+	retries int
+}
+
+// codecStream is what the Encode and Decode streams have in common.
+type codecStream interface {
+	Send(*pb.Response) error
+	Recv() (*pb.Request, error)
+	Context() context.Context
+}
+
+func serveGRPC(ctx context.Context, addr, dir string, opts options, slots *ffmpegSlots, retries int) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	pb.RegisterFileToVideoServer(server, &grpcServer{dir: dir, opts: opts, slots: slots, retries: retries})
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	logger.info("serve", "grpc listening", fields{"addr": addr})
+	return server.Serve(listener)
+}
+
+func (s *grpcServer) Encode(stream pb.FileToVideo_EncodeServer) error {
+	return s.run(stream, "encode")
+}
+
+func (s *grpcServer) Decode(stream pb.FileToVideo_DecodeServer) error {
+	return s.run(stream, "decode")
+}
+
+func (s *grpcServer) run(stream codecStream, mode string) error {
+	jobDir, err := os.MkdirTemp(s.dir, "grpc-")
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.RemoveAll(jobDir)
+
+	input := filepath.Join(jobDir, "input")
+	opts := s.opts
+	priority, err := receiveInput(stream, input, &opts)
+	if err != nil {
+		return err
+	}
+	if err := opts.validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	output := filepath.Join(jobDir, "output")
+	if mode == "encode" {
+		// ffmpeg picks the container from the extension
+		output += videoExtension(opts)
+		if err := checkContainer(output, opts); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	s.slots.clampSegments(&opts)
+
+	// Progress is only sent while the pipeline runs, so it never races with
+	// sending the output
+	progress := startProgressSender(stream)
+	opts.onProgress = progress.update
+	err = s.attempts(stream.Context(), mode, input, output, priority, opts)
+	progress.close()
+	if err != nil {
+		if stream.Context().Err() != nil {
+			return status.Error(codes.Canceled, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return sendOutput(stream, output)
+}
+
+// attempts runs a call, waiting for its ffmpeg slots before every attempt
+// and running it again after a failure another attempt may get past, as
+// jobServer.run does.
+func (s *grpcServer) attempts(ctx context.Context, mode, input, output string, priority int, opts options) error {
+	slots := s.slots.processes(mode, opts)
+	for attempt := 1; ; attempt++ {
+		if !s.slots.acquire(ctx, priority, slots) {
+			return ctx.Err()
+		}
+		tracked := metrics.track(mode, &opts)
+		var err error
+		if mode == "encode" {
+			err = encode(ctx, input, output, opts)
+		} else {
+			err = decode(ctx, input, output, opts)
+		}
+		s.slots.release(slots)
+
+		switch {
+		case err == nil:
+			tracked.finish(jobDone, nil)
+			return nil
+		case ctx.Err() != nil:
+			tracked.finish(jobCanceled, err)
+			return err
+		}
+		tracked.finish(jobFailed, err)
+		if attempt > s.retries || !retryable(err) {
+			return err
+		}
+		delay := retryDelay << (attempt - 1)
+		logger.info("serve", "grpc call failed, retrying", fields{"mode": mode, "attempt": attempt, "delay": delay, "error": err.Error()})
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// progressSender sends the progress of a run to its stream from a goroutine
+// of its own, the latest counts of the stages that moved at most every
+// grpcProgressInterval. The pipeline only records the counts, so a slow
+// client holds up neither the stages nor one another.
+type progressSender struct {
+	stream codecStream
+
+	mu      sync.Mutex
+	pending map[string]*pb.Progress // Not sent yet
+	stages  []string                // In the order they first reported
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startProgressSender(stream codecStream) *progressSender {
+	s := &progressSender{
+		stream:  stream,
+		pending: map[string]*pb.Progress{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(grpcProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-s.stop:
+				s.flush()
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// update is the ProgressFunc of the run.
+func (s *progressSender) update(stage string, done, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[stage]; !ok {
+		s.stages = append(s.stages, stage)
+	}
+	s.pending[stage] = &pb.Progress{Stage: stage, Done: done, Total: total}
+}
+
+// flush sends what changed since the last flush.
+func (s *progressSender) flush() {
+	s.mu.Lock()
+	var updates []*pb.Progress
+	for _, stage := range s.stages {
+		if p := s.pending[stage]; p != nil {
+			updates = append(updates, p)
+			s.pending[stage] = nil
+		}
+	}
+	s.mu.Unlock()
+	for _, p := range updates {
+		if s.stream.Send(&pb.Response{Kind: &pb.Response_Progress{Progress: p}}) != nil {
+			return
+		}
+	}
+}
+
+// close sends the last progress and returns once nothing more is sent.
+func (s *progressSender) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// receiveInput writes the streamed input to path and returns the priority
+// of the call. Options are only accepted as the first message.
+func receiveInput(stream codecStream, path string, opts *options) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, status.Error(codes.Internal, err.Error())
+	}
+	defer file.Close()
+
+	priority := 0
+	for first := true; ; first = false {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		switch kind := req.Kind.(type) {
+		case *pb.Request_Options:
+			if !first {
+				return 0, status.Error(codes.InvalidArgument, "options must be sent first")
+			}
+			if err := applyRequestOptions(opts, kind.Options); err != nil {
+				return 0, status.Error(codes.InvalidArgument, err.Error())
+			}
+			priority = int(kind.Options.Priority)
+		case *pb.Request_Data:
+			if _, err := file.Write(kind.Data); err != nil {
+				return 0, status.Error(codes.Internal, err.Error())
+			}
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, status.Error(codes.Internal, err.Error())
+	}
+	return priority, nil
+}
+
+// applyRequestOptions applies the options of a call to opts, the preset
+// first so the other fields override it, as the HTTP API does with the
+// preset of a job.
+func applyRequestOptions(opts *options, req *pb.Options) error {
+	if req.Preset != "" {
+		if err := applyPreset(opts, req.Preset, nil); err != nil {
+			return err
+		}
+	}
+	if req.Codec != "" {
+		opts.codec = req.Codec
+	}
+	if req.Bitrate != "" {
+		opts.bitrate = req.Bitrate
+	}
+	if req.DotSize != 0 {
+		opts.dotSize = int(req.DotSize)
+	}
+	settings := [][2]string{{"ecc", req.Ecc}, {"container", req.Container}}
+	if req.Interleave != 0 {
+		settings = append(settings, [2]string{"interleave", strconv.Itoa(int(req.Interleave))})
+	}
+	if req.Repeat != 0 {
+		settings = append(settings, [2]string{"repeat", strconv.Itoa(int(req.Repeat))})
+	}
+	for _, setting := range settings {
+		if setting[1] == "" {
+			continue
+		}
+		if err := opts.set(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendOutput(stream codecStream, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer file.Close()
+
+	buffer := make([]byte, grpcChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if err := stream.Send(&pb.Response{Kind: &pb.Response_Data{Data: buffer[:n]}}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ftv
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ErmitaVulpe/FileToVideo/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplyRequestOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   *pb.Options
+		check func(opts options) bool
+		fails bool
+	}{
+		{"defaults", &pb.Options{}, func(opts options) bool {
+			return opts.ecc == 0 && opts.interleave == 1 && opts.dotSize == 8
+		}, false},
+		{"preset", &pb.Options{Preset: "youtube"}, func(opts options) bool {
+			return opts.ecc == 32 && opts.interleave == 4 && opts.dotSize == 12
+		}, false},
+		{"preset overridden", &pb.Options{Preset: "youtube", Ecc: "hamming", DotSize: 6, Interleave: 2}, func(opts options) bool {
+			return opts.eccHamming && opts.interleave == 2 && opts.dotSize == 6 && opts.bitrate == "50M"
+		}, false},
+		{"repeat and container", &pb.Options{Repeat: 3, Container: containerMKV}, func(opts options) bool {
+			return opts.repeat == 3 && opts.container == containerMKV
+		}, false},
+		{"unknown preset", &pb.Options{Preset: "nope"}, nil, true},
+		{"invalid ecc", &pb.Options{Ecc: "lots"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultOptions()
+			err := applyRequestOptions(&opts, tt.req)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("applied")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(opts) {
+				t.Fatalf("options %+v", opts)
+			}
+		})
+	}
+}
+
+// fakeStream hands out requests and keeps the responses of a call.
+type fakeStream struct {
+	requests  []*pb.Request
+	responses []*pb.Response
+}
+
+func (s *fakeStream) Send(resp *pb.Response) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *fakeStream) Recv() (*pb.Request, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *fakeStream) Context() context.Context { return context.Background() }
+
+func TestReceiveInput(t *testing.T) {
+	optionsRequest := &pb.Request{Kind: &pb.Request_Options{Options: &pb.Options{Priority: 5, Ecc: "16"}}}
+	data := func(s string) *pb.Request { return &pb.Request{Kind: &pb.Request_Data{Data: []byte(s)}} }
+	tests := []struct {
+		name     string
+		requests []*pb.Request
+		priority int
+		code     codes.Code
+	}{
+		{"options", []*pb.Request{optionsRequest, data("in"), data("put")}, 5, codes.OK},
+		{"no options", []*pb.Request{data("in"), data("put")}, 0, codes.OK},
+		{"options late", []*pb.Request{data("in"), optionsRequest, data("put")}, 0, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input")
+			opts := defaultOptions()
+			priority, err := receiveInput(&fakeStream{requests: tt.requests}, path, &opts)
+			if status.Code(err) != tt.code {
+				t.Fatalf("received with %v, expected %v", err, tt.code)
+			}
+			if err != nil {
+				return
+			}
+			if priority != tt.priority {
+				t.Fatalf("priority %d, expected %d", priority, tt.priority)
+			}
+			if input, _ := os.ReadFile(path); string(input) != "input" {
+				t.Fatalf("input %q", input)
+			}
+		})
+	}
+}
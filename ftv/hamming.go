@@ -0,0 +1,83 @@
+package ftv
+
+// hamming is the extended Hamming(8,4) code, the cheap alternative to
+// Reed-Solomon for videos that only see the odd flipped bit. Every nibble of
+// data gets the three parity bits of Hamming(7,4) plus one over all seven,
+// which corrects a flipped bit per nibble and detects two rather than
+// miscorrecting them. It is systematic like Reed-Solomon: a codeword is k
+// bytes of data followed by k parity bytes, parity byte i holding the parity
+// of the high nibble of data byte i in its high half and of the low nibble in
+// its low half. Decoding is a table lookup per nibble.
+type hamming struct{}
+
+// hammingParity maps a nibble to its 4 parity bits, hammingDecode a nibble
+// and its parity bits, nibble<<4|parity, to the nibble they decode to, or
+// hammingFailed if two bits are wrong.
+var (
+	hammingParity [16]byte
+	hammingDecode [256]byte
+)
+
+const hammingFailed = 0x10
+
+func init() {
+	for d := 0; d < 16; d++ {
+		d1, d2, d3, d4 := d>>3&1, d>>2&1, d>>1&1, d&1
+		p1, p2, p3 := d1^d2^d4, d1^d3^d4, d2^d3^d4
+		p4 := d1 ^ d2 ^ d3 ^ d4 ^ p1 ^ p2 ^ p3
+		hammingParity[d] = byte(p1<<3 | p2<<2 | p3<<1 | p4)
+	}
+	// The code words are 4 bits apart: a word 1 bit off one is that one with
+	// a flipped bit, 2 bits off is as far from two of them
+	for word := 0; word < 256; word++ {
+		hammingDecode[word] = hammingFailed
+		for d := 0; d < 16; d++ {
+			if bitCount(byte(word)^byte(d<<4)^hammingParity[d]) <= 1 {
+				hammingDecode[word] = byte(d)
+			}
+		}
+	}
+}
+
+func bitCount(b byte) int {
+	n := 0
+	for ; b != 0; b &= b - 1 {
+		n++
+	}
+	return n
+}
+
+// encode fills the second half of codeword, the parity, from the data in
+// its first half.
+func (hamming) encode(codeword []byte) {
+	k := len(codeword) / 2
+	for i, b := range codeword[:k] {
+		codeword[k+i] = hammingParity[b>>4]<<4 | hammingParity[b&0xf]
+	}
+}
+
+// decode corrects codeword in place and returns how many data bytes were
+// wrong. It fails if any nibble had two bits flipped, or more that happen to
+// decode that way.
+func (hamming) decode(codeword []byte) (int, error) {
+	k := len(codeword) / 2
+	corrected := 0
+	failed := false
+	for i, b := range codeword[:k] {
+		parity := codeword[k+i]
+		high := hammingDecode[b&0xf0|parity>>4]
+		low := hammingDecode[b<<4|parity&0xf]
+		if (high|low)&hammingFailed != 0 {
+			failed = true
+			continue
+		}
+		if fixed := high<<4 | low; fixed != b {
+			codeword[i] = fixed
+			corrected++
+		}
+	}
+	if failed {
+		return corrected, errUncorrectable
+	}
+	return corrected, nil
+}
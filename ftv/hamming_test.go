@@ -0,0 +1,63 @@
+package ftv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHamming(t *testing.T) {
+	// Bits are flipped in a codeword of 4 data bytes and their 4 parity bytes
+	type flip struct{ index, bit int }
+	tests := []struct {
+		name      string
+		flips     []flip
+		corrected int // -1 if uncorrectable
+	}{
+		{"clean", nil, 0},
+		{"data bit", []flip{{1, 6}}, 1},
+		{"parity bit", []flip{{5, 2}}, 0},
+		{"every nibble", []flip{{0, 7}, {0, 0}, {1, 4}, {1, 3}, {2, 5}, {6, 1}, {3, 6}, {7, 0}}, 4},
+		{"data and parity of other nibbles", []flip{{2, 7}, {6, 3}}, 1},
+		{"two data bits", []flip{{0, 1}, {0, 2}}, -1},
+		{"data and parity bit", []flip{{3, 5}, {7, 4}}, -1},
+	}
+	data := []byte{0x00, 0xff, 0x5a, 0xc3}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codeword := append(append([]byte(nil), data...), make([]byte, len(data))...)
+			hamming{}.encode(codeword)
+			original := append([]byte(nil), codeword...)
+			for _, f := range tt.flips {
+				codeword[f.index] ^= 1 << f.bit
+			}
+
+			corrected, err := hamming{}.decode(codeword)
+			if tt.corrected < 0 {
+				if !errors.Is(err, errUncorrectable) {
+					t.Fatalf("decoded with %v, %d corrected", err, corrected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if corrected != tt.corrected || !bytes.Equal(codeword[:len(data)], original[:len(data)]) {
+				t.Fatalf("%d corrected, expected %d, data % x", corrected, tt.corrected, codeword[:len(data)])
+			}
+		})
+	}
+}
+
+// TestHammingDistance checks that every pair of code words is 4 bits apart,
+// which the decoding table relies on.
+func TestHammingDistance(t *testing.T) {
+	for a := 0; a < 16; a++ {
+		for b := a + 1; b < 16; b++ {
+			wordA, wordB := byte(a<<4)|hammingParity[a], byte(b<<4)|hammingParity[b]
+			if d := bitCount(wordA ^ wordB); d < 4 {
+				t.Errorf("%x and %x are %d bits apart", a, b, d)
+			}
+		}
+	}
+}
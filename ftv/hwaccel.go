@@ -0,0 +1,187 @@
+package ftv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// autoCodec makes encode pick the fastest H.264 encoder that works on this
+// machine instead of using a fixed one, vp9Codec and av1Codec do the same
+// for VP9 and AV1, which some platforms re-encode less than H.264.
+const (
+	autoCodec = "auto"
+	vp9Codec  = "vp9"
+	av1Codec  = "av1"
+)
+
+// codecFormats maps the codecs picking an encoder to the format they pick
+// one of.
+var codecFormats = map[string]string{autoCodec: "h264", vp9Codec: "vp9", av1Codec: "av1"}
+
+// softwareEncoders are used when no hardware encoder of a format is usable,
+// the first one ffmpeg has.
+var softwareEncoders = map[string][]string{
+	"h264": {"libx264"},
+	"vp9":  {"libvpx-vp9"},
+	"av1":  {"libsvtav1", "libaom-av1"},
+}
+
+// hwEncoder is a hardware encoder ffmpeg may have been built with.
+type hwEncoder struct {
+	codec  string
+	format string
+	vendor string
+	// Extra arguments placed before the input, and the filter that gets the
+	// frames into memory the encoder can read
+	initArgs []string
+	filter   string
+}
+
+// vaapiEncoder is the hardware encoder of format through VA-API.
+func vaapiEncoder(format string) hwEncoder {
+	return hwEncoder{
+		codec:    format + "_vaapi",
+		format:   format,
+		vendor:   "VA-API",
+		initArgs: []string{"-vaapi_device", "/dev/dri/renderD128"},
+		filter:   "format=nv12,hwupload",
+	}
+}
+
+// hwEncoders lists the hardware encoders in order of preference.
+var hwEncoders = []hwEncoder{
+	{codec: "h264_nvenc", format: "h264", vendor: "NVIDIA NVENC"},
+	{codec: "h264_qsv", format: "h264", vendor: "Intel Quick Sync"},
+	{codec: "h264_videotoolbox", format: "h264", vendor: "Apple VideoToolbox"},
+	{codec: "h264_amf", format: "h264", vendor: "AMD AMF"},
+	vaapiEncoder("h264"),
+	{codec: "vp9_qsv", format: "vp9", vendor: "Intel Quick Sync"},
+	vaapiEncoder("vp9"),
+	{codec: "av1_nvenc", format: "av1", vendor: "NVIDIA NVENC"},
+	{codec: "av1_qsv", format: "av1", vendor: "Intel Quick Sync"},
+	{codec: "av1_amf", format: "av1", vendor: "AMD AMF"},
+	vaapiEncoder("av1"),
+}
+
+// encoderArgs returns the extra arguments codec needs around the input.
+func encoderArgs(codec string) (initArgs []string, filter string) {
+	for _, encoder := range hwEncoders {
+		if encoder.codec == codec {
+			return encoder.initArgs, encoder.filter
+		}
+	}
+	return nil, ""
+}
+
+func isHardwareEncoder(codec string) bool {
+	for _, encoder := range hwEncoders {
+		if encoder.codec == codec {
+			return true
+		}
+	}
+	return false
+}
+
+var detectedEncoders = struct {
+	mu     sync.Mutex
+	codecs map[string]string // By ffmpeg path and format
+}{codecs: map[string]string{}}
+
+// resolveCodec returns codec, or the detected encoder if codec is one of
+// codecFormats. Detection runs once per ffmpeg binary and format.
+func resolveCodec(ctx context.Context, ffmpegPath, codec string, softwareOnly bool) (string, error) {
+	format, ok := codecFormats[codec]
+	if !ok {
+		return codec, nil
+	}
+	key := ffmpegPath + "\x00" + format + "\x00" + strconv.FormatBool(softwareOnly)
+	detectedEncoders.mu.Lock()
+	defer detectedEncoders.mu.Unlock()
+	if codec, ok := detectedEncoders.codecs[key]; ok {
+		return codec, nil
+	}
+	codec, err := detectEncoder(ctx, ffmpegPath, format, softwareOnly)
+	if err != nil {
+		return "", err
+	}
+	detectedEncoders.codecs[key] = codec
+	return codec, nil
+}
+
+// detectEncoder picks the first hardware encoder of format that ffmpeg lists
+// and that manages to encode a test frame. Being listed only means ffmpeg
+// was built with it, not that the hardware or driver is present.
+func detectEncoder(ctx context.Context, ffmpegPath, format string, softwareOnly bool) (string, error) {
+	available, err := listEncoders(ctx, ffmpegPath)
+	if err != nil {
+		return "", fmt.Errorf("listing encoders: %w", err)
+	}
+
+	for _, encoder := range hwEncoders {
+		if softwareOnly || encoder.format != format || !available[encoder.codec] {
+			continue
+		}
+		if err := probeEncoder(ctx, ffmpegPath, encoder); err != nil {
+			logger.verbose("ffmpeg", "encoder unusable", fields{"codec": encoder.codec, "error": err.Error()})
+			continue
+		}
+		logger.info("ffmpeg", "selected hardware encoder", fields{"codec": encoder.codec, "vendor": encoder.vendor})
+		return encoder.codec, nil
+	}
+
+	for _, codec := range softwareEncoders[format] {
+		if available[codec] {
+			if softwareOnly {
+				logger.info("ffmpeg", "using software encoder for reproducible output", fields{"codec": codec})
+				return codec, nil
+			}
+			logger.info("ffmpeg", "no hardware encoder found, using software encoder", fields{"codec": codec})
+			return codec, nil
+		}
+	}
+	return "", fmt.Errorf("ffmpeg has neither a hardware %s encoder nor %s", strings.ToUpper(format), strings.Join(softwareEncoders[format], " or "))
+}
+
+// listEncoders parses the output of `ffmpeg -encoders`.
+func listEncoders(ctx context.Context, ffmpegPath string) (map[string]bool, error) {
+	out, err := ffmpegCommand(ctx, ffmpegPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, ffmpegError(err)
+	}
+	encoders := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// Lines look like " V....D h264_nvenc  NVIDIA NVENC H.264 encoder"
+		columns := strings.Fields(scanner.Text())
+		if len(columns) >= 2 && len(columns[0]) == 6 && columns[0][0] == 'V' {
+			encoders[columns[1]] = true
+		}
+	}
+	return encoders, scanner.Err()
+}
+
+// probeEncoder encodes a single small frame with encoder.
+func probeEncoder(ctx context.Context, ffmpegPath string, encoder hwEncoder) error {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, encoder.initArgs...)
+	args = append(args, "-f", "lavfi", "-i", "color=black:s=256x256:d=0.1", "-frames:v", "1")
+	if encoder.filter != "" {
+		args = append(args, "-vf", encoder.filter)
+	}
+	args = append(args, "-c:v", encoder.codec, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	cmd := ffmpegCommand(ctx, ffmpegPath, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
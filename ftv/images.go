@@ -0,0 +1,146 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// imageFramePattern names the frames of an image sequence, counting from 1.
+const imageFramePattern = "frame-%06d.png"
+
+// imageTransport stores a video as a directory of lossless PNG frames
+// rather than running ffmpeg, for tooling around FileToVideo and to take
+// the codec out of the picture when chasing a bug.
+type imageTransport struct{}
+
+func (imageTransport) NewSink(ctx context.Context, dest string, opts options) (frameSink, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	// Frames left from an earlier video would be read as part of this one
+	stale, err := imageFrames(dest)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return &imageSink{
+		dir:     dest,
+		image:   image.NewRGBA(image.Rect(0, 0, opts.width, opts.height)),
+		encoder: &png.Encoder{CompressionLevel: png.BestSpeed},
+	}, nil
+}
+
+func (imageTransport) NewSource(ctx context.Context, src string, opts options) (frameSource, error) {
+	frames, err := imageFrames(src)
+	if err != nil {
+		return nil, err
+	}
+	return &imageSource{ctx: ctx, frames: frames, width: opts.width, height: opts.height}, nil
+}
+
+func (imageTransport) countFrames(src string) (int64, error) {
+	frames, err := imageFrames(src)
+	return int64(len(frames)), err
+}
+
+// imageFrames returns the frames in dir in order.
+func imageFrames(dir string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory of frames", dir)
+	}
+	frames, err := filepath.Glob(filepath.Join(dir, "frame-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(frames) // The index is zero padded
+	return frames, nil
+}
+
+type imageSink struct {
+	dir     string
+	image   *image.RGBA
+	encoder *png.Encoder
+	frames  int
+}
+
+func (s *imageSink) WriteFrame(pixels []byte) error {
+	copy(s.image.Pix, pixels)
+	// The serializer leaves alpha alone, the frames are opaque
+	for i := 3; i < len(s.image.Pix); i += 4 {
+		s.image.Pix[i] = 0xff
+	}
+	s.frames++
+	file, err := os.Create(filepath.Join(s.dir, fmt.Sprintf(imageFramePattern, s.frames)))
+	if err != nil {
+		return err
+	}
+	if err := s.encoder.Encode(file, s.image); err != nil {
+		file.Close()
+		return fmt.Errorf("writing frame %d: %w", s.frames, err)
+	}
+	return file.Close()
+}
+
+func (s *imageSink) Close() error { return nil }
+
+type imageSource struct {
+	ctx           context.Context
+	frames        []string
+	width, height int
+}
+
+func (s *imageSource) ReadFrame(pixels []byte) error {
+	if len(s.frames) == 0 {
+		return io.EOF
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	path := s.frames[0]
+	s.frames = s.frames[1:]
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != s.width || bounds.Dy() != s.height {
+		return fmt.Errorf("%s is %dx%d, expected %dx%d", path, bounds.Dx(), bounds.Dy(), s.width, s.height)
+	}
+	i := 0
+	if rgba, ok := img.(*image.RGBA); ok {
+		for p := 0; p < len(rgba.Pix); p += 4 {
+			pixels[i], pixels[i+1], pixels[i+2] = rgba.Pix[p], rgba.Pix[p+1], rgba.Pix[p+2]
+			i += 3
+		}
+		return nil
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pixels[i], pixels[i+1], pixels[i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+			i += 3
+		}
+	}
+	return nil
+}
+
+func (s *imageSource) Close() error { return nil }
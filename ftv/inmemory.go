@@ -0,0 +1,98 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// transportMemory keeps the frames of a video in memory, for running the
+// whole pipeline without ffmpeg in the tests. It cannot be picked with
+// -transport, the videos are gone once the program exits.
+const transportMemory = "memory"
+
+// memoryVideos holds the videos of the memory transport by their path, as
+// the RGB frames a source hands out. Frames are lossless, a test damages or
+// drops them here to see what decoding makes of it.
+var memoryVideos = struct {
+	sync.Mutex
+	frames map[string][][]byte
+}{frames: map[string][][]byte{}}
+
+// memoryVideo returns the frames of the video at path, nil if there is none.
+func memoryVideo(path string) [][]byte {
+	memoryVideos.Lock()
+	defer memoryVideos.Unlock()
+	return memoryVideos.frames[path]
+}
+
+// setMemoryVideo replaces the frames of the video at path.
+func setMemoryVideo(path string, frames [][]byte) {
+	memoryVideos.Lock()
+	defer memoryVideos.Unlock()
+	memoryVideos.frames[path] = frames
+}
+
+type memoryTransport struct{}
+
+func (memoryTransport) NewSink(ctx context.Context, dest string, opts options) (frameSink, error) {
+	return &memorySink{path: dest}, nil
+}
+
+func (memoryTransport) NewSource(ctx context.Context, src string, opts options) (frameSource, error) {
+	frames := memoryVideo(src)
+	if frames == nil {
+		return nil, fmt.Errorf("no video %s in memory", src)
+	}
+	return &memorySource{frames: frames}, nil
+}
+
+func (memoryTransport) countFrames(src string) (int64, error) {
+	return int64(len(memoryVideo(src))), nil
+}
+
+// memorySink stores the video once it is closed, like a file only complete
+// then.
+type memorySink struct {
+	path   string
+	frames [][]byte
+}
+
+func (s *memorySink) WriteFrame(pixels []byte) error {
+	rgb := make([]byte, len(pixels)/4*3)
+	rgbaToRGB(rgb, pixels)
+	s.frames = append(s.frames, rgb)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	setMemoryVideo(s.path, s.frames)
+	return nil
+}
+
+type memorySource struct {
+	frames [][]byte
+	next   int
+}
+
+func (s *memorySource) ReadFrame(pixels []byte) error {
+	if s.next == len(s.frames) {
+		return io.EOF
+	}
+	if len(s.frames[s.next]) != len(pixels) {
+		return fmt.Errorf("frame %d has %d bytes, expected %d", s.next, len(s.frames[s.next]), len(pixels))
+	}
+	copy(pixels, s.frames[s.next])
+	s.next++
+	return nil
+}
+
+func (s *memorySource) Close() error { return nil }
+
+// rgbaToRGB drops the alpha of the RGBA frame rgba into rgb.
+func rgbaToRGB(rgb, rgba []byte) {
+	for i, j := 0, 0; i+3 < len(rgba); i, j = i+4, j+3 {
+		copy(rgb[j:j+3], rgba[i:i+3])
+	}
+}
@@ -0,0 +1,115 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// payloadSource hands out the stream frames for encode, the stream being the
+// header set with setHeader followed by the payload. It is safe to request
+// frames from several goroutines.
+type payloadSource interface {
+	size() int64
+	setHeader(header []byte)
+	frame(id int) ([]byte, error)
+	// release is called once a frame returned by frame is no longer used
+	release(frame []byte)
+	Close() error
+}
+
+func openPayload(ctx context.Context, path string, capacity int, useMmap bool) (payloadSource, error) {
+	if isRemote(path) {
+		if useMmap {
+			return nil, errRemoteMmap
+		}
+		r, err := parseRemote(path)
+		if err != nil {
+			return nil, err
+		}
+		length, err := r.size(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &filePayload{
+			streamLayout: streamLayout{length: length, capacity: capacity},
+			file:         newRemoteReader(ctx, r, length),
+			buffers:      newFramePool(capacity),
+		}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if useMmap {
+		source, err := mmapPayload(file, info.Size(), capacity)
+		file.Close() // The mapping stays valid after the file is closed
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %w", path, err)
+		}
+		return source, nil
+	}
+	return &filePayload{
+		streamLayout: streamLayout{length: info.Size(), capacity: capacity},
+		file:         file,
+		buffers:      newFramePool(capacity),
+	}, nil
+}
+
+// sourceMetadata returns the metadata of the file at path that is stored in
+// the video. Of remote objects only the name is known, of standard input
+// nothing.
+func sourceMetadata(path string) (fileMetadata, error) {
+	if path == stdinInput {
+		return fileMetadata{}, nil
+	}
+	if isRemote(path) {
+		r, err := parseRemote(path)
+		if err != nil {
+			return fileMetadata{}, err
+		}
+		return fileMetadata{name: r.name()}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileMetadata{}, err
+	}
+	return metadataOf(info), nil
+}
+
+// filePayload reads every frame with ReadAt into a pooled buffer, either
+// from a file or with range requests from a remote.
+type filePayload struct {
+	streamLayout
+	file    io.ReaderAt
+	buffers *framePool
+}
+
+func (p *filePayload) frame(id int) ([]byte, error) {
+	header, start, end := p.bounds(id)
+	frame := p.buffers.get()
+	headerLength := copy(frame, header)
+
+	n, err := p.file.ReadAt(frame[headerLength:headerLength+int(end-start)], start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return frame[:headerLength+n], nil
+}
+
+func (p *filePayload) release(frame []byte) { p.buffers.put(frame) }
+
+func (p *filePayload) Close() error {
+	if closer, ok := p.file.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package ftv
+
+import (
+	"fmt"
+	"sync"
+)
+
+// The stream (header followed by the payload) is normally cut into
+// frames one after another, so a frame lost to the channel takes a whole
+// contiguous run of bytes with it. With an interleave depth of n the frames
+// are grouped in blocks of n and byte i of a block is carried by frame i%n of
+// the block, at position i/n. A lost frame then only costs every n-th byte
+// of its block, which error correction can repair where one long burst would
+// be beyond it.
+//
+// To keep every block complete, streams are padded to a multiple of n
+// frames.
+
+// interleavedFrames rounds frames up to whole blocks of depth.
+func interleavedFrames(frames int64, depth int) int64 {
+	d := int64(depth)
+	return (frames + d - 1) / d * d
+}
+
+// interleaveBlock spreads block over frames, frames[i] receiving every
+// len(frames)-th byte starting at i.
+func interleaveBlock(block []byte, frames [][]byte) {
+	depth := len(frames)
+	for i, b := range block {
+		frames[i%depth][i/depth] = b
+	}
+}
+
+// deinterleaveBlock is the inverse of interleaveBlock.
+func deinterleaveBlock(frames [][]byte, block []byte) {
+	depth := len(frames)
+	for i := range block {
+		block[i] = frames[i%depth][i/depth]
+	}
+}
+
+// digestBlock reads the interleaved block groups carries, every group
+// holding the copies of a frame as ffmpeg delivers them, and returns it with
+// the data of every frame, its number included. failed is the first frame
+// too damaged to correct, -1 if there is none.
+func digestBlock(groups [][]byte, opts options, ecc *frameECC) (block []byte, frames [][]byte, failed int) {
+	var raw []byte
+	if ecc != nil {
+		raw = make([]byte, rawFrameSize(opts))
+	}
+	failed = -1
+	frames = make([][]byte, len(groups))
+	for i, group := range groups {
+		frames[i] = make([]byte, frameDataSize(opts))
+		if repair := digestFrame(frameCopies(group, opts), frames[i], raw, opts, ecc); len(repair.failed) > 0 && failed < 0 {
+			failed = i
+		}
+	}
+	block = make([]byte, len(frames)*frameCapacity(opts))
+	deinterleaveBlock(frames, block)
+	return block, frames, failed
+}
+
+// frameCopies cuts group into the copies of a frame it holds.
+func frameCopies(group []byte, opts options) [][]byte {
+	rgbBytes := rgbFrameSize(opts)
+	copies := make([][]byte, len(group)/rgbBytes)
+	for c := range copies {
+		copies[c] = group[c*rgbBytes : (c+1)*rgbBytes]
+	}
+	return copies
+}
+
+// interleavedPayload wraps a payloadSource and hands out interleaved frames.
+// A block is read once, when the first of its frames is requested, and
+// dropped once all of them have been handed out.
+type interleavedPayload struct {
+	payloadSource
+	depth      int
+	capacity   int
+	dataFrames int // Frames of the wrapped source, the rest is padding
+	buffers    *framePool
+
+	mu     sync.Mutex
+	blocks map[int]*interleavedBlock
+}
+
+type interleavedBlock struct {
+	ready     chan struct{} // Closed once frames and err are set
+	frames    [][]byte
+	err       error
+	remaining int
+}
+
+func newInterleavedPayload(source payloadSource, depth, capacity, dataFrames int) *interleavedPayload {
+	return &interleavedPayload{
+		payloadSource: source,
+		depth:         depth,
+		capacity:      capacity,
+		dataFrames:    dataFrames,
+		buffers:       newFramePool(capacity),
+		blocks:        map[int]*interleavedBlock{},
+	}
+}
+
+func (p *interleavedPayload) frame(id int) ([]byte, error) {
+	index := id / p.depth
+	p.mu.Lock()
+	block, ok := p.blocks[index]
+	if !ok {
+		block = &interleavedBlock{ready: make(chan struct{}), remaining: p.depth}
+		p.blocks[index] = block
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		block.frames, block.err = p.readBlock(index)
+		close(block.ready)
+	}
+	<-block.ready
+
+	p.mu.Lock()
+	block.remaining--
+	if block.remaining == 0 {
+		delete(p.blocks, index)
+	}
+	p.mu.Unlock()
+	if block.err != nil {
+		return nil, block.err
+	}
+	return block.frames[id%p.depth], nil
+}
+
+// readBlock reads the frames of block index from the wrapped source and
+// interleaves them.
+func (p *interleavedPayload) readBlock(index int) ([][]byte, error) {
+	block := make([]byte, p.depth*p.capacity)
+	for i := 0; i < p.depth; i++ {
+		id := index*p.depth + i
+		if id >= p.dataFrames {
+			break // Padding stays zero
+		}
+		frame, err := p.payloadSource.frame(id)
+		if err != nil {
+			return nil, err
+		}
+		copy(block[i*p.capacity:], frame)
+		p.payloadSource.release(frame)
+	}
+
+	frames := make([][]byte, p.depth)
+	for i := range frames {
+		frames[i] = p.buffers.get()
+	}
+	interleaveBlock(block, frames)
+	return frames, nil
+}
+
+func (p *interleavedPayload) release(frame []byte) { p.buffers.put(frame) }
+
+// streamChunk is a piece of the decoded stream and where it starts.
+type streamChunk struct {
+	offset int64
+	value  []byte
+	frames int // Number of frames it was carried by
+}
+
+// deinterleaver collects the digested frames of each block on decode and
+// returns the block once it is complete. With a depth of 1 every frame is
+// returned as it comes.
+type deinterleaver struct {
+	depth    int
+	capacity int
+	frames   *framePool // Where the digested frames come from
+	buffers  *framePool // Of whole blocks
+
+	mu     sync.Mutex
+	blocks map[int][][]byte
+}
+
+func newDeinterleaver(depth, capacity int, frames *framePool) *deinterleaver {
+	return &deinterleaver{
+		depth:    depth,
+		capacity: capacity,
+		frames:   frames,
+		buffers:  newFramePool(depth * capacity),
+		blocks:   map[int][][]byte{},
+	}
+}
+
+// release returns the buffer of a chunk returned by add once it has been
+// written.
+func (d *deinterleaver) release(chunk streamChunk) {
+	if d.depth == 1 {
+		d.frames.put(chunk.value)
+	} else {
+		d.buffers.put(chunk.value)
+	}
+}
+
+func (d *deinterleaver) add(frame frameData) (streamChunk, bool) {
+	if d.depth == 1 {
+		return streamChunk{offset: int64(frame.frameID) * int64(d.capacity), value: frame.value, frames: 1}, true
+	}
+
+	index := frame.frameID / d.depth
+	d.mu.Lock()
+	frames, ok := d.blocks[index]
+	if !ok {
+		frames = make([][]byte, d.depth)
+		d.blocks[index] = frames
+	}
+	frames[frame.frameID%d.depth] = frame.value
+	for _, f := range frames {
+		if f == nil {
+			d.mu.Unlock()
+			return streamChunk{}, false
+		}
+	}
+	delete(d.blocks, index)
+	d.mu.Unlock()
+
+	block := d.buffers.get()
+	deinterleaveBlock(frames, block)
+	for _, f := range frames {
+		d.frames.put(f)
+	}
+	return streamChunk{offset: int64(index) * int64(len(block)), value: block, frames: d.depth}, true
+}
+
+// incomplete returns an error if a block is still missing frames, which
+// means the video was cut short.
+func (d *deinterleaver) incomplete() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for index, frames := range d.blocks {
+		missing := 0
+		for _, f := range frames {
+			if f == nil {
+				missing++
+			}
+		}
+		return withCause(ErrIncomplete, fmt.Errorf("interleaved block %d is missing %d of its %d frames", index, missing, d.depth))
+	}
+	return nil
+}
+
+// flush returns the blocks still missing frames, with the missing bytes
+// zeroed, for a partial recovery.
+func (d *deinterleaver) flush() []streamChunk {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var chunks []streamChunk
+	for index, frames := range d.blocks {
+		present := 0
+		for i, f := range frames {
+			if f == nil {
+				frames[i] = make([]byte, d.capacity)
+			} else {
+				present++
+			}
+		}
+		block := make([]byte, d.depth*d.capacity)
+		deinterleaveBlock(frames, block)
+		chunks = append(chunks, streamChunk{offset: int64(index) * int64(len(block)), value: block, frames: present})
+		delete(d.blocks, index)
+	}
+	return chunks
+}
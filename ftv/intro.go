@@ -0,0 +1,325 @@
+package ftv
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// An -intro video starts with a few seconds of a frame that tells in plain
+// text what the video is, for whoever finds it years later without knowing:
+// the file it holds, its size, when it was encoded and the command decoding
+// it. A band of bars along the bottom marks the frame, decode skips the
+// frames carrying it before the first one of the stream, so decoding needs
+// no flag for it.
+
+// introSeconds is how long the intro is shown, long enough to be read and to
+// be the thumbnail players pick.
+const introSeconds = 2
+
+// introMarker is the pattern of the bars, white for a set bit, read from the
+// most significant one. Data frames match it by chance about once in 2^32.
+const introMarker uint32 = 0xF7D1A5C3
+
+// introColumns is the most characters on a line of the intro, longer lines
+// go on on the next.
+const introColumns = 64
+
+// introFrames returns how many frames the intro of opts takes.
+func introFrames(opts options) int {
+	if !opts.intro {
+		return 0
+	}
+	return introSeconds * opts.fps
+}
+
+// writeIntro writes the intro of the payload of size bytes described by
+// metadata to sink, a size below 0 being unknown.
+func writeIntro(sink frameSink, metadata fileMetadata, size int64, opts options) error {
+	pixels := make([]byte, geometryOf(opts).frameBytes(4))
+	renderIntro(pixels, introLines(metadata, size, opts), opts)
+	for i := introFrames(opts); i > 0; i-- {
+		if err := sink.WriteFrame(pixels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// introLines is the text of the intro.
+func introLines(metadata fileMetadata, size int64, opts options) []string {
+	lines := []string{"FileToVideo", ""}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		lines = append(lines, "Version:  "+info.Main.Version)
+	}
+	lines = append(lines, fmt.Sprintf("Format:   v%d", formatVersion))
+	if metadata.name != "" {
+		lines = append(lines, "File:     "+metadata.name)
+	}
+	if size >= 0 {
+		lines = append(lines, fmt.Sprintf("Size:     %d bytes (%s)", size, formatBytes(size)))
+	}
+	if !opts.reproducible {
+		lines = append(lines, "Encoded:  "+time.Now().UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	lines = append(lines, "")
+
+	// Everything that must match when decoding, spelled out in case the
+	// defaults change
+	ecc := fmt.Sprint(opts.eccParity())
+	if opts.eccHamming {
+		ecc = eccHamming
+	}
+	command := fmt.Sprintf("FileToVideo -d -i VIDEO -size %dx%d -dot %d -dot-bits %d -modulation %s -ecc %s -interleave %d -repeat %d",
+		opts.width, opts.height, opts.dotSize, opts.dotBits, opts.modulation, ecc, opts.interleave, opts.repeat)
+	if opts.frameStrip {
+		command += " -frame-strip"
+	}
+	if opts.payloadFormat == payloadTar {
+		command += " -format tar"
+	}
+	if opts.deltaBase != "" {
+		command += " -base VIDEO_OF_THE_EARLIER_VERSION"
+	}
+	lines = append(lines,
+		"The frames after this one hold the data of the file",
+		"as colored dots. Decode it with FileToVideo, from",
+		"https://github.com/ErmitaVulpe/FileToVideo, with:",
+		"")
+	return append(lines, wrapWords(command, introColumns)...)
+}
+
+// wrapWords breaks s at spaces into lines of at most columns characters,
+// cutting words longer than that.
+func wrapWords(s string, columns int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > columns {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+		for len(line) > columns {
+			lines = append(lines, line[:columns])
+			line = line[columns:]
+		}
+	}
+	return append(lines, line)
+}
+
+// introBand returns the height of the marker band at the bottom of a frame
+// of opts and the width of its bars.
+func introBand(opts options) (height, bar int) {
+	height = opts.height / 24
+	if height < 4 {
+		height = 4
+	}
+	return height, opts.width / 32
+}
+
+// renderIntro draws lines in white on black into the RGBA frame pixels,
+// as large as they fit above the marker band, which it draws too.
+func renderIntro(pixels []byte, lines []string, opts options) {
+	for i := range pixels {
+		pixels[i] = 0
+	}
+	for i := 3; i < len(pixels); i += 4 {
+		pixels[i] = 255
+	}
+	fill := func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				copy(pixels[(y*opts.width+x)*4:], []byte{255, 255, 255})
+			}
+		}
+	}
+
+	bandHeight, bar := introBand(opts)
+	for i := 0; i < 32; i++ {
+		if introMarker>>(31-i)&1 == 1 {
+			fill(i*bar, opts.height-bandHeight, (i+1)*bar, opts.height)
+		}
+	}
+
+	// Glyphs are 5x7 dots in cells of 6x9, with a margin of a cell. The font
+	// only has ASCII, other characters are drawn as question marks.
+	var ascii []string
+	for _, line := range lines {
+		line = strings.Map(func(r rune) rune {
+			if r < ' ' || r > '~' {
+				return '?'
+			}
+			return r
+		}, line)
+		for len(line) > introColumns {
+			ascii = append(ascii, line[:introColumns])
+			line = line[introColumns:]
+		}
+		ascii = append(ascii, line)
+	}
+	columns := 1
+	for _, line := range ascii {
+		if len(line) > columns {
+			columns = len(line)
+		}
+	}
+	scale := opts.width / (6 * (columns + 2))
+	if rows := (opts.height - bandHeight) / (9 * (len(ascii) + 2)); rows < scale {
+		scale = rows
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	for row, line := range ascii {
+		for column, char := range []byte(line) {
+			glyph := introFont[char-' ']
+			x0, y0 := (column+1)*6*scale, (row+1)*9*scale
+			for gx, bits := range glyph {
+				for gy := 0; gy < 7; gy++ {
+					if bits>>gy&1 == 1 {
+						x, y := x0+gx*scale, y0+gy*scale
+						if x+scale <= opts.width && y+scale <= opts.height-bandHeight {
+							fill(x, y, x+scale, y+scale)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// isIntroFrame reports whether the RGB frame carries the marker band. Every
+// bar is judged by the average of a patch in its middle, clear of the edges
+// the codec blurred.
+func isIntroFrame(frame []byte, opts options) bool {
+	bandHeight, bar := introBand(opts)
+	if bar < 4 || len(frame) < opts.width*opts.height*3 {
+		return false
+	}
+	patch := bar / 4
+	if patch > bandHeight/2 {
+		patch = bandHeight / 2
+	}
+	y0 := opts.height - bandHeight/2 - patch/2
+	for i := 0; i < 32; i++ {
+		x0 := i*bar + bar/2 - patch/2
+		sum := 0
+		for y := y0; y < y0+patch; y++ {
+			for x := x0; x < x0+patch; x++ {
+				p := frame[(y*opts.width+x)*3:]
+				sum += int(p[0]) + int(p[1]) + int(p[2])
+			}
+		}
+		level := sum / (3 * patch * patch)
+		white := introMarker>>(31-i)&1 == 1
+		if white && level < 160 || !white && level > 96 {
+			return false
+		}
+	}
+	return true
+}
+
+// introFont holds the printable ASCII characters as 5 columns of 7 dots,
+// the lowest bit at the top.
+var introFont = [95][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // space
+	{0x00, 0x00, 0x5F, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7F, 0x14, 0x7F, 0x14}, // #
+	{0x24, 0x2A, 0x7F, 0x2A, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1C, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1C, 0x00}, // )
+	{0x14, 0x08, 0x3E, 0x08, 0x14}, // *
+	{0x08, 0x08, 0x3E, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3E, 0x51, 0x49, 0x45, 0x3E}, // 0
+	{0x00, 0x42, 0x7F, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4B, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7F, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3C, 0x4A, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1E}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3E}, // @
+	{0x7E, 0x11, 0x11, 0x11, 0x7E}, // A
+	{0x7F, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3E, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7F, 0x41, 0x41, 0x22, 0x1C}, // D
+	{0x7F, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7F, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3E, 0x41, 0x49, 0x49, 0x7A}, // G
+	{0x7F, 0x08, 0x08, 0x08, 0x7F}, // H
+	{0x00, 0x41, 0x7F, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3F, 0x01}, // J
+	{0x7F, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7F, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7F, 0x02, 0x0C, 0x02, 0x7F}, // M
+	{0x7F, 0x04, 0x08, 0x10, 0x7F}, // N
+	{0x3E, 0x41, 0x41, 0x41, 0x3E}, // O
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3E, 0x41, 0x51, 0x21, 0x5E}, // Q
+	{0x7F, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7F, 0x01, 0x01}, // T
+	{0x3F, 0x40, 0x40, 0x40, 0x3F}, // U
+	{0x1F, 0x20, 0x40, 0x20, 0x1F}, // V
+	{0x3F, 0x40, 0x38, 0x40, 0x3F}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7F, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // backslash
+	{0x00, 0x41, 0x41, 0x7F, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7F, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7F}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7E, 0x09, 0x01, 0x02}, // f
+	{0x0C, 0x52, 0x52, 0x52, 0x3E}, // g
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7D, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x44, 0x3D, 0x00}, // j
+	{0x7F, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7F, 0x40, 0x00}, // l
+	{0x7C, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7C, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0x7C, 0x14, 0x14, 0x14, 0x08}, // p
+	{0x08, 0x14, 0x14, 0x18, 0x7C}, // q
+	{0x7C, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3F, 0x44, 0x40, 0x20}, // t
+	{0x3C, 0x40, 0x40, 0x20, 0x7C}, // u
+	{0x1C, 0x20, 0x40, 0x20, 0x1C}, // v
+	{0x3C, 0x40, 0x30, 0x40, 0x3C}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x0C, 0x50, 0x50, 0x50, 0x3C}, // y
+	{0x44, 0x64, 0x54, 0x4C, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7F, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x02, 0x01, 0x02, 0x04, 0x02}, // ~
+}
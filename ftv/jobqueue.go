@@ -0,0 +1,208 @@
+package ftv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ffmpegSlots caps the ffmpeg processes the jobs of serve run at once, over
+// the HTTP API and gRPC alike. Jobs wait for the slots they need by priority,
+// the highest first, then by arrival, and one only starts once every job
+// ahead of it has, so a large job is not starved by a stream of small ones.
+type ffmpegSlots struct {
+	total int
+
+	mu      sync.Mutex
+	free    int
+	waiting []*slotWaiter
+	arrived int64
+}
+
+type slotWaiter struct {
+	priority int
+	arrival  int64
+	n        int
+	ready    chan struct{}
+}
+
+func newFFmpegSlots(total int) *ffmpegSlots {
+	return &ffmpegSlots{total: total, free: total}
+}
+
+// processes returns the ffmpeg processes a job of mode with opts runs: one
+// per segment when encoding, one when decoding. It never exceeds the total,
+// clampSegments makes sure the job keeps to that.
+func (s *ffmpegSlots) processes(mode string, opts options) int {
+	if mode != "encode" || opts.segments < 1 {
+		return 1
+	}
+	if opts.segments > s.total {
+		return s.total
+	}
+	return opts.segments
+}
+
+// clampSegments lowers -segments of opts to what the slots allow.
+func (s *ffmpegSlots) clampSegments(opts *options) {
+	if opts.segments > s.total {
+		opts.segments = s.total
+	}
+}
+
+// acquire waits for n slots and reports whether it got them, false if ctx
+// ended first. The slots must be handed back with release.
+func (s *ffmpegSlots) acquire(ctx context.Context, priority, n int) bool {
+	s.mu.Lock()
+	s.arrived++
+	w := &slotWaiter{priority: priority, arrival: s.arrived, n: n, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	sort.SliceStable(s.waiting, func(a, b int) bool {
+		if s.waiting[a].priority != s.waiting[b].priority {
+			return s.waiting[a].priority > s.waiting[b].priority
+		}
+		return s.waiting[a].arrival < s.waiting[b].arrival
+	})
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Handed the slots while giving up, so they go to the next in line
+		s.free += w.n
+	default:
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	s.dispatch()
+	return false
+}
+
+// release hands back n slots taken with acquire.
+func (s *ffmpegSlots) release(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.free += n
+	s.dispatch()
+}
+
+// dispatch starts the waiters at the head of the line the free slots allow.
+func (s *ffmpegSlots) dispatch() {
+	for len(s.waiting) > 0 && s.waiting[0].n <= s.free {
+		w := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		s.free -= w.n
+		close(w.ready)
+	}
+}
+
+// retryDelay is how long a failed job waits before its first retry, doubled
+// for every retry after that.
+const retryDelay = 5 * time.Second
+
+// retryable reports whether another attempt at a job that failed with err
+// may succeed. Errors the input or the setup is at fault for fail the same
+// way every time.
+func retryable(err error) bool {
+	for _, cause := range []error{errUsage, ErrFFmpegNotFound, ErrCorruptHeader, ErrUnsupportedVersion, ErrUncorrectable, ErrIncomplete, ErrHashMismatch} {
+		if errors.Is(err, cause) {
+			return false
+		}
+	}
+	return true
+}
+
+// jobFile is the file in the directory of every job of the HTTP API that
+// keeps it across restarts of serve.
+const jobFile = "job.json"
+
+// savedJob is a job as it is kept in its jobFile.
+type savedJob struct {
+	*job
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// save writes j to its jobFile, replacing the previous one in a single
+// rename so a crash never leaves half of it.
+func (s *jobServer) save(j *job) {
+	snapshot := j.snapshot()
+	snapshot.Progress = nil
+	data, err := json.MarshalIndent(savedJob{job: snapshot, Input: j.input, Output: j.output}, "", "  ")
+	if err == nil {
+		path := filepath.Join(s.dir, j.ID, jobFile)
+		if err = os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		logger.error("serve", fmt.Errorf("saving job %s: %w", j.ID, err))
+	}
+}
+
+// restore loads the jobs saved in the directory of the server. Those that
+// were queued or running when it stopped are queued again; the others are
+// listed and their results served as before.
+func (s *jobServer) restore() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*", jobFile))
+	if err != nil {
+		return err
+	}
+	resumed := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		saved := savedJob{job: &job{}}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			logger.error("serve", fmt.Errorf("skipping %s: %w", path, err))
+			continue
+		}
+		j := saved.job
+		j.input, j.output = saved.Input, saved.Output
+		j.Progress = map[string]*stageProgress{}
+		j.opts = s.opts
+		if j.Preset != "" {
+			// The preset may have changed or gone since the job was submitted
+			err := applyPreset(&j.opts, j.Preset, nil)
+			if err == nil {
+				err = j.opts.validate()
+			}
+			if err != nil && (j.State == jobQueued || j.State == jobRunning) {
+				j.setState(jobFailed, err)
+				s.save(j)
+			}
+		}
+
+		s.mu.Lock()
+		s.jobs[j.ID] = j
+		s.mu.Unlock()
+		if j.State == jobQueued || j.State == jobRunning {
+			s.start(j)
+			resumed++
+		}
+	}
+	if len(paths) > 0 {
+		logger.info("serve", "jobs restored", fields{"jobs": len(paths), "resumed": resumed})
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ftv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdinInput is the input name that encodes what is piped to the program.
+const stdinInput = "-"
+
+// liveFormats are the muxers ffmpeg streams to the live protocols with.
+var liveFormats = map[string]string{
+	"rtmp":  "flv",
+	"rtmps": "flv",
+	"srt":   "mpegts",
+}
+
+// liveFormat returns the muxer for dest if it is a live stream URL.
+func liveFormat(dest string) (string, bool) {
+	scheme, _, found := strings.Cut(dest, "://")
+	if !found {
+		return "", false
+	}
+	format, ok := liveFormats[strings.ToLower(scheme)]
+	return format, ok
+}
+
+func isLive(dest string) bool {
+	_, ok := liveFormat(dest)
+	return ok
+}
+
+// spoolStdin copies standard input to a temporary file, which the caller
+// removes. The stream header carries the length and the trailer the hash of
+// the payload, so all of it must be there before the first frame goes out.
+func spoolStdin() (string, error) {
+	file, err := os.CreateTemp("", ".filetovideo-stdin-*")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(file, os.Stdin)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("reading standard input: %w", err)
+	}
+	logger.verbose("reader", "standard input read", fields{"bytes": n})
+	return file.Name(), nil
+}
+
+// liveSync finds where a video starts in a live stream that was joined
+// while another one was already being sent: frames are dropped until one
+// carries a stream header, whose length then tells how many frames the
+// video has.
+type liveSync struct {
+	opts      options
+	ecc       *frameECC
+	data, raw []byte
+	frames    int  // Of the video once synced, 0 before
+	more      bool // Another part follows the last one counted in frames
+	skipped   int
+}
+
+func newLiveSync(opts options, ecc *frameECC) *liveSync {
+	s := &liveSync{opts: opts, ecc: ecc, data: make([]byte, frameDataSize(opts))}
+	if ecc != nil {
+		s.raw = make([]byte, rawFrameSize(opts))
+	}
+	return s
+}
+
+// check reports whether frame is the first of a video, synchronizing on it
+// if so.
+func (s *liveSync) check(frame []byte) bool {
+	for i := range s.data {
+		s.data[i] = 0
+	}
+	if repair := digestFrame([][]byte{frame}, s.data, s.raw, s.opts, s.ecc); len(repair.failed) > 0 {
+		s.skipped++
+		return false
+	}
+	if !bytes.HasPrefix(s.data, streamMagic) {
+		s.skipped++
+		return false
+	}
+	header, err := parseStreamHeader(s.data)
+	if err != nil {
+		s.skipped++
+		return false
+	}
+	s.frames = int(framesNeeded(header.length+int64(header.size), s.opts))
+	if header.version >= 2 {
+		s.frames++ // Trailer
+	}
+	s.more = header.more
+	logger.verbose("ffmpeg", "found the start of a video", fields{"skipped_frames": s.skipped, "frames": s.frames})
+	return true
+}
+
+// nextPart counts the frames of the part appended that frame, the one
+// after the last trailer, starts. Without -interleave no filler frames come
+// between them.
+func (s *liveSync) nextPart(frame []byte) {
+	s.more = false
+	for i := range s.data {
+		s.data[i] = 0
+	}
+	digestFrame([][]byte{frame}, s.data, s.raw, s.opts, s.ecc)
+	var part streamPart
+	if err := parsePartHeader(s.data, &part); err != nil {
+		// Decoding ends with the trailer missing
+		logger.error("ffmpeg", fmt.Errorf("reading the header of the next part: %w", err))
+		return
+	}
+	s.frames += int(framesNeeded(part.length+int64(part.size), s.opts)) + 1
+	s.more = part.more
+	logger.verbose("ffmpeg", "found the next part of the video", fields{"offset": part.offset, "frames": s.frames})
+}
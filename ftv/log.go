@@ -0,0 +1,267 @@
+package ftv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logFormat int
+
+const (
+	logText logFormat = iota
+	logJSON
+)
+
+func parseLogFormat(s string) (logFormat, error) {
+	switch s {
+	case "text":
+		return logText, nil
+	case "json":
+		return logJSON, nil
+	}
+	return logText, fmt.Errorf("unknown log format %q (expected text or json)", s)
+}
+
+type logLevel int
+
+const (
+	levelError   logLevel = iota // -q
+	levelInfo                    // default, summary only
+	levelVerbose                 // -v, per-stage timings and ffmpeg stderr
+	levelDebug                   // -vv, per-frame events
+)
+
+var levelNames = map[logLevel]string{
+	levelError:   "error",
+	levelInfo:    "info",
+	levelVerbose: "verbose",
+	levelDebug:   "debug",
+}
+
+// fields holds the structured part of an event. time.Duration values are
+// printed as-is in text mode and as milliseconds ("<key>_ms") in json mode.
+type fields map[string]interface{}
+
+// eventLogger is shared by every pipeline stage (reader, serializer, ffmpeg,
+// digester, writer) so the whole run can be followed either by a human or by
+// an orchestration system reading one JSON object per line.
+type eventLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format logFormat
+	level  logLevel
+
+	// tap, if set, sees every event whatever the level, the way the -tui
+	// dashboard follows ffmpeg without -v
+	tap func(level logLevel, stage, msg string)
+}
+
+var logger = &eventLogger{out: os.Stdout, level: levelInfo}
+
+func (l *eventLogger) enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+func (l *eventLogger) emit(level logLevel, stage, msg string, f fields) {
+	if l.tap != nil {
+		l.tap(level, stage, msg)
+	}
+	if !l.enabled(level) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.format == logJSON {
+		event := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339Nano),
+			"level": levelNames[level],
+			"stage": stage,
+			"msg":   msg,
+		}
+		for k, v := range f {
+			switch v := v.(type) {
+			case time.Duration:
+				event[k+"_ms"] = float64(v) / float64(time.Millisecond)
+			case error:
+				event[k] = v.Error()
+			default:
+				event[k] = v
+			}
+		}
+		line, err := json.Marshal(event)
+		if err != nil {
+			line = []byte(fmt.Sprintf(`{"level":"error","stage":"log","msg":%q}`, err.Error()))
+		}
+		l.out.Write(append(line, '\n'))
+		return
+	}
+
+	var sb strings.Builder
+	if level == levelError {
+		sb.WriteString("Error: ")
+	}
+	sb.WriteString(stage)
+	sb.WriteString(": ")
+	sb.WriteString(msg)
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%v", k, f[k])
+	}
+	sb.WriteByte('\n')
+	io.WriteString(l.out, sb.String())
+}
+
+func (l *eventLogger) info(stage, msg string, f fields) {
+	l.emit(levelInfo, stage, msg, f)
+}
+
+func (l *eventLogger) verbose(stage, msg string, f fields) {
+	l.emit(levelVerbose, stage, msg, f)
+}
+
+func (l *eventLogger) debug(stage, msg string, f fields) {
+	l.emit(levelDebug, stage, msg, f)
+}
+
+// error reports err under the given stage, or under the stage that caused it
+// if err came out of a pipeline.
+func (l *eventLogger) error(stage string, err error) {
+	l.reportError(stage, err, false)
+}
+
+// fatal reports err as an error event of the given stage and exits with the
+// code of its cause. It is used in place of panic so that failures stay
+// machine-parseable.
+func (l *eventLogger) fatal(stage string, err error) {
+	l.reportError(stage, err, true)
+	code, _ := exitCode(err)
+	os.Exit(code)
+}
+
+// reportError emits the event of error. In json the event carries the code
+// of its cause, and the exit code if the program exits with it.
+func (l *eventLogger) reportError(stage string, err error, exiting bool) {
+	code, name := exitCode(err)
+	var se *stageError
+	if errors.As(err, &se) {
+		stage, err = se.stage, se.err
+	}
+	var f fields
+	if l.format == logJSON {
+		f = fields{"code": name}
+		if exiting {
+			f["exit_code"] = code
+		}
+	}
+	l.emit(levelError, stage, err.Error(), f)
+}
+
+// stageStats collects the frame IDs a single worker handled so it can report
+// them as one event instead of one line per frame.
+type stageStats struct {
+	start      time.Time
+	frames     int
+	firstFrame int
+	lastFrame  int
+	blocked    time.Duration // Waiting for the next stage to take frames
+}
+
+func newStageStats() *stageStats {
+	return &stageStats{start: time.Now(), firstFrame: -1, lastFrame: -1}
+}
+
+func (s *stageStats) add(frameID int) {
+	if s.frames == 0 || frameID < s.firstFrame {
+		s.firstFrame = frameID
+	}
+	if frameID > s.lastFrame {
+		s.lastFrame = frameID
+	}
+	s.frames++
+}
+
+// blockedSince counts the time since start as spent blocked by backpressure.
+func (s *stageStats) blockedSince(start time.Time) {
+	s.blocked += time.Since(start)
+}
+
+func (s *stageStats) fields(worker int) fields {
+	return fields{
+		"worker":      worker,
+		"frames":      s.frames,
+		"first_frame": s.firstFrame,
+		"last_frame":  s.lastFrame,
+		"blocked":     s.blocked,
+		"elapsed":     time.Since(s.start),
+	}
+}
+
+// lineWriter turns everything written to it into one event per line. It is
+// plugged into ffmpeg's stderr, which uses \r for its progress updates.
+type lineWriter struct {
+	logger *eventLogger
+	level  logLevel
+	stage  string
+	buf    []byte
+	last   []string // The lines written last, oldest first
+}
+
+// lineWriterTail is how many lines a lineWriter remembers.
+const lineWriterTail = 5
+
+func (l *eventLogger) writer(stage string, level logLevel) *lineWriter {
+	return &lineWriter{logger: l, level: level, stage: stage}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.flushLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close emits a trailing line that was not terminated by a newline.
+func (w *lineWriter) Close() error {
+	w.flushLine(w.buf)
+	w.buf = nil
+	return nil
+}
+
+func (w *lineWriter) flushLine(line []byte) {
+	if text := strings.TrimSpace(string(line)); text != "" {
+		w.logger.emit(w.level, w.stage, text, nil)
+		if len(w.last) == lineWriterTail {
+			w.last = w.last[1:]
+		}
+		w.last = append(w.last, text)
+	}
+}
+
+// tail returns the lines written last, joined with " | ". Whatever wrote
+// them must be done.
+func (w *lineWriter) tail() string {
+	last := w.last
+	if text := strings.TrimSpace(string(w.buf)); text != "" {
+		last = append(append([]string{}, last...), text)
+	}
+	return strings.Join(last, " | ")
+}
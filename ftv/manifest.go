@@ -0,0 +1,165 @@
+package ftv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// manifestVersion is the version of the manifest layout, readers refuse
+// newer ones.
+const manifestVersion = 1
+
+// manifest is the sidecar JSON -manifest writes next to a video: the
+// settings it was encoded with, its stream header, the hash of the payload
+// and which frames carry what. Decoding with it takes the settings from it
+// and gets past a header or trailer damaged beyond what the ECC repairs.
+type manifest struct {
+	Manifest int               `json:"manifest"`
+	Format   int               `json:"format"` // Version of the stream in the video
+	File     manifestFile      `json:"file"`
+	Payload  int64             `json:"payload_bytes"` // The delta's with -base
+	SHA256   string            `json:"sha256"`        // Of the payload, as in the trailer
+	Settings map[string]string `json:"settings"`      // Keyed like the config file
+	Header   []byte            `json:"header"`        // The stream header, base64
+	Frames   []manifestRun     `json:"frames"`
+}
+
+type manifestFile struct {
+	Name     string `json:"name,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Modified string `json:"modified,omitempty"`
+}
+
+// manifestRun is a run of consecutive frames of the video holding the same
+// kind of content. The data frames carry the stream in blocks of -interleave
+// frames, and every frame but those of the intro is there -repeat times.
+type manifestRun struct {
+	Kind   string `json:"kind"` // intro, data or trailer
+	First  int    `json:"first_frame"`
+	Frames int    `json:"frames"`
+
+	// Data only, the bytes of the stream, header included, the frames hold
+	StreamLength int64 `json:"stream_length,omitempty"`
+}
+
+// newManifest describes the video encoded with opts whose stream starts with
+// header, carries a payload hashing to hash and takes dataFrames frames
+// before the trailer, not counting the copies of -repeat.
+func newManifest(header *streamHeader, hash []byte, dataFrames int, opts options) *manifest {
+	m := &manifest{
+		Manifest: manifestVersion,
+		Format:   header.version,
+		Payload:  header.length,
+		SHA256:   hex.EncodeToString(hash),
+		Settings: manifestSettings(opts),
+		Header:   header.marshal(),
+	}
+	m.File.Name = header.metadata.name
+	if header.metadata.mode != 0 {
+		m.File.Mode = fmt.Sprintf("%04o", header.metadata.mode)
+	}
+	if !header.metadata.modTime.IsZero() {
+		m.File.Modified = header.metadata.modTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	first := 0
+	if intro := introFrames(opts); intro > 0 {
+		m.Frames = append(m.Frames, manifestRun{Kind: "intro", Frames: intro})
+		first = intro
+	}
+	m.Frames = append(m.Frames,
+		manifestRun{Kind: "data", First: first, Frames: dataFrames * opts.repeat, StreamLength: int64(header.size) + header.length},
+		manifestRun{Kind: "trailer", First: first + dataFrames*opts.repeat, Frames: opts.repeat},
+	)
+	return m
+}
+
+// manifestSettings returns the settings decoding needs to match.
+func manifestSettings(opts options) map[string]string {
+	settings := map[string]string{
+		"codec":       opts.codec,
+		"size":        fmt.Sprintf("%dx%d", opts.width, opts.height),
+		"dot_size":    strconv.Itoa(opts.dotSize),
+		"modulation":  opts.modulation,
+		"ecc":         strconv.Itoa(opts.ecc),
+		"interleave":  strconv.Itoa(opts.interleave),
+		"repeat":      strconv.Itoa(opts.repeat),
+		"frame_strip": strconv.FormatBool(opts.frameStrip),
+		"fps":         strconv.Itoa(opts.fps),
+	}
+	if opts.modulation == modulationDots {
+		settings["dot_bits"] = strconv.Itoa(opts.dotBits)
+	}
+	if opts.eccHamming {
+		settings["ecc"] = eccHamming
+	}
+	return settings
+}
+
+// writeManifest writes m to path.
+func writeManifest(path string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readManifest reads the manifest at path.
+func readManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s is not a FileToVideo manifest: %w", path, err)
+	}
+	if m.Manifest < 1 || m.Manifest > manifestVersion {
+		return nil, fmt.Errorf("%s is a manifest of version %d, this build reads up to %d", path, m.Manifest, manifestVersion)
+	}
+	header, err := parseStreamHeader(m.Header)
+	if err != nil || header.size != len(m.Header) {
+		return nil, fmt.Errorf("%s holds no valid stream header", path)
+	}
+	if hash, err := hex.DecodeString(m.SHA256); err != nil || len(hash) != len(streamTrailer{}.hash) {
+		return nil, fmt.Errorf("%s holds no valid payload hash", path)
+	}
+	return &m, nil
+}
+
+// trailer returns the trailer of the video the manifest describes.
+func (m *manifest) trailer() *streamTrailer {
+	t := &streamTrailer{length: m.Payload}
+	hex.Decode(t.hash[:], []byte(m.SHA256))
+	for _, run := range m.Frames {
+		if run.Kind == "data" {
+			t.frame = run.Frames / m.repeat()
+		}
+	}
+	return t
+}
+
+// repeat returns the -repeat the video was encoded with.
+func (m *manifest) repeat() int {
+	if n, err := strconv.Atoi(m.Settings["repeat"]); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// repairHeader overwrites the start of prefix, the beginning of the stream
+// read from the video, with the header of the manifest and reports whether
+// it differed.
+func (m *manifest) repairHeader(prefix []byte) bool {
+	if bytes.Equal(prefix[:len(m.Header)], m.Header) {
+		return false
+	}
+	copy(prefix, m.Header)
+	return true
+}
@@ -0,0 +1,136 @@
+package ftv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Memory goes almost entirely to the frames in flight: a data frame and its
+// pixels for every frame a stage works on, waits in a queue or is held back
+// by a reorder buffer, plus the blocks of -interleave frames being gathered.
+// -max-memory shrinks the queues and then the parallelism until their sum
+// fits. ffmpeg is a process of its own and not counted.
+
+// memoryOverhead is what the program takes besides the frames: the runtime,
+// read and write buffers and the tables of the ECC.
+const memoryOverhead = 32 << 20
+
+// pipelineMemory returns about the most memory encoding or decoding with
+// opts takes, whichever is more.
+func pipelineMemory(opts options) int64 {
+	capacity := int64(frameCapacity(opts))
+	interleave := int64(opts.interleave)
+
+	// A reader, serializer and ffmpeg each hold a frame, the reorder buffer
+	// of every segment holds its window
+	encodeFrames := int64(opts.readers + opts.threads + opts.queueDepth + opts.segments*(opts.queueDepth+opts.reorderWindow+1))
+	encode := encodeFrames * (int64(geometryOf(opts).frameBytes(4)) + capacity)
+	if interleave > 1 {
+		encode += int64(opts.readers) * 2 * interleave * capacity
+	}
+
+	// ffmpeg hands every copy of -repeat over, the deinterleaver holds a
+	// block for every frame the writers have yet to take
+	decodeFrames := int64(1 + 2*opts.queueDepth + opts.threads + opts.writers)
+	decode := decodeFrames * (int64(rgbFrameSize(opts)*opts.repeat) + capacity)
+	if interleave > 1 {
+		decode += (decodeFrames + 1) * interleave * capacity
+	}
+
+	if decode > encode {
+		encode = decode
+	}
+	return memoryOverhead + encode
+}
+
+// fitMemory shrinks the pipeline of o until it fits in o.maxMemory: the
+// reorder window and the queues first, which only cost smoothing, then
+// segments and workers, which cost speed. The segments are kept with -split,
+// where they are the videos written.
+func (o *options) fitMemory() error {
+	if o.maxMemory <= 0 {
+		return nil
+	}
+	knobs := []struct {
+		value *int
+		min   int
+	}{
+		{&o.reorderWindow, 1},
+		{&o.queueDepth, 0},
+		{&o.segments, 1},
+		{&o.threads, 1},
+		{&o.readers, 1},
+		{&o.writers, 1},
+	}
+	for _, knob := range knobs {
+		if knob.value == &o.segments && o.split {
+			continue
+		}
+		for pipelineMemory(*o) > o.maxMemory && *knob.value > knob.min {
+			*knob.value /= 2
+			if *knob.value < knob.min {
+				*knob.value = knob.min
+			}
+		}
+	}
+	if need := pipelineMemory(*o); need > o.maxMemory {
+		return fmt.Errorf("frames of %dx%d need at least %s, more than -max-memory %s", o.width, o.height, formatBytes(need), formatBytes(o.maxMemory))
+	}
+	return nil
+}
+
+// parseBytes understands sizes such as "512M" or "2G", in powers of 1024.
+func parseBytes(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i"))
+	shift := 0
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		}
+	}
+	if shift != 0 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > 1<<(62-shift) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+// memoryFields describes the pipeline fitMemory sized.
+func memoryFields(o options) fields {
+	return fields{
+		"budget":      formatBytes(o.maxMemory),
+		"estimate":    formatBytes(pipelineMemory(o)),
+		"window":      o.reorderWindow,
+		"queue_depth": o.queueDepth,
+		"segments":    o.segments,
+		"threads":     o.threads,
+		"readers":     o.readers,
+		"writers":     o.writers,
+	}
+}
+
+// formatBytes writes n in the largest unit of parseBytes, with a decimal
+// if it is not a whole number of them.
+func formatBytes(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		shift  uint
+	}{{"G", 30}, {"M", 20}, {"K", 10}} {
+		if n >= 1<<unit.shift {
+			if n%(1<<unit.shift) == 0 {
+				return strconv.FormatInt(n>>unit.shift, 10) + unit.suffix
+			}
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(int64(1)<<unit.shift), unit.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,261 @@
+package ftv
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// runMerge combines partial recoveries of the same file, such as the decode
+// of a capture and the decode of the parts of it recorded again, filling the
+// holes of the first from the others. Every recovery but a complete one has
+// its hole map next to it.
+func runMerge(args []string) {
+	var (
+		input_files inputList
+		output_file string
+		force       bool
+	)
+
+	c := mergeCLI(&input_files, &output_file, &force)
+	c.parse(args)
+
+	if len(input_files) < 2 {
+		c.usageError("The -i flag must be given at least twice")
+	}
+	if output_file == "" {
+		c.usageError("The -o flag is mandatory")
+	}
+	if !force {
+		if _, err := os.Stat(output_file); err == nil {
+			logger.fatal("cli", fmt.Errorf("%s already exists, use -force", output_file))
+		}
+	}
+	if err := mergeRecoveries(input_files, output_file); err != nil {
+		logger.fatal("merge", err)
+	}
+}
+
+// mergeCLI defines the flags of the merge subcommand.
+func mergeCLI(input_files *inputList, output_file *string, force *bool) *cli {
+	c := newCLI("merge")
+	c.flags.Var(input_files, "i", "Path to a recovered file, given once per recovery; holes of the first are filled from the others in order")
+	c.flags.StringVar(output_file, "o", "", "Path to the merged file, which gets a hole map of its own if holes remain")
+	c.flags.BoolVar(force, "force", false, "Overwrite the output if it already exists")
+	return c
+}
+
+// recovery is a recovered file and what its hole map says of it.
+type recovery struct {
+	path   string
+	holes  holeMapFile
+	ranges []byteRange // Of the holes, merged
+}
+
+// loadRecovery reads the recovered file at path and its hole map. A file
+// without one is complete.
+func loadRecovery(path string) (*recovery, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &recovery{path: path, holes: holeMapFile{Length: info.Size()}}
+	data, err := os.ReadFile(path + ".holes.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.holes); err != nil {
+		return nil, fmt.Errorf("reading the hole map of %s: %w", path, err)
+	}
+	if r.holes.Length != info.Size() {
+		return nil, fmt.Errorf("%s is %d bytes but its hole map says %d", path, info.Size(), r.holes.Length)
+	}
+	for _, h := range r.holes.Holes {
+		r.ranges = append(r.ranges, byteRange{Offset: h.Offset, Length: h.Length})
+	}
+	r.ranges = mergeRanges(r.ranges)
+	return r, nil
+}
+
+// mergeRecoveries writes to dest the first of the recovered files inputs with
+// its holes filled from the others, and a hole map of what none of them has.
+func mergeRecoveries(inputs []string, dest string) error {
+	start := time.Now()
+	recoveries := make([]*recovery, len(inputs))
+	for i, path := range inputs {
+		// The output replaces dest only once it is complete, but the hole map
+		// of dest would be gone, or wrong, by the time the merge is read again
+		if path == dest || sameFile(path, dest) {
+			return fmt.Errorf("%s is one of the recoveries merged, the output must be another file", dest)
+		}
+		r, err := loadRecovery(path)
+		if err != nil {
+			return err
+		}
+		if i > 0 && r.holes.Length != recoveries[0].holes.Length {
+			return fmt.Errorf("%s is %d bytes and %s %d, they are not the same file", inputs[0], recoveries[0].holes.Length, path, r.holes.Length)
+		}
+		recoveries[i] = r
+	}
+	hash := ""
+	for _, r := range recoveries {
+		if r.holes.SHA256 == "" {
+			continue
+		}
+		if hash != "" && r.holes.SHA256 != hash {
+			return fmt.Errorf("%s is the recovery of another file than %s", r.path, inputs[0])
+		}
+		hash = r.holes.SHA256
+	}
+
+	// Written next to dest and renamed over it once merged, so a failed merge
+	// leaves whatever was there before
+	o, err := prepareOutput(dest)
+	if err != nil {
+		return err
+	}
+	defer o.cleanup()
+	base := recoveries[0]
+	out, err := os.OpenFile(o.path, os.O_RDWR|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	src, err := os.Open(base.path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("copying %s: %w", base.path, err)
+	}
+
+	// Holes are filled piece by piece, whatever the next recovery has of them
+	// is copied and the rest is left for the ones after it
+	holes := base.holes.Holes
+	frames := base.holes.Frames
+	filled := int64(0)
+	for _, r := range recoveries[1:] {
+		file, err := os.Open(r.path)
+		if err != nil {
+			return err
+		}
+		var remaining []hole
+		for _, h := range holes {
+			have, lack := splitRange(byteRange{Offset: h.Offset, Length: h.Length}, r.ranges)
+			for _, rg := range have {
+				if _, err := io.Copy(io.NewOffsetWriter(out, rg.Offset), io.NewSectionReader(file, rg.Offset, rg.Length)); err != nil {
+					file.Close()
+					return fmt.Errorf("copying from %s: %w", r.path, err)
+				}
+				filled += rg.Length
+			}
+			for _, rg := range lack {
+				remaining = append(remaining, hole{Offset: rg.Offset, Length: rg.Length, Reason: h.Reason})
+			}
+		}
+		file.Close()
+		holes = remaining
+		if r.holes.Frames != nil || len(r.ranges) == 0 {
+			frames = intersectFrames(frames, r.holes.Frames)
+		}
+		logger.verbose("merge", "recovery merged", fields{"input": r.path, "holes": len(holes)})
+	}
+
+	var verified *bool
+	if hash != "" {
+		sum, err := hashFile(out, []streamPart{{length: base.holes.Length}})
+		if err != nil {
+			return err
+		}
+		ok := hex.EncodeToString(sum[:]) == hash
+		verified = &ok
+		if !ok && len(holes) == 0 {
+			return withCause(ErrHashMismatch, errors.New("merged file does not match the hash in the video"))
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := o.commit(context.Background()); err != nil {
+		return err
+	}
+
+	holeMapPath := dest + ".holes.json"
+	if len(holes) == 0 {
+		if err := os.Remove(holeMapPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		logger.info("merge", "recoveries merged", fields{"output": dest, "filled_bytes": filled, "hash_verified": verified != nil, "elapsed": time.Since(start)})
+		return nil
+	}
+	data, _ := json.MarshalIndent(holeMapFile{base.holes.Length, hash, verified, holes, frames}, "", "  ")
+	if err := os.WriteFile(holeMapPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing hole map: %w", err)
+	}
+	logger.error("merge", fmt.Errorf("merged partially, %d holes remain", len(holes)))
+	logger.info("merge", "recoveries merged", fields{"output": dest, "holes": holeMapPath, "filled_bytes": filled, "elapsed": time.Since(start)})
+	return nil
+}
+
+// splitRange splits rg into the parts outside the sorted, merged holes and
+// the parts inside them.
+func splitRange(rg byteRange, holes []byteRange) (outside, inside []byteRange) {
+	next, end := rg.Offset, rg.Offset+rg.Length
+	for _, h := range holes {
+		hEnd := h.Offset + h.Length
+		if hEnd <= next {
+			continue
+		}
+		if h.Offset >= end {
+			break
+		}
+		if h.Offset > next {
+			outside = append(outside, byteRange{Offset: next, Length: h.Offset - next})
+			next = h.Offset
+		}
+		stop := hEnd
+		if stop > end {
+			stop = end
+		}
+		inside = append(inside, byteRange{Offset: next, Length: stop - next})
+		next = stop
+	}
+	if next < end {
+		outside = append(outside, byteRange{Offset: next, Length: end - next})
+	}
+	return outside, inside
+}
+
+// intersectFrames returns the frames in both of the runs a and b, which are
+// still to be recorded again once two recoveries are merged.
+func intersectFrames(a, b []frameRange) []frameRange {
+	var both []frameRange
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		r := a[i]
+		if b[j].First > r.First {
+			r.First, r.Start = b[j].First, b[j].Start
+		}
+		if b[j].Last < r.Last {
+			r.Last, r.End = b[j].Last, b[j].End
+		}
+		if r.First <= r.Last {
+			both = append(both, r)
+		}
+		if a[i].Last < b[j].Last {
+			i++
+		} else {
+			j++
+		}
+	}
+	return both
+}
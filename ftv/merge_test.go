@@ -0,0 +1,111 @@
+package ftv
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRecovery writes a recovery of data at path with the holes given, whose
+// bytes are zeroed, and the hash of the file if not empty.
+func writeRecovery(t *testing.T, path string, data []byte, hash string, holes ...hole) {
+	t.Helper()
+	data = append([]byte(nil), data...)
+	for _, h := range holes {
+		copy(data[h.Offset:h.Offset+h.Length], make([]byte, h.Length))
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if len(holes) == 0 {
+		return
+	}
+	holeMap, _ := json.Marshal(holeMapFile{Length: int64(len(data)), SHA256: hash, Holes: holes})
+	if err := os.WriteFile(path+".holes.json", holeMap, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeRecoveries(t *testing.T) {
+	dir := t.TempDir()
+	data := testPayload(10000)
+	first := filepath.Join(dir, "first.bin")
+	second := filepath.Join(dir, "second.bin")
+	writeRecovery(t, first, data, "", hole{Offset: 100, Length: 500, Reason: "missing"}, hole{Offset: 9000, Length: 1000, Reason: "missing"})
+	writeRecovery(t, second, data, "", hole{Offset: 9500, Length: 500, Reason: "missing"})
+
+	merged := filepath.Join(dir, "merged.bin")
+	if err := mergeRecoveries([]string{first, second}, merged); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:9500], data[:9500]) {
+		t.Fatal("holes not filled")
+	}
+	var holes holeMapFile
+	holeMap, err := os.ReadFile(merged + ".holes.json")
+	if err == nil {
+		err = json.Unmarshal(holeMap, &holes)
+	}
+	if err != nil || len(holes.Holes) != 1 || holes.Holes[0].Offset != 9500 || holes.Holes[0].Length != 500 {
+		t.Fatalf("hole map %+v (%v)", holes, err)
+	}
+}
+
+// TestMergeKeepsOutput checks that a merge into one of its inputs is refused
+// and that a failed merge leaves the output as it was.
+func TestMergeKeepsOutput(t *testing.T) {
+	dir := t.TempDir()
+	data := testPayload(1000)
+	first := filepath.Join(dir, "first.bin")
+	second := filepath.Join(dir, "second.bin")
+	writeRecovery(t, first, data, "", hole{Offset: 0, Length: 100, Reason: "missing"})
+	writeRecovery(t, second, data, "")
+	link := filepath.Join(dir, "link.bin")
+	if err := os.Symlink(first, link); err != nil {
+		t.Fatal(err)
+	}
+	// Of another file, which is only found out once merged
+	other := filepath.Join(dir, "other.bin")
+	writeRecovery(t, other, data, strings.Repeat("00", 32), hole{Offset: 0, Length: 100, Reason: "missing"})
+	existing := filepath.Join(dir, "existing.bin")
+	if err := os.WriteFile(existing, []byte("kept"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		inputs []string
+		dest   string
+	}{
+		{"first", []string{first, second}, first},
+		{"second", []string{first, second}, second},
+		{"link", []string{first, second}, link},
+		{"failed", []string{other, second}, existing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := os.ReadFile(tt.dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mergeRecoveries(tt.inputs, tt.dest); err == nil {
+				t.Fatal("merged")
+			}
+			after, err := os.ReadFile(tt.dest)
+			if err != nil || !bytes.Equal(before, after) {
+				t.Fatalf("output changed (%v)", err)
+			}
+		})
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 7 {
+		t.Fatalf("%d files left, expected the 7 written", len(entries))
+	}
+}
@@ -0,0 +1,211 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics counts the jobs of the serve and watch modes, which expose them at
+// /metrics in the Prometheus text format.
+var metrics = newMetricSet()
+
+// Upper bounds of the histogram buckets, the last one is +Inf
+var (
+	durationBuckets   = []float64{1, 5, 15, 60, 300, 900, 3600}
+	throughputBuckets = []float64{1, 10, 30, 60, 120, 250, 500, 1000}
+)
+
+type histogram struct {
+	bounds []float64
+	counts []int64 // Per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type metricSet struct {
+	mu             sync.Mutex
+	jobs           map[string]int64 // By mode and state
+	running        map[string]int64 // By mode
+	frames         map[string]int64 // By mode and stage
+	durations      map[string]*histogram
+	throughput     map[string]*histogram // Frames per second, by mode and stage
+	eccCorrected   int64
+	ffmpegFailures int64
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		jobs:       map[string]int64{},
+		running:    map[string]int64{},
+		frames:     map[string]int64{},
+		durations:  map[string]*histogram{},
+		throughput: map[string]*histogram{},
+	}
+}
+
+// labels renders label pairs, which makes them usable as map keys too.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// jobMetrics follows a single encode or decode run.
+type jobMetrics struct {
+	set    *metricSet
+	mode   string
+	start  time.Time
+	frames map[string]int64 // Of this run, by stage
+}
+
+// track counts a run of mode starting now. The frames every stage handles
+// are taken from the progress of opts, which is wrapped for it.
+func (m *metricSet) track(mode string, opts *options) *jobMetrics {
+	j := &jobMetrics{set: m, mode: mode, start: time.Now(), frames: map[string]int64{}}
+	m.mu.Lock()
+	m.running[mode]++
+	m.mu.Unlock()
+
+	next := opts.onProgress
+	opts.onProgress = func(stage string, done, total int64) {
+		// Calls are serialized, so only the counters shared with other runs
+		// need the lock
+		if added := done - j.frames[stage]; added > 0 {
+			j.frames[stage] = done
+			m.mu.Lock()
+			m.frames[labels("mode", mode, "stage", stage)] += added
+			m.mu.Unlock()
+		}
+		if next != nil {
+			next(stage, done, total)
+		}
+	}
+	return j
+}
+
+// finish records how the run ended, err being what it returned.
+func (j *jobMetrics) finish(state jobState, err error) {
+	elapsed := time.Since(j.start).Seconds()
+	m := j.set
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[j.mode]--
+	m.jobs[labels("mode", j.mode, "state", string(state))]++
+
+	var se *stageError
+	if errors.As(err, &se) && se.stage == "ffmpeg" {
+		m.ffmpegFailures++
+	}
+	if state != jobDone {
+		return
+	}
+	key := labels("mode", j.mode)
+	if m.durations[key] == nil {
+		m.durations[key] = newHistogram(durationBuckets)
+	}
+	m.durations[key].observe(elapsed)
+	if elapsed <= 0 {
+		return
+	}
+	for stage, frames := range j.frames {
+		key := labels("mode", j.mode, "stage", stage)
+		if m.throughput[key] == nil {
+			m.throughput[key] = newHistogram(throughputBuckets)
+		}
+		m.throughput[key].observe(float64(frames) / elapsed)
+	}
+}
+
+// addCorrected counts bytes the ECC of decode repaired.
+func (m *metricSet) addCorrected(n int64) {
+	m.mu.Lock()
+	m.eccCorrected += n
+	m.mu.Unlock()
+}
+
+// serveMetrics serves /metrics alone on addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	return serveHTTP(ctx, addr, mux)
+}
+
+func (m *metricSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metricSet) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounters(w, "filetovideo_jobs_total", "counter", "Finished jobs by mode and final state.", m.jobs)
+	running := map[string]int64{}
+	for mode, n := range m.running {
+		running[labels("mode", mode)] = n
+	}
+	writeCounters(w, "filetovideo_jobs_running", "gauge", "Jobs currently running by mode.", running)
+	writeCounters(w, "filetovideo_frames_total", "counter", "Frames handled by every stage of the pipeline.", m.frames)
+	writeHistograms(w, "filetovideo_job_duration_seconds", "Duration of successful jobs.", m.durations)
+	writeHistograms(w, "filetovideo_stage_frames_per_second", "Average frames per second of every stage over a successful job.", m.throughput)
+	writeCounters(w, "filetovideo_ecc_corrected_bytes_total", "counter", "Bytes repaired by error correction when decoding.", map[string]int64{"": m.eccCorrected})
+	writeCounters(w, "filetovideo_ffmpeg_failures_total", "counter", "Jobs that failed because of ffmpeg.", map[string]int64{"": m.ffmpegFailures})
+}
+
+func writeCounters(w io.Writer, name, kind, help string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %d\n", name, key, values[key])
+	}
+}
+
+func writeHistograms(w io.Writer, name, help string, values map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, key := range sortedKeys(values) {
+		h := values[key]
+		// The bucket label goes after the others
+		prefix := strings.TrimSuffix(key, "}")
+		if prefix != "{" {
+			prefix += ","
+		}
+		var cumulative int64
+		for i, count := range h.counts {
+			cumulative += count
+			bound := "+Inf"
+			if i < len(h.bounds) {
+				bound = fmt.Sprint(h.bounds[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%sle=%q} %d\n", name, prefix, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", name, key, h.sum, name, key, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package ftv
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapPayload(file *os.File, length int64, capacity int) (payloadSource, error) {
+	return nil, errors.New("memory-mapped input is not supported on this platform")
+}
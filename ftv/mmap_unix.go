@@ -0,0 +1,51 @@
+//go:build unix
+
+package ftv
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mappedPayload slices frames directly out of a read-only mapping of the
+// input, so the payload is never copied into the Go heap.
+type mappedPayload struct {
+	streamLayout
+	data []byte
+}
+
+func mmapPayload(file *os.File, length int64, capacity int) (payloadSource, error) {
+	if int64(int(length)) != length {
+		return nil, fmt.Errorf("file is too large to be mapped on this platform")
+	}
+	p := &mappedPayload{streamLayout: streamLayout{length: length, capacity: capacity}}
+	if length > 0 { // Empty files cannot be mapped
+		data, err := syscall.Mmap(int(file.Fd()), 0, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, err
+		}
+		p.data = data
+	}
+	return p, nil
+}
+
+func (p *mappedPayload) frame(id int) ([]byte, error) {
+	header, start, end := p.bounds(id)
+	if len(header) == 0 {
+		return p.data[start:end:end], nil
+	}
+	// Only the frames carrying the header need a copy
+	frame := make([]byte, 0, p.capacity)
+	frame = append(frame, header...)
+	return append(frame, p.data[start:end]...), nil
+}
+
+func (p *mappedPayload) release(frame []byte) {}
+
+func (p *mappedPayload) Close() error {
+	if p.data == nil {
+		return nil
+	}
+	return syscall.Munmap(p.data)
+}
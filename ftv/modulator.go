@@ -0,0 +1,30 @@
+package ftv
+
+// frameModulator is how the frames of a video carry bytes. encode and decode
+// only see a frame as the bytes it carries, so a new way of drawing them
+// into pixels only needs a frameModulator and a name for -modulation.
+type frameModulator interface {
+	// Capacity returns how many bytes a frame carries.
+	Capacity() int
+
+	// PackFrame paints data, at most Capacity bytes, into the zeroed RGBA
+	// frame pixelData.
+	PackFrame(data, pixelData []byte)
+
+	// UnpackFrame reads the RGB frame copies, the frame as decoded once for
+	// every -repeat, into the zeroed data.
+	UnpackFrame(copies [][]byte, data []byte)
+}
+
+// modulatorOf returns the modulation opts asks for.
+func modulatorOf(opts options) frameModulator {
+	g := geometryOf(opts)
+	switch {
+	case opts.modulation == modulationDCT:
+		return dctBlocks{g}
+	case opts.dotBits == 24:
+		return fullDots{g}
+	default:
+		return newBitDots(g)
+	}
+}
@@ -0,0 +1,412 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A parity video carries Reed-Solomon parity over the payload of another
+// video as a payload of its own. Made from the input file, it adds
+// protection to a video already uploaded, and decoding the video along with
+// it repairs what its frames lost.
+//
+// The payload of length L is laid out as k rows of S = ceil(L/k) bytes, the
+// last one padded with zeros. Byte c of every row makes the data of codeword
+// c, so the run of bytes a lost frame carried hits every codeword only a few
+// times.
+//
+// Parity file, all integers big-endian:
+//
+//	0   4  magic "FTVP"
+//	4   1  version
+//	5   1  parity bytes per codeword, m
+//	6   1  data bytes per codeword, k
+//	7   1  unused
+//	8   8  payload length L
+//	16  32 SHA-256 of the payload
+//	48     the m parity bytes of codeword 0, 1, ... S-1
+var parityMagic = []byte("FTVP")
+
+const (
+	parityVersion    = 1
+	parityHeaderSize = 48
+
+	// Columns handled at once by a worker, the rows are read in slices of
+	// this many bytes
+	parityBatch = 64 << 10
+)
+
+type parityHeader struct {
+	parity int // m
+	data   int // k
+	length int64
+	hash   [sha256.Size]byte
+}
+
+// newParityHeader returns the layout giving a payload of length bytes share
+// percent of parity.
+func newParityHeader(length int64, share float64) *parityHeader {
+	m := int(math.Round(255 * share / (100 + share)))
+	if m < 1 {
+		m = 1
+	}
+	if m > 254 {
+		m = 254
+	}
+	return &parityHeader{parity: m, data: 255 - m, length: length}
+}
+
+// stride returns the length of a row, which is the number of codewords.
+func (h *parityHeader) stride() int64 {
+	return (h.length + int64(h.data) - 1) / int64(h.data)
+}
+
+func (h *parityHeader) marshal() []byte {
+	b := make([]byte, parityHeaderSize)
+	copy(b, parityMagic)
+	b[4] = parityVersion
+	b[5] = byte(h.parity)
+	b[6] = byte(h.data)
+	binary.BigEndian.PutUint64(b[8:], uint64(h.length))
+	copy(b[16:], h.hash[:])
+	return b
+}
+
+func parseParityHeader(b []byte) (*parityHeader, error) {
+	if len(b) < parityHeaderSize || !bytes.Equal(b[:len(parityMagic)], parityMagic) {
+		return nil, withCause(ErrCorruptHeader, errors.New("not a parity video"))
+	}
+	if b[4] > parityVersion {
+		return nil, withCause(ErrUnsupportedVersion, fmt.Errorf("parity video was written in format v%d, this build reads up to v%d", b[4], parityVersion))
+	}
+	h := &parityHeader{parity: int(b[5]), data: int(b[6]), length: int64(binary.BigEndian.Uint64(b[8:]))}
+	copy(h.hash[:], b[16:])
+	if h.parity < 1 || h.data < 1 || h.parity+h.data > 255 || h.length < 0 || h.length > maxStreamLength {
+		return nil, withCause(ErrCorruptHeader, errors.New("corrupt parity header"))
+	}
+	return h, nil
+}
+
+// parseParityShare parses the -parity of encode, a percentage of the
+// payload such as 10%.
+func parseParityShare(s string) (float64, error) {
+	share, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || share <= 0 || share > 100 {
+		return 0, fmt.Errorf("parity must be a share of the payload between 0 and 100%%, not %q", s)
+	}
+	return share, nil
+}
+
+// parityVideoPath returns where the parity video of the video dest goes.
+func parityVideoPath(dest string) string {
+	ext := filepath.Ext(dest)
+	return strings.TrimSuffix(dest, ext) + ".parity" + ext
+}
+
+// forColumns calls fn with batches of the columns up to stride, spread over
+// threads workers. It returns the first error fn returned.
+func forColumns(stride int64, threads int, fn func(start, end int64) error) error {
+	var (
+		next int64
+		mu   sync.Mutex
+		err  error
+		wg   sync.WaitGroup
+	)
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				start := atomic.AddInt64(&next, parityBatch) - parityBatch
+				if start >= stride {
+					return
+				}
+				end := start + parityBatch
+				if end > stride {
+					end = stride
+				}
+				if batchErr := fn(start, end); batchErr != nil {
+					mu.Lock()
+					if err == nil {
+						err = batchErr
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// readRow fills buf with the bytes of row from column start on, zeros past
+// the end of the payload.
+func readRow(file *os.File, h *parityHeader, row int, start int64, buf []byte) error {
+	offset := int64(row)*h.stride() + start
+	n := 0
+	if offset < h.length {
+		want := buf
+		if rest := h.length - offset; int64(len(want)) > rest {
+			want = want[:rest]
+		}
+		var err error
+		if n, err = file.ReadAt(want, offset); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return nil
+}
+
+// writeParity writes the parity file of the payload at path to dest.
+func writeParity(path, dest string, share float64, threads int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	h := newParityHeader(info.Size(), share)
+	if h.hash, err = hashFile(file, []streamPart{{length: h.length}}); err != nil {
+		return err
+	}
+
+	rs := newReedSolomon(h.parity)
+	parity := make([]byte, h.stride()*int64(h.parity))
+	err = forColumns(h.stride(), threads, func(start, end int64) error {
+		row := make([]byte, end-start)
+		for i := 0; i < h.data; i++ {
+			if err := readRow(file, h, i, start, row); err != nil {
+				return err
+			}
+			for c, b := range row {
+				column := (start + int64(c)) * int64(h.parity)
+				rs.feed(parity[column:column+int64(h.parity)], b)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dest, append(h.marshal(), parity...), 0o644)
+}
+
+// encodeParity encodes the parity video of the payload at path next to the
+// video dest, with the settings of that video.
+func encodeParity(ctx context.Context, path, dest string, opts options) error {
+	share, err := parseParityShare(opts.parity)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "filetovideo-parity-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	name := "payload"
+	if path != stdinInput {
+		name = filepath.Base(path)
+	}
+	parityFile := filepath.Join(dir, name+".parity")
+	if err := writeParity(path, parityFile, share, opts.threads); err != nil {
+		return fmt.Errorf("computing the parity: %w", err)
+	}
+
+	parityOpts := opts
+	parityOpts.parity = ""
+	parityOpts.split = false
+	parityOpts.onProgress = nil
+	parityOpts.onQueues = nil
+	video := parityVideoPath(dest)
+	logger.verbose("parity", "encoding the parity video", fields{"video": video, "share": share})
+	return encode(ctx, parityFile, video, parityOpts)
+}
+
+// parityData is a decoded parity video.
+type parityData struct {
+	header *parityHeader
+	parity []byte
+}
+
+// loadParity decodes the parity video of opts.parity, which must have been
+// encoded with the settings of opts.
+func loadParity(ctx context.Context, opts options) (*parityData, error) {
+	dir, err := os.MkdirTemp("", "filetovideo-parity-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "parity")
+
+	parityOpts := opts
+	parityOpts.parity = ""
+	parityOpts.deltaBase = ""
+	parityOpts.reportPath = ""
+	parityOpts.partial = false
+	parityOpts.split = false
+	parityOpts.restoreMetadata = false
+	parityOpts.overwrite = true
+	parityOpts.onProgress = nil
+	parityOpts.onQueues = nil
+	logger.verbose("parity", "decoding the parity video", fields{"video": opts.parity})
+	if err := decode(ctx, opts.parity, path, parityOpts); err != nil {
+		return nil, fmt.Errorf("decoding the parity video %s: %w", opts.parity, err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseParityHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)-parityHeaderSize) != h.stride()*int64(h.parity) {
+		return nil, withCause(ErrCorruptHeader, errors.New("parity video holds the wrong amount of parity"))
+	}
+	return &parityData{header: h, parity: b[parityHeaderSize:]}, nil
+}
+
+// repair corrects file, the decoded payload, in place. The bytes in
+// erasures, sorted and not overlapping, are known to be wrong; every
+// codeword corrects as many of them plus half as many bytes nobody knew
+// about as it has parity bytes. It returns how many bytes it corrected.
+func (q *parityData) repair(file *os.File, erasures []byteRange, threads int) (int64, error) {
+	h := q.header
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != h.length {
+		return 0, fmt.Errorf("parity video is of a payload of %d bytes, not %d", h.length, info.Size())
+	}
+	if sum, err := hashFile(file, []streamPart{{length: h.length}}); err != nil {
+		return 0, err
+	} else if sum == h.hash {
+		return 0, nil
+	}
+
+	rs := newReedSolomon(h.parity)
+	stride := h.stride()
+	var corrected, failed atomic.Int64
+	err = forColumns(stride, threads, func(start, end int64) error {
+		width := int(end - start)
+		rows := make([][]byte, h.data)
+		erased := make([][]bool, h.data)
+		for i := range rows {
+			rows[i] = make([]byte, width)
+			if err := readRow(file, h, i, start, rows[i]); err != nil {
+				return err
+			}
+			erased[i] = markErasures(erasures, int64(i)*stride+start, width)
+		}
+
+		dirty := make([]bool, h.data)
+		codeword := make([]byte, h.data+h.parity)
+		var positions []int
+		for c := 0; c < width; c++ {
+			positions = positions[:0]
+			for i := range rows {
+				codeword[i] = rows[i][c]
+				if erased[i] != nil && erased[i][c] {
+					positions = append(positions, i)
+				}
+			}
+			column := (start + int64(c)) * int64(h.parity)
+			copy(codeword[h.data:], q.parity[column:column+int64(h.parity)])
+			n, err := rs.decodeErasures(codeword, positions)
+			if err != nil && len(positions) > 0 {
+				// Suspect bytes are not all wrong, too many of them may
+				// still leave few enough errors
+				for i := range rows {
+					codeword[i] = rows[i][c]
+				}
+				copy(codeword[h.data:], q.parity[column:column+int64(h.parity)])
+				n, err = rs.decode(codeword)
+			}
+			if err != nil {
+				failed.Add(1)
+				continue
+			}
+			if n == 0 {
+				continue
+			}
+			for i := range rows {
+				if rows[i][c] != codeword[i] {
+					rows[i][c] = codeword[i]
+					dirty[i] = true
+					corrected.Add(1)
+				}
+			}
+		}
+
+		for i, row := range rows {
+			if !dirty[i] {
+				continue
+			}
+			offset := int64(i)*stride + start
+			if rest := h.length - offset; int64(len(row)) > rest {
+				row = row[:rest]
+			}
+			if _, err := file.WriteAt(row, offset); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n := failed.Load(); n > 0 {
+		return corrected.Load(), withCause(ErrUncorrectable, fmt.Errorf("%d of %d codewords of the parity have too many errors to correct", n, stride))
+	}
+	if sum, err := hashFile(file, []streamPart{{length: h.length}}); err != nil {
+		return 0, err
+	} else if sum != h.hash {
+		return corrected.Load(), withCause(ErrHashMismatch, errors.New("repaired payload does not match the hash in the parity video"))
+	}
+	return corrected.Load(), nil
+}
+
+// markErasures returns which of the width bytes from offset on are in
+// erasures, nil if none is.
+func markErasures(erasures []byteRange, offset int64, width int) []bool {
+	end := offset + int64(width)
+	i := sort.Search(len(erasures), func(i int) bool { return erasures[i].Offset+erasures[i].Length > offset })
+	var marks []bool
+	for ; i < len(erasures) && erasures[i].Offset < end; i++ {
+		if marks == nil {
+			marks = make([]bool, width)
+		}
+		from, to := erasures[i].Offset-offset, erasures[i].Offset+erasures[i].Length-offset
+		if from < 0 {
+			from = 0
+		}
+		if to > int64(width) {
+			to = int64(width)
+		}
+		for j := from; j < to; j++ {
+			marks[j] = true
+		}
+	}
+	return marks
+}
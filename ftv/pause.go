@@ -0,0 +1,57 @@
+package ftv
+
+import (
+	"context"
+	"sync"
+)
+
+// pauseGate holds back the frames entering the pipelines of the process
+// while it is paused. The frames already in flight go on to ffmpeg and the
+// output, which then idle until the gate opens again.
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // Closed on resume, nil while running
+}
+
+// pipelinePause is the gate of every run of the process, SIGUSR1 closes it
+// and SIGUSR2 opens it again (see pause_unix.go).
+var pipelinePause = &pauseGate{}
+
+// pause closes the gate, false if it already was.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed = make(chan struct{})
+	return true
+}
+
+// resume opens the gate, false if it was not closed.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return false
+	}
+	close(g.resumed)
+	g.resumed = nil
+	return true
+}
+
+// wait blocks while the gate is closed, false if ctx is done first.
+func (g *pauseGate) wait(ctx context.Context) bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package ftv
+
+// watchPauseSignals does nothing, there is no SIGUSR1 or SIGUSR2 to pause
+// and resume with.
+func watchPauseSignals() {}
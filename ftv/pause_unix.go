@@ -0,0 +1,27 @@
+//go:build unix
+
+package ftv
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchPauseSignals pauses the pipelines on SIGUSR1 and resumes them on
+// SIGUSR2, so a long job can yield to other work without being restarted.
+func watchPauseSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				if pipelinePause.pause() {
+					logger.info("pipeline", "paused, send SIGUSR2 to resume", fields{"pid": os.Getpid()})
+				}
+			} else if pipelinePause.resume() {
+				logger.info("pipeline", "resumed", nil)
+			}
+		}
+	}()
+}
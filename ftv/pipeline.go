@@ -0,0 +1,76 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// stageError attributes a pipeline failure to the stage it happened in.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.stage + ": " + e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// pipeline is shared by the goroutines of a single encode or decode run. The
+// first stage to fail cancels ctx for all the others, so that they and the
+// ffmpeg child process shut down instead of blocking on channels nobody
+// reads anymore. Cancelling the parent context has the same effect.
+type pipeline struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func newPipeline(parent context.Context) *pipeline {
+	ctx, cancel := context.WithCancel(parent)
+	return &pipeline{parent: parent, ctx: ctx, cancel: cancel}
+}
+
+// fail records err as the reason the run failed and stops every stage. Errors
+// reported after the run was already stopped are only consequences and are
+// dropped.
+func (p *pipeline) fail(stage string, err error) {
+	p.mu.Lock()
+	if p.err == nil && p.ctx.Err() == nil {
+		p.err = &stageError{stage: stage, err: err}
+	}
+	p.mu.Unlock()
+	p.cancel()
+}
+
+// failed reports whether the run has been stopped.
+func (p *pipeline) failed() bool {
+	return p.ctx.Err() != nil
+}
+
+// send hands frame to the next stage unless the run is stopped first.
+func (p *pipeline) send(ch chan<- frameData, frame frameData) bool {
+	select {
+	case ch <- frame:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// result releases the pipeline and returns why it failed, if it did.
+func (p *pipeline) result() error {
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.parent.Err(); err != nil {
+		return withCause(ErrInterrupted, fmt.Errorf("interrupted: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package ftv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Chroma subsampling stores the color of a 2x2 (4:2:0) or 2x1 (4:2:2) block
+// of pixels once, so the colors of neighbouring dots bleed into each other
+// and a single pixel dot loses its color entirely. Encode therefore asks for
+// full chroma unless a pixel format is configured, and both sides convert
+// with nearest neighbour chroma so that the color sampled in the middle of a
+// dot is the dot's own.
+
+// fullChromaFormats are the pixel formats without subsampling, in order of
+// preference: RGB ones keep the frames exact for lossless codecs.
+var fullChromaFormats = []string{"gbrp", "bgr0", "bgra", "rgb24", "yuv444p", "yuvj444p", "nv24"}
+
+// scalerFlags are passed to ffmpeg's -sws_flags on both sides.
+const scalerFlags = "neighbor+accurate_rnd+full_chroma_int+full_chroma_inp"
+
+// Color conversions are pinned rather than left to the ffmpeg build, whose
+// defaults for the matrix and the range differ and can shift levels enough
+// to flip bits. Encode converts with the BT.709 matrix into the range of the
+// pixel format and tags the video with both, decode converts back with the
+// same matrix and the range of the tag.
+const colorMatrix = "bt709"
+
+// decodeFilter converts decoded frames to rgb24. Untagged videos are taken
+// to be of the limited range, as ffmpeg does for any YUV.
+const decodeFilter = "scale=flags=" + scalerFlags + ":in_color_matrix=" + colorMatrix + ",format=rgb24"
+
+// isRGBFormat reports whether pixelFormat stores RGB rather than YUV.
+func isRGBFormat(pixelFormat string) bool {
+	for _, prefix := range []string{"gbr", "rgb", "bgr", "argb", "abgr", "0rgb", "0bgr"} {
+		if strings.HasPrefix(pixelFormat, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeColor returns the filter converting the RGBA frames for pixelFormat,
+// empty if they need no matrix, and the options tagging the video with its
+// colors. RGB and the yuvj formats use the full range, other YUV formats, the
+// encoder's default among them, the limited one.
+func encodeColor(pixelFormat string) (filter string, tags []string) {
+	if isRGBFormat(pixelFormat) {
+		return "", []string{"-colorspace", "rgb", "-color_primaries", colorMatrix, "-color_trc", colorMatrix, "-color_range", "pc"}
+	}
+	colorRange, tag := "limited", "tv"
+	if strings.HasPrefix(pixelFormat, "yuvj") {
+		colorRange, tag = "full", "pc"
+	}
+	filter = fmt.Sprintf("scale=flags=%s:out_color_matrix=%s:out_range=%s", scalerFlags, colorMatrix, colorRange)
+	return filter, []string{"-colorspace", colorMatrix, "-color_primaries", colorMatrix, "-color_trc", colorMatrix, "-color_range", tag}
+}
+
+var encoderFormats = struct {
+	mu      sync.Mutex
+	formats map[string][]string // By ffmpeg path and codec
+}{formats: map[string][]string{}}
+
+// isSubsampled reports whether pixelFormat stores chroma at a lower
+// resolution than luma.
+func isSubsampled(pixelFormat string) bool {
+	for _, layout := range []string{"420", "422", "411", "410", "nv12", "nv21", "nv16", "yuyv", "uyvy"} {
+		if strings.Contains(pixelFormat, layout) {
+			return true
+		}
+	}
+	return false
+}
+
+// pickPixelFormat returns the pixel format encode asks codec for: the
+// configured one, otherwise the first full chroma format the encoder takes,
+// or empty to leave the choice to ffmpeg when it takes none. Hardware
+// encoders keep the format they were probed with, listing one does not mean
+// the hardware can encode it.
+func pickPixelFormat(ctx context.Context, opts options, codec string) (string, error) {
+	pixelFormat := opts.pixelFormat
+	if pixelFormat == "" && !isHardwareEncoder(codec) {
+		supported, err := encoderPixelFormats(ctx, opts.ffmpegPath, codec)
+		if err != nil {
+			return "", err
+		}
+	pick:
+		for _, preferred := range fullChromaFormats {
+			for _, format := range supported {
+				if format == preferred {
+					pixelFormat = format
+					break pick
+				}
+			}
+		}
+		if pixelFormat == "" {
+			logger.verbose("ffmpeg", "encoder only takes subsampled chroma", fields{"codec": codec, "formats": strings.Join(supported, " ")})
+		}
+	}
+	if isSubsampled(pixelFormat) && opts.dotSize < 2 {
+		return "", fmt.Errorf("dots of a single pixel lose their color in %s, use a larger -dot or a pixel format without chroma subsampling", pixelFormat)
+	}
+	return pixelFormat, nil
+}
+
+// encoderPixelFormats returns the pixel formats `ffmpeg -h encoder=codec`
+// lists, asking once per ffmpeg binary and codec.
+func encoderPixelFormats(ctx context.Context, ffmpegPath, codec string) ([]string, error) {
+	key := ffmpegPath + "\x00" + codec
+	encoderFormats.mu.Lock()
+	defer encoderFormats.mu.Unlock()
+	if formats, ok := encoderFormats.formats[key]; ok {
+		return formats, nil
+	}
+	out, err := ffmpegCommand(ctx, ffmpegPath, "-hide_banner", "-h", "encoder="+codec).Output()
+	if err != nil {
+		return nil, ffmpegError(fmt.Errorf("listing the pixel formats of %s: %w", codec, err))
+	}
+	var formats []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// "    Supported pixel formats: yuv420p yuvj420p yuv444p ..."
+		if _, list, found := strings.Cut(line, "Supported pixel formats:"); found {
+			formats = strings.Fields(list)
+			break
+		}
+	}
+	encoderFormats.formats[key] = formats
+	return formats, nil
+}
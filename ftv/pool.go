@@ -0,0 +1,38 @@
+package ftv
+
+import "sync"
+
+// framePool recycles fixed size frame buffers so the pipeline does not hand
+// the garbage collector a multi-megabyte slice for every frame.
+type framePool struct {
+	pool sync.Pool
+	size int
+}
+
+func newFramePool(size int) *framePool {
+	p := &framePool{size: size}
+	p.pool.New = func() interface{} {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	return p
+}
+
+// get returns a zeroed buffer of the pool's size.
+func (p *framePool) get() []byte {
+	buffer := *p.pool.Get().(*[]byte)
+	for i := range buffer {
+		buffer[i] = 0
+	}
+	return buffer
+}
+
+// put hands a buffer obtained from get back to the pool. The caller must not
+// touch it afterwards.
+func (p *framePool) put(buffer []byte) {
+	if cap(buffer) != p.size {
+		return
+	}
+	buffer = buffer[:p.size]
+	p.pool.Put(&buffer)
+}
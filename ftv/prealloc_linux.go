@@ -0,0 +1,24 @@
+package ftv
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize allocates without changing the size of the file, which
+// stays sparse as far as decode can tell
+const fallocKeepSize = 0x01
+
+// preallocate allocates the blocks of the first size bytes of file. File
+// systems without fallocate are left to allocate as decode writes.
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	switch err {
+	case nil, syscall.EOPNOTSUPP, syscall.ENOSYS:
+		return nil
+	case syscall.ENOSPC:
+		return fmt.Errorf("not enough disk space for the %d bytes the video holds", size)
+	}
+	return fmt.Errorf("preallocating %d bytes: %w", size, err)
+}
@@ -0,0 +1,146 @@
+package ftv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// presets are named groups of settings, keyed like the config file. They
+// are applied on top of the config file and the environment, but flags given
+// on the command line still win.
+var presets = map[string]map[string]string{
+	// Survives YouTube's re-encode of a 1080p60 upload: large dots so the
+	// detail is not smoothed away, a keyframe every half second as YouTube
+	// recommends and 4:2:0 chroma since that is what it serves anyway. The
+	// dots and ECC are those of the youtube channel, so the two agree.
+	"youtube": {
+		"bitrate":      "50M",
+		"dot_size":     "12",
+		"dot_bits":     "3",
+		"ecc":          "32",
+		"interleave":   "4",
+		"gop":          "30",
+		"pixel_format": "yuv420p",
+	},
+	// The same for vertical 1080x1920 uploads to YouTube Shorts, Instagram
+	// Reels or TikTok
+	"shorts": {
+		"size":         "1080x1920",
+		"bitrate":      "50M",
+		"dot_size":     "12",
+		"dot_bits":     "3",
+		"ecc":          "32",
+		"interleave":   "4",
+		"gop":          "30",
+		"pixel_format": "yuv420p",
+	},
+}
+
+// channels describe how much abuse the video will take on its way to the
+// decoder, and pick the modulation surviving it. They are applied like
+// presets, after them.
+var channels = map[string]map[string]string{
+	// The file is kept as encoded: a byte per channel of every pixel, with a
+	// little ECC against a damaged copy
+	"lossless": {
+		"codec":      "ffv1",
+		"dot_size":   "1",
+		"dot_bits":   "24",
+		"ecc":        "8",
+		"repeat":     "1",
+		"interleave": "1",
+	},
+	// Lossy, but at a bitrate that keeps small dots intact
+	"highbitrate": {
+		"bitrate":    "100M",
+		"dot_size":   "4",
+		"dot_bits":   "3",
+		"ecc":        "16",
+		"repeat":     "1",
+		"interleave": "1",
+	},
+	// Re-encoded by the platform, which smears fine detail and damages
+	// frames around scene cuts
+	"youtube": {
+		"dot_size":   "12",
+		"dot_bits":   "3",
+		"ecc":        "32",
+		"repeat":     "1",
+		"interleave": "4",
+	},
+	// Filmed off a screen: blur, noise and dropped frames
+	"camera": {
+		"dot_size":   "24",
+		"dot_bits":   "3",
+		"ecc":        "64",
+		"repeat":     "3",
+		"interleave": "8",
+	},
+}
+
+// presetFlags maps config keys to the flag setting them where the names
+// differ.
+var presetFlags = map[string]string{
+	"dot_size":       "dot",
+	"dot_bits":       "dot-bits",
+	"threads":        "t",
+	"reorder_window": "window",
+	"queue_depth":    "queue-depth",
+	"max_throughput": "max-throughput",
+	"frame_strip":    "frame-strip",
+}
+
+func presetNames() string { return settingNames(presets) }
+
+func channelNames() string { return settingNames(channels) }
+
+func settingNames(table map[string]map[string]string) string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset applies the preset called name to opts, skipping the settings
+// whose flag is in explicit.
+func applyPreset(opts *options, name string, explicit map[string]bool) error {
+	settings, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q (available: %s)", name, presetNames())
+	}
+	if err := applySettings(opts, settings, explicit); err != nil {
+		return fmt.Errorf("preset %s: %w", name, err)
+	}
+	return nil
+}
+
+// applyChannel applies the channel called name like applyPreset.
+func applyChannel(opts *options, name string, explicit map[string]bool) error {
+	settings, ok := channels[name]
+	if !ok {
+		return fmt.Errorf("unknown channel %q (available: %s)", name, channelNames())
+	}
+	if err := applySettings(opts, settings, explicit); err != nil {
+		return fmt.Errorf("channel %s: %w", name, err)
+	}
+	return nil
+}
+
+func applySettings(opts *options, settings map[string]string, explicit map[string]bool) error {
+	for key, value := range settings {
+		flagName := key
+		if mapped, ok := presetFlags[key]; ok {
+			flagName = mapped
+		}
+		if explicit[flagName] {
+			continue
+		}
+		if err := opts.set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package ftv
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func childProcAttr() *syscall.SysProcAttr { return nil }
+
+// lowerPriority makes cmd run under nice(1), so that it is niced before it
+// starts any thread. Without nice on the PATH cmd is left as it is.
+func lowerPriority(cmd *exec.Cmd) {
+	nice, err := exec.LookPath("nice")
+	if err != nil || cmd.Err != nil {
+		logger.verbose("ffmpeg", "cannot lower the priority, nice not found", nil)
+		return
+	}
+	cmd.Args = append([]string{nice, "-n", "10", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = nice
+}
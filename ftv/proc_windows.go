@@ -0,0 +1,24 @@
+package ftv
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// childProcAttr starts children in a process group of their own, Windows
+// delivers Ctrl+C to every process of the console otherwise and ffmpeg would
+// quit before the pipeline can stop it.
+func childProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// belowNormalPriorityClass is missing from package syscall
+const belowNormalPriorityClass = 0x00004000
+
+// lowerPriority starts cmd in the below normal priority class.
+func lowerPriority(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+}
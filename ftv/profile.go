@@ -0,0 +1,154 @@
+package ftv
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Profiles -profile can write, a comma-separated list of them. The CPU and
+// memory profiles are for go tool pprof, the trace for go tool trace.
+const (
+	profileCPU   = "cpu"
+	profileMem   = "mem"
+	profileTrace = "trace"
+)
+
+// profileFiles names the file every profile is written to, in the current
+// directory.
+var profileFiles = map[string]string{
+	profileCPU:   "filetovideo.cpu.pprof",
+	profileMem:   "filetovideo.mem.pprof",
+	profileTrace: "filetovideo.trace",
+}
+
+// parseProfiles returns the profiles of the value of -profile.
+func parseProfiles(value string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		if _, ok := profileFiles[kind]; !ok {
+			return nil, fmt.Errorf("unknown profile %q (expected %s, %s or %s)", kind, profileCPU, profileMem, profileTrace)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// startProfiles starts the CPU profile and the trace among kinds and returns
+// the function that stops them and writes the memory profile, which is taken
+// at the end of the run.
+func startProfiles(kinds []string) (stop func(), err error) {
+	var stops []func()
+	stop = func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+	for _, kind := range kinds {
+		path := profileFiles[kind]
+		if kind == profileMem {
+			stops = append(stops, func() {
+				file, err := os.Create(path)
+				if err != nil {
+					logger.error("profile", err)
+					return
+				}
+				defer file.Close()
+				runtime.GC() // Up to date statistics of what is still in use
+				if err := pprof.WriteHeapProfile(file); err != nil {
+					logger.error("profile", err)
+					return
+				}
+				logger.info("profile", "profile written", fields{"profile": profileMem, "path": path})
+			})
+			continue
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if kind == profileCPU {
+			err = pprof.StartCPUProfile(file)
+		} else {
+			err = trace.Start(file)
+		}
+		if err != nil {
+			file.Close()
+			stop()
+			return nil, err
+		}
+		kind := kind
+		stops = append(stops, func() {
+			if kind == profileCPU {
+				pprof.StopCPUProfile()
+			} else {
+				trace.Stop()
+			}
+			if err := file.Close(); err != nil {
+				logger.error("profile", err)
+				return
+			}
+			logger.info("profile", "profile written", fields{"profile": kind, "path": path})
+		})
+	}
+	return stop, nil
+}
+
+// timingPhase is what a stage of the pipeline spends its time on.
+type timingPhase int
+
+const (
+	phaseRead      timingPhase = iota // Of the payload, encode
+	phaseSerialize                    // Painting the frames, encode
+	phaseReorder                      // Readers held back until ffmpeg catches up, encode
+	phaseFFmpeg                       // Handing frames to ffmpeg or taking them from it
+	phaseDigest                       // Reading the frames back into bytes, decode
+	phaseWrite                        // Of the payload, decode
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{"read", "serialize", "reorder wait", "ffmpeg wait", "digest", "write"}
+
+// stageTimings adds up the time the workers of every stage spend on each
+// phase, for the breakdown -profile logs. A nil *stageTimings takes nothing,
+// so the stages need not check whether -profile was given.
+type stageTimings struct {
+	spent [phaseCount]atomic.Int64 // Nanoseconds
+}
+
+// since counts the time since start as spent on phase.
+func (t *stageTimings) since(phase timingPhase, start time.Time) {
+	if t != nil {
+		t.spent[phase].Add(int64(time.Since(start)))
+	}
+}
+
+// log reports the time spent on every phase that took any, summed over the
+// workers, so a phase can take longer than the run.
+func (t *stageTimings) log() {
+	var total time.Duration
+	for phase := range t.spent {
+		total += time.Duration(t.spent[phase].Load())
+	}
+	if total == 0 {
+		return
+	}
+	for phase := range t.spent {
+		spent := time.Duration(t.spent[phase].Load()).Round(time.Millisecond)
+		if spent == 0 {
+			continue
+		}
+		logger.info("profile", phaseNames[phase]+" time", fields{
+			"spent":   spent,
+			"percent": round2(100 * float64(spent) / float64(total)),
+		})
+	}
+}
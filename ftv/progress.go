@@ -0,0 +1,147 @@
+package ftv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressFunc is told how many frames a stage of the pipeline has handled
+// so far: reader, serializer and ffmpeg when encoding, ffmpeg, digester and
+// writer when decoding. total is -1 while it is not known yet, decode only
+// learns it once the first frame has been read. Calls are serialized and
+// hold up the pipeline, so they should return quickly.
+type ProgressFunc func(stage string, done, total int64)
+
+// queueGauge tells how many frames wait in the queue in front of a stage.
+type queueGauge struct {
+	stage    string
+	capacity int
+	length   func() int
+}
+
+// queuesFunc is given the queues of a run once its pipeline is set up. The
+// gauges may be read from any goroutine until the run returns.
+type queuesFunc func(queues []queueGauge)
+
+// progress keeps the per-stage counters of a run and forwards every update
+// to the embedding application's ProgressFunc. When every stage handled its
+// first and last frame is kept for the summary of the run.
+type progress struct {
+	mu          sync.Mutex
+	fn          ProgressFunc
+	stages      []string
+	done        map[string]int64
+	total       map[string]int64
+	first, last map[string]time.Time
+}
+
+func newProgress(fn ProgressFunc, stages ...string) *progress {
+	p := &progress{
+		fn:     fn,
+		stages: stages,
+		done:   map[string]int64{},
+		total:  map[string]int64{},
+		first:  map[string]time.Time{},
+		last:   map[string]time.Time{},
+	}
+	for _, stage := range stages {
+		p.total[stage] = -1
+	}
+	return p
+}
+
+// setTotal sets the expected frame count of every stage.
+func (p *progress) setTotal(total int64) {
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for stage := range p.total {
+		p.total[stage] = total
+		p.fn(stage, p.done[stage], total)
+	}
+}
+
+// add records that stage finished another frame.
+func (p *progress) add(stage string) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done[stage] == 0 {
+		p.first[stage] = now
+	}
+	p.last[stage] = now
+	p.done[stage]++
+	if p.fn != nil {
+		p.fn(stage, p.done[stage], p.total[stage])
+	}
+}
+
+// frames returns how many frames stage handled.
+func (p *progress) frames(stage string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done[stage]
+}
+
+// stageThroughput is how fast a stage went over the time it had frames to
+// handle, from its first frame to its last.
+type stageThroughput struct {
+	stage  string
+	frames int64
+	active time.Duration
+}
+
+func (t stageThroughput) perSecond() float64 {
+	if t.active <= 0 {
+		return 0
+	}
+	return float64(t.frames) / t.active.Seconds()
+}
+
+// throughput returns the throughput of every stage in pipeline order.
+func (p *progress) throughput() []stageThroughput {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stages := make([]stageThroughput, len(p.stages))
+	for i, stage := range p.stages {
+		stages[i] = stageThroughput{stage: stage, frames: p.done[stage], active: p.last[stage].Sub(p.first[stage])}
+	}
+	return stages
+}
+
+// terminalProgress renders the progress of all stages on a single,
+// continuously rewritten line of out.
+func terminalProgress(out io.Writer, stages ...string) ProgressFunc {
+	done := map[string]int64{}
+	total := map[string]int64{}
+	for _, stage := range stages {
+		total[stage] = -1
+	}
+	var last time.Time
+	return func(stage string, d, t int64) {
+		done[stage], total[stage] = d, t
+		finished := t >= 0 && d == t && stage == stages[len(stages)-1]
+		if time.Since(last) < 100*time.Millisecond && !finished {
+			return
+		}
+		last = time.Now()
+
+		parts := make([]string, len(stages))
+		for i, s := range stages {
+			if total[s] < 0 {
+				parts[i] = fmt.Sprintf("%s %d", s, done[s])
+			} else {
+				parts[i] = fmt.Sprintf("%s %d/%d", s, done[s], total[s])
+			}
+		}
+		fmt.Fprintf(out, "\r%s", strings.Join(parts, "  "))
+		if finished {
+			fmt.Fprintln(out)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package ftv
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// stdoutOutput is the output name that decodes to standard output.
+const stdoutOutput = "-"
+
+// NewReader decodes the video at source, a path or anything else decode
+// takes, with opts and returns its payload as a stream rather than writing
+// a file. The payload is checked against the hash in the video at its end:
+// Read returns the error of a damaged or incomplete video after the data
+// before it, matching the Err causes with errors.Is. Closing the reader
+// early stops decoding.
+func NewReader(source string, opts Options) (io.ReadCloser, error) {
+	if err := opts.opts.validate(); err != nil {
+		return nil, err
+	}
+	return newReader(context.Background(), source, opts.opts)
+}
+
+// newReader is NewReader with explicit options, decoding until ctx is done.
+func newReader(ctx context.Context, source string, opts options) (io.ReadCloser, error) {
+	if opts.partial || opts.reportPath != "" || opts.deltaBase != "" {
+		return nil, errors.New("partial recovery, reports and deltas need a file to decode to")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	reader := &payloadReader{PipeReader: r, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(reader.done)
+		w.CloseWithError(decodePayload(ctx, []string{source}, "", newPayloadPipe(w), opts))
+	}()
+	return reader, nil
+}
+
+type payloadReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops decoding and waits for ffmpeg to exit.
+func (r *payloadReader) Close() error {
+	r.PipeReader.Close()
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// payloadPipe is the output of a decode streaming the payload. Frames are
+// digested in parallel, so chunks arrive out of order and are held back
+// until everything before them has been written. The hash the trailer
+// carries is computed as the payload goes through.
+type payloadPipe struct {
+	mu     sync.Mutex
+	w      io.Writer
+	next   int64 // Payload offset written up to
+	held   map[int64][]byte
+	starts map[int64]bool // Payload offsets of appended parts
+	hash   hash.Hash
+}
+
+func newPayloadPipe(w io.Writer) *payloadPipe {
+	return &payloadPipe{w: w, held: map[int64][]byte{}, starts: map[int64]bool{}, hash: sha256.New()}
+}
+
+// startPart marks where a part appended to the video starts, its hash
+// chains the hash of what precedes it. Parts are found before their data is
+// written.
+func (p *payloadPipe) startPart(offset int64) {
+	p.mu.Lock()
+	p.starts[offset] = true
+	p.mu.Unlock()
+}
+
+func (p *payloadPipe) WriteAt(b []byte, off int64) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(b)
+	if off != p.next {
+		if off > p.next {
+			// The caller reuses the buffer once the write returns
+			p.held[off] = append([]byte(nil), b...)
+		}
+		return n, nil
+	}
+	for {
+		if p.starts[p.next] && p.next > 0 {
+			sum := p.hash.Sum(nil)
+			p.hash = sha256.New()
+			p.hash.Write(sum)
+		}
+		// Writing blocks until the reader takes the data, which holds back
+		// the pipeline as well
+		if _, err := p.w.Write(b); err != nil {
+			return 0, err
+		}
+		p.hash.Write(b)
+		p.next += int64(len(b))
+		var ok bool
+		if b, ok = p.held[p.next]; !ok {
+			return n, nil
+		}
+		delete(p.held, p.next)
+	}
+}
+
+// sum returns the hash of the payload written, which must be all of parts.
+func (p *payloadPipe) sum(parts []streamPart) ([sha256.Size]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sum [sha256.Size]byte
+	last := parts[len(parts)-1]
+	if end := last.offset + last.length; p.next != end {
+		return sum, withCause(ErrIncomplete, fmt.Errorf("video is incomplete, the payload stops at byte %d of %d", p.next, end))
+	}
+	copy(sum[:], p.hash.Sum(nil))
+	return sum, nil
+}
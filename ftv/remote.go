@@ -0,0 +1,501 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3PartSize is the size of the parts of a multipart upload. S3 allows at
+// most 10000 parts, so objects up to ~640 GB can be uploaded.
+const s3PartSize = 64 << 20
+
+// remote is an object in S3 (s3://bucket/key), Google Cloud Storage
+// (gs://bucket/key) or behind a plain HTTP(S) URL. S3 uses the AWS_*
+// environment variables for credentials, region and endpoint, GCS uses HMAC
+// keys from GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY. Without credentials
+// requests are sent unsigned, which works for public objects.
+type remote struct {
+	url    *url.URL
+	signer *s3Signer // nil for plain HTTP
+}
+
+// isRemote reports whether path names a remote object rather than a file.
+func isRemote(path string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "http://", "https://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isURL reports whether path looks like a URL ffmpeg may be able to open
+// (http, hls, rtmp, srt, ...) rather than a local path.
+func isURL(path string) bool {
+	scheme, _, found := strings.Cut(path, "://")
+	if !found || len(scheme) < 2 { // Not a Windows drive letter
+		return false
+	}
+	for _, c := range scheme {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRemote(location string) (*remote, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &remote{url: u}, nil
+	case "s3", "gs":
+	default:
+		return nil, fmt.Errorf("unsupported remote %q", location)
+	}
+
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%s: expected %s://bucket/key", location, u.Scheme)
+	}
+	r := &remote{}
+	if u.Scheme == "gs" {
+		r.url = &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + bucket + "/" + key}
+		r.signer = &s3Signer{
+			accessKey: os.Getenv("GCS_ACCESS_KEY_ID"),
+			secretKey: os.Getenv("GCS_SECRET_ACCESS_KEY"),
+			region:    "auto",
+		}
+	} else {
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			// S3 compatible stores usually only support path style addressing
+			base, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("AWS_ENDPOINT_URL: %w", err)
+			}
+			r.url = base.JoinPath(bucket, key)
+		} else {
+			r.url = &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com", Path: "/" + key}
+		}
+		r.signer = &s3Signer{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			region:       region,
+		}
+	}
+	if r.signer.accessKey == "" || r.signer.secretKey == "" {
+		r.signer = nil
+	}
+	return r, nil
+}
+
+func (r *remote) String() string { return r.url.Redacted() }
+
+// name returns the last element of the object key.
+func (r *remote) name() string { return path.Base(r.url.Path) }
+
+// ext returns the extension of the object name, which decides the
+// container ffmpeg writes.
+func (r *remote) ext() string { return path.Ext(r.url.Path) }
+
+// do sends a signed request and fails on anything but a 2xx response.
+func (r *remote) do(ctx context.Context, method string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := *r.url
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if r.signer != nil {
+		r.signer.sign(req, unsignedPayload, time.Now())
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w", method, r, err)
+	}
+	return resp, nil
+}
+
+func (r *remote) size(ctx context.Context) (int64, error) {
+	resp, err := r.do(ctx, http.MethodHead, nil, nil, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("%s: server did not report the size", r)
+	}
+	return resp.ContentLength, nil
+}
+
+// readRange fetches len(p) bytes at off with a range request, sending it
+// again up to remoteRetries times when it fails in a way another attempt may
+// get past: the connection broke, or the server answered 429 or 5xx.
+func (r *remote) readRange(ctx context.Context, p []byte, off int64) error {
+	delay := remoteRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := r.readRangeOnce(ctx, p, off)
+		if err == nil || attempt == remoteRetries || ctx.Err() != nil || !transientHTTP(err) {
+			return err
+		}
+		logger.verbose("reader", "range request failed, retrying", fields{"remote": r.String(), "offset": off, "attempt": attempt + 1, "error": err.Error()})
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (r *remote) readRangeOnce(ctx context.Context, p []byte, off int64) error {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)}}
+	resp, err := r.do(ctx, http.MethodGet, nil, nil, 0, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%s: %w", r, errNoRanges)
+	}
+	if _, err := io.ReadFull(resp.Body, p); err != nil {
+		return fmt.Errorf("%s: reading bytes %d to %d: %w", r, off, off+int64(len(p)), err)
+	}
+	return nil
+}
+
+var errNoRanges = errors.New("server does not support range requests")
+
+// transientHTTP reports whether a request that failed with err may succeed
+// when sent again.
+func transientHTTP(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	return !errors.Is(err, errNoRanges)
+}
+
+// sourceURL returns a URL ffmpeg can read the object from directly.
+func (r *remote) sourceURL() string {
+	if r.signer == nil {
+		return r.url.String()
+	}
+	return r.signer.presign(http.MethodGet, r.url, 12*time.Hour, time.Now()).String()
+}
+
+// upload stores the file at localPath as the object.
+func (r *remote) upload(ctx context.Context, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if r.signer == nil || info.Size() <= s3PartSize {
+		resp, err := r.do(ctx, http.MethodPut, nil, file, info.Size(), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return r.multipartUpload(ctx, file, info.Size())
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// multipartUpload sends large files in s3PartSize parts, a single PUT is
+// limited to 5 GB.
+func (r *remote) multipartUpload(ctx context.Context, file *os.File, size int64) error {
+	resp, err := r.do(ctx, http.MethodPost, url.Values{"uploads": {""}}, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("starting multipart upload: %w", err)
+	}
+
+	err = func() error {
+		var complete completeMultipartUpload
+		for offset, number := int64(0), 1; offset < size; offset, number = offset+s3PartSize, number+1 {
+			length := size - offset
+			if length > s3PartSize {
+				length = s3PartSize
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}
+			resp, err := r.do(ctx, http.MethodPut, query, io.NewSectionReader(file, offset, length), length, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+			logger.verbose("upload", "part uploaded", fields{"part": number, "bytes": length})
+		}
+
+		body, err := xml.Marshal(complete)
+		if err != nil {
+			return err
+		}
+		resp, err := r.do(ctx, http.MethodPost, url.Values{"uploadId": {initiated.UploadID}}, bytes.NewReader(body), int64(len(body)), nil)
+		if err != nil {
+			return err
+		}
+		// S3 reports some failures with a 200 and an error document
+		defer resp.Body.Close()
+		var result struct {
+			XMLName xml.Name
+			Message string
+		}
+		if xml.NewDecoder(resp.Body).Decode(&result) == nil && result.XMLName.Local == "Error" {
+			return fmt.Errorf("completing multipart upload: %s", result.Message)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Don't leave the parts behind, S3 bills for them
+		if resp, abortErr := r.do(context.Background(), http.MethodDelete, url.Values{"uploadId": {initiated.UploadID}}, nil, 0, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+// Remote inputs are read remoteChunkSize bytes at a time, the frames being
+// cut from the chunks: a range request per frame would make a 1 GB object
+// ~90k sequential round trips, and as many billed requests. remoteRetries
+// failed requests of a chunk are sent again, remoteRetryDelay after the
+// first, the delay doubling every time.
+const (
+	remoteChunkSize  = 8 << 20
+	remoteRetries    = 3
+	remoteRetryDelay = time.Second
+)
+
+// remoteReader adapts a remote to the io.ReaderAt payloadSource reads from.
+// While the frames of a chunk are read, the next chunk is fetched, so the
+// readers seldom wait on the network. Frames are requested about in order,
+// chunks before the one before the last requested are dropped.
+type remoteReader struct {
+	ctx    context.Context
+	remote *remote
+	size   int64
+
+	mu     sync.Mutex
+	chunks map[int64]*remoteChunk
+}
+
+// remoteChunk is a chunk of a remote, data and err set once done is closed.
+type remoteChunk struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newRemoteReader(ctx context.Context, r *remote, size int64) *remoteReader {
+	return &remoteReader{ctx: ctx, remote: r, size: size, chunks: map[int64]*remoteChunk{}}
+}
+
+func (r *remoteReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		index := off / remoteChunkSize
+		chunk := r.chunk(index)
+		<-chunk.done
+		if chunk.err != nil {
+			return n, chunk.err
+		}
+		copied := copy(p[n:], chunk.data[off-index*remoteChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// chunk returns the chunk at index and starts fetching the one after it.
+func (r *remoteReader) chunk(index int64) *remoteChunk {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.chunks {
+		if i < index-1 {
+			delete(r.chunks, i)
+		}
+	}
+	chunk := r.fetch(index)
+	if (index+1)*remoteChunkSize < r.size {
+		r.fetch(index + 1)
+	}
+	return chunk
+}
+
+// fetch returns the chunk at index, starting its range request if it is not
+// there yet. r.mu is held.
+func (r *remoteReader) fetch(index int64) *remoteChunk {
+	if chunk, ok := r.chunks[index]; ok {
+		return chunk
+	}
+	chunk := &remoteChunk{done: make(chan struct{})}
+	r.chunks[index] = chunk
+	go func() {
+		defer close(chunk.done)
+		start := index * remoteChunkSize
+		length := r.size - start
+		if length > remoteChunkSize {
+			length = remoteChunkSize
+		}
+		chunk.data = make([]byte, length)
+		chunk.err = r.remote.readRange(r.ctx, chunk.data, start)
+	}()
+	return chunk
+}
+
+func (r *remoteReader) Close() error { return nil }
+
+// outputFile is where encode and decode write their result. It is written
+// to a temporary file first: next to a local destination and renamed over
+// it by commit once everything checked out, so that a run that fails or is
+// interrupted never leaves a plausible but broken file behind, or anywhere
+// for a remote destination and uploaded by commit, since neither ffmpeg's
+// mp4 muxer nor the decode writers write sequentially.
+type outputFile struct {
+	path   string
+	dest   string // Local destination, empty if path is written in place
+	remote *remote
+}
+
+func prepareOutput(dest string) (*outputFile, error) {
+	if !isRemote(dest) {
+		// The extension is kept, ffmpeg picks the container from it
+		ext := filepath.Ext(dest)
+		pattern := "." + strings.TrimSuffix(filepath.Base(dest), ext) + ".filetovideo-*" + ext
+		file, err := os.CreateTemp(filepath.Dir(dest), pattern)
+		if err != nil {
+			return nil, err
+		}
+		err = file.Chmod(0o644)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return nil, err
+		}
+		return &outputFile{path: file.Name(), dest: dest}, nil
+	}
+	r, err := parseRemote(dest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp("", "filetovideo-*"+r.ext())
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &outputFile{path: file.Name(), remote: r}, nil
+}
+
+// prepareVideoOutput is prepareOutput for the video of encode, which is
+// written in place when it is a live stream, a directory of frames, a video
+// in memory or standard output.
+func prepareVideoOutput(dest string, opts options) (*outputFile, error) {
+	if isLive(dest) || opts.transport == transportImages || opts.transport == transportMemory || dest == stdoutOutput {
+		return &outputFile{path: dest}, nil
+	}
+	return prepareOutput(dest)
+}
+
+// commit moves a local output into place or uploads a remote one.
+func (o *outputFile) commit(ctx context.Context) error {
+	if o.dest != "" {
+		return os.Rename(o.path, o.dest)
+	}
+	if o.remote == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := o.remote.upload(ctx, o.path); err != nil {
+		return err
+	}
+	logger.verbose("upload", "output uploaded", fields{"remote": o.remote.String(), "elapsed": time.Since(start)})
+	return nil
+}
+
+// cleanup removes the temporary file, which is gone already once a local
+// output was committed.
+func (o *outputFile) cleanup() {
+	if o.dest != "" || o.remote != nil {
+		os.Remove(o.path)
+	}
+}
+
+// ffmpegSource returns what to pass to ffmpeg's -i for src. Buckets are
+// turned into presigned URLs, other URLs and local paths are passed as-is.
+func ffmpegSource(src string) (string, error) {
+	if !isRemote(src) {
+		return src, nil
+	}
+	r, err := parseRemote(src)
+	if err != nil {
+		return "", err
+	}
+	return r.sourceURL(), nil
+}
+
+var errRemoteMmap = errors.New("remote inputs cannot be memory-mapped")
@@ -0,0 +1,92 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRemoteReader reads an object frame by frame, which must take a range
+// request per chunk rather than per frame, and get past a failed request.
+func TestRemoteReader(t *testing.T) {
+	object := testPayload(2*remoteChunkSize + 12345)
+	var gets, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet && atomic.AddInt32(&gets, 1) == 2 {
+			atomic.AddInt32(&failures, 1)
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, req, "object", time.Time{}, bytes.NewReader(object))
+	}))
+	defer server.Close()
+
+	r, err := parseRemote(server.URL + "/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := r.size(context.Background())
+	if err != nil || size != int64(len(object)) {
+		t.Fatalf("size %d (%v), expected %d", size, err, len(object))
+	}
+	reader := newRemoteReader(context.Background(), r, size)
+	const frame = 12142
+	read := make([]byte, 0, len(object))
+	p := make([]byte, frame)
+	for off := int64(0); ; off += frame {
+		n, err := reader.ReadAt(p, off)
+		read = append(read, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading at %d: %v", off, err)
+		}
+	}
+	if !bytes.Equal(read, object) {
+		t.Fatal("object read differs")
+	}
+	if atomic.LoadInt32(&gets) != 4 || atomic.LoadInt32(&failures) != 1 {
+		t.Fatalf("%d range requests, %d failed, expected 3 chunks and a retry", gets, failures)
+	}
+}
+
+func TestRemoteReaderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		requests int32
+	}{
+		{"not found", func(w http.ResponseWriter, req *http.Request) {
+			http.NotFound(w, req)
+		}, 1},
+		{"no ranges", func(w http.ResponseWriter, req *http.Request) {
+			w.Write(make([]byte, 100))
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				tt.handler(w, req)
+			}))
+			defer server.Close()
+			r, err := parseRemote(server.URL + "/object")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newRemoteReader(context.Background(), r, 100).ReadAt(make([]byte, 10), 0); err == nil {
+				t.Fatal("read")
+			}
+			if atomic.LoadInt32(&requests) != tt.requests {
+				t.Fatalf("%d requests, expected %d", requests, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package ftv
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// frameHeap is a min-heap of frames ordered by frameID.
+type frameHeap []frameData
+
+func (h frameHeap) Len() int            { return len(h) }
+func (h frameHeap) Less(i, j int) bool  { return h[i].frameID < h[j].frameID }
+func (h frameHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *frameHeap) Push(x interface{}) { *h = append(*h, x.(frameData)) }
+func (h *frameHeap) Pop() interface{} {
+	old := *h
+	frame := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return frame
+}
+
+// reorderBuffer puts frames finished by parallel workers back in frameID
+// order. Workers call wait before handing over a frame, which blocks them
+// while their frame is more than window frames ahead of the next one to be
+// released, so the buffer never holds more than window frames.
+type reorderBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	frames  frameHeap
+	next    int
+	window  int
+	aborted bool
+}
+
+func newReorderBuffer(window int) *reorderBuffer {
+	r := &reorderBuffer{window: window}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// wait blocks until frameID is within the window. It returns false if the
+// buffer was aborted in the meantime.
+func (r *reorderBuffer) wait(frameID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for frameID-r.next >= r.window && !r.aborted {
+		r.cond.Wait()
+	}
+	return !r.aborted
+}
+
+// abort releases every goroutine blocked in wait.
+func (r *reorderBuffer) abort() {
+	r.mu.Lock()
+	r.aborted = true
+	r.mu.Unlock()
+	r.cond.Broadcast()
+}
+
+func (r *reorderBuffer) push(frame frameData) {
+	r.mu.Lock()
+	heap.Push(&r.frames, frame)
+	r.mu.Unlock()
+}
+
+// pop returns the next frame in order if it has already arrived.
+func (r *reorderBuffer) pop() (frameData, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.frames) == 0 || r.frames[0].frameID != r.next {
+		return frameData{}, false
+	}
+	frame := heap.Pop(&r.frames).(frameData)
+	r.next++
+	r.cond.Broadcast()
+	return frame, true
+}
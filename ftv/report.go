@@ -0,0 +1,271 @@
+package ftv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// integrityReport collects what decode found out about the state of a video,
+// to be written as JSON with -report.
+type integrityReport struct {
+	mu        sync.Mutex
+	frames    int
+	corrected int
+	codewords int
+	errors    []frameErrors
+}
+
+type frameErrors struct {
+	Frame              int   `json:"frame"`
+	CorrectedBytes     int   `json:"corrected_bytes"`
+	CorrectedCodewords int   `json:"corrected_codewords"`
+	FailedCodewords    []int `json:"failed_codewords,omitempty"`
+	CRCMismatch        bool  `json:"crc_mismatch,omitempty"`
+}
+
+type byteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func newIntegrityReport() *integrityReport { return &integrityReport{} }
+
+// add records the repair of frame id, frames without errors are only
+// counted.
+func (r *integrityReport) add(id int, repair frameRepair) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames++
+	r.corrected += repair.corrected
+	r.codewords += repair.codewords
+	if repair.corrected > 0 || len(repair.failed) > 0 || repair.mismatch {
+		r.errors = append(r.errors, frameErrors{
+			Frame:              id,
+			CorrectedBytes:     repair.corrected,
+			CorrectedCodewords: repair.codewords,
+			FailedCodewords:    repair.failed,
+			CRCMismatch:        repair.mismatch,
+		})
+	}
+}
+
+// failedFrames returns the frames with codewords beyond repair or data not
+// matching their strip.
+func (r *integrityReport) failedFrames() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var failed []int
+	for _, e := range r.errors {
+		if len(e.FailedCodewords) > 0 || e.CRCMismatch {
+			failed = append(failed, e.Frame)
+		}
+	}
+	sort.Ints(failed)
+	return failed
+}
+
+// suspectRanges maps the codewords beyond repair, and the whole of frames
+// not matching their strip, to the bytes of the output they carry. An
+// interleaved frame carries bytes scattered over its whole block, so all of
+// the block is suspect.
+func (r *integrityReport) suspectRanges(header *streamHeader, opts options) []byteRange {
+	ecc := opts.frameECC()
+	capacity := int64(frameCapacity(opts))
+	depth := int64(opts.interleave)
+
+	r.mu.Lock()
+	var ranges []byteRange
+	for _, e := range r.errors {
+		if e.CRCMismatch {
+			start := int64(e.Frame) / depth * depth * capacity
+			if rg := clipToPayload(byteRange{Offset: start, Length: depth * capacity}, header); rg.Length > 0 {
+				ranges = append(ranges, rg)
+			}
+			continue
+		}
+		for _, b := range e.FailedCodewords {
+			var start, end int64
+			if depth > 1 {
+				start = int64(e.Frame) / depth * depth * capacity
+				end = start + depth*capacity
+			} else {
+				s, t := ecc.codewordData(b)
+				start, end = int64(e.Frame)*capacity+int64(s), int64(e.Frame)*capacity+int64(t)
+			}
+			if rg := clipToPayload(byteRange{Offset: start, Length: end - start}, header); rg.Length > 0 {
+				ranges = append(ranges, rg)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	return mergeRanges(ranges)
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap or touch.
+func mergeRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Offset < ranges[j].Offset })
+	var merged []byteRange
+	for _, rg := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].Offset+merged[n-1].Length >= rg.Offset {
+			if end := rg.Offset + rg.Length; end > merged[n-1].Offset+merged[n-1].Length {
+				merged[n-1].Length = end - merged[n-1].Offset
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	return merged
+}
+
+// write stores the report at path. header is nil if the stream header could
+// not be read, verified nil if the video carries no hash.
+func (r *integrityReport) write(path, source string, header *streamHeader, verified *bool, opts options) error {
+	report := struct {
+		Source             string        `json:"source"`
+		Frames             int           `json:"frames"`
+		ECCParity          int           `json:"ecc_parity"`
+		ECCCode            string        `json:"ecc_code"`
+		CorrectedBytes     int           `json:"corrected_bytes"`
+		CorrectedCodewords int           `json:"corrected_codewords"`
+		FailedFrames       []int         `json:"failed_frames"`
+		HashVerified       *bool         `json:"hash_verified"`
+		FrameErrors        []frameErrors `json:"frame_errors"`
+		SuspectRanges      []byteRange   `json:"suspect_ranges"`
+	}{
+		Source:        source,
+		ECCParity:     opts.eccParity(),
+		FailedFrames:  r.failedFrames(),
+		HashVerified:  verified,
+		FrameErrors:   []frameErrors{},
+		SuspectRanges: []byteRange{},
+	}
+	switch {
+	case opts.eccHamming:
+		report.ECCCode = eccHamming
+		report.ECCParity = opts.frameECC().parity
+	case report.ECCParity > 0:
+		report.ECCCode = "reed-solomon"
+	default:
+		report.ECCCode = "none"
+	}
+	if header != nil {
+		if ranges := r.suspectRanges(header, opts); ranges != nil {
+			report.SuspectRanges = ranges
+		}
+	}
+
+	r.mu.Lock()
+	report.Frames = r.frames
+	report.CorrectedBytes = r.corrected
+	report.CorrectedCodewords = r.codewords
+	report.FrameErrors = append(report.FrameErrors, r.errors...)
+	r.mu.Unlock()
+	sort.Slice(report.FrameErrors, func(i, j int) bool { return report.FrameErrors[i].Frame < report.FrameErrors[j].Frame })
+	if report.FailedFrames == nil {
+		report.FailedFrames = []int{}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+type hole struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Reason string `json:"reason"` // missing (zero-filled) or damaged (kept as decoded)
+}
+
+// frameRange is a run of frames of the video, the copies of -repeat
+// included, and when it plays.
+type frameRange struct {
+	First int     `json:"first_frame"`
+	Last  int     `json:"last_frame"`
+	Start float64 `json:"start_seconds"`
+	End   float64 `json:"end_seconds"`
+}
+
+func (r frameRange) String() string {
+	return fmt.Sprintf("%d-%d (%s-%s)", r.First, r.Last, seconds(r.Start), seconds(r.End))
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+// holeFrames returns the frames of the video carrying the payload ranges,
+// joined into runs. They are the parts of a capture to record again. The
+// bytes of an interleaved frame are spread over its whole block, so the
+// whole block is needed.
+func holeFrames(ranges []byteRange, header *streamHeader, opts options) []frameRange {
+	blockBytes := int64(frameCapacity(opts)) * int64(opts.interleave)
+	fps := float64(opts.fps)
+	var blocks [][2]int
+	for _, rg := range ranges {
+		for _, part := range header.payloadParts() {
+			start, end := rg.Offset, rg.Offset+rg.Length
+			if start < part.offset {
+				start = part.offset
+			}
+			if end > part.offset+part.length {
+				end = part.offset + part.length
+			}
+			if start >= end {
+				continue
+			}
+			// Offsets in the stream of the part, which starts with a block
+			first := (int64(part.size) + start - part.offset) / blockBytes
+			last := (int64(part.size) + end - part.offset - 1) / blockBytes
+			blocks = append(blocks, [2]int{part.first + int(first)*opts.interleave, part.first + int(last+1)*opts.interleave - 1})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i][0] < blocks[j][0] })
+	var frames []frameRange
+	for _, b := range blocks {
+		first, last := b[0]*opts.repeat, (b[1]+1)*opts.repeat-1
+		if n := len(frames); n > 0 && frames[n-1].Last+1 >= first {
+			if last > frames[n-1].Last {
+				frames[n-1].Last = last
+				frames[n-1].End = float64(last+1) / fps
+			}
+			continue
+		}
+		frames = append(frames, frameRange{First: first, Last: last, Start: float64(first) / fps, End: float64(last+1) / fps})
+	}
+	return frames
+}
+
+// holeMapFile is the hole map of a partial recovery. Hash is the SHA-256 of
+// the payload if the trailer of the video was read, which tells whether
+// recoveries merged together got it right.
+type holeMapFile struct {
+	Length       int64        `json:"length"`
+	SHA256       string       `json:"sha256,omitempty"`
+	HashVerified *bool        `json:"hash_verified"`
+	Holes        []hole       `json:"holes"`
+	Frames       []frameRange `json:"frames,omitempty"`
+}
+
+// holeMap lists the parts of a partially recovered file that are not known
+// to be right, and the frames of the video they are in. verified tells
+// whether the file matched the hash in the video, if it did not there may be
+// damage no frame reported.
+func holeMap(length int64, hash string, missing, damaged []byteRange, frames []frameRange, verified *bool) []byte {
+	holes := []hole{}
+	for _, r := range missing {
+		holes = append(holes, hole{Offset: r.Offset, Length: r.Length, Reason: "missing"})
+	}
+	for _, r := range damaged {
+		holes = append(holes, hole{Offset: r.Offset, Length: r.Length, Reason: "damaged"})
+	}
+	sort.Slice(holes, func(i, j int) bool { return holes[i].Offset < holes[j].Offset })
+	data, _ := json.MarshalIndent(holeMapFile{length, hash, verified, holes, frames}, "", "  ")
+	return append(data, '\n')
+}
@@ -0,0 +1,257 @@
+package ftv
+
+import (
+	"errors"
+	"sync"
+)
+
+// Reed-Solomon over GF(2^8) with the primitive polynomial x^8+x^4+x^3+x^2+1
+// and the generator roots α^0 ... α^(parity-1). Codewords are at most 255
+// bytes, shorter ones are treated as if padded with leading zeros.
+
+var (
+	gfExp [512]byte // Doubled so products of two logs need no modulo
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+// gfPow returns α^e.
+func gfPow(e int) byte {
+	e %= 255
+	if e < 0 {
+		e += 255
+	}
+	return gfExp[e]
+}
+
+// polyEval evaluates p, lowest degree first, at x.
+func polyEval(p []byte, x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+var errUncorrectable = errors.New("too many errors to correct")
+
+type reedSolomon struct {
+	parity    int
+	generator []byte // Highest degree first, monic
+}
+
+var (
+	rsCodesMu sync.Mutex
+	rsCodes   = map[int]*reedSolomon{}
+)
+
+// newReedSolomon returns the code with parity check bytes per codeword,
+// which corrects up to parity/2 wrong bytes.
+func newReedSolomon(parity int) *reedSolomon {
+	rsCodesMu.Lock()
+	defer rsCodesMu.Unlock()
+	if rs, ok := rsCodes[parity]; ok {
+		return rs
+	}
+	g := []byte{1}
+	for i := 0; i < parity; i++ {
+		// Multiply by (x - α^i)
+		next := make([]byte, len(g)+1)
+		for j, c := range g {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfPow(i))
+		}
+		g = next
+	}
+	rs := &reedSolomon{parity: parity, generator: g}
+	rsCodes[parity] = rs
+	return rs
+}
+
+// encode fills the last parity bytes of codeword from the data in front of
+// them.
+func (rs *reedSolomon) encode(codeword []byte) {
+	data := len(codeword) - rs.parity
+	remainder := codeword[data:]
+	for i := range remainder {
+		remainder[i] = 0
+	}
+	for i := 0; i < data; i++ {
+		rs.feed(remainder, codeword[i])
+	}
+}
+
+// feed adds the next data byte b to remainder, the parity of the data fed
+// so far, which starts zeroed. Codewords too long to be held at once are
+// encoded a byte at a time this way.
+func (rs *reedSolomon) feed(remainder []byte, b byte) {
+	coef := b ^ remainder[0]
+	copy(remainder, remainder[1:])
+	remainder[rs.parity-1] = 0
+	if coef != 0 {
+		for j := 1; j <= rs.parity; j++ {
+			remainder[j-1] ^= gfMul(rs.generator[j], coef)
+		}
+	}
+}
+
+// decode corrects codeword in place and returns how many bytes were wrong.
+func (rs *reedSolomon) decode(codeword []byte) (int, error) {
+	return rs.decodeErasures(codeword, nil)
+}
+
+// decodeErasures is decode for a codeword whose bytes at the indices in
+// erasures are known to be wrong. Knowing where they are, the code corrects
+// twice as many: wrong bytes count twice, erasures once against parity.
+func (rs *reedSolomon) decodeErasures(codeword []byte, erasures []int) (int, error) {
+	n := len(codeword)
+	if len(erasures) > rs.parity {
+		return 0, errUncorrectable
+	}
+	syndromes := make([]byte, rs.parity)
+	clean := true
+	for i := range syndromes {
+		syndromes[i] = syndrome(codeword, i)
+		if syndromes[i] != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return 0, nil
+	}
+
+	// The erasure locator has the roots α^-j of the erased bytes n-1-j
+	locator := []byte{1}
+	for _, i := range erasures {
+		x := gfPow(n - 1 - i)
+		next := make([]byte, len(locator)+1)
+		for j, c := range locator {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, x)
+		}
+		locator = next
+	}
+
+	// Berlekamp-Massey, starting from the erasures, finds the error locator,
+	// lowest degree first
+	previous := append([]byte(nil), locator...)
+	e := len(erasures)
+	errs, shift := e, 1
+	lastDiscrepancy := byte(1)
+	for k := e; k < rs.parity; k++ {
+		d := syndromes[k]
+		for i := 1; i <= errs && i < len(locator); i++ {
+			d ^= gfMul(locator[i], syndromes[k-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		scale := gfDiv(d, lastDiscrepancy)
+		updated := make([]byte, len(locator))
+		copy(updated, locator)
+		if need := len(previous) + shift; need > len(updated) {
+			updated = append(updated, make([]byte, need-len(updated))...)
+		}
+		for i, c := range previous {
+			updated[i+shift] ^= gfMul(scale, c)
+		}
+		if 2*errs <= k+e {
+			previous = locator
+			errs = k + 1 + e - errs
+			lastDiscrepancy = d
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = updated
+	}
+	if 2*errs-e > rs.parity {
+		return 0, errUncorrectable
+	}
+	if len(locator) <= errs {
+		locator = append(locator, make([]byte, errs+1-len(locator))...)
+	}
+	locator = locator[:errs+1]
+
+	// Chien search, byte n-1-j is wrong where the locator has the root α^-j
+	var positions []int
+	for j := 0; j < n; j++ {
+		if polyEval(locator, gfPow(-j)) == 0 {
+			positions = append(positions, j)
+		}
+	}
+	if len(positions) != errs {
+		return 0, errUncorrectable
+	}
+
+	// Forney, with the evaluator S(x)Λ(x) mod x^parity
+	evaluator := make([]byte, rs.parity)
+	for i, s := range syndromes {
+		for j, l := range locator {
+			if i+j < rs.parity {
+				evaluator[i+j] ^= gfMul(s, l)
+			}
+		}
+	}
+	derivative := make([]byte, len(locator))
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+	for _, j := range positions {
+		xInv := gfPow(-j)
+		denominator := polyEval(derivative, xInv)
+		if denominator == 0 {
+			return 0, errUncorrectable
+		}
+		magnitude := gfMul(gfPow(j), gfDiv(polyEval(evaluator, xInv), denominator))
+		codeword[n-1-j] ^= magnitude
+	}
+
+	// A pattern beyond the capacity of the code can decode to a wrong
+	// codeword, which the syndromes catch most of the time
+	for i := 0; i < rs.parity; i++ {
+		if syndrome(codeword, i) != 0 {
+			return 0, errUncorrectable
+		}
+	}
+	return errs, nil
+}
+
+// syndrome evaluates codeword, highest degree first, at α^i.
+func syndrome(codeword []byte, i int) byte {
+	var s byte
+	x := gfPow(i)
+	for _, c := range codeword {
+		s = gfMul(s, x) ^ c
+	}
+	return s
+}
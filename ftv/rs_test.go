@@ -0,0 +1,71 @@
+package ftv
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// TestReedSolomonEncode checks the code against the error correction of the
+// version 1-M QR code of HELLO WORLD, which uses the same field and roots.
+func TestReedSolomonEncode(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	parity := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	codeword := append(append([]byte(nil), data...), make([]byte, len(parity))...)
+	newReedSolomon(len(parity)).encode(codeword)
+	if !bytes.Equal(codeword[len(data):], parity) {
+		t.Fatalf("parity %v, expected %v", codeword[len(data):], parity)
+	}
+}
+
+func TestReedSolomonDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		length    int
+		parity    int
+		errors    int
+		erasures  int
+		corrected int // -1 if uncorrectable
+	}{
+		{"clean", 255, 32, 0, 0, 0},
+		{"errors", 255, 32, 16, 0, 16},
+		{"short codeword", 40, 8, 4, 0, 4},
+		{"erasures", 255, 32, 0, 32, 32},
+		{"errors and erasures", 255, 32, 6, 20, 26},
+		{"errors beyond capacity", 255, 32, 17, 0, -1},
+		{"erasures beyond capacity", 255, 32, 0, 33, -1},
+		{"both beyond capacity", 255, 32, 12, 10, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(tt.length*1000 + tt.errors*100 + tt.erasures)))
+			rs := newReedSolomon(tt.parity)
+			codeword := make([]byte, tt.length)
+			rng.Read(codeword[:tt.length-tt.parity])
+			rs.encode(codeword)
+			original := append([]byte(nil), codeword...)
+
+			// Erased bytes come first, the wrong ones after them
+			positions := rng.Perm(tt.length)[:tt.errors+tt.erasures]
+			for _, i := range positions {
+				codeword[i] ^= byte(1 + rng.Intn(255))
+			}
+			erasures := positions[tt.errors:]
+
+			corrected, err := rs.decodeErasures(codeword, erasures)
+			if tt.corrected < 0 {
+				if !errors.Is(err, errUncorrectable) {
+					t.Fatalf("decoded with %v, %d corrected", err, corrected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if corrected != tt.corrected || !bytes.Equal(codeword, original) {
+				t.Fatalf("%d corrected, expected %d, codeword restored: %v", corrected, tt.corrected, bytes.Equal(codeword, original))
+			}
+		})
+	}
+}
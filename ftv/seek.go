@@ -0,0 +1,211 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// decodeRange is decode of only the opts.rangeLength bytes of the payload
+// from opts.rangeOffset on. The header says where the payload starts, which
+// gives the interleaved blocks holding the range; ffmpeg seeks to the first
+// of them, other transports read through the frames before it, and only the
+// blocks of the range are digested.
+func decodeRange(ctx context.Context, srcFile, destFile string, opts options) error {
+	start := time.Now()
+	ecc := opts.frameECC()
+	rgbBytes := rgbFrameSize(opts)
+	capacity := frameCapacity(opts)
+	geometry := geometryOf(opts)
+
+	source, err := transportOf(opts).NewSource(ctx, srcFile, opts)
+	if err != nil {
+		return &stageError{stage: "ffmpeg", err: err}
+	}
+	defer func() { source.Close() }()
+
+	// Every frame comes with its copies, the intro only precedes the first
+	intro := 0
+	readGroup := func(first bool) ([]byte, error) {
+		group := make([]byte, rgbBytes*opts.repeat)
+		for c := 0; c < opts.repeat; c++ {
+			err := source.ReadFrame(group[c*rgbBytes : (c+1)*rgbBytes])
+			if err == io.EOF && c > 0 {
+				// The copies that made it still get a vote
+				return group[:c*rgbBytes], nil
+			}
+			if err == io.EOF {
+				return nil, withCause(ErrIncomplete, errors.New("video ends before the end of the range"))
+			}
+			if err != nil {
+				return nil, err
+			}
+			if first && c == 0 && isIntroFrame(group[:rgbBytes], opts) {
+				intro++
+				c--
+			}
+		}
+		return group, nil
+	}
+	readBlock := func(first bool) ([][]byte, error) {
+		groups := make([][]byte, opts.interleave)
+		for i := range groups {
+			group, err := readGroup(first && i == 0)
+			if err != nil {
+				return nil, err
+			}
+			groups[i] = group
+		}
+		return groups, nil
+	}
+
+	groups, err := readBlock(true)
+	if err != nil {
+		return &stageError{stage: "ffmpeg", err: err}
+	}
+	header, err := readHeaderBlock(groups, opts, ecc)
+	if err != nil {
+		return &stageError{stage: "digester", err: err}
+	}
+	if numbered := header.version >= numberedVersion; numbered != opts.frameNumbers {
+		// The header starts the first frame either way, the frames are cut
+		// into blocks as its version says
+		opts.frameNumbers = numbered
+		capacity = frameCapacity(opts)
+	}
+	if header.delta {
+		return &stageError{stage: "writer", err: errors.New("video holds the changes to an earlier version of the file, which only decode as a whole with -base")}
+	}
+	if end := opts.rangeOffset + opts.rangeLength; end > header.length {
+		err := fmt.Errorf("range ends at byte %d of a payload of %d bytes", end, header.length)
+		if header.more {
+			err = fmt.Errorf("%w, parts appended to the video are not covered", err)
+		}
+		return &stageError{stage: "writer", err: err}
+	}
+
+	blockBytes := int64(capacity) * int64(opts.interleave)
+	rangeStart := int64(header.size) + opts.rangeOffset
+	rangeEnd := rangeStart + opts.rangeLength
+	firstBlock := int(rangeStart / blockBytes)
+	lastBlock := int((rangeEnd - 1) / blockBytes)
+	framesPerBlock := opts.interleave * opts.repeat
+
+	// The source delivers the block after the header next. Seeking restarts
+	// ffmpeg and decodes from the keyframe before the time, which only pays
+	// off past a GOP of frames.
+	seeked := false
+	if skip := (firstBlock - 1) * framesPerBlock; skip > 0 {
+		if opts.transport == transportFFmpeg && skip > opts.gop {
+			if err := source.Close(); err != nil {
+				return &stageError{stage: "ffmpeg", err: err}
+			}
+			startFrame := intro + firstBlock*framesPerBlock
+			// Half a frame early, so the rounding of the timestamps does not
+			// drop the first frame wanted
+			opts.seek = (float64(startFrame) - 0.5) / float64(opts.fps)
+			logger.verbose("ffmpeg", "seeking", fields{"frame": startFrame, "seconds": opts.seek})
+			if source, err = transportOf(opts).NewSource(ctx, srcFile, opts); err != nil {
+				return &stageError{stage: "ffmpeg", err: err}
+			}
+			seeked = true
+		} else {
+			discard := make([]byte, rgbBytes)
+			for i := 0; i < skip; i++ {
+				if err := source.ReadFrame(discard); err == io.EOF {
+					return &stageError{stage: "ffmpeg", err: withCause(ErrIncomplete, errors.New("video ends before the range"))}
+				} else if err != nil {
+					return &stageError{stage: "ffmpeg", err: err}
+				}
+			}
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	var output *outputFile
+	var file *os.File
+	if destFile != stdoutOutput {
+		if output, err = prepareOutput(destFile); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		defer output.cleanup()
+		if file, err = os.Create(output.path); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		defer file.Close()
+		out = file
+	}
+
+	for b := firstBlock; b <= lastBlock; b++ {
+		if b > 0 {
+			if groups, err = readBlock(false); err != nil {
+				return &stageError{stage: "ffmpeg", err: err}
+			}
+		}
+		block, frames, failed := digestBlock(groups, opts, ecc)
+		if failed >= 0 {
+			return &stageError{stage: "digester", err: withCause(ErrUncorrectable, fmt.Errorf("frame %d has too many errors to correct", b*opts.interleave+failed))}
+		}
+		misplaced := func(got, want int) error {
+			err := fmt.Errorf("frame %d was read where frame %d belongs", got, want)
+			if seeked {
+				err = fmt.Errorf("%w, seeking needs the -fps the video has", err)
+			}
+			return &stageError{stage: "ffmpeg", err: err}
+		}
+		if opts.frameNumbers {
+			for i, frame := range frames {
+				want := b*opts.interleave + i
+				number, ok := readFrameNumber(frame, capacity)
+				if !ok {
+					return &stageError{stage: "digester", err: withCause(ErrUncorrectable, fmt.Errorf("frame %d: data does not match its CRC", want))}
+				}
+				if number != want {
+					return misplaced(number, want)
+				}
+			}
+		}
+		if opts.frameStrip {
+			for i, group := range groups {
+				want := b*opts.interleave + i
+				strip := readStrip(frameCopies(group, opts), geometry)
+				if strip.offset != stripOffset(strip.index, capacity, opts.interleave) {
+					logger.verbose("digester", "frame strip unreadable", fields{"frame": want})
+					continue
+				}
+				if strip.index != want {
+					return misplaced(strip.index, want)
+				}
+				if !strip.matches(frames[i]) {
+					return &stageError{stage: "digester", err: withCause(ErrUncorrectable, fmt.Errorf("frame %d: data does not match the CRC of its strip", want))}
+				}
+			}
+		}
+
+		blockStart := int64(b) * blockBytes
+		from, to := int64(0), blockBytes
+		if rangeStart > blockStart {
+			from = rangeStart - blockStart
+		}
+		if rangeEnd < blockStart+blockBytes {
+			to = rangeEnd - blockStart
+		}
+		if _, err := out.Write(block[from:to]); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+	}
+
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+		if err := output.commit(ctx); err != nil {
+			return &stageError{stage: "writer", err: err}
+		}
+	}
+	logger.info("decode", "range decoded", fields{"output": destFile, "offset": opts.rangeOffset, "bytes": opts.rangeLength, "frames": (lastBlock - firstBlock + 1) * opts.interleave, "seeked": seeked, "elapsed": time.Since(start)})
+	return nil
+}
@@ -0,0 +1,166 @@
+package ftv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segmentPlan splits the frames of an encode into contiguous segments that
+// are encoded by separate ffmpeg processes.
+type segmentPlan struct {
+	total int // Frames in the whole video
+	count int // Number of segments, none of them empty
+	size  int // Frames per segment, the last one may be shorter
+}
+
+func newSegmentPlan(total, requested int) segmentPlan {
+	if requested > total {
+		requested = total
+	}
+	if requested < 1 {
+		requested = 1
+	}
+	size := (total + requested - 1) / requested
+	if size < 1 {
+		size = 1
+	}
+	return segmentPlan{total: total, count: (total + size - 1) / size, size: size}
+}
+
+// first returns the first frame of segment s.
+func (s segmentPlan) first(segment int) int { return segment * s.size }
+
+// of returns the segment frame id belongs to.
+func (s segmentPlan) of(id int) int { return id / s.size }
+
+// frame returns the i-th frame to read. Frames alternate between segments so
+// that every ffmpeg process gets work from the start: the first frame of each
+// segment, then the second of each, and so on.
+func (s segmentPlan) frame(i int) int {
+	if s.count == 1 {
+		return i
+	}
+	last := s.total - s.first(s.count-1) // Length of the last segment
+	if i < s.count*last {
+		return s.first(i%s.count) + i/s.count
+	}
+	// Only the full segments have frames left
+	i -= s.count * last
+	return s.first(i%(s.count-1)) + last + i/(s.count-1)
+}
+
+// segmentPaths returns the part files for a video at dest, keeping its
+// extension so ffmpeg picks the same container.
+func segmentPaths(dest string, count int) []string {
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s.part%d%s", base, i, ext)
+	}
+	return paths
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// concatSegments joins parts into dest with ffmpeg's concat demuxer, which
+// copies the streams without re-encoding them.
+func concatSegments(ctx context.Context, parts []string, dest string, opts options) error {
+	list := dest + ".segments.txt"
+	var content strings.Builder
+	for _, part := range parts {
+		abs, err := filepath.Abs(part)
+		if err != nil {
+			return err
+		}
+		// ffmpeg takes forward slashes on Windows too, backslashes would
+		// need escaping
+		fmt.Fprintf(&content, "file '%s'\n", strings.ReplaceAll(filepath.ToSlash(abs), "'", `'\''`))
+	}
+	if err := os.WriteFile(list, []byte(content.String()), 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(list)
+
+	args := []string{
+		"-y",
+		"-f", "concat",
+		"-safe", "0", // Allow absolute paths in the list
+		"-i", list,
+		"-c", "copy",
+	}
+	args = append(args, muxerArgs(dest, opts)...)
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath, append(args, dest)...)
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stderr.Close()
+	if err != nil {
+		return ffmpegExitError(fmt.Errorf("joining segments: %w", err), stderr)
+	}
+	logger.verbose("ffmpeg", "segments joined", fields{"segments": len(parts)})
+	return nil
+}
+
+// sortSegmentPaths orders the part files of a split video by their number,
+// which a glob would sort part10 before part2 by. Paths not named like
+// segmentPaths names them are left in the order given.
+func sortSegmentPaths(paths []string) {
+	numbers := make(map[string]int, len(paths))
+	for _, path := range paths {
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+		i := strings.LastIndex(base, ".part")
+		if i < 0 {
+			return
+		}
+		n, err := strconv.Atoi(base[i+len(".part"):])
+		if err != nil {
+			return
+		}
+		numbers[path] = n
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return numbers[paths[i]] < numbers[paths[j]] })
+}
+
+// splitVideoFrames returns how many frames the video has whose first
+// interleaved block is groups, every group holding the copies of a frame as
+// ffmpeg delivers them. The parts of a split video but the last have
+// exactly their share of those.
+func splitVideoFrames(groups [][]byte, opts options, ecc *frameECC) (int, error) {
+	header, err := readHeaderBlock(groups, opts, ecc)
+	if err != nil {
+		return 0, err
+	}
+	total := int(framesNeeded(header.length+int64(header.size), opts))
+	if header.version >= 2 {
+		total++ // Trailer
+	}
+	return total, nil
+}
+
+// readHeaderBlock reads the stream header off groups, the first interleaved
+// block of a video.
+func readHeaderBlock(groups [][]byte, opts options, ecc *frameECC) (*streamHeader, error) {
+	block, _, failed := digestBlock(groups, opts, ecc)
+	if failed >= 0 {
+		return nil, fmt.Errorf("frame %d of the stream header has too many errors to correct", failed)
+	}
+	header, err := parseStreamHeader(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(block) < header.size {
+		return nil, errors.New("the stream header does not fit the first block of the video")
+	}
+	return header, nil
+}
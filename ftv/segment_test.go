@@ -0,0 +1,39 @@
+package ftv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortSegmentPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"numeric order", []string{"v.part10.mp4", "v.part2.mp4", "v.part0.mp4", "v.part1.mp4"},
+			[]string{"v.part0.mp4", "v.part1.mp4", "v.part2.mp4", "v.part10.mp4"}},
+		{"dots in the directory", []string{"out.d/v.x.part1.mkv", "out.d/v.x.part0.mkv"},
+			[]string{"out.d/v.x.part0.mkv", "out.d/v.x.part1.mkv"}},
+		{"as segmentPaths names them", reverse(segmentPaths("dir/video.webm", 12)), segmentPaths("dir/video.webm", 12)},
+		{"other names", []string{"b.mp4", "v.part1.mp4", "a.mp4"}, []string{"b.mp4", "v.part1.mp4", "a.mp4"}},
+		{"not a number", []string{"v.part2.mp4", "v.partx.mp4", "v.part1.mp4"}, []string{"v.part2.mp4", "v.partx.mp4", "v.part1.mp4"}},
+		{"empty", []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := append([]string{}, tt.paths...)
+			sortSegmentPaths(paths)
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Fatalf("sorted %q, expected %q", paths, tt.want)
+			}
+		})
+	}
+}
+
+func reverse(paths []string) []string {
+	for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+		paths[i], paths[j] = paths[j], paths[i]
+	}
+	return paths
+}
@@ -0,0 +1,175 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// runSelftest encodes random payloads of several sizes, decodes them again
+// and compares the hashes, to check that ffmpeg and the encoder picked on
+// this machine round-trip. Every payload goes through the current settings
+// and through the lossless channel, which tells a broken setup apart from
+// settings too weak for the codec.
+func runSelftest(args []string) {
+	var keep bool
+
+	c := selftestCLI(&keep)
+	c.parse(args)
+
+	lossless := c.opts
+	if err := applySettings(&lossless, channels["lossless"], nil); err != nil {
+		logger.fatal("selftest", err)
+	}
+	configs := []struct {
+		name string
+		opts options
+	}{
+		{"current", c.opts},
+		{"lossless", lossless},
+	}
+
+	dir, err := os.MkdirTemp("", "filetovideo-selftest-*")
+	if err != nil {
+		logger.fatal("selftest", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []selftestResult
+	failed := 0
+	for _, config := range configs {
+		// Empty, a single byte, just over a frame, and enough for a few
+		// interleaved blocks
+		capacity := int64(frameCapacity(config.opts))
+		sizes := []int64{0, 1, capacity + 1, capacity * int64(4*config.opts.interleave)}
+		for i, size := range sizes {
+			if ctx.Err() != nil {
+				break
+			}
+			name := fmt.Sprintf("%s-%d", config.name, i)
+			result := roundTrip(ctx, filepath.Join(dir, name), size, config.opts)
+			result.Config = config.name
+			if result.Error != "" {
+				failed++
+			}
+			results = append(results, result)
+		}
+	}
+	if failed == 0 || !keep {
+		os.RemoveAll(dir)
+	}
+
+	// The results are the output of the command, so they are not subject to -q
+	if logger.format == logJSON {
+		json.NewEncoder(os.Stdout).Encode(results)
+	} else {
+		for _, r := range results {
+			status := "PASS"
+			if r.Error != "" {
+				status = "FAIL"
+			}
+			fmt.Printf("%s  %-8s  %10d bytes  %8s", status, r.Config, r.Bytes, time.Duration(r.Seconds*float64(time.Second)).Round(time.Millisecond))
+			if r.Error != "" {
+				fmt.Printf("  %s", r.Error)
+			}
+			fmt.Println()
+		}
+	}
+	if ctx.Err() != nil {
+		logger.fatal("selftest", ctx.Err())
+	}
+	if failed > 0 {
+		if keep {
+			logger.info("selftest", "files kept", fields{"dir": dir})
+		}
+		logger.fatal("selftest", fmt.Errorf("%d of %d round trips failed", failed, len(results)))
+	}
+}
+
+// selftestCLI defines the flags of selftest.
+func selftestCLI(keep *bool) *cli {
+	c := newCLI("selftest")
+	c.flags.BoolVar(keep, "keep", false, "Keep the payloads and videos of failed runs")
+	return c
+}
+
+type selftestResult struct {
+	Config  string  `json:"config"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// roundTrip encodes size random bytes to a video at base and decodes it.
+func roundTrip(ctx context.Context, base string, size int64, opts options) selftestResult {
+	result := selftestResult{Bytes: size}
+	start := time.Now()
+	err := func() error {
+		payload, video, decoded := base+".bin", base+".mkv", base+".out"
+		want, err := writeRandom(payload, size)
+		if err != nil {
+			return err
+		}
+		if err := encode(ctx, payload, video, opts); err != nil {
+			return fmt.Errorf("encoding: %w", err)
+		}
+		if err := decode(ctx, video, decoded, opts); err != nil {
+			return fmt.Errorf("decoding: %w", err)
+		}
+		got, err := hashOf(decoded)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("decoded file differs from the payload")
+		}
+		for _, path := range []string{payload, video, decoded} {
+			os.Remove(path)
+		}
+		return nil
+	}()
+	result.Seconds = time.Since(start).Seconds()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// writeRandom writes size random bytes to path and returns their SHA-256.
+func writeRandom(path string, size int64) ([]byte, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if _, err := io.CopyN(io.MultiWriter(file, hash), random, size); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), file.Close()
+}
+
+func hashOf(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
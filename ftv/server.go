@@ -0,0 +1,637 @@
+package ftv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runServe exposes encode and decode as an HTTP job API:
+//
+//	POST /jobs?mode=encode|decode  submit a job, either as a multipart upload
+//	                               (field "file") or as a JSON body
+//	                               {"mode": "...", "path": "..."}; an optional
+//	                               preset is applied to the job only, and
+//	                               jobs of a higher priority run first
+//	GET  /jobs                     list all jobs
+//	GET  /jobs/{id}                status and progress of a job
+//	DELETE /jobs/{id}              cancel a queued or running job
+//	GET  /jobs/{id}/result         download the output of a finished job
+//	GET  /presets                  names of the presets jobs may pick
+//	GET  /metrics                  job counters in the Prometheus text format
+//
+// Jobs wait in a queue until the ffmpeg processes they run fit in
+// -max-ffmpeg, and one that fails is retried up to -retries times. The queue
+// is kept in -dir, so jobs a restart interrupts run again when serve is back.
+// With -ui the page in ui.html is served at / as well, for submitting jobs
+// from a browser.
+// With -grpc-addr the gRPC service from pb/filetovideo.proto is served as
+// well, or instead of the HTTP API if -addr is empty.
+func runServe(args []string) {
+	var (
+		addr         string
+		grpc_addr    string
+		jobs_dir     string
+		allow_paths  bool
+		metrics_addr string
+		ui           bool
+		max_ffmpeg   int
+		retries      int
+	)
+
+	c := serveCLI(&addr, &grpc_addr, &jobs_dir, &allow_paths, &metrics_addr, &ui, &max_ffmpeg, &retries)
+	c.parse(args)
+
+	if addr == "" && grpc_addr == "" {
+		c.usageError("At least one of -addr and -grpc-addr is required")
+	}
+	if ui && addr == "" {
+		c.usageError("The -ui flag requires the HTTP API, -addr cannot be empty")
+	}
+	if max_ffmpeg < 1 {
+		c.usageError("The -max-ffmpeg flag must be at least 1")
+	}
+	if retries < 0 {
+		c.usageError("The -retries flag cannot be negative")
+	}
+	// Jobs without a preset encode with the flags alone
+	if err := checkContainer("video"+videoExtension(c.opts), c.opts); err != nil {
+		c.usageError(err.Error())
+	}
+	if err := os.MkdirAll(jobs_dir, 0o755); err != nil {
+		logger.fatal("serve", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slots := newFFmpegSlots(max_ffmpeg)
+	var wg sync.WaitGroup
+	if grpc_addr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveGRPC(ctx, grpc_addr, jobs_dir, c.opts, slots, retries); err != nil {
+				logger.fatal("serve", err)
+			}
+		}()
+	}
+	if metrics_addr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveMetrics(ctx, metrics_addr); err != nil {
+				logger.fatal("serve", err)
+			}
+		}()
+	}
+	if addr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jobs := newJobServer(ctx, jobs_dir, c.opts, allow_paths, slots, retries)
+			if err := jobs.restore(); err != nil {
+				logger.fatal("serve", err)
+			}
+			var handler http.Handler = jobs
+			if ui {
+				handler = withUI(handler)
+			}
+			if err := serveHTTP(ctx, addr, handler); err != nil {
+				logger.fatal("serve", err)
+			}
+			// Interrupted jobs are saved as queued before serve exits
+			jobs.running.Wait()
+		}()
+	}
+	wg.Wait()
+}
+
+// serveCLI defines the flags of serve.
+func serveCLI(addr, grpc_addr, jobs_dir *string, allow_paths *bool, metrics_addr *string, ui *bool, max_ffmpeg, retries *int) *cli {
+	c := newCLI("serve")
+	c.flags.StringVar(addr, "addr", "127.0.0.1:8080", "Address the HTTP API listens on, empty to disable it")
+	c.flags.StringVar(grpc_addr, "grpc-addr", "", "Address the gRPC service listens on, empty to disable it")
+	c.flags.StringVar(jobs_dir, "dir", filepath.Join(os.TempDir(), "filetovideo-jobs"), "Directory holding job inputs and outputs")
+	c.flags.BoolVar(allow_paths, "allow-paths", false, "Allow jobs to reference files on the server by path")
+	c.flags.StringVar(metrics_addr, "metrics-addr", "", "Address serving only /metrics, which the HTTP API serves as well")
+	c.flags.BoolVar(ui, "ui", false, "Serve a web page at / for submitting jobs and downloading their results from a browser")
+	c.flags.IntVar(max_ffmpeg, "max-ffmpeg", 2, "Most ffmpeg processes all jobs run at once, a job encoding in -segments takes one per segment")
+	c.flags.IntVar(retries, "retries", 2, "Times a failed job or gRPC call is run again, unless its input is at fault")
+	return c
+}
+
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.info("serve", "listening", fields{"addr": addr})
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+type jobState string
+
+const (
+	jobQueued   jobState = "queued"
+	jobRunning  jobState = "running"
+	jobDone     jobState = "done"
+	jobFailed   jobState = "failed"
+	jobCanceled jobState = "canceled"
+)
+
+type stageProgress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// job is a single encode or decode run submitted through the API.
+type job struct {
+	mu sync.Mutex
+
+	ID       string                    `json:"id"`
+	Mode     string                    `json:"mode"`
+	Name     string                    `json:"name"`
+	Preset   string                    `json:"preset,omitempty"`
+	Priority int                       `json:"priority"`
+	State    jobState                  `json:"state"`
+	Attempts int                       `json:"attempts"`
+	Error    string                    `json:"error,omitempty"`
+	Progress map[string]*stageProgress `json:"progress"`
+	Created  time.Time                 `json:"created"`
+	Finished *time.Time                `json:"finished,omitempty"`
+
+	input  string
+	output string
+	opts   options
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	canceled bool // Through the API, rather than by serve shutting down
+}
+
+// snapshot returns a copy of the job that is safe to marshal.
+func (j *job) snapshot() *job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := &job{
+		ID: j.ID, Mode: j.Mode, Name: j.Name, Preset: j.Preset, Priority: j.Priority, State: j.State, Error: j.Error,
+		Attempts: j.Attempts, Created: j.Created, Finished: j.Finished,
+		Progress: map[string]*stageProgress{},
+	}
+	for stage, p := range j.Progress {
+		copied := *p
+		s.Progress[stage] = &copied
+	}
+	return s
+}
+
+func (j *job) setState(state jobState, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.State = state
+	if err != nil {
+		j.Error = err.Error()
+	} else if state == jobDone {
+		j.Error = "" // Of an attempt before the one that succeeded
+	}
+	if state != jobRunning && state != jobQueued {
+		now := time.Now()
+		j.Finished = &now
+	}
+}
+
+// resultName is the file name offered when downloading the output.
+func (j *job) resultName() string {
+	return outputName(j.Mode, j.Name, filepath.Ext(j.output))
+}
+
+// outputName returns the name of the output of mode run on the input name:
+// the name with videoExt, the extension of the video, when encoding, and
+// without its extension when decoding.
+func outputName(mode, name, videoExt string) string {
+	if mode == "encode" {
+		return name + videoExt
+	}
+	if name := strings.TrimSuffix(name, filepath.Ext(name)); name != "" {
+		return name
+	}
+	return "decoded"
+}
+
+type jobServer struct {
+	ctx        context.Context
+	dir        string
+	opts       options
+	allowPaths bool
+	slots      *ffmpegSlots
+	retries    int
+	running    sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobServer(ctx context.Context, dir string, opts options, allowPaths bool, slots *ffmpegSlots, retries int) *jobServer {
+	return &jobServer{ctx: ctx, dir: dir, opts: opts, allowPaths: allowPaths, slots: slots, retries: retries, jobs: map[string]*job{}}
+}
+
+func (s *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "jobs" && r.Method == http.MethodPost:
+		s.submit(w, r)
+	case path == "jobs" && r.Method == http.MethodGet:
+		s.list(w)
+	case path == "presets" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, sortedKeys(presets))
+	case path == "metrics" && r.Method == http.MethodGet:
+		metrics.ServeHTTP(w, r)
+	case len(parts) == 2 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		s.status(w, parts[1])
+	case len(parts) == 2 && parts[0] == "jobs" && r.Method == http.MethodDelete:
+		s.cancel(w, parts[1])
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "result" && r.Method == http.MethodGet:
+		s.result(w, r, parts[1])
+	default:
+		httpError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	j := &job{
+		ID:       newJobID(),
+		Mode:     r.URL.Query().Get("mode"),
+		Preset:   r.URL.Query().Get("preset"),
+		State:    jobQueued,
+		Progress: map[string]*stageProgress{},
+		Created:  time.Now(),
+	}
+	if value := r.URL.Query().Get("priority"); value != "" {
+		priority, err := parsePriority(value)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		j.Priority = priority
+	}
+	jobDir := filepath.Join(s.dir, j.ID)
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	switch mediaType {
+	case "multipart/form-data":
+		err = s.receiveUpload(r, j, jobDir)
+	case "application/json":
+		err = s.receivePath(r, j)
+	default:
+		err = errors.New("expected a multipart upload or a JSON body")
+	}
+	if err == nil && j.Mode != "encode" && j.Mode != "decode" {
+		err = fmt.Errorf("unknown mode %q (expected encode or decode)", j.Mode)
+	}
+	j.opts = s.opts
+	if err == nil && j.Preset != "" {
+		// The job asked for the preset, so it wins over the server's flags
+		if err = applyPreset(&j.opts, j.Preset, nil); err == nil {
+			err = j.opts.validate()
+		}
+	}
+	j.output = filepath.Join(jobDir, "output")
+	if err == nil && j.Mode == "encode" {
+		// ffmpeg picks the container from the extension
+		j.output += videoExtension(j.opts)
+		err = checkContainer(j.output, j.opts)
+	}
+	if err != nil {
+		os.RemoveAll(jobDir)
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.start(j)
+
+	logger.info("serve", "job submitted", fields{"job": j.ID, "mode": j.Mode, "name": j.Name, "preset": j.Preset, "priority": j.Priority})
+	writeJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// receiveUpload streams the "file" part of a multipart request to disk.
+func (s *jobServer) receiveUpload(r *http.Request, j *job, jobDir string) error {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return errors.New(`missing "file" field`)
+		}
+		if err != nil {
+			return err
+		}
+		if part.FormName() == "mode" && j.Mode == "" {
+			mode, _ := io.ReadAll(io.LimitReader(part, 16))
+			j.Mode = string(mode)
+			continue
+		}
+		if part.FormName() == "preset" && j.Preset == "" {
+			preset, _ := io.ReadAll(io.LimitReader(part, 64))
+			j.Preset = string(preset)
+			continue
+		}
+		if part.FormName() == "priority" {
+			value, _ := io.ReadAll(io.LimitReader(part, 16))
+			if j.Priority, err = parsePriority(string(value)); err != nil {
+				return err
+			}
+			continue
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		j.Name = filepath.Base(part.FileName())
+		j.input = filepath.Join(jobDir, "input")
+		file, err := os.Create(j.input)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, part)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
+
+// receivePath accepts a job referencing a file that is already on the server.
+func (s *jobServer) receivePath(r *http.Request, j *job) error {
+	if !s.allowPaths {
+		return errors.New("path references are disabled, start the server with -allow-paths")
+	}
+	var body struct {
+		Mode     string `json:"mode"`
+		Path     string `json:"path"`
+		Preset   string `json:"preset"`
+		Priority *int   `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return err
+	}
+	if body.Mode != "" {
+		j.Mode = body.Mode
+	}
+	if body.Preset != "" {
+		j.Preset = body.Preset
+	}
+	if body.Priority != nil {
+		j.Priority = *body.Priority
+	}
+	if !isRemote(body.Path) && !(j.Mode == "decode" && isURL(body.Path)) {
+		if _, err := os.Stat(body.Path); err != nil {
+			return err
+		}
+	}
+	j.input = body.Path
+	j.Name = filepath.Base(body.Path)
+	return nil
+}
+
+// parsePriority parses the priority of a submitted job.
+func parsePriority(value string) (int, error) {
+	priority, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid priority %q, expected an integer", value)
+	}
+	return priority, nil
+}
+
+// start lists j and queues it on a context of its own, so canceling it stops
+// nothing else.
+func (s *jobServer) start(j *job) {
+	j.ctx, j.cancel = context.WithCancel(s.ctx)
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	s.save(j)
+
+	s.running.Add(1)
+	go s.run(j)
+}
+
+// run waits for the ffmpeg slots j needs and runs it, again after a failure
+// another attempt may get past, up to -retries times.
+func (s *jobServer) run(j *job) {
+	defer s.running.Done()
+	defer j.cancel()
+
+	opts := j.opts
+	s.slots.clampSegments(&opts)
+	opts.onProgress = func(stage string, done, total int64) {
+		j.mu.Lock()
+		j.Progress[stage] = &stageProgress{Done: done, Total: total}
+		j.mu.Unlock()
+	}
+	slots := s.slots.processes(j.Mode, opts)
+
+	for {
+		if !s.slots.acquire(j.ctx, j.Priority, slots) {
+			s.stopped(j)
+			return
+		}
+		err := s.attempt(j, opts)
+		s.slots.release(slots)
+
+		switch {
+		case err == nil:
+			j.setState(jobDone, nil)
+			s.save(j)
+			logger.info("serve", "job finished", fields{"job": j.ID})
+			return
+		case j.ctx.Err() != nil:
+			s.stopped(j)
+			return
+		}
+
+		attempts := j.snapshot().Attempts
+		if attempts > s.retries || !retryable(err) {
+			j.setState(jobFailed, err)
+			s.save(j)
+			logger.error("serve", fmt.Errorf("job %s: %w", j.ID, err))
+			return
+		}
+		delay := retryDelay << (attempts - 1)
+		logger.info("serve", "job failed, retrying", fields{"job": j.ID, "attempt": attempts, "delay": delay, "error": err.Error()})
+		j.setState(jobQueued, err)
+		s.save(j)
+		select {
+		case <-time.After(delay):
+		case <-j.ctx.Done():
+			s.stopped(j)
+			return
+		}
+	}
+}
+
+// attempt runs j once with opts.
+func (s *jobServer) attempt(j *job, opts options) error {
+	tracked := metrics.track(j.Mode, &opts)
+	j.mu.Lock()
+	j.Attempts++
+	j.Progress = map[string]*stageProgress{}
+	j.mu.Unlock()
+	j.setState(jobRunning, nil)
+	s.save(j)
+
+	var err error
+	if j.Mode == "encode" {
+		err = encode(j.ctx, j.input, j.output, opts)
+	} else {
+		err = decode(j.ctx, j.input, j.output, opts)
+	}
+	switch {
+	case err == nil:
+		tracked.finish(jobDone, nil)
+	case j.ctx.Err() != nil:
+		tracked.finish(jobCanceled, err)
+	default:
+		tracked.finish(jobFailed, err)
+	}
+	return err
+}
+
+// stopped records that j ended unfinished: canceled if it was asked to be,
+// otherwise serve is shutting down and it stays queued, to run again on the
+// next start.
+func (s *jobServer) stopped(j *job) {
+	j.mu.Lock()
+	canceled := j.canceled
+	j.mu.Unlock()
+	if canceled {
+		j.setState(jobCanceled, nil)
+		logger.info("serve", "job canceled", fields{"job": j.ID})
+	} else {
+		j.setState(jobQueued, nil)
+	}
+	s.save(j)
+}
+
+func (s *jobServer) lookup(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+func (s *jobServer) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.Before(jobs[b].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *jobServer) status(w http.ResponseWriter, id string) {
+	j := s.lookup(id)
+	if j == nil {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, j.snapshot())
+}
+
+// cancel stops a queued or running job. Its files are kept until -dir is
+// cleaned up.
+func (s *jobServer) cancel(w http.ResponseWriter, id string) {
+	j := s.lookup(id)
+	if j == nil {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return
+	}
+	j.mu.Lock()
+	state := j.State
+	unfinished := state == jobQueued || state == jobRunning
+	if unfinished {
+		j.canceled = true
+	}
+	j.mu.Unlock()
+	if !unfinished {
+		httpError(w, http.StatusConflict, fmt.Errorf("job %s is already %s", id, state))
+		return
+	}
+	j.cancel()
+	writeJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+func (s *jobServer) result(w http.ResponseWriter, r *http.Request, id string) {
+	j := s.lookup(id)
+	if j == nil {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return
+	}
+	snapshot := j.snapshot()
+	if snapshot.State != jobDone {
+		httpError(w, http.StatusConflict, fmt.Errorf("job %s is %s", id, snapshot.State))
+		return
+	}
+
+	file, err := os.Open(j.output)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": j.resultName()}))
+	http.ServeContent(w, r, j.resultName(), info.ModTime(), file)
+}
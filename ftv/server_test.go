@@ -0,0 +1,47 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSubmitContainer checks that a job whose video cannot be written in its
+// container is refused rather than queued to fail in ffmpeg.
+func TestSubmitContainer(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  string
+		status int
+	}{
+		{"ffv1 in mp4", "ffv1", http.StatusBadRequest},
+		{"h264 in mp4", autoCodec, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultOptions()
+			opts.codec, opts.container = tt.codec, containerMP4
+			ctx, cancel := context.WithCancel(context.Background())
+			s := newJobServer(ctx, t.TempDir(), opts, false, newFFmpegSlots(1), 0)
+			defer s.running.Wait()
+			defer cancel()
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			form.WriteField("mode", "encode")
+			file, _ := form.CreateFormFile("file", "input.bin")
+			file.Write([]byte("payload"))
+			form.Close()
+			req := httptest.NewRequest(http.MethodPost, "/jobs", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, expected %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
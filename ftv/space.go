@@ -0,0 +1,26 @@
+package ftv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// reserveSpace makes sure file can grow to size bytes before decode starts
+// writing to it, so a nearly full disk fails right away with a clear error
+// rather than halfway through the video. Where the file system supports it
+// the space is allocated as well.
+func reserveSpace(file *os.File, size int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	needed := size - info.Size()
+	if needed <= 0 {
+		return nil
+	}
+	if free, ok := freeSpace(filepath.Dir(file.Name())); ok && uint64(needed) > free {
+		return fmt.Errorf("video holds %d bytes but only %d are free on the disk of %s", size, free, file.Name())
+	}
+	return preallocate(file, size)
+}
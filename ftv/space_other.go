@@ -0,0 +1,5 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package ftv
+
+func freeSpace(dir string) (uint64, bool) { return 0, false }
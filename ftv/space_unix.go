@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package ftv
+
+import "syscall"
+
+// freeSpace returns the bytes available to this user on the file system of
+// dir, false if it cannot be told.
+func freeSpace(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
@@ -0,0 +1,42 @@
+package ftv
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Whence values of lseek that find the data and holes of a sparse file
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataExtents returns the stretches of the first size bytes of file that
+// hold data. File systems that do not track holes report the whole file.
+func dataExtents(file *os.File, size int64) []extent {
+	var extents []extent
+	for offset := int64(0); offset < size; {
+		data, err := file.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // Only a hole is left
+		}
+		if err != nil {
+			return []extent{{length: size}}
+		}
+		hole, err := file.Seek(data, seekHole)
+		if err != nil {
+			return []extent{{length: size}}
+		}
+		if hole > size {
+			hole = size
+		}
+		extents = append(extents, extent{offset: data, length: hole - data})
+		offset = hole
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return []extent{{length: size}}
+	}
+	return extents
+}
@@ -0,0 +1,902 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The stream carried by the frames is a header followed by the payload.
+//
+// Format v1 header, all integers big-endian:
+//
+//	0   4  magic "FTVD"
+//	4   1  version the video was written with
+//	5   1  oldest version able to read it
+//	6   2  header size, readers skip fields they don't know
+//	8   8  payload length
+//
+// Since v3 the header goes on with the metadata of the original file:
+//
+//	16  4  mode bits, 0 if unknown
+//	20  8  modification time in nanoseconds since the epoch, 0 if unknown
+//	28  2  length of the name
+//	30  n  base name of the file, UTF-8
+//
+// Since v5 a byte of flags follows the name:
+//
+//	30+n 1 bit 0: the payload is a delta against an earlier version of the
+//	       file (see delta.go), which only v5 readers know to apply
+//	       bit 1: the frames use the dct modulation (see dct.go)
+//	       bit 2: the frames start with a strip (see strip.go)
+//	       bit 3: a part is appended right after this one (see writer.go)
+//	       bit 4: the payload is a tar archive (see tar.go)
+//
+// Bits 1 to 4 were added after v5 without bumping it, as a v5 reader not
+// knowing them still decodes the video correctly or cannot read it at all:
+// frames of the dct modulation or with a strip do not even yield the header
+// to a reader expecting dots without one, which fails on the magic; appended
+// parts are found after the trailer as before bit 3, which only tells a
+// -live decode to wait for them; and a tar archive is a payload like any
+// other. Bit 0 changes what the payload means, which is why deltas needed v5.
+//
+// Since v6 the data of every frame ends with the number of the frame and a
+// CRC (see framenumber.go), which the header is read before: it still starts
+// the first frame, whatever a reader takes the end of the frames to be. The
+// stream is cut into frames differently than before, so v6 videos need a v6
+// reader, and a v6 reader finding an older header decodes the frames again
+// without numbers. Parts appended to an older video are not numbered either.
+//
+// Format v0, written before the header was versioned, only has the 8 byte
+// payload length. It is recognized by the missing magic.
+//
+// Since v2 the data frames are followed by a trailer frame, which is not part
+// of the stream:
+//
+//	0   4  magic "FTVE"
+//	4   8  payload length
+//	12  8  id of the trailer frame, the number of data frames before it
+//	20  32 SHA-256 of the payload
+//
+// Since v4 more payload can be appended to a video. The appended part starts
+// at the first frame after the trailer that begins an interleaved block, the
+// frames before it are copies of the trailer. Its stream starts with a header
+// of its own:
+//
+//	0   4  magic "FTVA"
+//	4   1  version the part was written with
+//	5   1  oldest version able to read it
+//	6   2  header size
+//	8   8  offset of the part in the payload
+//	16  8  length of the part
+//	24  1  bit 0: another part is appended right after this one, absent
+//	       from parts appended before the flag existed
+//
+// and its trailer carries the length of the whole payload and, in place of
+// the hash of the payload, the SHA-256 of the previous trailer's hash
+// followed by the payload of the part.
+const (
+	formatVersion    = 6
+	formatCompat     = 6  // Oldest version that can read what this build writes
+	partCompat       = 4  // The same for appended parts
+	streamHeaderSize = 16 // Without the metadata
+	metadataSize     = 14 // Without the name
+	legacyHeaderSize = 8
+	partHeaderSize   = 24
+	trailerSize      = 52
+)
+
+var (
+	streamMagic  = []byte("FTVD")
+	partMagic    = []byte("FTVA")
+	trailerMagic = []byte("FTVE")
+)
+
+type streamHeader struct {
+	version  int
+	compat   int
+	size     int // Bytes the header takes in the stream
+	length   int64
+	metadata fileMetadata
+	delta    bool // The payload is a delta to apply to the base
+	dct      bool // The frames use the dct modulation
+	strip    bool // The frames start with a strip
+	more     bool // A part is appended right after the first one
+	tar      bool // The payload is a tar archive
+
+	// The parts of the payload once the whole stream has been read, length is
+	// then the length of all of them
+	parts []streamPart
+}
+
+// streamPart is a run of the payload carried by consecutive frames. A video
+// holds one, and one more for every time it was appended to.
+type streamPart struct {
+	first  int   // Frame the part starts at
+	frames int   // Data frames of the part, including padding
+	start  int64 // Stream offset of the first frame
+	size   int   // Of the header in front of the payload
+	offset int64 // Of the part in the payload
+	length int64
+	more   bool // Another part is appended right after this one
+}
+
+// end returns the stream offset the frames of the part end at.
+func (p streamPart) end(capacity int) int64 {
+	return p.start + int64(p.frames)*int64(capacity)
+}
+
+// payloadParts returns the parts of the payload. A header that was parsed on
+// its own only knows the first.
+func (h *streamHeader) payloadParts() []streamPart {
+	if h.parts != nil {
+		return h.parts
+	}
+	return []streamPart{{size: h.size, length: h.length}}
+}
+
+// fileMetadata is what the video remembers about the original file. Videos
+// from before v3 and remote inputs lack some or all of it.
+type fileMetadata struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func metadataOf(info os.FileInfo) fileMetadata {
+	return fileMetadata{name: info.Name(), mode: info.Mode().Perm(), modTime: info.ModTime()}
+}
+
+func newStreamHeader(length int64, metadata fileMetadata) *streamHeader {
+	if len(metadata.name) > math.MaxUint16 {
+		metadata.name = ""
+	}
+	return &streamHeader{
+		version:  formatVersion,
+		compat:   formatCompat,
+		size:     streamHeaderSize + metadataSize + len(metadata.name) + 1, // And the flags
+		length:   length,
+		metadata: metadata,
+	}
+}
+
+func (h *streamHeader) marshal() []byte {
+	b := make([]byte, h.size)
+	copy(b, streamMagic)
+	b[4] = byte(h.version)
+	b[5] = byte(h.compat)
+	binary.BigEndian.PutUint16(b[6:], uint16(h.size))
+	binary.BigEndian.PutUint64(b[8:], uint64(h.length))
+
+	m := b[streamHeaderSize:]
+	binary.BigEndian.PutUint32(m, uint32(h.metadata.mode))
+	if !h.metadata.modTime.IsZero() {
+		binary.BigEndian.PutUint64(m[4:], uint64(h.metadata.modTime.UnixNano()))
+	}
+	binary.BigEndian.PutUint16(m[12:], uint16(len(h.metadata.name)))
+	copy(m[metadataSize:], h.metadata.name)
+	if h.delta {
+		m[metadataSize+len(h.metadata.name)] |= 1
+	}
+	if h.dct {
+		m[metadataSize+len(h.metadata.name)] |= 2
+	}
+	if h.strip {
+		m[metadataSize+len(h.metadata.name)] |= 4
+	}
+	if h.more {
+		m[metadataSize+len(h.metadata.name)] |= 8
+	}
+	if h.tar {
+		m[metadataSize+len(h.metadata.name)] |= 16
+	}
+	return b
+}
+
+// maxStreamLength is the most payload any header may declare, whatever
+// -max-length says. It is beyond any real file and far enough from the int64
+// limit that the offsets and frame counts computed from it cannot overflow.
+const maxStreamLength = 1 << 50
+
+// checkLength refuses a part whose header declares more payload than
+// opts.maxLength allows or than frames, the frames of the video or -1 if
+// unknown, can hold, before any space is allocated for it. Frames holds
+// what the part needs, its trailer included. A partial decode, or one with a
+// parity video, expects the video to be short and only checks the maximum.
+func checkLength(part streamPart, frames, videoFrames int64, opts options) error {
+	if end := part.offset + part.length; opts.maxLength > 0 && end > opts.maxLength {
+		return withCause(ErrCorruptHeader, fmt.Errorf("video declares a payload of %d bytes, more than the %d of -max-length", end, opts.maxLength))
+	}
+	if needed := frames * int64(opts.repeat); videoFrames >= 0 && needed > videoFrames && !opts.partial && opts.parity == "" {
+		return withCause(ErrIncomplete, fmt.Errorf("video declares a payload of %d bytes, which takes %d frames, but has %d", part.offset+part.length, needed, videoFrames))
+	}
+	return nil
+}
+
+// errShortHeader means more of the stream is needed to parse the header.
+var errShortHeader = errors.New("stream header incomplete")
+
+// parseStreamHeader parses the header at the start of prefix.
+func parseStreamHeader(prefix []byte) (*streamHeader, error) {
+	if len(prefix) < legacyHeaderSize {
+		return nil, errShortHeader
+	}
+	if !bytes.Equal(prefix[:len(streamMagic)], streamMagic) {
+		length := int64(binary.BigEndian.Uint64(prefix))
+		if length < 0 || length > maxStreamLength {
+			return nil, withCause(ErrCorruptHeader, errors.New("not a FileToVideo video, or -size, -dot, -modulation, -frame-strip, -interleave or -repeat differ from the ones used to encode it"))
+		}
+		return &streamHeader{version: 0, compat: 0, size: legacyHeaderSize, length: length}, nil
+	}
+
+	if len(prefix) < streamHeaderSize {
+		return nil, errShortHeader
+	}
+	h := &streamHeader{
+		version: int(prefix[4]),
+		compat:  int(prefix[5]),
+		size:    int(binary.BigEndian.Uint16(prefix[6:])),
+		length:  int64(binary.BigEndian.Uint64(prefix[8:])),
+	}
+	if h.compat > formatVersion {
+		return nil, withCause(ErrUnsupportedVersion, fmt.Errorf("video was written in format v%d and needs a reader for v%d or newer, this build reads up to v%d", h.version, h.compat, formatVersion))
+	}
+	if h.size < streamHeaderSize || h.length < 0 || h.length > maxStreamLength {
+		return nil, withCause(ErrCorruptHeader, errors.New("corrupt stream header"))
+	}
+	if len(prefix) < h.size {
+		return nil, errShortHeader
+	}
+
+	if h.version >= 3 {
+		m := prefix[streamHeaderSize:h.size]
+		if len(m) < metadataSize {
+			return nil, withCause(ErrCorruptHeader, errors.New("corrupt stream header"))
+		}
+		nameLength := int(binary.BigEndian.Uint16(m[12:]))
+		if len(m) < metadataSize+nameLength {
+			return nil, withCause(ErrCorruptHeader, errors.New("corrupt stream header"))
+		}
+		h.metadata.mode = os.FileMode(binary.BigEndian.Uint32(m)).Perm()
+		if modTime := int64(binary.BigEndian.Uint64(m[4:])); modTime != 0 {
+			h.metadata.modTime = time.Unix(0, modTime)
+		}
+		h.metadata.name = string(m[metadataSize : metadataSize+nameLength])
+		if h.version >= 5 && len(m) > metadataSize+nameLength {
+			h.delta = m[metadataSize+nameLength]&1 != 0
+			h.dct = m[metadataSize+nameLength]&2 != 0
+			h.strip = m[metadataSize+nameLength]&4 != 0
+			h.more = m[metadataSize+nameLength]&8 != 0
+			h.tar = m[metadataSize+nameLength]&16 != 0
+		}
+	}
+	return h, nil
+}
+
+// marshalPartHeader returns the header of a part appended at offset, more
+// telling whether another one follows.
+func marshalPartHeader(offset, length int64, more bool) []byte {
+	b := make([]byte, partHeaderSize+1)
+	copy(b, partMagic)
+	b[4] = formatVersion
+	b[5] = partCompat
+	binary.BigEndian.PutUint16(b[6:], uint16(len(b)))
+	binary.BigEndian.PutUint64(b[8:], uint64(offset))
+	binary.BigEndian.PutUint64(b[16:], uint64(length))
+	if more {
+		b[partHeaderSize] |= 1
+	}
+	return b
+}
+
+// errNoPart means the frames after a trailer do not start an appended part.
+var errNoPart = errors.New("no appended part")
+
+// parsePartHeader parses the header of an appended part at the start of
+// prefix, filling in size, offset, length and more of part.
+func parsePartHeader(prefix []byte, part *streamPart) error {
+	if len(prefix) < len(partMagic) {
+		return errShortHeader
+	}
+	if !bytes.Equal(prefix[:len(partMagic)], partMagic) {
+		return errNoPart
+	}
+	if len(prefix) < partHeaderSize {
+		return errShortHeader
+	}
+	if version, compat := int(prefix[4]), int(prefix[5]); compat > formatVersion {
+		return withCause(ErrUnsupportedVersion, fmt.Errorf("video was appended to in format v%d and needs a reader for v%d or newer, this build reads up to v%d", version, compat, formatVersion))
+	}
+	part.size = int(binary.BigEndian.Uint16(prefix[6:]))
+	part.offset = int64(binary.BigEndian.Uint64(prefix[8:]))
+	part.length = int64(binary.BigEndian.Uint64(prefix[16:]))
+	if part.size < partHeaderSize || part.offset < 0 || part.length < 0 || part.offset > maxStreamLength-part.length {
+		return withCause(ErrCorruptHeader, errors.New("corrupt header of an appended part"))
+	}
+	if len(prefix) < part.size {
+		return errShortHeader
+	}
+	part.more = part.size > partHeaderSize && prefix[partHeaderSize]&1 != 0
+	return nil
+}
+
+type streamTrailer struct {
+	length int64
+	frame  int
+	hash   [sha256.Size]byte
+}
+
+func (t *streamTrailer) marshal() []byte {
+	b := make([]byte, trailerSize)
+	copy(b, trailerMagic)
+	binary.BigEndian.PutUint64(b[4:], uint64(t.length))
+	binary.BigEndian.PutUint64(b[12:], uint64(t.frame))
+	copy(b[20:], t.hash[:])
+	return b
+}
+
+// parseTrailer recognizes the trailer among the digested frames. A data
+// frame starting with the magic is not taken for it, since it would also
+// have to carry its own frame id.
+func parseTrailer(frame frameData) (*streamTrailer, bool) {
+	t, ok := unmarshalTrailer(frame.value)
+	if !ok || t.frame != frame.frameID {
+		return nil, false
+	}
+	return t, true
+}
+
+// unmarshalTrailer parses the trailer at the start of b, whatever frame it
+// was found in.
+func unmarshalTrailer(b []byte) (*streamTrailer, bool) {
+	if len(b) < trailerSize || !bytes.Equal(b[:len(trailerMagic)], trailerMagic) {
+		return nil, false
+	}
+	t := &streamTrailer{
+		length: int64(binary.BigEndian.Uint64(b[4:])),
+		frame:  int(binary.BigEndian.Uint64(b[12:])),
+	}
+	copy(t.hash[:], b[20:])
+	return t, true
+}
+
+// hashedPayload hashes the payload alongside encode rather than in a pass
+// over it of its own. Tapped, every frame the readers get is copied to the
+// hashing goroutine, which holds back the ones ahead of the next frame to
+// hash: the readers of a single video go through it in order, give or take
+// the reorder window. Segments are read side by side, so with several the
+// goroutine reads the payload itself instead, as the pipeline does.
+type hashedPayload struct {
+	payloadSource
+	tap     chan frameData // nil unless tapped
+	buffers *framePool
+	stop    chan struct{}
+	done    chan struct{}
+	sum     [sha256.Size]byte
+	err     error
+}
+
+// newHashedPayload starts hashing the payload of source, whose header of
+// headerSize bytes must already be set. An appended part is hashed after
+// previous, the hash of the payload before it. close must be called once
+// encode is over.
+func newHashedPayload(source payloadSource, tapped bool, capacity, headerSize, frames int, previous []byte) *hashedPayload {
+	h := &hashedPayload{payloadSource: source, stop: make(chan struct{}), done: make(chan struct{})}
+	if tapped {
+		h.tap = make(chan frameData)
+		h.buffers = newFramePool(capacity)
+	}
+	go func() {
+		defer close(h.done)
+		start := time.Now()
+		h.sum, h.err = h.run(headerSize, frames, previous)
+		if h.err == nil {
+			logger.verbose("reader", "payload hashed", fields{"elapsed": time.Since(start), "tapped": tapped})
+		}
+	}()
+	return h
+}
+
+func (h *hashedPayload) frame(id int) ([]byte, error) {
+	frame, err := h.payloadSource.frame(id)
+	if err != nil || h.tap == nil {
+		return frame, err
+	}
+	data := h.buffers.get()[:len(frame)]
+	copy(data, frame)
+	select {
+	case h.tap <- frameData{frameID: id, value: data}:
+	case <-h.stop:
+	}
+	return frame, nil
+}
+
+func (h *hashedPayload) run(headerSize, frames int, previous []byte) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	hash := sha256.New()
+	hash.Write(previous)
+	held := map[int][]byte{}
+	skip := headerSize
+	for id := 0; id < frames; id++ {
+		var frame []byte
+		if h.tap != nil {
+			for frame == nil {
+				if frame = held[id]; frame != nil {
+					delete(held, id)
+					break
+				}
+				select {
+				case tapped := <-h.tap:
+					held[tapped.frameID] = tapped.value
+				case <-h.stop:
+					return sum, errors.New("encoding stopped")
+				}
+			}
+		} else {
+			select {
+			case <-h.stop:
+				return sum, errors.New("encoding stopped")
+			default:
+			}
+			var err error
+			if frame, err = h.payloadSource.frame(id); err != nil {
+				return sum, err
+			}
+		}
+		data := frame
+		if skip > 0 {
+			n := skip
+			if n > len(data) {
+				n = len(data)
+			}
+			data, skip = data[n:], skip-n
+		}
+		hash.Write(data)
+		if h.tap != nil {
+			h.buffers.put(frame)
+		} else {
+			h.payloadSource.release(frame)
+		}
+	}
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
+
+// hash waits until the whole payload is hashed.
+func (h *hashedPayload) hash(ctx context.Context) ([sha256.Size]byte, error) {
+	select {
+	case <-h.done:
+		return h.sum, h.err
+	case <-ctx.Done():
+		return h.sum, ctx.Err()
+	}
+}
+
+// close stops hashing and waits for the goroutine, which must be done with
+// the payload before it is closed.
+func (h *hashedPayload) close() {
+	close(h.stop)
+	<-h.done
+}
+
+// hashFile returns the hash the trailer of the last of parts carries for the
+// payload written to file: the SHA-256 of the first part, chained through
+// the ones appended to it.
+func hashFile(file *os.File, parts []streamPart) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	for i, part := range parts {
+		hash := sha256.New()
+		if i > 0 {
+			hash.Write(sum[:])
+		}
+		if _, err := io.Copy(hash, io.NewSectionReader(file, part.offset, part.length)); err != nil {
+			return sum, err
+		}
+		copy(sum[:], hash.Sum(nil))
+	}
+	return sum, nil
+}
+
+// streamLayout maps frames to the stream on encode.
+type streamLayout struct {
+	header   []byte
+	length   int64 // Of the payload
+	capacity int
+}
+
+func (l *streamLayout) size() int64 { return l.length }
+
+func (l *streamLayout) setHeader(header []byte) { l.header = header }
+
+// bounds returns the part of the header and the payload range carried by
+// frame id.
+func (l *streamLayout) bounds(id int) (header []byte, start, end int64) {
+	frameStart := int64(id) * int64(l.capacity)
+	frameEnd := frameStart + int64(l.capacity)
+	headerSize := int64(len(l.header))
+	if frameStart < headerSize {
+		headerEnd := headerSize
+		if frameEnd < headerEnd {
+			headerEnd = frameEnd
+		}
+		header = l.header[frameStart:headerEnd]
+	}
+
+	start, end = frameStart-headerSize, frameEnd-headerSize
+	if start < 0 {
+		start = 0
+	}
+	if end > l.length {
+		end = l.length
+	}
+	if end < start {
+		end = start
+	}
+	return header, start, end
+}
+
+// streamFrames returns how many frames a stream of streamLength bytes fills.
+func streamFrames(streamLength int64, capacity int) int64 {
+	c := int64(capacity)
+	return (streamLength + c - 1) / c
+}
+
+// payloadOutput is where streamWriter puts the payload: a file, or the
+// stream of NewReader.
+type payloadOutput interface {
+	io.WriterAt
+
+	// sum returns the hash the trailer of the last of parts carries for the
+	// payload written
+	sum(parts []streamPart) ([sha256.Size]byte, error)
+}
+
+type fileOutput struct{ *os.File }
+
+func (f fileOutput) sum(parts []streamPart) ([sha256.Size]byte, error) {
+	return hashFile(f.File, parts)
+}
+
+// streamWriter writes the decoded stream to the output. Where the payload
+// goes depends on the header of the part it belongs to, so chunks are held
+// back until that header has been read.
+type streamWriter struct {
+	out      payloadOutput
+	capacity int // Of a frame
+	depth    int // Interleave depth, appended parts start on a block
+	onPart   func(header *streamHeader, part streamPart) error
+	manifest *manifest // From -manifest, whose header replaces the one read
+
+	mu      sync.Mutex
+	header  *streamHeader // Of the first part
+	parts   []streamPart
+	next    int64 // Stream offset the next part would start at
+	ended   bool  // Nothing follows the last part
+	trailer *streamTrailer
+	prefix  []byte // Start of the next part, collected until its header is complete
+	pending []streamChunk
+	written []byteRange // Of the stream
+}
+
+// setTrailer records trailer, unless one further into the video is known.
+// Every appended part adds one.
+func (w *streamWriter) setTrailer(trailer *streamTrailer) {
+	w.mu.Lock()
+	if w.trailer == nil || trailer.frame > w.trailer.frame {
+		w.trailer = trailer
+	}
+	w.mu.Unlock()
+}
+
+// complete reports whether the stream is known to end with the last part
+// found, whatever frames follow it.
+func (w *streamWriter) complete() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ended
+}
+
+// newStreamWriter returns a writer of the stream of frames of capacity
+// bytes to out. onPart is called with the header of the video as each part
+// is found.
+func newStreamWriter(out payloadOutput, capacity, depth int, onPart func(*streamHeader, streamPart) error) *streamWriter {
+	return &streamWriter{out: out, capacity: capacity, depth: depth, onPart: onPart}
+}
+
+func (w *streamWriter) write(chunk streamChunk) error {
+	w.mu.Lock()
+	w.written = append(w.written, byteRange{Offset: chunk.offset, Length: int64(len(chunk.value))})
+	for _, part := range w.parts {
+		if chunk.offset >= part.start && chunk.offset < part.end(w.capacity) {
+			w.mu.Unlock()
+			return w.writeChunk(part, chunk)
+		}
+	}
+	defer w.mu.Unlock()
+	if w.ended || chunk.offset < w.next {
+		return nil // Frames after the stream, or a damaged copy of a trailer
+	}
+
+	// The caller reuses the buffer once write returns
+	chunk.value = append([]byte(nil), chunk.value...)
+	w.pending = append(w.pending, chunk)
+	for {
+		for extended := true; extended; {
+			extended = false
+			for _, c := range w.pending {
+				if c.offset == w.next+int64(len(w.prefix)) {
+					w.prefix = append(w.prefix, c.value...)
+					extended = true
+				}
+			}
+		}
+
+		part, err := w.parsePart()
+		if err == errShortHeader {
+			return nil
+		}
+		if err == errNoPart {
+			// Whatever follows the last trailer, it is not an appended part
+			w.ended = true
+			w.pending, w.prefix = nil, nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.onPart(w.header, part); err != nil {
+			return err
+		}
+		w.parts = append(w.parts, part)
+		trailer := part.first + part.frames
+		w.next = interleavedFrames(int64(trailer)+1, w.depth) * int64(w.capacity)
+		if w.header.version < 2 {
+			w.ended = true // Only videos with a trailer can be appended to
+		}
+
+		held := w.pending
+		w.pending, w.prefix = nil, nil
+		for _, c := range held {
+			switch {
+			case c.offset >= part.start && c.offset < part.end(w.capacity):
+				if err := w.writeChunk(part, c); err != nil {
+					return err
+				}
+			case c.offset >= w.next && !w.ended:
+				w.pending = append(w.pending, c)
+			}
+		}
+		if len(w.pending) == 0 {
+			return nil
+		}
+	}
+}
+
+// parsePart parses the header at the start of the next part. The first part
+// starts with the header of the video, the ones appended with their own.
+func (w *streamWriter) parsePart() (streamPart, error) {
+	part := streamPart{first: int(w.next / int64(w.capacity)), start: w.next}
+	if len(w.parts) == 0 {
+		if w.manifest != nil {
+			if len(w.prefix) < len(w.manifest.Header) {
+				return part, errShortHeader
+			}
+			if w.manifest.repairHeader(w.prefix) {
+				logger.info("writer", "stream header damaged, using the one of the manifest", nil)
+			}
+		}
+		header, err := parseStreamHeader(w.prefix)
+		if err != nil {
+			return part, err
+		}
+		if len(w.prefix) < header.size {
+			return part, errShortHeader
+		}
+		w.header = header
+		part.size, part.length = header.size, header.length
+	} else {
+		if err := parsePartHeader(w.prefix, &part); err != nil {
+			return part, err
+		}
+		last := w.parts[len(w.parts)-1]
+		if part.offset != last.offset+last.length {
+			return part, fmt.Errorf("appended part starts at byte %d of the payload but the parts before it end at %d", part.offset, last.offset+last.length)
+		}
+	}
+	frames := streamFrames(int64(part.size)+part.length, w.capacity)
+	part.frames = int(interleavedFrames(frames, w.depth))
+	return part, nil
+}
+
+// writeChunk writes the payload chunk carries for part.
+func (w *streamWriter) writeChunk(part streamPart, chunk streamChunk) error {
+	offset := chunk.offset - part.start - int64(part.size)
+	value := chunk.value
+	if offset < 0 {
+		if -offset >= int64(len(value)) {
+			return nil // Header only
+		}
+		value = value[-offset:]
+		offset = 0
+	}
+	// The padding at the end of a part would overwrite the next one
+	if offset >= part.length {
+		return nil
+	}
+	if rest := part.length - offset; int64(len(value)) > rest {
+		value = value[:rest]
+	}
+	_, err := w.out.WriteAt(value, part.offset+offset)
+	return err
+}
+
+// result returns the header once everything has been written, with the
+// parts found and the length of all of them.
+func (w *streamWriter) result() (*streamHeader, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.header == nil {
+		if len(w.pending) == 0 {
+			return nil, withCause(ErrIncomplete, errors.New("video contains no frames"))
+		}
+		if _, err := parseStreamHeader(w.prefix); err != nil && err != errShortHeader {
+			return nil, err
+		}
+		return nil, withCause(ErrIncomplete, errors.New("video ends before the end of the stream header"))
+	}
+	header := *w.header
+	header.parts = append([]streamPart(nil), w.parts...)
+	last := w.parts[len(w.parts)-1]
+	header.length = last.offset + last.length
+	return &header, nil
+}
+
+// missing returns the ranges of the payload no frame was written to.
+func (w *streamWriter) missing(header *streamHeader) []byteRange {
+	w.mu.Lock()
+	written := append([]byteRange(nil), w.written...)
+	w.mu.Unlock()
+	sort.Slice(written, func(i, j int) bool { return written[i].Offset < written[j].Offset })
+
+	var missing []byteRange
+	for _, part := range header.payloadParts() {
+		next := part.start + int64(part.size) // Stream offset everything before is written
+		end := next + part.length
+		for _, r := range written {
+			if r.Offset >= end {
+				break
+			}
+			if r.Offset > next {
+				missing = append(missing, byteRange{Offset: next, Length: r.Offset - next})
+			}
+			if r.Offset+r.Length > next {
+				next = r.Offset + r.Length
+			}
+		}
+		if next < end {
+			missing = append(missing, byteRange{Offset: next, Length: end - next})
+		}
+	}
+	for i := range missing {
+		missing[i] = clipToPayload(missing[i], header)
+	}
+	return missing
+}
+
+// clipToPayload turns a range of the stream into one of the payload. The
+// range must not span several parts.
+func clipToPayload(r byteRange, header *streamHeader) byteRange {
+	for _, part := range header.payloadParts() {
+		payloadStart := part.start + int64(part.size)
+		if r.Offset+r.Length <= part.start || r.Offset >= payloadStart+part.length {
+			continue
+		}
+		start, end := r.Offset-payloadStart, r.Offset+r.Length-payloadStart
+		if start < 0 {
+			start = 0
+		}
+		if end > part.length {
+			end = part.length
+		}
+		if end < start {
+			end = start
+		}
+		return byteRange{Offset: part.offset + start, Length: end - start}
+	}
+	return byteRange{}
+}
+
+// trailerHash returns the hash of the payload in the trailer, if the trailer
+// of the whole stream was read.
+func (w *streamWriter) trailerHash(header *streamHeader) (string, bool) {
+	w.mu.Lock()
+	trailer := w.trailer
+	w.mu.Unlock()
+	if header.version < 2 || trailer == nil || trailer.length != header.length {
+		return "", false
+	}
+	return hex.EncodeToString(trailer.hash[:]), true
+}
+
+// verify checks the written payload against the trailer. Videos from before
+// v2 have none.
+func (w *streamWriter) verify(header *streamHeader) error {
+	w.mu.Lock()
+	trailer := w.trailer
+	w.mu.Unlock()
+	if header.version < 2 {
+		return nil
+	}
+	parts := header.payloadParts()
+	last := parts[len(parts)-1]
+	if trailer == nil || trailer.frame < last.first+last.frames {
+		return withCause(ErrIncomplete, errors.New("video is incomplete, the end of data trailer is missing"))
+	}
+	if trailer.length != header.length {
+		return fmt.Errorf("trailer declares %d bytes but the header %d", trailer.length, header.length)
+	}
+	sum, err := w.out.sum(parts)
+	if err != nil {
+		return err
+	}
+	if sum != trailer.hash {
+		return withCause(ErrHashMismatch, errors.New("decoded payload does not match the hash in the trailer"))
+	}
+	return nil
+}
+
+// originalName returns the stored name of the file, refusing anything that
+// would place it outside the current directory.
+func originalName(metadata fileMetadata) (string, error) {
+	name := metadata.name
+	if name == "" {
+		return "", errors.New("video does not record the original file name, use -o")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("video records an unsafe file name %q, use -o", name)
+	}
+	return name, nil
+}
+
+// renameNew moves the file at from to to, unless to already exists.
+func renameNew(from, to string) error {
+	if err := os.Link(from, to); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, use -o or -force", to)
+		}
+		if _, statErr := os.Lstat(to); statErr == nil {
+			return fmt.Errorf("%s already exists, use -o or -force", to)
+		}
+		// Hard links are not supported everywhere
+		return os.Rename(from, to)
+	}
+	return os.Remove(from)
+}
+
+// restoreMetadata applies the stored mode bits and modification time to the
+// file at path, leaving whatever the video does not record.
+func restoreMetadata(path string, metadata fileMetadata) error {
+	if metadata.mode != 0 {
+		if err := os.Chmod(path, metadata.mode); err != nil {
+			return err
+		}
+	}
+	if !metadata.modTime.IsZero() {
+		if err := os.Chtimes(path, metadata.modTime, metadata.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
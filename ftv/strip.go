@@ -0,0 +1,111 @@
+package ftv
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// With -frame-strip the top rows of every frame are reserved for a strip
+// telling which frame it is, independently of the order ffmpeg delivers
+// frames in: the frame index, the stream offset of its data and a CRC of
+// index, offset and data. Every bit of the strip is a black or white band
+// over the full height of the strip, read back as the average of hundreds of
+// pixels, so the strip survives damage well beyond what the data does.
+const (
+	stripRecordSize = 16 // Index, offset and CRC
+	stripBits       = stripRecordSize * 8
+	stripMinHeight  = 16 // Pixels, rounded up to a whole row of dots
+	stripMinBand    = 4  // Narrowest band of a bit in pixels
+)
+
+// stripHeight returns how many rows of pixels the strip of opts takes, 0
+// without a strip.
+func stripHeight(opts options) int {
+	if !opts.frameStrip {
+		return 0
+	}
+	unit := opts.dotSize
+	if opts.modulation == modulationDCT {
+		unit = dctBlock
+	}
+	return (stripMinHeight + unit - 1) / unit * unit
+}
+
+type frameStrip struct {
+	index  int
+	offset int64 // Of the frame's data, or its interleaved block, in the stream
+	crc    uint32
+}
+
+// stripOffset returns the stream offset the strip of frame index carries.
+func stripOffset(index, capacity, depth int) int64 {
+	return int64(index/depth*depth) * int64(capacity)
+}
+
+// newFrameStrip returns the strip of frame index carrying data.
+func newFrameStrip(index int, data []byte, capacity, depth int) frameStrip {
+	s := frameStrip{index: index, offset: stripOffset(index, capacity, depth)}
+	s.crc = s.checksum(data)
+	return s
+}
+
+func (s frameStrip) checksum(data []byte) uint32 {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[0:], uint32(s.index))
+	binary.BigEndian.PutUint64(b[4:], uint64(s.offset))
+	return crc32.Update(crc32.ChecksumIEEE(b[:]), crc32.IEEETable, data)
+}
+
+// matches reports whether data is what the frame of the strip carried.
+func (s frameStrip) matches(data []byte) bool { return s.checksum(data) == s.crc }
+
+// stripBand returns the width in pixels of the band of a bit.
+func (g frameGeometry) stripBand() int { return g.width / stripBits }
+
+// writeStrip paints s over the strip of the RGBA frame pixelData.
+func writeStrip(s frameStrip, pixelData []byte, g frameGeometry) {
+	var record [stripRecordSize]byte
+	binary.BigEndian.PutUint32(record[0:], uint32(s.index))
+	binary.BigEndian.PutUint64(record[4:], uint64(s.offset))
+	binary.BigEndian.PutUint32(record[12:], s.crc)
+
+	band := g.stripBand()
+	for line := 0; line < g.top; line++ {
+		for column := 0; column < g.width; column++ {
+			var value byte
+			if bit := column / band; bit < stripBits && record[bit/8]&(0x80>>(bit%8)) != 0 {
+				value = 0xff
+			}
+			pixelCoords := (line*g.width + column) * 4 // 4 channels
+			pixelData[pixelCoords], pixelData[pixelCoords+1], pixelData[pixelCoords+2] = value, value, value
+		}
+	}
+}
+
+// readStrip reads the strip of the RGB frame copies. The edges of every band
+// are left out, they blur into the neighbouring bands.
+func readStrip(copies [][]byte, g frameGeometry) frameStrip {
+	var record [stripRecordSize]byte
+	band := g.stripBand()
+	margin := band / 4
+	for bit := 0; bit < stripBits; bit++ {
+		sum, count := 0, 0
+		for _, c := range copies {
+			for line := 1; line < g.top-1; line++ {
+				for column := bit*band + margin; column < (bit+1)*band-margin; column++ {
+					pixelCoords := (line*g.width + column) * 3
+					sum += int(c[pixelCoords]) + int(c[pixelCoords+1]) + int(c[pixelCoords+2])
+					count += 3
+				}
+			}
+		}
+		if sum*2 > count*0xff {
+			record[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	return frameStrip{
+		index:  int(binary.BigEndian.Uint32(record[0:])),
+		offset: int64(binary.BigEndian.Uint64(record[4:])),
+		crc:    binary.BigEndian.Uint32(record[12:]),
+	}
+}
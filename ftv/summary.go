@@ -0,0 +1,69 @@
+package ftv
+
+import (
+	"math"
+	"time"
+)
+
+// runSummary is logged once an encode or decode succeeded. Besides what the
+// run produced it tells how efficiently the payload fits the video and which
+// stage of the pipeline held the others back.
+type runSummary struct {
+	mode    string
+	payload int64             // Bytes
+	frames  int64             // Of the stream, the copies -repeat writes not counted
+	start   time.Time         // Of the run
+	stages  []stageThroughput // Empty if the stages were not followed
+
+	// Decode only, what the ECC repaired and what it could not
+	corrected       int64
+	failedCodewords int64
+}
+
+func (s runSummary) log(opts options) {
+	elapsed := time.Since(s.start)
+	videoFrames := s.frames * int64(opts.repeat)
+	duration := time.Duration(float64(videoFrames) / float64(opts.fps) * float64(time.Second))
+	f := fields{
+		"payload_bytes":  s.payload,
+		"frames":         videoFrames,
+		"video_duration": duration.Round(time.Millisecond),
+		"elapsed":        elapsed.Round(time.Millisecond),
+	}
+	if duration > 0 {
+		f["mb_per_video_minute"] = round2(float64(s.payload) / 1e6 / duration.Minutes())
+	}
+	if elapsed > 0 {
+		f["mb_per_second"] = round2(float64(s.payload) / 1e6 / elapsed.Seconds())
+	}
+	logger.info("summary", s.mode+" summary", f)
+
+	for _, stage := range s.stages {
+		logger.info("summary", stage.stage+" throughput", fields{
+			"frames":            stage.frames,
+			"active":            stage.active.Round(time.Millisecond),
+			"frames_per_second": round2(stage.perSecond()),
+		})
+	}
+
+	ecc := opts.frameECC()
+	if ecc == nil {
+		return
+	}
+	f = fields{
+		"parity_bytes":        ecc.parity,
+		"codewords_per_frame": ecc.blocks,
+		"overhead_percent":    round2(100 * float64(ecc.parity) / float64(ecc.length)),
+	}
+	if opts.eccHamming {
+		f["code"] = "hamming"
+	}
+	if s.mode == "decode" {
+		f["corrected_bytes"] = s.corrected
+		f["failed_codewords"] = s.failedCodewords
+	}
+	logger.info("summary", "error correction", f)
+}
+
+// round2 rounds v to two decimals, which is all a summary needs.
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
@@ -0,0 +1,92 @@
+package ftv
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Payload formats. A tar payload is stored as is, but is checked to be a
+// tar archive on the way in and marked so in the stream header, which lets
+// `tar cf - dir | FileToVideo -i - -format tar` and
+// `FileToVideo -d -format tar -o - | tar xf -` carry a whole directory
+// without a temporary file on either side.
+const (
+	payloadRaw = "raw"
+	payloadTar = "tar"
+)
+
+// tarEntry is a regular file in a tar archive.
+type tarEntry struct {
+	name   string
+	offset int64 // Of its data in the archive
+}
+
+// tarStream passes r through as it is read, failing the read that reaches a
+// part of it that does not belong in a tar archive. Closing it stops
+// reading r. onEntry, unless nil, is called with every regular file as its
+// header is read.
+func tarStream(r io.Reader, onEntry func(tarEntry)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tee := &countingReader{r: io.TeeReader(r, pw)}
+		archive := tar.NewReader(tee)
+		entries := 0
+		for {
+			header, err := archive.Next()
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				// The reader has taken the header blocks and nothing more
+				if onEntry != nil && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse) {
+					onEntry(tarEntry{name: header.Name, offset: tee.n})
+				}
+				_, err = io.Copy(io.Discard, archive)
+			}
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("input is not a tar archive: %w", err))
+				return
+			}
+			entries++
+		}
+		// What follows the end of the archive pads its last record
+		if _, err := io.Copy(io.Discard, tee); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		logger.verbose("reader", "tar archive read", fields{"entries": entries})
+		pw.Close()
+	}()
+	return pr
+}
+
+// checkTarFile fails unless the file at path is a tar archive, and returns
+// the regular files in it.
+func checkTarFile(path string) ([]tarEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []tarEntry
+	archive := tarStream(file, func(entry tarEntry) { entries = append(entries, entry) })
+	defer archive.Close()
+	if _, err = io.Copy(io.Discard, archive); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,55 @@
+package ftv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// throttle spaces out the frames of a run so that no more than a given
+// number per second go through the pipeline, shared by all the workers of a
+// stage. A nil throttle lets everything through.
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newThrottle(framesPerSecond float64) *throttle {
+	if framesPerSecond <= 0 {
+		return nil
+	}
+	return &throttle{interval: time.Duration(float64(time.Second) / framesPerSecond)}
+}
+
+// wait blocks until the next frame may go, false if ctx is done first. No
+// frame goes while the pipelines are paused.
+func (t *throttle) wait(ctx context.Context) bool {
+	if !pipelinePause.wait(ctx) {
+		return false
+	}
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now // Time spent idle is not saved up for a burst
+	}
+	at := t.next
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,259 @@
+package ftv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// The pipeline hands frames to a frameSink when encoding and takes them from
+// a frameSource when decoding, a videoTransport opens both. ffmpeg is the one
+// transport for real videos, images.go stores the frames as pictures and
+// y4m.go as a raw stream for other encoders. inmemory.go keeps them in
+// memory for the tests.
+//
+// Frames are raw pixels in the size of the options, RGBA going into a sink
+// and RGB coming out of a source.
+
+// frameSink writes the frames of a video.
+type frameSink interface {
+	// WriteFrame appends a frame to the video. The caller may reuse pixels
+	// once it returns.
+	WriteFrame(pixels []byte) error
+
+	// Close finishes the video. After a failed WriteFrame it reports why the
+	// sink failed if it knows better.
+	Close() error
+}
+
+// frameSource reads the frames of a video.
+type frameSource interface {
+	// ReadFrame fills pixels with the next frame. It returns io.EOF at the
+	// end of the video, which a frame cut short also counts as.
+	ReadFrame(pixels []byte) error
+
+	// Close releases the video. Closed before the end, the source was not
+	// needed anymore and only reports errors of its own.
+	Close() error
+}
+
+// videoTransport opens the videos of encode and decode.
+type videoTransport interface {
+	NewSink(ctx context.Context, dest string, opts options) (frameSink, error)
+	NewSource(ctx context.Context, src string, opts options) (frameSource, error)
+}
+
+// frameCounter is a videoTransport that can tell how many frames a video has
+// without reading them. ffmpeg would have to demux the whole video, so only
+// image sequences can.
+type frameCounter interface {
+	countFrames(src string) (int64, error)
+}
+
+// videoFrames returns the frames of the videos in srcs, or -1 if the
+// transport of opts cannot count them.
+func videoFrames(srcs []string, opts options) (int64, error) {
+	counter, ok := transportOf(opts).(frameCounter)
+	if !ok {
+		return -1, nil
+	}
+	var total int64
+	for _, src := range srcs {
+		frames, err := counter.countFrames(src)
+		if err != nil {
+			return 0, err
+		}
+		total += frames
+	}
+	return total, nil
+}
+
+const (
+	transportFFmpeg = "ffmpeg"
+	transportImages = "images"
+	transportY4M    = "y4m"
+)
+
+// transportOf returns the transport opts asks for. Sinks of the same
+// transport share what it found out about the system.
+func transportOf(opts options) videoTransport {
+	if opts.loopback != nil {
+		return opts.loopback
+	}
+	switch opts.transport {
+	case transportImages:
+		return imageTransport{}
+	case transportY4M:
+		return y4mTransport{}
+	case transportMemory:
+		return memoryTransport{}
+	}
+	return &ffmpegTransport{}
+}
+
+// ffmpegTransport runs an ffmpeg process for every video.
+type ffmpegTransport struct {
+	once    sync.Once
+	encoder *videoEncoder // Picked for the first sink, probing takes a while
+	err     error
+}
+
+func (t *ffmpegTransport) NewSink(ctx context.Context, dest string, opts options) (frameSink, error) {
+	t.once.Do(func() {
+		opts.codec = containerCodec(dest, opts)
+		t.encoder, t.err = newVideoEncoder(ctx, opts)
+	})
+	if t.err != nil {
+		return nil, t.err
+	}
+	cmd := t.encoder.command(ctx, opts, dest)
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		stderr.Close()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, ffmpegError(err)
+	}
+	return &ffmpegSink{cmd: cmd, stdin: stdin, stderr: stderr}, nil
+}
+
+func (*ffmpegTransport) NewSource(ctx context.Context, src string, opts options) (frameSource, error) {
+	source, err := ffmpegSource(src)
+	if err != nil {
+		return nil, err
+	}
+	ctx, stop := context.WithCancel(ctx)
+	var inputArgs []string
+	if opts.live && !isURL(source) {
+		inputArgs = []string{"-follow", "1"} // Keep reading as the file grows
+	}
+	if opts.seek > 0 {
+		// Before the input it seeks to the keyframe ahead and decodes on
+		// from there, the frames before the time are dropped
+		inputArgs = append(inputArgs, "-ss", strconv.FormatFloat(opts.seek, 'f', -1, 64))
+	}
+	filters := decodeFilter
+	if opts.sourceFilter != "" {
+		filters = opts.sourceFilter + "," + filters
+	}
+	args := append(inputArgs,
+		"-i", source,
+		"-vsync", "passthrough", // Never duplicate or drop frames, segment joins may have odd timestamps
+		"-sws_flags", scalerFlags, // Upsampled chroma must not blend neighbouring dots
+		"-vf", filters,
+		"-f", "rawvideo",
+		"-preset", "fast",
+		"-b:v", "100M",
+		"-an",
+	)
+	args = append(args, opts.ffmpegArgs...)
+	cmd := ffmpegCommand(ctx, opts.ffmpegPath, append(args, "-")...)
+	if opts.nice {
+		lowerPriority(cmd)
+	}
+	stderr := logger.writer("ffmpeg", levelVerbose)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stderr.Close()
+		stop()
+		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		stop()
+		return nil, ffmpegError(fmt.Errorf("starting command: %w", err))
+	}
+	// Small frames are read several at a time rather than a syscall or more
+	// each, large ones go straight from the pipe into the frame
+	return &ffmpegFrameSource{cmd: cmd, stdout: bufio.NewReaderSize(stdout, ffmpegReadBuffer), stderr: stderr, ctx: ctx, stop: stop}, nil
+}
+
+type ffmpegSink struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *lineWriter
+}
+
+func (s *ffmpegSink) WriteFrame(pixels []byte) error {
+	_, err := s.stdin.Write(pixels)
+	return err
+}
+
+// Close waits for ffmpeg to finish the video. If ffmpeg died its exit
+// status explains a failed write better than the broken pipe does.
+func (s *ffmpegSink) Close() error {
+	closeErr := s.stdin.Close()
+	err := s.cmd.Wait()
+	s.stderr.Close()
+	if err != nil {
+		return ffmpegExitError(fmt.Errorf("waiting for command to finish: %w", err), s.stderr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closing stdin: %w", closeErr)
+	}
+	return nil
+}
+
+// ffmpegReadBuffer is the buffer ffmpeg's output is read through.
+const ffmpegReadBuffer = 1 << 20
+
+type ffmpegFrameSource struct {
+	cmd     *exec.Cmd
+	stdout  io.Reader
+	stderr  *lineWriter
+	ctx     context.Context
+	stop    context.CancelFunc
+	ended   bool  // ffmpeg got to the end of its output
+	readErr error // Reading the output failed short of its end
+}
+
+func (s *ffmpegFrameSource) ReadFrame(pixels []byte) error {
+	n, err := io.ReadFull(s.stdout, pixels)
+	switch {
+	case err == nil:
+		return nil
+	case err == io.ErrUnexpectedEOF:
+		// ffmpeg only writes whole frames, unless it died or was killed.
+		// Either way there is no more of the video.
+		if s.ctx.Err() == nil {
+			logger.info("ffmpeg", "output ends in the middle of a frame, which is dropped", fields{"bytes": n, "frame_bytes": len(pixels)})
+		}
+		fallthrough
+	case err == io.EOF:
+		s.ended = true
+		return io.EOF
+	}
+	s.readErr = fmt.Errorf("reading from command output: %w", err)
+	return s.readErr
+}
+
+// Close kills ffmpeg if it is still going, a live source for instance would
+// have it wait for more.
+func (s *ffmpegFrameSource) Close() error {
+	if !s.ended {
+		s.stop()
+	}
+	err := s.cmd.Wait()
+	s.stderr.Close()
+	s.stop()
+	if s.readErr != nil {
+		// What ffmpeg said last may tell why its output broke off
+		if tail := s.stderr.tail(); tail != "" {
+			return ffmpegError(fmt.Errorf("%w: %s", s.readErr, tail))
+		}
+		return ffmpegError(s.readErr)
+	}
+	if err != nil && s.ended {
+		return ffmpegExitError(fmt.Errorf("waiting for command to finish: %w", err), s.stderr)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package ftv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard is the -tui view of a single run: a block of lines on a terminal,
+// redrawn in place, with the frames every stage handled and how fast, the
+// frames queued in front of it, the last status line of ffmpeg, an ETA and
+// the latest events. The bottleneck is the stage with a full queue in front
+// of it while the queues after it run empty.
+type dashboard struct {
+	mu      sync.Mutex
+	out     io.Writer
+	title   string
+	stages  []string
+	done    map[string]int64
+	total   map[string]int64
+	rates   map[string]*stageRate
+	queues  map[string]queueGauge
+	ffmpeg  string
+	events  []string
+	start   time.Time
+	drawn   int // Lines drawn last, which the next redraw goes back up over
+	logs    bytes.Buffer
+	logOut  io.Writer
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+const (
+	dashboardRefresh = 250 * time.Millisecond
+	dashboardEvents  = 5 // How many of the latest events are shown
+)
+
+// stageRate smooths the frames per second of a stage over the last few
+// redraws.
+type stageRate struct {
+	frames int64
+	at     time.Time
+	perSec float64
+}
+
+// isTerminal reports whether file is a terminal rather than a file or pipe.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newDashboard starts drawing the dashboard on out and sets opts up to feed
+// it. The log is held back while it is shown and printed below it once
+// close is called.
+func newDashboard(out io.Writer, title string, opts *options, stages ...string) *dashboard {
+	d := &dashboard{
+		out:     out,
+		title:   title,
+		stages:  stages,
+		done:    map[string]int64{},
+		total:   map[string]int64{},
+		rates:   map[string]*stageRate{},
+		queues:  map[string]queueGauge{},
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, stage := range stages {
+		d.total[stage] = -1
+		d.rates[stage] = &stageRate{at: d.start}
+	}
+
+	next := opts.onProgress
+	opts.onProgress = func(stage string, done, total int64) {
+		d.mu.Lock()
+		d.done[stage], d.total[stage] = done, total
+		d.mu.Unlock()
+		if next != nil {
+			next(stage, done, total)
+		}
+	}
+	opts.onQueues = func(queues []queueGauge) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, q := range queues {
+			d.queues[q.stage] = q
+		}
+	}
+
+	logger.mu.Lock()
+	d.logOut = logger.out
+	logger.out = &d.logs
+	logger.tap = d.event
+	logger.mu.Unlock()
+
+	go d.loop()
+	return d
+}
+
+// ffmpegTrouble are words in the lines of ffmpeg that are worth showing
+// among the events, unlike its banner and stream listing.
+var ffmpegTrouble = []string{"error", "warning", "invalid", "corrupt", "missing"}
+
+// event keeps the status lines of ffmpeg and the events worth seeing apart
+// from the per-frame ones.
+func (d *dashboard) event(level logLevel, stage, msg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if stage == "ffmpeg" && (strings.HasPrefix(msg, "frame=") || strings.HasPrefix(msg, "size=")) {
+		d.ffmpeg = msg
+		return
+	}
+	if level > levelInfo {
+		// Only the output of ffmpeg has anything to show at these levels
+		trouble := false
+		for _, word := range ffmpegTrouble {
+			trouble = trouble || stage == "ffmpeg" && strings.Contains(strings.ToLower(msg), word)
+		}
+		if !trouble {
+			return
+		}
+	}
+	if level == levelError {
+		msg = "Error: " + msg
+	}
+	if len(d.events) == dashboardEvents {
+		d.events = d.events[1:]
+	}
+	d.events = append(d.events, time.Now().Format("15:04:05")+" "+stage+": "+msg)
+}
+
+func (d *dashboard) loop() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ticker.C:
+		case <-d.stop:
+			d.draw()
+			return
+		}
+	}
+}
+
+// close draws the dashboard a last time, leaves it on the terminal and
+// prints the log held back while it was shown.
+func (d *dashboard) close() {
+	close(d.stop)
+	<-d.stopped
+
+	logger.mu.Lock()
+	logger.out = d.logOut
+	logger.tap = nil
+	logs := d.logs.Bytes()
+	logger.mu.Unlock()
+	d.logOut.Write(logs)
+}
+
+func (d *dashboard) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(d.start)
+
+	var sb strings.Builder
+	if d.drawn > 0 {
+		fmt.Fprintf(&sb, "\x1b[%dA", d.drawn)
+	}
+	line := func(format string, args ...interface{}) {
+		sb.WriteString("\r\x1b[K")
+		fmt.Fprintf(&sb, format, args...)
+		sb.WriteByte('\n')
+	}
+
+	last := d.stages[len(d.stages)-1]
+	eta := "unknown"
+	if total, done := d.total[last], d.done[last]; total >= 0 && done >= total {
+		eta = "done"
+	} else if rate := d.rates[last].perSec; total >= 0 && rate > 0 {
+		eta = time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	line("%s  elapsed %s  ETA %s", d.title, elapsed.Round(time.Second), eta)
+	line("%-12s %17s %10s %12s", "stage", "frames", "frames/s", "queued")
+	for _, stage := range d.stages {
+		d.rates[stage].update(d.done[stage], now)
+		frames := fmt.Sprint(d.done[stage])
+		if d.total[stage] >= 0 {
+			frames += "/" + fmt.Sprint(d.total[stage])
+		}
+		queued := "-"
+		if q, ok := d.queues[stage]; ok {
+			queued = fmt.Sprintf("%d/%d", q.length(), q.capacity)
+		}
+		line("%-12s %17s %10.1f %12s", stage, frames, d.rates[stage].perSec, queued)
+	}
+	ffmpeg := d.ffmpeg
+	if ffmpeg == "" {
+		ffmpeg = "no status yet"
+	}
+	line("ffmpeg: %s", ffmpeg)
+	line("recent:")
+	for i := 0; i < dashboardEvents; i++ {
+		if i < len(d.events) {
+			line("  %s", d.events[i])
+		} else {
+			line("")
+		}
+	}
+	d.drawn = 4 + len(d.stages) + dashboardEvents
+	io.WriteString(d.out, sb.String())
+}
+
+// update takes in that the stage is at frames by now. Rates are averaged
+// over a second or so, so a stage handing frames over in bursts reads
+// steady.
+func (r *stageRate) update(frames int64, now time.Time) {
+	span := now.Sub(r.at).Seconds()
+	if span <= 0 {
+		return
+	}
+	current := float64(frames-r.frames) / span
+	weight := span / (span + 1)
+	r.perSec += (current - r.perSec) * weight
+	r.frames, r.at = frames, now
+}
@@ -0,0 +1,30 @@
+package ftv
+
+import (
+	_ "embed"
+	"errors"
+	"net/http"
+)
+
+// uiPage is the single page of serve -ui. It talks to the job API only, so
+// whatever it does can be done with curl just as well.
+//
+//go:embed ui.html
+var uiPage []byte
+
+// withUI serves uiPage at / and hands every other request to api.
+func withUI(api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			api.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(uiPage)
+	})
+}
@@ -0,0 +1,345 @@
+package ftv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// upload sends the encoded video at path to target and returns where it
+// ended up. target is one of
+//
+//	youtube               a private YouTube video, authorized with the OAuth
+//	                      device flow on first use
+//	rclone:remote:path    anything rclone can write to, a trailing slash
+//	                      keeps the file name
+//	http(s)://...         a PUT of the file, e.g. to a presigned URL
+func upload(ctx context.Context, path, target string, opts options) (string, error) {
+	if err := checkUploadTarget(target, opts); err != nil {
+		return "", err
+	}
+	switch {
+	case target == "youtube":
+		return uploadYouTube(ctx, path, opts)
+	case strings.HasPrefix(target, "rclone:"):
+		return uploadRclone(ctx, path, strings.TrimPrefix(target, "rclone:"))
+	default:
+		return uploadHTTP(ctx, path, target, opts)
+	}
+}
+
+// checkUploadTarget catches mistakes in target before hours are spent
+// encoding.
+func checkUploadTarget(target string, opts options) error {
+	switch {
+	case target == "youtube":
+		if opts.youtubeClientID == "" || opts.youtubeClientSecret == "" {
+			return errors.New("youtube_client_id and youtube_client_secret must be configured")
+		}
+	case strings.HasPrefix(target, "rclone:"):
+		if _, err := exec.LookPath("rclone"); err != nil {
+			return err
+		}
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+	default:
+		return fmt.Errorf("unknown upload target %q (expected youtube, rclone:remote:path or an http(s) URL)", target)
+	}
+	return nil
+}
+
+func uploadHTTP(ctx context.Context, path, target string, opts options) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, file)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", videoContentType(path, opts))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		return location, nil
+	}
+	return target, nil
+}
+
+func uploadRclone(ctx context.Context, path, remote string) (string, error) {
+	if strings.HasSuffix(remote, "/") || strings.HasSuffix(remote, ":") {
+		remote += filepath.Base(path)
+	}
+	cmd := command(ctx, "rclone", "copyto", path, remote)
+	stderr := logger.writer("rclone", levelVerbose)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stderr.Close()
+	if err != nil {
+		return "", fmt.Errorf("rclone copyto: %w", err)
+	}
+
+	// Not every backend can create public links, the remote path is the
+	// next best answer
+	link, err := command(ctx, "rclone", "link", remote).Output()
+	if err != nil {
+		return remote, nil
+	}
+	return strings.TrimSpace(string(link)), nil
+}
+
+// statusError is a non-2xx response, with the start of its body.
+type statusError struct {
+	code    int
+	status  string
+	message string
+}
+
+func (e *statusError) Error() string { return e.status + ": " + e.message }
+
+// checkResponse turns a non-2xx response into a statusError carrying the
+// start of the body, which is where APIs explain what went wrong.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &statusError{code: resp.StatusCode, status: resp.Status, message: strings.TrimSpace(string(body))}
+}
+
+// --- YouTube
+
+const (
+	youtubeScope     = "https://www.googleapis.com/auth/youtube"
+	youtubeDeviceURL = "https://oauth2.googleapis.com/device/code"
+	youtubeTokenURL  = "https://oauth2.googleapis.com/token"
+	youtubeUploadURL = "https://www.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&part=snippet,status"
+)
+
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+func uploadYouTube(ctx context.Context, path string, opts options) (string, error) {
+	accessToken, err := youtubeAccessToken(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("authorizing: %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// A resumable session is opened with the metadata, then the video is
+	// sent to the session URL in one request
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"snippet": map[string]string{"title": filepath.Base(path)},
+		"status":  map[string]string{"privacyStatus": "private"},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, youtubeUploadURL, bytes.NewReader(metadata))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "video/*")
+	req.Header.Set("X-Upload-Content-Length", fmt.Sprint(info.Size()))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("starting upload: %w", err)
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("starting upload: no session URL returned")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, session, file)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "video/*")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("uploading: %w", err)
+	}
+	var video struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&video); err != nil {
+		return "", fmt.Errorf("reading upload response: %w", err)
+	}
+	return "https://youtu.be/" + video.ID, nil
+}
+
+// youtubeTokenPath is where the refresh token is kept between runs.
+func youtubeTokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "filetovideo", "youtube-token.json"), nil
+}
+
+// youtubeAccessToken refreshes the stored token, or runs the device flow if
+// there is none yet.
+func youtubeAccessToken(ctx context.Context, opts options) (string, error) {
+	tokenPath, err := youtubeTokenPath()
+	if err != nil {
+		return "", err
+	}
+
+	var stored oauthToken
+	if data, err := os.ReadFile(tokenPath); err == nil && json.Unmarshal(data, &stored) == nil && stored.RefreshToken != "" {
+		token, err := postOAuth(ctx, youtubeTokenURL, url.Values{
+			"client_id":     {opts.youtubeClientID},
+			"client_secret": {opts.youtubeClientSecret},
+			"refresh_token": {stored.RefreshToken},
+			"grant_type":    {"refresh_token"},
+		})
+		if err == nil && token.Error == "" {
+			return token.AccessToken, nil
+		}
+		logger.info("upload", "stored YouTube authorization is no longer valid", nil)
+	}
+
+	token, err := youtubeDeviceFlow(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(oauthToken{RefreshToken: token.RefreshToken})
+	if err := os.MkdirAll(filepath.Dir(tokenPath), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(tokenPath, data, 0o600); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func youtubeDeviceFlow(ctx context.Context, opts options) (*oauthToken, error) {
+	resp, err := postForm(ctx, youtubeDeviceURL, url.Values{
+		"client_id": {opts.youtubeClientID},
+		"scope":     {youtubeScope},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var device struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
+		return nil, err
+	}
+
+	// The user has to act on this even with -q
+	fmt.Fprintf(os.Stderr, "To allow uploading to YouTube, visit %s and enter the code %s\n", device.VerificationURL, device.UserCode)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token, err := postOAuth(ctx, youtubeTokenURL, url.Values{
+			"client_id":     {opts.youtubeClientID},
+			"client_secret": {opts.youtubeClientSecret},
+			"device_code":   {device.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch token.Error {
+		case "":
+			return token, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("authorization failed: %s", token.Error)
+		}
+	}
+	return nil, errors.New("authorization code expired")
+}
+
+// postOAuth posts a form to an OAuth endpoint. Errors like
+// authorization_pending come back in the token rather than as err.
+func postOAuth(ctx context.Context, endpoint string, form url.Values) (*oauthToken, error) {
+	resp, err := postForm(ctx, endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var token oauthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	if token.Error == "" && resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return &token, nil
+}
+
+func postForm(ctx context.Context, endpoint string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,44 @@
+package ftv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadHTTPContentType(t *testing.T) {
+	tests := []struct {
+		name, container string
+		contentType     string
+	}{
+		{"video.mp4", "", "video/mp4"},
+		{"video.mkv", "", "video/x-matroska"},
+		{"video.webm", "", "video/webm"},
+		{"video.out", containerMKV, "video/x-matroska"},
+		{"video.avi", "", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				contentType = req.Header.Get("Content-Type")
+			}))
+			defer server.Close()
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, []byte("video"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			opts := defaultOptions()
+			opts.container = tt.container
+			if _, err := uploadHTTP(context.Background(), path, server.URL, opts); err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tt.contentType {
+				t.Fatalf("Content-Type %s, expected %s", contentType, tt.contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package ftv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runWatch polls input directories and encodes (or decodes) every file that
+// appears in them. Next to each output a <output>.status file records the
+// state of the run and the size and time of the input, so finished files are
+// not processed again after a restart, unless they changed since.
+func runWatch(args []string) {
+	var (
+		encode_in    string
+		encode_out   string
+		decode_in    string
+		decode_out   string
+		interval     time.Duration
+		metrics_addr string
+	)
+
+	c := watchCLI(&encode_in, &encode_out, &decode_in, &decode_out, &interval, &metrics_addr)
+	c.parse(args)
+
+	if (encode_in == "") != (encode_out == "") {
+		c.usageError("The -in and -out flags must be given together")
+	}
+	if (decode_in == "") != (decode_out == "") {
+		c.usageError("The -decode-in and -decode-out flags must be given together")
+	}
+	if encode_in == "" && decode_in == "" {
+		c.usageError("At least one of -in or -decode-in is required")
+	}
+	if interval <= 0 {
+		c.usageError("The -interval flag must be positive")
+	}
+
+	var watchers []*watcher
+	if encode_in != "" {
+		if err := checkContainer("video"+videoExtension(c.opts), c.opts); err != nil {
+			c.usageError(err.Error())
+		}
+		watchers = append(watchers, newWatcher("encode", encode_in, encode_out))
+	}
+	if decode_in != "" {
+		watchers = append(watchers, newWatcher("decode", decode_in, decode_out))
+	}
+	for _, w := range watchers {
+		if err := os.MkdirAll(w.outDir, 0o755); err != nil {
+			logger.fatal("watch", err)
+		}
+		logger.info("watch", "watching", fields{"mode": w.mode, "dir": w.inDir, "output": w.outDir})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if metrics_addr != "" {
+		go func() {
+			if err := serveMetrics(ctx, metrics_addr); err != nil {
+				logger.fatal("watch", err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, w := range watchers {
+			if err := w.scan(ctx, c.opts); err != nil {
+				logger.error("watch", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchCLI defines the flags of watch.
+func watchCLI(encode_in, encode_out, decode_in, decode_out *string, interval *time.Duration, metrics_addr *string) *cli {
+	c := newCLI("watch")
+	c.flags.StringVar(encode_in, "in", "", "Directory watched for files to encode")
+	c.flags.StringVar(encode_out, "out", "", "Directory encoded videos are written to")
+	c.flags.StringVar(decode_in, "decode-in", "", "Directory watched for videos to decode")
+	c.flags.StringVar(decode_out, "decode-out", "", "Directory decoded files are written to")
+	c.flags.DurationVar(interval, "interval", 2*time.Second, "How often the input directories are scanned")
+	c.flags.StringVar(metrics_addr, "metrics-addr", "", "Address serving /metrics in the Prometheus text format, empty to disable it")
+	return c
+}
+
+// watchStatus is the content of a .status file.
+type watchStatus struct {
+	Input    string     `json:"input"`
+	Output   string     `json:"output"`
+	State    jobState   `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Of the input when it was processed, a file dropped again under the
+	// same name is processed again. Status files from before they were
+	// recorded have neither and are taken as still current.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+type watcher struct {
+	mode   string
+	inDir  string
+	outDir string
+
+	// Files seen in the previous scan. A file is only picked up once it
+	// stayed the same for a whole interval, so half-copied files are skipped.
+	seen map[string]fileSnapshot
+
+	// Files skipped as their output is another's, so the error is only
+	// logged once
+	collisions map[string]bool
+}
+
+func newWatcher(mode, inDir, outDir string) *watcher {
+	return &watcher{mode: mode, inDir: inDir, outDir: outDir, seen: map[string]fileSnapshot{}, collisions: map[string]bool{}}
+}
+
+func (w *watcher) scan(ctx context.Context, opts options) error {
+	entries, err := os.ReadDir(w.inDir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+
+	current := map[string]fileSnapshot{}
+	outputs := map[string]string{} // Input claiming every output, the first by name
+	for _, entry := range entries {
+		// Status files are skipped so an output directory can itself be watched
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") ||
+			strings.HasSuffix(entry.Name(), ".status") || strings.HasSuffix(entry.Name(), ".status.tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := entry.Name()
+		snapshot := fileSnapshot{size: info.Size(), modTime: info.ModTime()}
+		current[name] = snapshot
+		output := w.output(name, opts)
+		if first, ok := outputs[output]; ok {
+			// Such as x.mp4 and x.mkv, which both decode to x
+			w.collision(name, output, filepath.Join(w.inDir, first))
+			continue
+		}
+		outputs[output] = name
+		if previous, ok := w.seen[name]; !ok || previous != snapshot {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		w.process(ctx, name, snapshot, opts)
+	}
+	w.seen = current
+	for name := range w.collisions {
+		if _, ok := current[name]; !ok {
+			delete(w.collisions, name)
+		}
+	}
+	return nil
+}
+
+// collision logs that the input called name is skipped as its output is
+// that of other, once for as long as it is there.
+func (w *watcher) collision(name, output, other string) {
+	if !w.collisions[name] {
+		logger.error("watch", fmt.Errorf("skipping %s, whose output %s is that of %s", filepath.Join(w.inDir, name), output, other))
+		w.collisions[name] = true
+	}
+}
+
+// output returns the path of the output of the input called name.
+func (w *watcher) output(name string, opts options) string {
+	return filepath.Join(w.outDir, outputName(w.mode, name, videoExtension(opts)))
+}
+
+// process runs a single file unless its status file shows it was already
+// handled as it is now. Runs cut short by a crash are left "running" and are
+// retried. A status file of another input, which a file of the same output
+// name left in an earlier scan, is only replaced once that input is gone.
+func (w *watcher) process(ctx context.Context, name string, snapshot fileSnapshot, opts options) {
+	output := w.output(name, opts)
+	statusPath := output + ".status"
+	input := filepath.Join(w.inDir, name)
+
+	var status watchStatus
+	if data, err := os.ReadFile(statusPath); err == nil && json.Unmarshal(data, &status) == nil {
+		if status.Input != input && !sameFile(status.Input, input) {
+			if _, err := os.Stat(status.Input); err == nil {
+				w.collision(name, output, status.Input)
+				return
+			}
+		} else if status.State != jobRunning && status.State != jobCanceled {
+			if status.ModTime.IsZero() || status.Size == snapshot.size && status.ModTime.Equal(snapshot.modTime) {
+				return
+			}
+			logger.info("watch", "input changed since it was processed", fields{"input": input, "state": status.State})
+		}
+	}
+
+	status = watchStatus{
+		Input:   input,
+		Output:  output,
+		State:   jobRunning,
+		Started: time.Now(),
+		Size:    snapshot.size,
+		ModTime: snapshot.modTime,
+	}
+	if err := writeWatchStatus(statusPath, &status); err != nil {
+		logger.error("watch", err)
+		return
+	}
+	logger.info("watch", "processing", fields{"mode": w.mode, "input": status.Input})
+
+	tracked := metrics.track(w.mode, &opts)
+	var err error
+	if w.mode == "encode" {
+		err = encode(ctx, status.Input, output, opts)
+	} else {
+		err = decode(ctx, status.Input, output, opts)
+	}
+
+	now := time.Now()
+	status.Finished = &now
+	switch {
+	case err != nil && ctx.Err() != nil:
+		status.State = jobCanceled
+		status.Error = err.Error()
+	case err != nil:
+		status.State = jobFailed
+		status.Error = err.Error()
+		logger.error("watch", fmt.Errorf("%s: %w", status.Input, err))
+	default:
+		status.State = jobDone
+		logger.info("watch", "finished", fields{"input": status.Input, "output": output})
+	}
+	tracked.finish(status.State, err)
+	if err := writeWatchStatus(statusPath, &status); err != nil {
+		logger.error("watch", err)
+	}
+}
+
+// sameFile reports whether the paths a and b lead to the same file.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
+
+// writeWatchStatus replaces the status file atomically so readers never see
+// a partial write.
+func writeWatchStatus(path string, status *watchStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,102 @@
+package ftv
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readWatchStatus returns the status file of output.
+func readWatchStatus(t *testing.T, output string) watchStatus {
+	t.Helper()
+	data, err := os.ReadFile(output + ".status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status watchStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// scanTwice scans w twice, a file being picked up once it was seen the
+// same in the scan before.
+func scanTwice(t *testing.T, w *watcher, opts options) {
+	t.Helper()
+	for i := 0; i < 2; i++ {
+		if err := w.scan(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWatchChanged checks that a file is encoded once, and again when it is
+// dropped anew with other content.
+func TestWatchChanged(t *testing.T) {
+	opts := testOptions(t, nil)
+	inDir, outDir := t.TempDir(), t.TempDir()
+	w := newWatcher("encode", inDir, outDir)
+	input := filepath.Join(inDir, "file.bin")
+	output := filepath.Join(outDir, "file.bin.mp4")
+
+	drop := func(payload []byte, mtime time.Time) {
+		if err := os.WriteFile(input, payload, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(input, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop(testPayload(1000), time.Unix(1000, 0))
+	scanTwice(t, w, opts)
+	first := readWatchStatus(t, output)
+	if first.State != jobDone || first.Size != 1000 {
+		t.Fatalf("status %+v after the first drop", first)
+	}
+
+	scanTwice(t, w, opts)
+	if again := readWatchStatus(t, output); !again.Started.Equal(first.Started) {
+		t.Fatal("unchanged file processed again")
+	}
+
+	drop(testPayload(2000), time.Unix(2000, 0))
+	scanTwice(t, w, opts)
+	second := readWatchStatus(t, output)
+	if second.State != jobDone || second.Size != 2000 || second.Started.Equal(first.Started) {
+		t.Fatalf("status %+v after dropping the file again", second)
+	}
+}
+
+// TestWatchCollision checks that of two videos decoding to the same file
+// only the first is decoded.
+func TestWatchCollision(t *testing.T) {
+	opts := testOptions(t, nil)
+	inDir, outDir := t.TempDir(), t.TempDir()
+	w := newWatcher("decode", inDir, outDir)
+	for _, name := range []string{"x.mkv", "x.mp4"} {
+		if err := os.WriteFile(filepath.Join(inDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanTwice(t, w, opts)
+	status := readWatchStatus(t, filepath.Join(outDir, "x"))
+	if status.Input != filepath.Join(inDir, "x.mkv") {
+		t.Fatalf("output of %s", status.Input)
+	}
+	if !w.collisions["x.mp4"] {
+		t.Fatal("collision not detected")
+	}
+
+	// Once the first is gone, the other gets the output
+	if err := os.Remove(filepath.Join(inDir, "x.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	scanTwice(t, w, opts)
+	if status := readWatchStatus(t, filepath.Join(outDir, "x")); status.Input != filepath.Join(inDir, "x.mp4") {
+		t.Fatalf("output of %s after the first is gone", status.Input)
+	}
+}
@@ -0,0 +1,262 @@
+package ftv
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// writerPartFrames is about how many frames every part of a video made by
+// NewWriter spans.
+//
+// The header of a video carries the length of the payload and the trailer
+// its hash, neither of which is known while the data is still coming in. So
+// the data written is cut into parts, each encoded as soon as it is complete
+// just like -append would add it to the video: with a header, a trailer and
+// the hash of everything so far. Every part's header tells whether another
+// one follows, which lets -live decoding know when the video is over.
+const writerPartFrames = 120
+
+// NewWriter returns a writer encoding what is written to it into the video
+// dest with opts, with ffmpeg running as the data comes in. Close finishes the video,
+// it is unusable before. Videos made this way are split into parts and
+// need a reader for v4 or newer, and their header records no file name.
+func NewWriter(dest string, opts Options) (io.WriteCloser, error) {
+	if err := opts.opts.validate(); err != nil {
+		return nil, err
+	}
+	return newWriter(context.Background(), dest, fileMetadata{}, opts.opts)
+}
+
+// encodeReader encodes everything r yields into the video dest.
+func encodeReader(ctx context.Context, r io.Reader, dest string, opts options) error {
+	w, err := newWriter(ctx, dest, fileMetadata{}, opts)
+	if err != nil {
+		return err
+	}
+	if opts.payloadFormat == payloadTar {
+		archive := tarStream(r, nil)
+		defer archive.Close()
+		r = archive
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.err = err
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+type videoWriter struct {
+	opts       options
+	metadata   fileMetadata
+	ctx        context.Context
+	cancel     context.CancelFunc
+	output     *outputFile
+	sink       frameSink
+	serializer *frameSerializer
+	throttle   *throttle
+	pixels     []byte
+	capacity   int // Of a frame
+	start      time.Time
+
+	buffer  []byte         // Payload of the part being collected
+	written int64          // Payload of the parts already encoded
+	frame   int            // Next frame of the video
+	trailer *streamTrailer // Of the last part encoded, nil before the first
+	closed  bool
+	err     error // Sticky, nothing more is encoded after it
+}
+
+func newWriter(ctx context.Context, dest string, metadata fileMetadata, opts options) (*videoWriter, error) {
+	if opts.segments > 1 || opts.appendTo != "" || opts.deltaBase != "" {
+		return nil, errors.New("segments, appending and deltas need the whole input up front")
+	}
+	output, err := prepareVideoOutput(dest, opts)
+	if err != nil {
+		return nil, &stageError{stage: "ffmpeg", err: err}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	sink, err := transportOf(opts).NewSink(ctx, output.path, opts)
+	if err == nil && opts.intro {
+		// The size is not known yet
+		if err = writeIntro(sink, metadata, -1, opts); err != nil {
+			sink.Close()
+		}
+	}
+	if err != nil {
+		cancel()
+		output.cleanup()
+		return nil, &stageError{stage: "ffmpeg", err: err}
+	}
+	w := &videoWriter{
+		opts:       opts,
+		metadata:   metadata,
+		ctx:        ctx,
+		cancel:     cancel,
+		output:     output,
+		sink:       sink,
+		serializer: newFrameSerializer(opts),
+		throttle:   newThrottle(opts.maxThroughput),
+		pixels:     make([]byte, geometryOf(opts).frameBytes(4)),
+		capacity:   frameCapacity(opts),
+		start:      time.Now(),
+	}
+	logger.verbose("ffmpeg", "opened", fields{"elapsed": time.Since(w.start)})
+	return w, nil
+}
+
+// partLimit returns how much payload the next part takes, filling its
+// frames with its header.
+func (w *videoWriter) partLimit() int {
+	headerSize := partHeaderSize + 1
+	if w.trailer == nil {
+		headerSize = newStreamHeader(0, w.metadata).size
+	}
+	return writerPartFrames*w.capacity - headerSize
+}
+
+func (w *videoWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to a closed video")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buffer = append(w.buffer, p...)
+	// A part is only encoded once more data follows it, its header says so
+	for limit := w.partLimit(); len(w.buffer) > limit; limit = w.partLimit() {
+		if w.err = w.encodePart(w.buffer[:limit], true); w.err != nil {
+			return 0, w.err
+		}
+		w.buffer = append(w.buffer[:0], w.buffer[limit:]...)
+	}
+	return len(p), nil
+}
+
+// Close encodes the rest of the data and waits for ffmpeg to finish the
+// video.
+func (w *videoWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	defer w.cancel()
+	defer w.output.cleanup()
+
+	if w.err == nil {
+		w.err = w.encodePart(w.buffer, false)
+	}
+	if w.err != nil {
+		w.cancel() // Kill ffmpeg rather than let it finish a broken video
+	}
+	closeErr := w.sink.Close()
+	switch {
+	case w.err != nil:
+	case closeErr != nil:
+		w.err = &stageError{stage: "ffmpeg", err: closeErr}
+	default:
+		if err := w.output.commit(w.ctx); err != nil {
+			w.err = &stageError{stage: "upload", err: err}
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+	logger.verbose("ffmpeg", "finished", fields{"frames": w.frame, "elapsed": time.Since(w.start)})
+	runSummary{mode: "encode", payload: w.written, frames: int64(w.frame), start: w.start}.log(w.opts)
+	return nil
+}
+
+// encodePart encodes payload as the next part, more telling whether
+// another one follows.
+func (w *videoWriter) encodePart(payload []byte, more bool) error {
+	var header []byte
+	var previous []byte
+	if w.trailer == nil {
+		h := newStreamHeader(int64(len(payload)), w.metadata)
+		h.dct = w.opts.modulation == modulationDCT
+		h.strip = w.opts.frameStrip
+		h.tar = w.opts.payloadFormat == payloadTar
+		h.more = more
+		header = h.marshal()
+	} else {
+		header = marshalPartHeader(w.written, int64(len(payload)), more)
+		previous = w.trailer.hash[:]
+
+		// The frames up to the next interleaved block repeat the trailer
+		for end := int(interleavedFrames(int64(w.frame), w.opts.interleave)); w.frame < end; {
+			filler := *w.trailer
+			filler.frame = w.frame
+			if err := w.writeFrame(filler.marshal()); err != nil {
+				return err
+			}
+		}
+	}
+
+	hash := sha256.New()
+	hash.Write(previous)
+	hash.Write(payload)
+	trailer := &streamTrailer{length: w.written + int64(len(payload))}
+	copy(trailer.hash[:], hash.Sum(nil))
+
+	stream := append(header, payload...)
+	depth := w.opts.interleave
+	frames := int(interleavedFrames(streamFrames(int64(len(stream)), w.capacity), depth))
+	block := make([]byte, depth*w.capacity)
+	interleaved := make([][]byte, depth)
+	for i := range interleaved {
+		interleaved[i] = make([]byte, w.capacity)
+	}
+	for b := 0; b < frames/depth; b++ {
+		n := copy(block, stream[b*len(block):])
+		for i := n; i < len(block); i++ {
+			block[i] = 0
+		}
+		if depth > 1 {
+			interleaveBlock(block, interleaved)
+		} else {
+			interleaved[0] = block
+		}
+		for _, frame := range interleaved {
+			if err := w.writeFrame(frame); err != nil {
+				return err
+			}
+		}
+	}
+
+	trailer.frame = w.frame
+	if err := w.writeFrame(trailer.marshal()); err != nil {
+		return err
+	}
+	w.written = trailer.length
+	w.trailer = trailer
+	logger.debug("serializer", "part encoded", fields{"offset": w.written - int64(len(payload)), "length": len(payload), "frames": frames})
+	return nil
+}
+
+// writeFrame serializes frame as the next frame of the video and hands it
+// to the sink.
+func (w *videoWriter) writeFrame(frame []byte) error {
+	if !w.throttle.wait(w.ctx) {
+		return w.ctx.Err()
+	}
+	for i := range w.pixels {
+		w.pixels[i] = 0
+	}
+	serializing := time.Now()
+	w.serializer.serialize(w.frame, frame, w.pixels)
+	w.opts.timings.since(phaseSerialize, serializing)
+	writing := time.Now()
+	for n := 0; n < w.opts.repeat; n++ {
+		if err := w.sink.WriteFrame(w.pixels); err != nil {
+			return &stageError{stage: "ffmpeg", err: fmt.Errorf("writing frame %d: %w", w.frame, err)}
+		}
+	}
+	w.opts.timings.since(phaseFFmpeg, writing)
+	w.frame++
+	return nil
+}
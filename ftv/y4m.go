@@ -0,0 +1,220 @@
+package ftv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// y4mTransport stores a video as an uncompressed YUV4MPEG2 stream, which
+// ffmpeg, x264, rav1e and most analysis tools read from a pipe, so the frames
+// can go through an encoder FileToVideo knows nothing about. Chroma is kept
+// at full resolution and range, the frames survive the round trip to YUV
+// up to rounding.
+type y4mTransport struct{}
+
+// y4mFrameTag starts every frame, without frame parameters.
+const y4mFrameTag = "FRAME\n"
+
+func (y4mTransport) NewSink(ctx context.Context, dest string, opts options) (frameSink, error) {
+	out, file := io.Writer(os.Stdout), (*os.File)(nil)
+	if dest != stdoutOutput {
+		var err error
+		if file, err = os.Create(dest); err != nil {
+			return nil, err
+		}
+		out = file
+	}
+	s := &y4mSink{
+		file:  file,
+		out:   bufio.NewWriterSize(out, 1<<20),
+		frame: make([]byte, 3*opts.width*opts.height),
+	}
+	// XCOLORRANGE is an extension ffmpeg reads, other tools ignore it
+	_, err := fmt.Fprintf(s.out, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444 XCOLORRANGE=FULL\n", opts.width, opts.height, opts.fps)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (y4mTransport) NewSource(ctx context.Context, src string, opts options) (frameSource, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	in := bufio.NewReaderSize(file, 1<<20)
+	width, height, err := readY4MHeader(in)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading %s: %w", src, err)
+	}
+	if width != opts.width || height != opts.height {
+		file.Close()
+		return nil, fmt.Errorf("%s is %dx%d, expected %dx%d", src, width, height, opts.width, opts.height)
+	}
+	return &y4mSource{ctx: ctx, file: file, in: in, frame: make([]byte, 3*width*height)}, nil
+}
+
+// countFrames works out the frames from the size of the file, every frame
+// takes the same space.
+func (y4mTransport) countFrames(src string) (int64, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	in := bufio.NewReader(file)
+	width, height, err := readY4MHeader(in)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", src, err)
+	}
+	headerBytes, _ := file.Seek(0, io.SeekCurrent)
+	headerBytes -= int64(in.Buffered())
+	return (info.Size() - headerBytes) / int64(len(y4mFrameTag)+3*width*height), nil
+}
+
+// readY4MHeader reads the stream header off in and returns the frame size.
+// Only the 4:4:4 streams y4mSink writes are accepted, subsampled chroma
+// would blur the dots.
+func readY4MHeader(in *bufio.Reader) (width, height int, err error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return 0, 0, errors.New("not a YUV4MPEG2 stream")
+	}
+	params := strings.Fields(line)
+	if len(params) == 0 || params[0] != "YUV4MPEG2" {
+		return 0, 0, errors.New("not a YUV4MPEG2 stream")
+	}
+	chroma := "420jpeg" // The default of the format
+	for _, param := range params[1:] {
+		switch param[0] {
+		case 'W':
+			width, err = strconv.Atoi(param[1:])
+		case 'H':
+			height, err = strconv.Atoi(param[1:])
+		case 'C':
+			chroma = param[1:]
+		case 'I':
+			if param != "Ip" {
+				return 0, 0, fmt.Errorf("interlaced streams are not supported (%s)", param)
+			}
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid header parameter %s", param)
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("header without a frame size")
+	}
+	if chroma != "444" {
+		return 0, 0, fmt.Errorf("chroma %s is not supported, only 444", chroma)
+	}
+	return width, height, nil
+}
+
+type y4mSink struct {
+	file  *os.File // nil when writing to standard output, which stays open
+	out   *bufio.Writer
+	frame []byte // Y, U and V planes
+}
+
+func (s *y4mSink) WriteFrame(pixels []byte) error {
+	rgbaToYUV444(s.frame, pixels)
+	if _, err := s.out.WriteString(y4mFrameTag); err != nil {
+		return err
+	}
+	_, err := s.out.Write(s.frame)
+	return err
+}
+
+func (s *y4mSink) Close() error {
+	err := s.out.Flush()
+	if s.file != nil {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type y4mSource struct {
+	ctx    context.Context
+	file   *os.File
+	in     *bufio.Reader
+	frame  []byte
+	frames int
+}
+
+func (s *y4mSource) ReadFrame(pixels []byte) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	tag, err := s.in.ReadString('\n')
+	if err == io.EOF && tag == "" {
+		return io.EOF
+	}
+	if err == nil && !strings.HasPrefix(tag, "FRAME") {
+		err = fmt.Errorf("frame %d does not start with a FRAME tag", s.frames+1)
+	}
+	if err == nil {
+		_, err = io.ReadFull(s.in, s.frame)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		logger.verbose("y4m", "the last frame is cut short", fields{"frame": s.frames + 1})
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	s.frames++
+	yuv444ToRGB(pixels, s.frame)
+	return nil
+}
+
+func (s *y4mSource) Close() error { return s.file.Close() }
+
+// rgbaToYUV444 converts an RGBA frame to the planes of full range BT.601
+// YUV, which is what the yuvj formats of ffmpeg hold.
+func rgbaToYUV444(planes, rgba []byte) {
+	n := len(planes) / 3
+	y, u, v := planes[:n], planes[n:2*n], planes[2*n:]
+	for i := 0; i < n; i++ {
+		r, g, b := int(rgba[4*i]), int(rgba[4*i+1]), int(rgba[4*i+2])
+		y[i] = byte((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+		u[i] = clampByte((-11059*r-21709*g+32768*b+1<<15)>>16 + 128)
+		v[i] = clampByte((32768*r-27439*g-5329*b+1<<15)>>16 + 128)
+	}
+}
+
+// yuv444ToRGB is the inverse of rgbaToYUV444, into an RGB frame.
+func yuv444ToRGB(rgb, planes []byte) {
+	n := len(planes) / 3
+	y, u, v := planes[:n], planes[n:2*n], planes[2*n:]
+	for i := 0; i < n; i++ {
+		luma, cb, cr := int(y[i])<<16+1<<15, int(u[i])-128, int(v[i])-128
+		rgb[3*i] = clampByte((luma + 91881*cr) >> 16)
+		rgb[3*i+1] = clampByte((luma - 22554*cb - 46802*cr) >> 16)
+		rgb[3*i+2] = clampByte((luma + 116130*cb) >> 16)
+	}
+}
+
+func clampByte(v int) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
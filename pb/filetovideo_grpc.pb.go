@@ -0,0 +1,214 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: filetovideo.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FileToVideo_Encode_FullMethodName = "/filetovideo.v1.FileToVideo/Encode"
+	FileToVideo_Decode_FullMethodName = "/filetovideo.v1.FileToVideo/Decode"
+)
+
+// FileToVideoClient is the client API for FileToVideo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileToVideoClient interface {
+	// Encode turns the streamed payload into a video.
+	Encode(ctx context.Context, opts ...grpc.CallOption) (FileToVideo_EncodeClient, error)
+	// Decode extracts the payload from a streamed video.
+	Decode(ctx context.Context, opts ...grpc.CallOption) (FileToVideo_DecodeClient, error)
+}
+
+type fileToVideoClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileToVideoClient(cc grpc.ClientConnInterface) FileToVideoClient {
+	return &fileToVideoClient{cc}
+}
+
+func (c *fileToVideoClient) Encode(ctx context.Context, opts ...grpc.CallOption) (FileToVideo_EncodeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileToVideo_ServiceDesc.Streams[0], FileToVideo_Encode_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileToVideoEncodeClient{stream}
+	return x, nil
+}
+
+type FileToVideo_EncodeClient interface {
+	Send(*Request) error
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type fileToVideoEncodeClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileToVideoEncodeClient) Send(m *Request) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileToVideoEncodeClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileToVideoClient) Decode(ctx context.Context, opts ...grpc.CallOption) (FileToVideo_DecodeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileToVideo_ServiceDesc.Streams[1], FileToVideo_Decode_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileToVideoDecodeClient{stream}
+	return x, nil
+}
+
+type FileToVideo_DecodeClient interface {
+	Send(*Request) error
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type fileToVideoDecodeClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileToVideoDecodeClient) Send(m *Request) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileToVideoDecodeClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FileToVideoServer is the server API for FileToVideo service.
+// All implementations must embed UnimplementedFileToVideoServer
+// for forward compatibility
+type FileToVideoServer interface {
+	// Encode turns the streamed payload into a video.
+	Encode(FileToVideo_EncodeServer) error
+	// Decode extracts the payload from a streamed video.
+	Decode(FileToVideo_DecodeServer) error
+	mustEmbedUnimplementedFileToVideoServer()
+}
+
+// UnimplementedFileToVideoServer must be embedded to have forward compatible implementations.
+type UnimplementedFileToVideoServer struct {
+}
+
+func (UnimplementedFileToVideoServer) Encode(FileToVideo_EncodeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Encode not implemented")
+}
+func (UnimplementedFileToVideoServer) Decode(FileToVideo_DecodeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Decode not implemented")
+}
+func (UnimplementedFileToVideoServer) mustEmbedUnimplementedFileToVideoServer() {}
+
+// UnsafeFileToVideoServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileToVideoServer will
+// result in compilation errors.
+type UnsafeFileToVideoServer interface {
+	mustEmbedUnimplementedFileToVideoServer()
+}
+
+func RegisterFileToVideoServer(s grpc.ServiceRegistrar, srv FileToVideoServer) {
+	s.RegisterService(&FileToVideo_ServiceDesc, srv)
+}
+
+func _FileToVideo_Encode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileToVideoServer).Encode(&fileToVideoEncodeServer{stream})
+}
+
+type FileToVideo_EncodeServer interface {
+	Send(*Response) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type fileToVideoEncodeServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileToVideoEncodeServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileToVideoEncodeServer) Recv() (*Request, error) {
+	m := new(Request)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileToVideo_Decode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileToVideoServer).Decode(&fileToVideoDecodeServer{stream})
+}
+
+type FileToVideo_DecodeServer interface {
+	Send(*Response) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type fileToVideoDecodeServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileToVideoDecodeServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileToVideoDecodeServer) Recv() (*Request, error) {
+	m := new(Request)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FileToVideo_ServiceDesc is the grpc.ServiceDesc for FileToVideo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileToVideo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filetovideo.v1.FileToVideo",
+	HandlerType: (*FileToVideoServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Encode",
+			Handler:       _FileToVideo_Encode_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Decode",
+			Handler:       _FileToVideo_Decode_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "filetovideo.proto",
+}